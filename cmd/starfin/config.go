@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// Config is the configuration of the server, read from a YAML or TOML file whose values can be overridden by the
+// environment variables
+type Config struct {
+	Port          string `yaml:"port" toml:"port"`
+	CookieSecret  string `yaml:"cookie_secret" toml:"cookie_secret"`
+	CachePath     string `yaml:"cache_path" toml:"cache_path"`
+	MediaInfoPath string `yaml:"mediainfo_path" toml:"mediainfo_path"`
+
+	// Default values of the settings that can be changed from the admin panel
+	LogLevel     string `yaml:"log_level" toml:"log_level"`
+	TMDBAPIKey   string `yaml:"tmdb_api_key" toml:"tmdb_api_key"`
+	ItemsPerPage int64  `yaml:"items_per_page" toml:"items_per_page"`
+
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	Rarbg    RarbgConfig    `yaml:"rarbg" toml:"rarbg"`
+	Timeouts TimeoutsConfig `yaml:"timeouts" toml:"timeouts"`
+	Refresh  RefreshConfig  `yaml:"refresh" toml:"refresh"`
+	Metadata MetadataConfig `yaml:"metadata" toml:"metadata"`
+}
+
+// DatabaseConfig selects the storage backend, and how to connect to it
+type DatabaseConfig struct {
+	Backend       string `yaml:"backend" toml:"backend"` // "mongodb", "sqlite" or "memory"
+	URL           string `yaml:"url" toml:"url"`
+	Port          string `yaml:"port" toml:"port"`
+	Name          string `yaml:"name" toml:"name"`
+	User          string `yaml:"user" toml:"user"`
+	Password      string `yaml:"password" toml:"password"`
+	SQLiteFile    string `yaml:"sqlite_file" toml:"sqlite_file"`
+	MemoryFixture string `yaml:"memory_fixture" toml:"memory_fixture"`
+}
+
+// RarbgConfig enables the search of the RARBG torrents, and their Torznab API
+type RarbgConfig struct {
+	Enabled       bool   `yaml:"enabled" toml:"enabled"`
+	TorznabAPIKey string `yaml:"torznab_api_key" toml:"torznab_api_key"`
+	SQLiteFile    string `yaml:"sqlite_file" toml:"sqlite_file"`
+}
+
+// TimeoutsConfig holds the durations after which slow operations are cancelled
+type TimeoutsConfig struct {
+	Request  duration `yaml:"request" toml:"request"`   // Storage and TMDB calls of a request, 0 to disable
+	Task     duration `yaml:"task" toml:"task"`         // Background task, such as adding a scanned file
+	TMDB     duration `yaml:"tmdb" toml:"tmdb"`         // Single request to TMDB
+	Shutdown duration `yaml:"shutdown" toml:"shutdown"` // Requests in progress when the server stops
+}
+
+// RefreshConfig holds how old the metadata can get before being refreshed
+type RefreshConfig struct {
+	FilmInterval    duration `yaml:"film_interval" toml:"film_interval"`
+	PersonInterval  duration `yaml:"person_interval" toml:"person_interval"`
+	RatingsInterval duration `yaml:"ratings_interval" toml:"ratings_interval"`
+	RateLimit       duration `yaml:"rate_limit" toml:"rate_limit"` // Default value, can be changed from the admin panel
+}
+
+// MetadataConfig holds the default metadata settings, used until other settings are saved from the admin panel
+type MetadataConfig struct {
+	Language               string   `yaml:"language" toml:"language"`
+	FallbackLanguage       string   `yaml:"fallback_language" toml:"fallback_language"`
+	CertificationCountries []string `yaml:"certification_countries" toml:"certification_countries"`
+}
+
+// duration is a time.Duration written as a Go duration in the configuration file, e.g. "30s"
+type duration time.Duration
+
+// UnmarshalText parses a Go duration
+func (d *duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// defaultConfig returns the configuration used for the values that are neither in the file nor in the environment
+func defaultConfig() Config {
+	return Config{
+		Port:          "8080",
+		CachePath:     "cache",
+		MediaInfoPath: "mediainfo",
+		LogLevel:      "info",
+		ItemsPerPage:  20,
+		Database: DatabaseConfig{
+			Backend:    DBBackendMongoDB,
+			SQLiteFile: "starfin.db",
+		},
+		Timeouts: TimeoutsConfig{
+			Request:  duration(30 * time.Second),
+			Task:     duration(business.DefaultTaskTimeout),
+			TMDB:     duration(10 * time.Second),
+			Shutdown: duration(30 * time.Second),
+		},
+		Refresh: RefreshConfig{
+			FilmInterval:    duration(business.DefaultRefreshSettings.FilmInterval),
+			PersonInterval:  duration(business.DefaultRefreshSettings.PersonInterval),
+			RatingsInterval: duration(business.DefaultRefreshSettings.RatingsInterval),
+			RateLimit:       duration(business.DefaultRefreshSettings.RateLimit),
+		},
+		Metadata: MetadataConfig{
+			Language:               infrastructure.DefaultMetadataSettings.Language,
+			FallbackLanguage:       infrastructure.DefaultMetadataSettings.FallbackLanguage,
+			CertificationCountries: slices.Clone(infrastructure.DefaultMetadataSettings.CertificationCountries),
+		},
+	}
+}
+
+// loadConfig reads the configuration file at path, if path is not empty, and the environment variables.
+// The configuration is validated, and all the invalid values are reported at once
+func loadConfig(path string) (*Config, error) {
+	config := defaultConfig()
+	if path != "" {
+		if err := config.readFile(path); err != nil {
+			return nil, fmt.Errorf("error while reading configuration file %s: %w", path, err)
+		}
+	}
+	if err := config.readEnv(); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return &config, nil
+}
+
+// readFile decodes a YAML or TOML file, depending on its extension. Unknown keys are rejected
+func (c *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		return decoder.Decode(c)
+	case ".toml":
+		decoder := toml.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(c)
+		// The default message does not tell which keys are unknown
+		var strictErr *toml.StrictMissingError
+		if errors.As(err, &strictErr) {
+			return errors.New(strictErr.String())
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown configuration format %q, expected .yaml, .yml or .toml", ext)
+	}
+}
+
+// readEnv overrides the configuration with the environment variables that are set and not empty
+func (c *Config) readEnv() error {
+	strs := map[string]*string{
+		EnvPort:                     &c.Port,
+		EnvCookieSecret:             &c.CookieSecret,
+		EnvCachePath:                &c.CachePath,
+		EnvMediaInfoPath:            &c.MediaInfoPath,
+		EnvLogLevel:                 &c.LogLevel,
+		EnvTMDBAPIKey:               &c.TMDBAPIKey,
+		EnvDBBackend:                &c.Database.Backend,
+		EnvDBURL:                    &c.Database.URL,
+		EnvDBPort:                   &c.Database.Port,
+		EnvDBName:                   &c.Database.Name,
+		EnvDBUser:                   &c.Database.User,
+		EnvDBPassword:               &c.Database.Password,
+		EnvSQLiteFile:               &c.Database.SQLiteFile,
+		EnvMemoryFixture:            &c.Database.MemoryFixture,
+		EnvTorznabAPIKey:            &c.Rarbg.TorznabAPIKey,
+		EnvRarbgSqliteFile:          &c.Rarbg.SQLiteFile,
+		EnvMetadataLanguage:         &c.Metadata.Language,
+		EnvMetadataFallbackLanguage: &c.Metadata.FallbackLanguage,
+	}
+	for env, str := range strs {
+		if value := os.Getenv(env); value != "" {
+			*str = value
+		}
+	}
+	if value := os.Getenv(EnvCertificationCountries); value != "" {
+		c.Metadata.CertificationCountries = strings.Split(value, ",")
+	}
+
+	var errs []error
+	if value := os.Getenv(EnvItemsPerPage); value != "" {
+		itemsPerPage, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error parsing env var %q: %w", EnvItemsPerPage, err))
+		}
+		c.ItemsPerPage = itemsPerPage
+	}
+	if value := os.Getenv(EnvEnableRarbg); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error parsing env var %q: %w", EnvEnableRarbg, err))
+		}
+		c.Rarbg.Enabled = enabled
+	}
+	durations := map[string]*duration{
+		EnvRequestTimeout:         &c.Timeouts.Request,
+		EnvTaskTimeout:            &c.Timeouts.Task,
+		EnvTMDBTimeout:            &c.Timeouts.TMDB,
+		EnvShutdownTimeout:        &c.Timeouts.Shutdown,
+		EnvRefreshFilmInterval:    &c.Refresh.FilmInterval,
+		EnvRefreshPersonInterval:  &c.Refresh.PersonInterval,
+		EnvRefreshRatingsInterval: &c.Refresh.RatingsInterval,
+		EnvRefreshRateLimit:       &c.Refresh.RateLimit,
+	}
+	for env, d := range durations {
+		if value := os.Getenv(env); value != "" {
+			if err := d.UnmarshalText([]byte(value)); err != nil {
+				errs = append(errs, fmt.Errorf("error parsing env var %q: %w", env, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the values of the configuration
+func (c Config) validate() error {
+	var errs []error
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid port %q", c.Port))
+	}
+	if c.CookieSecret == "" {
+		errs = append(errs, errors.New("the cookie secret is needed"))
+	}
+	if c.CachePath == "" {
+		errs = append(errs, errors.New("the cache path is needed"))
+	}
+	if c.MediaInfoPath == "" {
+		errs = append(errs, errors.New("the mediainfo path is needed"))
+	}
+
+	switch c.Database.Backend {
+	case DBBackendMongoDB:
+		if c.Database.URL == "" || c.Database.Name == "" {
+			errs = append(errs, errors.New("the MongoDB URL and database name are needed"))
+		}
+	case DBBackendSQLite:
+		if c.Database.SQLiteFile == "" {
+			errs = append(errs, errors.New("the SQLite file is needed"))
+		}
+	case DBBackendMemory:
+		if c.Rarbg.Enabled {
+			errs = append(errs, fmt.Errorf("RARBG torrents are not available with the %q database backend", c.Database.Backend))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown database backend %q, expected %q, %q or %q", c.Database.Backend, DBBackendMongoDB, DBBackendSQLite, DBBackendMemory))
+	}
+
+	if c.Timeouts.Request < 0 || c.Timeouts.Task < 0 || c.Timeouts.TMDB < 0 {
+		errs = append(errs, errors.New("the timeouts cannot be negative"))
+	}
+	if c.Timeouts.Shutdown <= 0 {
+		errs = append(errs, errors.New("the shutdown timeout must be positive"))
+	}
+	if c.Refresh.FilmInterval <= 0 || c.Refresh.PersonInterval <= 0 || c.Refresh.RatingsInterval <= 0 {
+		errs = append(errs, errors.New("the refresh intervals must be positive"))
+	}
+	if _, err := c.metadataSettings(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := business.ValidateServerSettings(c.serverSettings()); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// metadataSettings returns the default metadata settings
+func (c Config) metadataSettings() (*model.MetadataSettings, error) {
+	return business.ParseMetadataSettings(c.Metadata.Language, c.Metadata.FallbackLanguage, strings.Join(c.Metadata.CertificationCountries, ","))
+}
+
+// serverSettings returns the default server settings
+func (c Config) serverSettings() model.ServerSettings {
+	return model.ServerSettings{
+		LogLevel:         strings.ToLower(c.LogLevel),
+		TMDBAPIKey:       c.TMDBAPIKey,
+		ItemsPerPage:     c.ItemsPerPage,
+		RefreshRateLimit: time.Duration(c.Refresh.RateLimit),
+	}
+}
+
+// refreshSettings returns the settings of the background refreshes
+func (c Config) refreshSettings() business.RefreshSettings {
+	settings := business.DefaultRefreshSettings
+	settings.FilmInterval = time.Duration(c.Refresh.FilmInterval)
+	settings.PersonInterval = time.Duration(c.Refresh.PersonInterval)
+	settings.RatingsInterval = time.Duration(c.Refresh.RatingsInterval)
+	settings.RateLimit = time.Duration(c.Refresh.RateLimit)
+	settings.TaskTimeout = time.Duration(c.Timeouts.Task)
+	return settings
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clearEnv empties the environment variables of the configuration for the duration of the test
+func clearEnv(t *testing.T) {
+	for _, env := range []string{
+		EnvPort, EnvCookieSecret, EnvDBBackend, EnvSQLiteFile, EnvMemoryFixture, EnvDBURL, EnvDBPort, EnvDBName, EnvDBUser,
+		EnvDBPassword, EnvCachePath, EnvMediaInfoPath, EnvLogLevel, EnvTMDBAPIKey, EnvItemsPerPage, EnvRefreshFilmInterval,
+		EnvRefreshPersonInterval, EnvRefreshRatingsInterval, EnvRefreshRateLimit, EnvRequestTimeout, EnvTaskTimeout,
+		EnvTMDBTimeout, EnvShutdownTimeout, EnvMetadataLanguage, EnvMetadataFallbackLanguage, EnvCertificationCountries,
+		EnvEnableRarbg, EnvTorznabAPIKey, EnvRarbgSqliteFile,
+	} {
+		t.Setenv(env, "")
+	}
+}
+
+// writeConfigFile writes a configuration file in a temporary directory, and returns its path
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+// validConfig returns the default configuration, completed with the values that have no default
+func validConfig() Config {
+	config := defaultConfig()
+	config.CookieSecret = "secret"
+	config.Database.URL = "mongodb://localhost"
+	config.Database.Name = "starfin"
+	return config
+}
+
+// TestLoadConfig reads the same configuration from a YAML and a TOML file, the environment overriding the file
+// which overrides the defaults
+func TestLoadConfig(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+port: "9000"
+cookie_secret: secret
+database:
+  backend: sqlite
+  sqlite_file: library.db
+timeouts:
+  request: 1m
+metadata:
+  certification_countries: [FR, US]
+`,
+		"config.toml": `
+port = "9000"
+cookie_secret = "secret"
+
+[database]
+backend = "sqlite"
+sqlite_file = "library.db"
+
+[timeouts]
+request = "1m"
+
+[metadata]
+certification_countries = ["FR", "US"]
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv(EnvPort, "9100")
+			t.Setenv(EnvTaskTimeout, "10m")
+
+			config, err := loadConfig(writeConfigFile(t, name, content))
+			require.NoError(t, err)
+			assert.Equal(t, "9100", config.Port)
+			assert.Equal(t, "secret", config.CookieSecret)
+			assert.Equal(t, DBBackendSQLite, config.Database.Backend)
+			assert.Equal(t, "library.db", config.Database.SQLiteFile)
+			assert.Equal(t, duration(time.Minute), config.Timeouts.Request)
+			assert.Equal(t, duration(10*time.Minute), config.Timeouts.Task)
+			assert.Equal(t, []string{"FR", "US"}, config.Metadata.CertificationCountries)
+			// Neither in the file nor in the environment
+			assert.Equal(t, "cache", config.CachePath)
+			assert.Equal(t, duration(10*time.Second), config.Timeouts.TMDB)
+			assert.Equal(t, "en-US", config.Metadata.Language)
+		})
+	}
+
+	// Without a file, the environment is enough
+	clearEnv(t)
+	t.Setenv(EnvCookieSecret, "secret")
+	t.Setenv(EnvDBBackend, DBBackendMemory)
+	t.Setenv(EnvCertificationCountries, "FR,BE")
+	config, err := loadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, "8080", config.Port)
+	assert.Equal(t, []string{"FR", "BE"}, config.Metadata.CertificationCountries)
+
+	// The values of the file are validated too
+	_, err = loadConfig(writeConfigFile(t, "config.yaml", "port: \"0\"\ncookie_secret: secret\n"))
+	assert.ErrorContains(t, err, `invalid port "0"`)
+}
+
+// TestReadFile rejects the files that cannot be fully decoded
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		err     string
+	}{
+		{"unknown YAML key", "config.yaml", "port: \"9000\"\ncookie: secret\n", "cookie"},
+		{"unknown nested YAML key", "config.yml", "database:\n  host: localhost\n", "host"},
+		{"unknown TOML key", "config.toml", "port = \"9000\"\ncookie = \"secret\"\n", "cookie"},
+		{"unknown nested TOML key", "config.toml", "[database]\nhost = \"localhost\"\n", "host"},
+		{"bad YAML duration", "config.yaml", "timeouts:\n  request: soon\n", "soon"},
+		{"bad TOML duration", "config.toml", "[timeouts]\nrequest = \"soon\"\n", "soon"},
+		{"bad YAML value", "config.yaml", "items_per_page: many\n", "many"},
+		{"unknown format", "config.json", "{}", `unknown configuration format ".json"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultConfig()
+			err := config.readFile(writeConfigFile(t, test.file, test.content))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+
+	config := defaultConfig()
+	assert.Error(t, config.readFile(filepath.Join(t.TempDir(), "missing.yaml")))
+}
+
+// TestReadEnv reports all the environment variables that cannot be parsed together
+func TestReadEnv(t *testing.T) {
+	clearEnv(t)
+	t.Setenv(EnvItemsPerPage, "many")
+	t.Setenv(EnvEnableRarbg, "maybe")
+	t.Setenv(EnvRequestTimeout, "soon")
+	t.Setenv(EnvRefreshRateLimit, "2")
+	t.Setenv(EnvShutdownTimeout, "1m")
+
+	config := defaultConfig()
+	err := config.readEnv()
+	for _, env := range []string{EnvItemsPerPage, EnvEnableRarbg, EnvRequestTimeout, EnvRefreshRateLimit} {
+		assert.ErrorContains(t, err, env)
+	}
+	assert.NotContains(t, err.Error(), EnvShutdownTimeout)
+	assert.Equal(t, duration(time.Minute), config.Timeouts.Shutdown)
+}
+
+// TestValidate rejects each invalid value, and reports them all together
+func TestValidate(t *testing.T) {
+	require.NoError(t, validConfig().validate())
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		err    string
+	}{
+		{"port not a number", func(c *Config) { c.Port = "http" }, `invalid port "http"`},
+		{"port out of range", func(c *Config) { c.Port = "65536" }, `invalid port "65536"`},
+		{"no cookie secret", func(c *Config) { c.CookieSecret = "" }, "the cookie secret is needed"},
+		{"no cache path", func(c *Config) { c.CachePath = "" }, "the cache path is needed"},
+		{"no mediainfo path", func(c *Config) { c.MediaInfoPath = "" }, "the mediainfo path is needed"},
+		{"unknown backend", func(c *Config) { c.Database.Backend = "postgres" }, `unknown database backend "postgres"`},
+		{"no MongoDB URL", func(c *Config) { c.Database.URL = "" }, "the MongoDB URL and database name are needed"},
+		{"no SQLite file", func(c *Config) { c.Database.Backend, c.Database.SQLiteFile = DBBackendSQLite, "" }, "the SQLite file is needed"},
+		{"RARBG in memory", func(c *Config) { c.Database.Backend, c.Rarbg.Enabled = DBBackendMemory, true }, "RARBG torrents are not available"},
+		{"negative timeout", func(c *Config) { c.Timeouts.TMDB = duration(-time.Second) }, "the timeouts cannot be negative"},
+		{"no shutdown timeout", func(c *Config) { c.Timeouts.Shutdown = 0 }, "the shutdown timeout must be positive"},
+		{"no refresh interval", func(c *Config) { c.Refresh.RatingsInterval = 0 }, "the refresh intervals must be positive"},
+		{"bad metadata language", func(c *Config) { c.Metadata.Language = "english!" }, "invalid metadata language"},
+		{"no certification country", func(c *Config) { c.Metadata.CertificationCountries = nil }, "at least one certification country is needed"},
+		{"bad log level", func(c *Config) { c.LogLevel = "verbose" }, "invalid log level 'verbose'"},
+		{"no items per page", func(c *Config) { c.ItemsPerPage = 0 }, "the number of items per page must be between 1 and"},
+		{"no refresh rate limit", func(c *Config) { c.Refresh.RateLimit = 0 }, "the refresh rate limit must be positive"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validConfig()
+			test.change(&config)
+			assert.ErrorContains(t, config.validate(), test.err)
+		})
+	}
+
+	// The log level is not case sensitive
+	config := validConfig()
+	config.LogLevel = "DEBUG"
+	assert.NoError(t, config.validate())
+
+	// Every invalid value is reported at once
+	config = validConfig()
+	for _, test := range tests[1:6] {
+		test.change(&config)
+	}
+	config.LogLevel = "verbose"
+	err := config.validate()
+	for _, test := range tests[1:6] {
+		assert.ErrorContains(t, err, test.err)
+	}
+	assert.ErrorContains(t, err, "invalid log level")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+	"github.com/Agurato/starfin/internal/service/server"
+)
+
+// Database backends, selected in the configuration
+const (
+	DBBackendMongoDB = "mongodb"
+	DBBackendSQLite  = "sqlite"
+	DBBackendMemory  = "memory" // Nothing is persisted, for demos
+)
+
+// database is implemented by the storage backends, and stores everything managers and handlers need
+type database interface {
+	business.FilmStorer
+	business.FileStorer
+	business.UserStorer
+	business.VolumeStorer
+	business.PersonStorer
+	business.SearchStorer
+	business.GraphStorer
+	business.CollectionStorer
+	business.SmartCollectionStorer
+	business.HomeStorer
+	business.RefreshStorer
+	business.SettingsStorer
+	server.OwnerStorer
+	server.TorrentStorer
+
+	Migrate(ctx context.Context, dryRun bool) ([]model.Migration, error)
+	MergeDuplicates(ctx context.Context, dryRun bool) ([]model.Duplicate, error)
+	Close() error
+}
+
+// openDatabase opens the database of the configured backend.
+// The RARBG torrents are stored in a separate database, which is only opened if enableRarbg is set
+func openDatabase(ctx context.Context, config *Config, enableRarbg bool) (database, error) {
+	switch backend := config.Database.Backend; backend {
+	case DBBackendMongoDB:
+		db := infrastructure.NewMongoDB(
+			config.Database.User,
+			config.Database.Password,
+			config.Database.URL,
+			config.Database.Port,
+			config.Database.Name)
+		if enableRarbg {
+			db.InitRarbg("rarbg")
+		}
+		return db, nil
+	case DBBackendSQLite:
+		db, err := infrastructure.NewSQLite(config.Database.SQLiteFile)
+		if err != nil {
+			return nil, err
+		}
+		if enableRarbg {
+			if err := db.InitRarbg(config.Rarbg.SQLiteFile); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+		return db, nil
+	case DBBackendMemory:
+		if enableRarbg {
+			return nil, fmt.Errorf("RARBG torrents are not available with the %q database backend", backend)
+		}
+		db := infrastructure.NewMemory()
+		if fixture := config.Database.MemoryFixture; fixture != "" {
+			if err := db.LoadFixture(ctx, fixture); err != nil {
+				return nil, err
+			}
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown database backend %q, expected %q, %q or %q", backend, DBBackendMongoDB, DBBackendSQLite, DBBackendMemory)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// doctorTimeout is the maximum duration of each check
+const doctorTimeout = time.Minute
+
+// doctor runs the checks, prints their results, and counts the failed ones
+type doctor struct {
+	failed int
+}
+
+// check runs a check and prints its result, with the way to fix it if it failed
+func (d *doctor) check(name string, run func(ctx context.Context) error) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	if err := run(ctx); err != nil {
+		d.failed++
+		fmt.Printf("FAIL %s: %v\n", name, err)
+		return false
+	}
+	fmt.Printf("OK   %s\n", name)
+	return true
+}
+
+// skip prints a check that cannot be run because a previous one failed
+func (d *doctor) skip(name, reason string) {
+	fmt.Printf("SKIP %s: %s\n", name, reason)
+}
+
+// runDoctor checks the installation, without changing anything but the cache directory, which is created if needed
+func runDoctor(config *Config, args []string) error {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: starfin doctor")
+	}
+
+	d := &doctor{}
+	d.run(config)
+	if d.failed > 0 {
+		return fmt.Errorf("%d checks failed", d.failed)
+	}
+	return nil
+}
+
+// run runs the checks, skipping the ones depending on a failed check
+func (d *doctor) run(config *Config) {
+	d.check("mediainfo executable", func(ctx context.Context) error {
+		if _, err := exec.LookPath(config.MediaInfoPath); err != nil {
+			return fmt.Errorf("%w, set its path with %s", err, EnvMediaInfoPath)
+		}
+		return nil
+	})
+	cacheWritable := d.check("cache directory", func(ctx context.Context) error {
+		if err := os.MkdirAll(config.CachePath, 0o755); err != nil {
+			return err
+		}
+		file, err := os.CreateTemp(config.CachePath, ".doctor")
+		if err != nil {
+			return err
+		}
+		file.Close()
+		return os.Remove(file.Name())
+	})
+
+	var db database
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+	if !d.check("database", func(ctx context.Context) (err error) {
+		if db, err = openDatabase(ctx, config, config.Rarbg.Enabled); err != nil {
+			return err
+		}
+		// Opening the database does not always connect to it
+		_, err = db.GetUserNb(ctx)
+		return err
+	}) {
+		return
+	}
+
+	migrated := d.check("migrations", func(ctx context.Context) error {
+		migrations, err := db.Migrate(ctx, true)
+		if err == nil && len(migrations) > 0 {
+			err = fmt.Errorf("%d migrations are pending, apply them with the migrate command", len(migrations))
+		}
+		return err
+	})
+
+	d.check("owner", func(ctx context.Context) error {
+		ownerPresent, err := db.IsOwnerPresent(ctx)
+		if err == nil && !ownerPresent {
+			err = errors.New("there is no owner, add one with the user add command")
+		}
+		return err
+	})
+
+	d.check("volumes", func(ctx context.Context) error {
+		volumes, err := db.GetVolumes(ctx)
+		if err != nil {
+			return err
+		}
+		var errs []error
+		for _, volume := range volumes {
+			if fileInfo, err := os.Stat(volume.Path); err != nil {
+				errs = append(errs, fmt.Errorf("volume %q: %w", volume.Name, err))
+			} else if !fileInfo.IsDir() {
+				errs = append(errs, fmt.Errorf("volume %q: %s is not a directory", volume.Name, volume.Path))
+			}
+		}
+		return errors.Join(errs...)
+	})
+
+	d.check("duplicates", func(ctx context.Context) error {
+		duplicates, err := db.MergeDuplicates(ctx, true)
+		if err == nil && len(duplicates) > 0 {
+			err = fmt.Errorf("%d films, people, collections or files are stored several times, list them with 'merge-duplicates -dry-run' and merge them with the merge-duplicates command", len(duplicates))
+		}
+		return err
+	})
+
+	// The library is loaded like the server loads it, with the settings saved from the admin panel
+	var lib *library
+	switch {
+	case !migrated:
+		d.skip("library", "the database is not migrated")
+	case !cacheWritable:
+		d.skip("library", "the cache directory is not writable")
+	default:
+		d.check("library", func(ctx context.Context) (err error) {
+			lib, err = newLibrary(ctx, config, db)
+			return err
+		})
+	}
+	if lib == nil {
+		d.skip("TMDB API key", "the library is not loaded")
+		return
+	}
+	defer lib.fw.Stop()
+	d.check("TMDB API key", lib.metadata.CheckTMDBAPIKey)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// runExport writes the library, the users and the settings to a JSON file, in the format of the fixtures of the memory
+// backend. The file holds the password hashes of the users, so it is only readable by its owner
+func runExport(config *Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: starfin export <file>")
+	}
+
+	ctx := context.Background()
+	db, err := openDatabase(ctx, config, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// The documents are exported as they are read by the current version
+	if migrations, err := db.Migrate(ctx, true); err != nil {
+		return err
+	} else if len(migrations) > 0 {
+		return fmt.Errorf("%d migrations are pending, apply them with the migrate command first", len(migrations))
+	}
+
+	fixture, err := exportFixture(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error while encoding export: %w", err)
+	}
+	if err := os.WriteFile(flags.Arg(0), data, 0o600); err != nil {
+		return fmt.Errorf("error while writing export: %w", err)
+	}
+	fmt.Printf("Exported %d users, %d volumes, %d films, %d people, %d collections and %d smart collections to %s\n",
+		len(fixture.Users), len(fixture.Volumes), len(fixture.Films), len(fixture.People), len(fixture.Collections),
+		len(fixture.SmartCollections), flags.Arg(0))
+	return nil
+}
+
+// runImport adds the documents of a JSON file written by the export command to an empty database
+func runImport(config *Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: starfin import <file>")
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("error while reading import: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var fixture infrastructure.MemoryFixture
+	if err := decoder.Decode(&fixture); err != nil {
+		return fmt.Errorf("error while decoding import: %w", err)
+	}
+	if err := validateFixture(&fixture); err != nil {
+		return fmt.Errorf("invalid import: %w", err)
+	}
+
+	ctx := context.Background()
+	db, err := openDatabase(ctx, config, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Migrate(ctx, false); err != nil {
+		return err
+	}
+	// The IDs of the imported documents would collide with the ones already stored
+	if userNb, err := db.GetUserNb(ctx); err != nil {
+		return err
+	} else if films, err := db.GetFilms(ctx); err != nil {
+		return err
+	} else if userNb > 0 || len(films) > 0 {
+		return errors.New("the database is not empty, the import is only done into a new database")
+	}
+
+	if err := importFixture(ctx, db, &fixture); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d users, %d volumes, %d films, %d people, %d collections and %d smart collections\n",
+		len(fixture.Users), len(fixture.Volumes), len(fixture.Films), len(fixture.People), len(fixture.Collections),
+		len(fixture.SmartCollections))
+	return nil
+}
+
+// exportFixture reads the library, the users and the settings of the database
+func exportFixture(ctx context.Context, db database) (*infrastructure.MemoryFixture, error) {
+	var (
+		fixture infrastructure.MemoryFixture
+		err     error
+	)
+	if fixture.Users, err = db.GetUsers(ctx); err != nil {
+		return nil, err
+	}
+	if fixture.Volumes, err = db.GetVolumes(ctx); err != nil {
+		return nil, err
+	}
+	if fixture.Films, err = db.GetFilms(ctx); err != nil {
+		return nil, err
+	}
+	if fixture.People, err = db.GetPeople(ctx); err != nil {
+		return nil, err
+	}
+	collections, err := db.GetCollectionSummaries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, collection := range collections {
+		fixture.Collections = append(fixture.Collections, collection.Collection)
+	}
+	for _, user := range fixture.Users {
+		smartCollections, err := db.GetSmartCollections(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		fixture.SmartCollections = append(fixture.SmartCollections, smartCollections...)
+	}
+	// The settings that were never saved from the admin panel are left out
+	if settings, err := db.GetMetadataSettings(ctx); err == nil {
+		fixture.MetadataSettings = settings
+	}
+	if settings, err := db.GetHomeSettings(ctx); err == nil {
+		fixture.HomeSettings = settings
+	}
+	if settings, err := db.GetServerSettings(ctx); err == nil {
+		fixture.ServerSettings = settings
+	}
+	return &fixture, nil
+}
+
+// validateFixture checks that the documents of an import can all be stored, so that it does not fail halfway.
+// The documents must have IDs, and must be unique like the indexes of the storage backends require
+func validateFixture(fixture *infrastructure.MemoryFixture) error {
+	ids := make(map[primitive.ObjectID]bool)
+	checkID := func(kind, name string, id primitive.ObjectID) error {
+		if id.IsZero() {
+			return fmt.Errorf("%s %q has no ID", kind, name)
+		}
+		if ids[id] {
+			return fmt.Errorf("%s %q has the ID %s of another document", kind, name, id.Hex())
+		}
+		ids[id] = true
+		return nil
+	}
+
+	userNames := make(map[string]bool)
+	for _, user := range fixture.Users {
+		if err := checkID("user", user.Name, user.ID); err != nil {
+			return err
+		}
+		if userNames[user.Name] {
+			return fmt.Errorf("user %q is there several times", user.Name)
+		}
+		userNames[user.Name] = true
+	}
+	for _, volume := range fixture.Volumes {
+		if err := checkID("volume", volume.Name, volume.ID); err != nil {
+			return err
+		}
+	}
+	filmTMDBIDs := make(map[int]bool)
+	paths := make(map[string]bool)
+	for _, film := range fixture.Films {
+		if err := checkID("film", film.Title, film.ID); err != nil {
+			return err
+		}
+		// The films not matched on TMDB all have the ID 0
+		if film.TMDBID > 0 && filmTMDBIDs[film.TMDBID] {
+			return fmt.Errorf("film %q has the TMDB ID %d of another film", film.Title, film.TMDBID)
+		}
+		filmTMDBIDs[film.TMDBID] = true
+		for _, volumeFile := range film.VolumeFiles {
+			if paths[volumeFile.Path] {
+				return fmt.Errorf("file %q is in several films", volumeFile.Path)
+			}
+			paths[volumeFile.Path] = true
+			if !ids[volumeFile.FromVolume] {
+				return fmt.Errorf("file %q is in the unknown volume %s", volumeFile.Path, volumeFile.FromVolume.Hex())
+			}
+		}
+	}
+	personTMDBIDs := make(map[int64]bool)
+	for _, person := range fixture.People {
+		if err := checkID("person", person.Name, person.ID); err != nil {
+			return err
+		}
+		if personTMDBIDs[person.TMDBID] {
+			return fmt.Errorf("person %q has the TMDB ID %d of another person", person.Name, person.TMDBID)
+		}
+		personTMDBIDs[person.TMDBID] = true
+	}
+	collectionTMDBIDs := make(map[int64]bool)
+	for _, collection := range fixture.Collections {
+		if err := checkID("collection", collection.Name, collection.ID); err != nil {
+			return err
+		}
+		if collectionTMDBIDs[collection.TMDBID] {
+			return fmt.Errorf("collection %q has the TMDB ID %d of another collection", collection.Name, collection.TMDBID)
+		}
+		collectionTMDBIDs[collection.TMDBID] = true
+	}
+	for _, collection := range fixture.SmartCollections {
+		if !slices.ContainsFunc(fixture.Users, func(user model.User) bool { return user.ID == collection.UserID }) {
+			return fmt.Errorf("smart collection %q belongs to the unknown user %s", collection.Name, collection.UserID.Hex())
+		}
+	}
+	return nil
+}
+
+// importFixture adds the documents of a valid fixture to an empty database.
+// If one of them cannot be stored, the documents already imported are deleted, so that the import can be run again
+func importFixture(ctx context.Context, db database, fixture *infrastructure.MemoryFixture) (err error) {
+	var imported infrastructure.MemoryFixture
+	defer func() {
+		if err != nil {
+			if cleanupErr := deleteImported(ctx, db, &imported); cleanupErr != nil {
+				err = fmt.Errorf("%w, and the documents already imported could not all be deleted: %w", err, cleanupErr)
+			}
+		}
+	}()
+
+	for _, user := range fixture.Users {
+		user := user
+		if err := db.CreateUser(ctx, &user); err != nil {
+			return fmt.Errorf("error while importing user %q: %w", user.Name, err)
+		}
+		imported.Users = append(imported.Users, user)
+	}
+	for _, volume := range fixture.Volumes {
+		volume := volume
+		if err := db.AddVolume(ctx, &volume); err != nil {
+			return fmt.Errorf("error while importing volume %q: %w", volume.Name, err)
+		}
+		imported.Volumes = append(imported.Volumes, volume)
+	}
+	for _, film := range fixture.Films {
+		film := film
+		if err := db.AddFilm(ctx, &film); err != nil {
+			return fmt.Errorf("error while importing film %q: %w", film.Title, err)
+		}
+		imported.Films = append(imported.Films, film)
+	}
+	for _, person := range fixture.People {
+		person := person
+		// Unlike AddPerson, UpdatePerson reports the errors, and adds the person as the database is empty
+		if err := db.UpdatePerson(ctx, &person); err != nil {
+			return fmt.Errorf("error while importing person %q: %w", person.Name, err)
+		}
+		imported.People = append(imported.People, person)
+	}
+	// The collections and the settings are replaced when they are imported again, so they are not deleted
+	for _, collection := range fixture.Collections {
+		collection := collection
+		if err := db.AddCollection(ctx, &collection); err != nil {
+			return fmt.Errorf("error while importing collection %q: %w", collection.Name, err)
+		}
+	}
+	for _, collection := range fixture.SmartCollections {
+		collection := collection
+		if err := db.AddSmartCollection(ctx, &collection); err != nil {
+			return fmt.Errorf("error while importing smart collection %q: %w", collection.Name, err)
+		}
+		imported.SmartCollections = append(imported.SmartCollections, collection)
+	}
+	if fixture.MetadataSettings != nil {
+		if err := db.SetMetadataSettings(ctx, fixture.MetadataSettings); err != nil {
+			return err
+		}
+	}
+	if fixture.HomeSettings != nil {
+		if err := db.SetHomeSettings(ctx, fixture.HomeSettings); err != nil {
+			return err
+		}
+	}
+	if fixture.ServerSettings != nil {
+		if err := db.SetServerSettings(ctx, fixture.ServerSettings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteImported deletes the documents of a failed import, in the reverse order of their import
+func deleteImported(ctx context.Context, db database, imported *infrastructure.MemoryFixture) error {
+	var errs []error
+	for _, collection := range imported.SmartCollections {
+		errs = append(errs, db.DeleteSmartCollection(ctx, collection.ID))
+	}
+	for _, person := range imported.People {
+		errs = append(errs, db.DeletePerson(ctx, person.TMDBID))
+	}
+	for _, film := range imported.Films {
+		errs = append(errs, db.DeleteFilm(ctx, film.ID))
+	}
+	for _, volume := range imported.Volumes {
+		errs = append(errs, db.DeleteVolume(ctx, volume.ID))
+	}
+	for _, user := range imported.Users {
+		errs = append(errs, db.DeleteUser(ctx, user.ID))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// newExportedLibrary returns the export of a library holding a document of each kind
+func newExportedLibrary(t *testing.T) *infrastructure.MemoryFixture {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	owner := model.User{ID: primitive.NewObjectID(), Name: "owner", Password: "hash", IsOwner: true, IsAdmin: true}
+	require.NoError(t, db.CreateUser(ctx, &owner))
+	require.NoError(t, db.CreateUser(ctx, &model.User{ID: primitive.NewObjectID(), Name: "guest", Password: "hash"}))
+	volume := model.Volume{ID: primitive.NewObjectID(), Name: "Films", Path: "/films", IsRecursive: true, MediaType: "Movie"}
+	require.NoError(t, db.AddVolume(ctx, &volume))
+	for _, film := range []model.Film{
+		{ID: primitive.NewObjectID(), TMDBID: 949, Title: "Heat", ReleaseYear: 1995, Directors: []int64{638},
+			Characters:  []model.Character{{CharacterName: "Lt. Vincent Hanna", ActorID: 1158}},
+			VolumeFiles: []model.VolumeFile{{Path: "/films/Heat.1995.mkv", FromVolume: volume.ID}},
+			DateAdded:   time.Date(2024, 3, 10, 20, 30, 0, 0, time.UTC)},
+		{ID: primitive.NewObjectID(), TMDBID: 348, Title: "Alien", ReleaseYear: 1979, CollectionID: 8091,
+			VolumeFiles: []model.VolumeFile{{Path: "/films/Alien.1979.mkv", FromVolume: volume.ID}}},
+	} {
+		film := film
+		require.NoError(t, db.AddFilm(ctx, &film))
+	}
+	db.AddPerson(ctx, &model.Person{ID: primitive.NewObjectID(), TMDBID: 638, Name: "Michael Mann"})
+	db.AddPerson(ctx, &model.Person{ID: primitive.NewObjectID(), TMDBID: 1158, Name: "Al Pacino"})
+	require.NoError(t, db.AddCollection(ctx, &model.Collection{ID: primitive.NewObjectID(), TMDBID: 8091, Name: "Alien Collection"}))
+	require.NoError(t, db.AddSmartCollection(ctx, &model.SmartCollection{UserID: owner.ID, Name: "Mann", Query: `director:"Michael Mann"`}))
+	require.NoError(t, db.SetMetadataSettings(ctx, &model.MetadataSettings{Language: "fr-FR", FallbackLanguage: "en-US"}))
+
+	fixture, err := exportFixture(ctx, db)
+	require.NoError(t, err)
+	return fixture
+}
+
+// TestExportImport imports an export through its JSON encoding, and exports the same library again
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	exported := newExportedLibrary(t)
+	data, err := json.Marshal(exported)
+	require.NoError(t, err)
+	var fixture infrastructure.MemoryFixture
+	require.NoError(t, json.Unmarshal(data, &fixture))
+	require.NoError(t, validateFixture(&fixture))
+
+	db := infrastructure.NewMemory()
+	require.NoError(t, importFixture(ctx, db, &fixture))
+	reexported, err := exportFixture(ctx, db)
+	require.NoError(t, err)
+
+	// The smart collections get new IDs when they are added
+	require.Len(t, reexported.SmartCollections, 1)
+	for _, f := range []*infrastructure.MemoryFixture{exported, reexported} {
+		for i := range f.SmartCollections {
+			f.SmartCollections[i].ID = primitive.NilObjectID
+		}
+	}
+	assert.Equal(t, exported, reexported)
+}
+
+// TestValidateFixture rejects the imports whose documents could not all be stored
+func TestValidateFixture(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(f *infrastructure.MemoryFixture)
+	}{
+		{"user without ID", func(f *infrastructure.MemoryFixture) { f.Users[1].ID = primitive.NilObjectID }},
+		{"same user name", func(f *infrastructure.MemoryFixture) { f.Users[1].Name = f.Users[0].Name }},
+		{"same ID", func(f *infrastructure.MemoryFixture) { f.Films[1].ID = f.Volumes[0].ID }},
+		{"same film TMDB ID", func(f *infrastructure.MemoryFixture) { f.Films[1].TMDBID = f.Films[0].TMDBID }},
+		{"file in several films", func(f *infrastructure.MemoryFixture) { f.Films[1].VolumeFiles[0].Path = f.Films[0].VolumeFiles[0].Path }},
+		{"unknown volume", func(f *infrastructure.MemoryFixture) { f.Films[1].VolumeFiles[0].FromVolume = primitive.NewObjectID() }},
+		{"same person TMDB ID", func(f *infrastructure.MemoryFixture) { f.People[1].TMDBID = f.People[0].TMDBID }},
+		{"unknown smart collection user", func(f *infrastructure.MemoryFixture) { f.SmartCollections[0].UserID = primitive.NewObjectID() }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fixture := newExportedLibrary(t)
+			require.NoError(t, validateFixture(fixture))
+			test.change(fixture)
+			assert.Error(t, validateFixture(fixture))
+		})
+	}
+
+	// The films not matched on TMDB all have the TMDB ID 0
+	fixture := newExportedLibrary(t)
+	fixture.Films[0].TMDBID, fixture.Films[1].TMDBID = 0, 0
+	assert.NoError(t, validateFixture(fixture))
+}
+
+// failingDatabase fails to store the smart collections
+type failingDatabase struct {
+	database
+}
+
+func (failingDatabase) AddSmartCollection(ctx context.Context, collection *model.SmartCollection) error {
+	return errors.New("disk full")
+}
+
+// TestImportCleanup deletes the documents already imported when the import fails, so that it can be run again
+func TestImportCleanup(t *testing.T) {
+	ctx := context.Background()
+	fixture := newExportedLibrary(t)
+	db := infrastructure.NewMemory()
+	err := importFixture(ctx, failingDatabase{db}, fixture)
+	assert.ErrorContains(t, err, "disk full")
+
+	userNb, err := db.GetUserNb(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, userNb)
+	volumes, err := db.GetVolumes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, volumes)
+	films, err := db.GetFilms(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, films)
+	people, err := db.GetPeople(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, people)
+
+	require.NoError(t, importFixture(ctx, db, fixture))
+	reexported, err := exportFixture(ctx, db)
+	require.NoError(t, err)
+	assert.Len(t, reexported.Films, 2)
+	assert.Len(t, reexported.SmartCollections, 1)
+}
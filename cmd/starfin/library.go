@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/infrastructure"
+)
+
+// library holds the managers of the films of the library, which are shared by the server and the commands changing it
+type library struct {
+	cache       *infrastructure.Cache
+	metadata    *infrastructure.MetadataWrapper
+	filterer    *business.Filterer
+	searchIndex *business.SearchIndex
+
+	fm        *business.FilmManager
+	sem       *business.SearchManager
+	refresher *business.Refresher
+	paginater *business.Paginater
+	sm        *business.SettingsManager
+	fw        *business.FileWatcher
+	vm        *business.VolumeManager
+}
+
+// newLibrary instantiates the managers of the library stored in db, with the settings saved from the admin panel.
+// The file events are handled until ctx is done or the file watcher is stopped, but the volumes are not watched
+// until they are synchronized
+func newLibrary(ctx context.Context, config *Config, db database) (*library, error) {
+	c := infrastructure.NewCache(config.CachePath)
+	metadata := infrastructure.NewMetadataWrapper(config.MediaInfoPath, time.Duration(config.Timeouts.TMDB))
+
+	filterer := business.NewFilterer()
+	searchIndex := business.NewSearchIndex()
+	fm := business.NewFilmManager(db, c, metadata, filterer, searchIndex)
+	filterer.AddFilms(fm.GetFilms(ctx))
+	sem := business.NewSearchManager(db, searchIndex)
+	if err := sem.BuildIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	refreshSettings := config.refreshSettings()
+	refresher := business.NewRefresher(db, metadata, fm, searchIndex, c, refreshSettings)
+	paginater := business.NewPaginater(config.ItemsPerPage)
+
+	// The settings must be loaded before any film is fetched
+	metadataSettings, _ := config.metadataSettings()
+	sm := business.NewSettingsManager(db, metadata, refresher, paginater, *metadataSettings, config.serverSettings())
+	sm.LoadMetadataSettings(ctx)
+	if err := sm.LoadServerSettings(ctx); err != nil {
+		return nil, err
+	}
+
+	fw := business.NewFileWatcher(ctx, db, fm, metadata, refreshSettings.TaskTimeout)
+	vm := business.NewVolumeManager(db, fw, fm, metadata, refreshSettings.TaskTimeout)
+
+	return &library{
+		cache:       c,
+		metadata:    metadata,
+		filterer:    filterer,
+		searchIndex: searchIndex,
+		fm:          fm,
+		sem:         sem,
+		refresher:   refresher,
+		paginater:   paginater,
+		sm:          sm,
+		fw:          fw,
+		vm:          vm,
+	}, nil
+}
+
+// withLibrary opens and migrates the database, and runs a command changing the library.
+// The images of the films that are not cached when the command ends can be cached from the admin panel
+func withLibrary(config *Config, run func(ctx context.Context, db database, lib *library) error) (err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := openDatabase(ctx, config, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := db.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("error while closing the database: %w", closeErr))
+		}
+	}()
+	if _, err := db.Migrate(ctx, false); err != nil {
+		return err
+	}
+
+	lib, err := newLibrary(ctx, config, db)
+	if err != nil {
+		return err
+	}
+	defer lib.fw.Stop()
+	return run(ctx, db, lib)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// runMergeDuplicates merges the films, people and collections stored several times, or lists them with -dry-run.
+// A file that is in films having different TMDB IDs is only moved to the film given with -keep
+func runMergeDuplicates(config *Config, args []string) error {
+	flags := flag.NewFlagSet("merge-duplicates", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list the duplicates without merging them")
+	keep := flags.String("keep", "", "ID of the film that keeps the files it shares with films having other TMDB IDs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var keptFilmID primitive.ObjectID
+	if *keep != "" {
+		var err error
+		if keptFilmID, err = primitive.ObjectIDFromHex(*keep); err != nil {
+			return fmt.Errorf("incorrect film ID: %w", err)
+		}
+	}
+
+	ctx := context.Background()
+	db, err := openDatabase(ctx, config, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	duplicates, err := db.MergeDuplicates(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+	var merged, conflicts int
+	for _, duplicate := range duplicates {
+		if duplicate.Kind != model.DuplicateFile {
+			merged++
+			fmt.Printf("%s %q (TMDB ID %d) is stored %d times, kept as %s, deleting %s\n",
+				duplicate.Kind, duplicate.Name, duplicate.TMDBID, len(duplicate.IDs), duplicate.IDs[0].Hex(), hexIDs(duplicate.IDs[1:]))
+			continue
+		}
+		if !slices.Contains(duplicate.IDs, keptFilmID) {
+			conflicts++
+			fmt.Printf("file %q is in the films %s, which have different TMDB IDs: keep it in one of them with -keep <film-id>\n",
+				duplicate.Name, hexIDs(duplicate.IDs))
+			continue
+		}
+		if err := keepFile(ctx, db, duplicate, keptFilmID, *dryRun); err != nil {
+			return err
+		}
+	}
+	if len(duplicates) == 0 {
+		fmt.Println("There are no duplicates")
+	} else if !*dryRun {
+		fmt.Printf("%d duplicates merged\n", merged)
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%d files are in several films", conflicts)
+	}
+	return nil
+}
+
+// keepFile removes a file from the films other than the one keeping it, and deletes the films left without files.
+// With dryRun, the changes are only printed
+func keepFile(ctx context.Context, db database, duplicate model.Duplicate, keptFilmID primitive.ObjectID, dryRun bool) error {
+	for _, filmID := range duplicate.IDs {
+		if filmID == keptFilmID {
+			continue
+		}
+		film, err := db.GetFilmFromID(ctx, filmID)
+		if err != nil {
+			return err
+		}
+		film.VolumeFiles = slices.DeleteFunc(film.VolumeFiles, func(vf model.VolumeFile) bool { return vf.Path == duplicate.Name })
+		if len(film.VolumeFiles) == 0 {
+			fmt.Printf("file %q is kept in film %s, deleting film %s %q which has no other file\n", duplicate.Name, keptFilmID.Hex(), filmID.Hex(), film.Title)
+			if !dryRun {
+				if err := db.DeleteFilm(ctx, filmID); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		fmt.Printf("file %q is kept in film %s, removing it from film %s %q\n", duplicate.Name, keptFilmID.Hex(), filmID.Hex(), film.Title)
+		if !dryRun {
+			if err := db.AddFilm(ctx, film); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hexIDs returns the hexadecimal IDs of documents, separated by commas
+func hexIDs(ids []primitive.ObjectID) string {
+	var hex string
+	for i, id := range ids {
+		if i > 0 {
+			hex += ", "
+		}
+		hex += id.Hex()
+	}
+	return hex
+}
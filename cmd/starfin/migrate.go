@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+// runMigrate applies the pending migrations of the database, or lists them with -dry-run
+func runMigrate(config *Config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list the pending migrations without applying them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db, err := openDatabase(ctx, config, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrations, err := db.Migrate(ctx, *dryRun)
+	for _, migration := range migrations {
+		if *dryRun {
+			fmt.Printf("Pending migration %d: %s\n", migration.Version, migration.Description)
+		} else {
+			fmt.Printf("Applied migration %d: %s\n", migration.Version, migration.Description)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		fmt.Println("The database is up to date")
+	}
+	return nil
+}
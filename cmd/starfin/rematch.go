@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// runRematch searches TMDB again for a film, or matches it with the TMDB film of a link
+func runRematch(config *Config, args []string) error {
+	flags := flag.NewFlagSet("rematch", flag.ContinueOnError)
+	link := flags.String("link", "", "TMDB, IMDb or Letterboxd link of the film, instead of searching its file name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: starfin rematch [-link <url>] <film-id>")
+	}
+	filmID := flags.Arg(0)
+
+	return withLibrary(config, func(ctx context.Context, db database, lib *library) error {
+		var newID string
+		var err error
+		if *link != "" {
+			newID, err = lib.fm.EditFilmWithLink(ctx, filmID, *link)
+		} else {
+			newID, err = lib.fm.RematchFilm(ctx, filmID)
+		}
+		if err != nil {
+			return err
+		}
+		film, err := lib.fm.GetFilm(ctx, newID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Film %s matched with %q (TMDB ID %d)\n", newID, film.Title, film.TMDBID)
+		return nil
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// runScan synchronizes the library with the files of a volume, from its ID or its name
+func runScan(config *Config, args []string) error {
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: starfin scan <volume>")
+	}
+
+	return withLibrary(config, func(ctx context.Context, db database, lib *library) error {
+		volume, err := findVolume(ctx, db, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		filmCount := len(db.GetFilmsFromVolume(ctx, volume.ID))
+		if err := lib.vm.ScanVolume(ctx, volume.ID.Hex()); err != nil {
+			return err
+		}
+		fmt.Printf("Volume %q scanned: %d films before, %d films now\n", volume.Name, filmCount, len(db.GetFilmsFromVolume(ctx, volume.ID)))
+		return nil
+	})
+}
@@ -1,116 +1,276 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/Agurato/starfin/internal/business"
-	"github.com/Agurato/starfin/internal/infrastructure"
-	"github.com/Agurato/starfin/internal/model"
 	"github.com/Agurato/starfin/internal/service/server"
 )
 
-// Environment variables names
+// Environment variables names, which override the values of the configuration file
 const (
-	EnvCookieSecret = "COOKIE_SECRET"
-	EnvDBURL        = "DB_URL"
-	EnvDBPort       = "DB_PORT"
-	EnvDBName       = "DB_NAME"
-	EnvDBUser       = "DB_USER"
-	EnvDBPassword   = "DB_PASSWORD"
-	EnvTMDBAPIKey   = "TMDB_API_KEY" // This may be configurable via admin panel in the future
-	EnvCachePath    = "CACHE_PATH"
+	EnvConfigFile = "CONFIG_FILE" // Path of the YAML or TOML configuration file, if any
+
+	EnvPort          = "PORT"
+	EnvCookieSecret  = "COOKIE_SECRET"
+	EnvDBBackend     = "DB_BACKEND" // "mongodb" (default), "sqlite" or "memory"
+	EnvSQLiteFile    = "SQLITE_FILE"
+	EnvMemoryFixture = "MEMORY_FIXTURE"
+	EnvDBURL         = "DB_URL"
+	EnvDBPort        = "DB_PORT"
+	EnvDBName        = "DB_NAME"
+	EnvDBUser        = "DB_USER"
+	EnvDBPassword    = "DB_PASSWORD"
+	EnvCachePath     = "CACHE_PATH"
+	EnvMediaInfoPath = "MEDIAINFO_PATH"
+
+	// Default values of the settings that can be changed from the admin panel
+	EnvLogLevel     = "LOG_LEVEL"
+	EnvTMDBAPIKey   = "TMDB_API_KEY"
 	EnvItemsPerPage = "ITEMS_PER_PAGE"
 
+	EnvRefreshFilmInterval    = "REFRESH_FILM_INTERVAL"
+	EnvRefreshPersonInterval  = "REFRESH_PERSON_INTERVAL"
+	EnvRefreshRatingsInterval = "REFRESH_RATINGS_INTERVAL"
+	EnvRefreshRateLimit       = "REFRESH_RATE_LIMIT"
+
+	EnvRequestTimeout = "REQUEST_TIMEOUT"
+	EnvTaskTimeout    = "TASK_TIMEOUT"
+	EnvTMDBTimeout    = "TMDB_TIMEOUT"
+
+	EnvShutdownTimeout = "SHUTDOWN_TIMEOUT"
+
+	EnvMetadataLanguage         = "METADATA_LANGUAGE"
+	EnvMetadataFallbackLanguage = "METADATA_FALLBACK_LANGUAGE"
+	EnvCertificationCountries   = "CERTIFICATION_COUNTRIES"
+
 	EnvEnableRarbg     = "ENABLE_RARBG"
 	EnvTorznabAPIKey   = "TORZNAB_API_KEY"
 	EnvRarbgSqliteFile = "RARBG_SQLITE_FILE"
 )
 
 func main() {
-	err := initApp()
+	godotenv.Load()
+
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+
+	// The server runs when no command is given
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	}
+	run, ok := commands[command]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+
+	config, err := loadConfig(os.Getenv(EnvConfigFile))
 	if err != nil {
-		log.Error().Err(fmt.Errorf("error during initialization: %w", err))
+		log.Error().Err(err).Send()
+		os.Exit(1)
+	}
+	// The log level is replaced by the one saved from the admin panel once the database is opened
+	level, _ := zerolog.ParseLevel(config.LogLevel)
+	zerolog.SetGlobalLevel(level)
+
+	if err := run(config, args); err != nil {
+		log.Error().Err(fmt.Errorf("error during %s: %w", command, err)).Send()
+		os.Exit(1)
 	}
 }
 
-func initApp() error {
-	err := godotenv.Load()
+// commands administer the server without a browser, using the same managers as the server
+var commands = map[string]func(config *Config, args []string) error{
+	"serve":            runServe,
+	"scan":             runScan,
+	"rematch":          runRematch,
+	"user":             runUser,
+	"volume":           runVolume,
+	"migrate":          runMigrate,
+	"merge-duplicates": runMergeDuplicates,
+	"export":           runExport,
+	"import":           runImport,
+	"doctor":           runDoctor,
+}
 
-	// TODO: Set level via environment variable
-	zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+const usage = `Usage: starfin [command] [arguments]
+
+Commands:
+  serve                                   run the server (default)
+  scan <volume>                           add the new files of a volume, and remove the missing ones
+  rematch [-link <url>] <film-id>         search TMDB again for a film, or match it with a TMDB, IMDb or Letterboxd link
+  user add [-admin] <name>                add a user, the first one being the owner; the password is read from stdin
+  user passwd <name>                      replace the password of a user, read from stdin
+  user delete <name>                      delete a user
+  volume add [-recursive] [-type Film|TV] <name> <path>
+                                          add a volume, and scan its files
+  volume list                             list the volumes
+  volume remove <volume>                  remove a volume, from its ID or its name
+  migrate [-dry-run]                      apply the pending migrations of the database
+  merge-duplicates [-dry-run] [-keep <film-id>]
+                                          merge the films, people and collections stored several times
+  export <file>                           write the library, the users and the settings to a JSON file
+  import <file>                           add the documents of an exported file to an empty database
+  doctor                                  check the installation
+  help                                    print this help
+`
 
-	db := infrastructure.NewMongoDB(
-		os.Getenv(EnvDBUser),
-		os.Getenv(EnvDBPassword),
-		os.Getenv(EnvDBURL),
-		os.Getenv(EnvDBPort),
-		os.Getenv(EnvDBName))
+// runServe runs the server until it is interrupted by a signal
+func runServe(config *Config, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: starfin serve")
+	}
+	return initApp(config)
+}
 
-	c := infrastructure.NewCache(os.Getenv(EnvCachePath))
+// initApp runs the server until it is interrupted by a signal, and shuts it down
+func initApp(config *Config) (err error) {
+	// The context of the background tasks, which run until the server is shut down
+	ctx, cancelTasks := context.WithCancel(context.Background())
+	defer cancelTasks()
 
-	metadata, err := infrastructure.NewMetadataWrapper(os.Getenv(EnvTMDBAPIKey))
+	db, err := openDatabase(ctx, config, config.Rarbg.Enabled)
 	if err != nil {
 		return err
 	}
+	// The database is closed last, once nothing uses it anymore
+	defer func() {
+		if closeErr := db.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("error while closing the database: %w", closeErr))
+		}
+	}()
+	if _, err := db.Migrate(ctx, false); err != nil {
+		return err
+	}
 
-	enableRarbg, err := strconv.ParseBool(os.Getenv(EnvEnableRarbg))
+	lib, err := newLibrary(ctx, config, db)
 	if err != nil {
-		return fmt.Errorf("error parsing env var %q: %w", EnvEnableRarbg, err)
+		return err
 	}
-
-	filterer := business.NewFilterer()
-	fm := business.NewFilmManager(db, c, metadata, filterer)
-	filterer.AddFilms(fm.GetFilms())
-
-	fw := business.NewFileWatcher(db, fm, metadata)
+	// The files added or removed while the server was stopped are synchronized before the volumes are watched
+	if err := lib.fw.SynchronizeVolumes(ctx); err != nil {
+		return err
+	}
+	watcherDone := make(chan struct{})
 	go func() {
-		err = fw.Run()
-		if err != nil {
-			log.Fatal().Err(err)
+		defer close(watcherDone)
+		if err := lib.fw.Run(); err != nil {
+			log.Error().Err(err).Msg("Could not watch the volumes")
 		}
 	}()
 
-	pm := business.NewPersonManager(db)
+	pm := business.NewPersonManager(db, lib.cache, lib.metadata, lib.searchIndex)
 	um := business.NewUserManager(db)
-	vm := business.NewVolumeManager(db, fw, fm, metadata)
 
-	itemsPerPage, err := strconv.ParseInt(os.Getenv(EnvItemsPerPage), 10, 64)
-	if err != nil {
-		return fmt.Errorf("error getting ITEMS_PER_PAGE: %w", err)
-	}
-	fp := business.NewPaginater[model.Film](itemsPerPage)
-	pp := business.NewPaginater[model.Person](itemsPerPage)
+	refresherDone := make(chan struct{})
+	go func() {
+		defer close(refresherDone)
+		lib.refresher.Run(ctx)
+	}()
+
+	hm := business.NewHomeManager(db, lib.filterer)
 
-	mainHandler := server.NewMainHandler(c, um)
-	adminHandler := server.NewAdminHandler(fm, um, vm)
-	filmHandler := server.NewFilmHandler(fm, pm, filterer, fp)
-	personHandler := server.NewPersonHandler(pm, fm, pp)
+	mainHandler := server.NewMainHandler(lib.cache, um, hm)
+	adminHandler := server.NewAdminHandler(lib.fm, um, lib.vm, lib.refresher, lib.sm, hm)
+	filmHandler := server.NewFilmHandler(lib.fm, pm, lib.filterer, lib.paginater)
+	personHandler := server.NewPersonHandler(pm, lib.fm, lib.paginater)
+	searchHandler := server.NewSearchHandler(lib.sem)
+	scm := business.NewSmartCollectionManager(db, lib.fm)
+	smartCollectionHandler := server.NewSmartCollectionHandler(scm, lib.paginater)
+	cm := business.NewCollectionManager(db)
+	collectionHandler := server.NewCollectionHandler(cm)
+	gm := business.NewGraphManager(db, lib.searchIndex)
+	connectionHandler := server.NewConnectionHandler(gm)
 
 	var rarbgHandler *server.RarbgHandler = nil
-	if enableRarbg {
-		db.InitRarbg("rarbg")
-		rarbgHandler = server.NewRarbgHandler(db, os.Getenv(EnvTorznabAPIKey))
+	if config.Rarbg.Enabled {
+		rarbgHandler = server.NewRarbgHandler(db, config.Rarbg.TorznabAPIKey)
 	}
 
-	srv := server.NewServer(
-		os.Getenv(EnvCookieSecret),
+	router := server.NewServer(
+		config.CookieSecret,
+		time.Duration(config.Timeouts.Request),
 		mainHandler,
 		adminHandler,
 		filmHandler,
 		personHandler,
+		searchHandler,
+		smartCollectionHandler,
+		collectionHandler,
+		connectionHandler,
 		rarbgHandler,
 		db)
-	err = srv.Run()
-	if err != nil {
-		return err
+	srv := &http.Server{Addr: ":" + config.Port, Handler: router}
+	shutdownTimeout := time.Duration(config.Timeouts.Shutdown)
+
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Info().Str("address", srv.Addr).Msg("Listening")
+		serveErr <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("error while serving: %w", err)
+	case <-signalCtx.Done():
+		// A second signal kills the server without waiting for the shutdown
+		stopSignals()
+		log.Info().Dur("timeout", shutdownTimeout).Msg("Shutting down")
 	}
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var errs []error
+	// Stop accepting requests, and wait for the ones in progress, such as downloads
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("error while waiting for the requests in progress: %w", err))
+		srv.Close()
+	}
+	// Stop the background tasks
+	if err := lib.vm.CancelScans(shutdownCtx); err != nil {
+		errs = append(errs, err)
+	}
+	lib.fw.Stop()
+	cancelTasks()
+	select {
+	case <-watcherDone:
+	case <-shutdownCtx.Done():
+		errs = append(errs, errors.New("error while waiting for the file watcher to stop: deadline exceeded"))
+	}
+	select {
+	case <-refresherDone:
+	case <-shutdownCtx.Done():
+		errs = append(errs, errors.New("error while waiting for the refresh in progress: deadline exceeded"))
+	}
+	if err := lib.cache.FlushRetries(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("error while flushing the cache retries: %w", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error during shutdown: %w", errors.Join(errs...))
+	}
+	log.Info().Msg("Server stopped")
 	return nil
 }
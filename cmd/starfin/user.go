@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+const userUsage = "usage: starfin user add [-admin] <name> | passwd <name> | delete <name>"
+
+// runUser adds a user, sets the password of a user, or deletes a user.
+// The first user added is the owner of the server
+func runUser(config *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(userUsage)
+	}
+	flags := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	isAdmin := flags.Bool("admin", false, "allow the user to administer the server")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || (*isAdmin && args[0] != "add") {
+		return errors.New(userUsage)
+	}
+	username := flags.Arg(0)
+
+	ctx := context.Background()
+	db, err := openDatabase(ctx, config, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Migrate(ctx, false); err != nil {
+		return err
+	}
+	um := business.NewUserManager(db)
+
+	switch args[0] {
+	case "add":
+		password1, password2, err := readPassword(os.Stdin)
+		if err != nil {
+			return err
+		}
+		ownerPresent, err := um.IsOwnerPresent(ctx)
+		if err != nil {
+			return err
+		}
+		if !ownerPresent {
+			if _, err := um.CreateOwner(ctx, username, password1, password2); err != nil {
+				return err
+			}
+			fmt.Printf("Owner %q added\n", username)
+			return nil
+		}
+		if _, err := um.CreateUser(ctx, username, password1, password2, *isAdmin, false); err != nil {
+			return err
+		}
+		fmt.Printf("User %q added\n", username)
+	case "passwd":
+		// Fails early, before asking for the password
+		if err := um.GetUserFromName(ctx, username, &model.User{}); err != nil {
+			return fmt.Errorf("could not get user: %w", err)
+		}
+		password1, password2, err := readPassword(os.Stdin)
+		if err != nil {
+			return err
+		}
+		if err := um.ResetUserPassword(ctx, username, password1, password2); err != nil {
+			return err
+		}
+		fmt.Printf("Password of %q changed\n", username)
+	case "delete":
+		var user model.User
+		if err := um.GetUserFromName(ctx, username, &user); err != nil {
+			return fmt.Errorf("could not get user: %w", err)
+		}
+		if user.IsOwner {
+			return errors.New("the owner cannot be deleted")
+		}
+		if err := um.DeleteUser(ctx, user.ID.Hex()); err != nil {
+			return err
+		}
+		fmt.Printf("User %q deleted\n", username)
+	default:
+		return errors.New(userUsage)
+	}
+	return nil
+}
+
+// readPassword reads a password and its confirmation, without echoing them when the input is a terminal,
+// or one per line so that they can also be piped.
+// The prompts are written to the standard error, to keep the output of the command clean
+func readPassword(in *os.File) (password1, password2 string, err error) {
+	reader := bufio.NewReader(in)
+	readLine := func(prompt string) (string, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		if term.IsTerminal(int(in.Fd())) {
+			password, err := term.ReadPassword(int(in.Fd()))
+			// The new line typed is not echoed either
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return "", fmt.Errorf("error while reading password: %w", err)
+			}
+			return string(password), nil
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+			return "", fmt.Errorf("error while reading password: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	if password1, err = readLine("Password: "); err != nil {
+		return "", "", err
+	}
+	if password2, err = readLine("Confirm password: "); err != nil {
+		return "", "", err
+	}
+	return password1, password2, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+const volumeUsage = "usage: starfin volume add [-recursive] [-type Film|TV] <name> <path> | list | remove <volume>"
+
+// runVolume adds a volume and scans its files, lists the volumes, or removes a volume from its ID or its name
+func runVolume(config *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(volumeUsage)
+	}
+	flags := flag.NewFlagSet("volume "+args[0], flag.ContinueOnError)
+	isRecursive := flags.Bool("recursive", false, "include the subfolders of the volume")
+	mediaType := flags.String("type", "Film", `media type of the volume, "Film" or "TV"`)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		if flags.NArg() != 2 {
+			return errors.New(volumeUsage)
+		}
+		if *mediaType != "Film" && *mediaType != "TV" {
+			return fmt.Errorf(`unknown media type %q, expected "Film" or "TV"`, *mediaType)
+		}
+		return withLibrary(config, func(ctx context.Context, db database, lib *library) error {
+			name, path := flags.Arg(0), flags.Arg(1)
+			if err := lib.vm.CreateVolume(ctx, name, path, *isRecursive, *mediaType); err != nil {
+				return err
+			}
+			fmt.Printf("Volume %q added, scanning its files\n", name)
+			if err := lib.vm.WaitScans(ctx); err != nil {
+				return err
+			}
+			volume, err := findVolume(ctx, db, name)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Volume %q scanned: %d films\n", name, len(db.GetFilmsFromVolume(ctx, volume.ID)))
+			return nil
+		})
+	case "list":
+		if flags.NArg() != 0 {
+			return errors.New(volumeUsage)
+		}
+		ctx := context.Background()
+		db, err := openDatabase(ctx, config, false)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		volumes, err := db.GetVolumes(ctx)
+		if err != nil {
+			return err
+		}
+		for _, volume := range volumes {
+			recursive := ""
+			if volume.IsRecursive {
+				recursive = ", recursive"
+			}
+			fmt.Printf("%s %q %s (%s%s)\n", volume.ID.Hex(), volume.Name, volume.Path, volume.MediaType, recursive)
+		}
+		if len(volumes) == 0 {
+			fmt.Println("There are no volumes")
+		}
+		return nil
+	case "remove":
+		if flags.NArg() != 1 {
+			return errors.New(volumeUsage)
+		}
+		return withLibrary(config, func(ctx context.Context, db database, lib *library) error {
+			volume, err := findVolume(ctx, db, flags.Arg(0))
+			if err != nil {
+				return err
+			}
+			if err := lib.vm.DeleteVolume(ctx, volume.ID.Hex()); err != nil {
+				return err
+			}
+			fmt.Printf("Volume %q removed\n", volume.Name)
+			return nil
+		})
+	default:
+		return errors.New(volumeUsage)
+	}
+}
+
+// findVolume returns a volume from its hexadecimal ID or its name, as long as no other volume has this name
+func findVolume(ctx context.Context, db database, volumeIDOrName string) (*model.Volume, error) {
+	volumes, err := db.GetVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var found []model.Volume
+	for _, volume := range volumes {
+		if volume.ID.Hex() == volumeIDOrName {
+			return &volume, nil
+		}
+		if volume.Name == volumeIDOrName {
+			found = append(found, volume)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("volume %q: %w", volumeIDOrName, model.ErrNotFound)
+	case 1:
+		return &found[0], nil
+	default:
+		return nil, fmt.Errorf("%d volumes are named %q, use the ID of the volume instead", len(found), volumeIDOrName)
+	}
+}
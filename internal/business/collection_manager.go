@@ -0,0 +1,73 @@
+package business
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+type CollectionStorer interface {
+	GetCollectionFromID(ctx context.Context, collectionID primitive.ObjectID) (*model.Collection, error)
+	GetCollectionSummaries(ctx context.Context) ([]model.CollectionSummary, error)
+	GetFilmsFromCollection(ctx context.Context, tmdbID int64) ([]model.Film, error)
+}
+
+type CollectionManager struct {
+	CollectionStorer
+}
+
+// NewCollectionManager instantiates a new CollectionManager
+func NewCollectionManager(cs CollectionStorer) *CollectionManager {
+	return &CollectionManager{
+		CollectionStorer: cs,
+	}
+}
+
+// GetCollections returns the collections having films in the library, sorted by name
+func (cm CollectionManager) GetCollections(ctx context.Context) ([]model.CollectionSummary, error) {
+	return cm.CollectionStorer.GetCollectionSummaries(ctx)
+}
+
+// GetCollection returns a collection from its hexadecimal ID, and its films in release order, whether they are in the library or not
+func (cm CollectionManager) GetCollection(ctx context.Context, collectionHexID string) (*model.Collection, []model.CollectionEntry, error) {
+	collectionID, err := primitive.ObjectIDFromHex(collectionHexID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("incorrect collection ID: %w", err)
+	}
+	collection, err := cm.CollectionStorer.GetCollectionFromID(ctx, collectionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get collection from ID '%s': %w", collectionHexID, err)
+	}
+	films, err := cm.CollectionStorer.GetFilmsFromCollection(ctx, collection.TMDBID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]model.CollectionEntry, 0, len(collection.Parts))
+	for _, part := range collection.Parts {
+		entry := model.CollectionEntry{CollectionPart: part}
+		if index := slices.IndexFunc(films, func(film model.Film) bool { return film.TMDBID == part.TMDBID }); index >= 0 {
+			entry.Film = &films[index]
+		}
+		entries = append(entries, entry)
+	}
+	// Films of the library that TMDB no longer lists in the collection
+	var others []model.CollectionEntry
+	for i, film := range films {
+		if !slices.ContainsFunc(collection.Parts, func(part model.CollectionPart) bool { return part.TMDBID == film.TMDBID }) {
+			others = append(others, model.CollectionEntry{
+				CollectionPart: model.CollectionPart{TMDBID: film.TMDBID, Title: film.Title, PosterPath: film.PosterPath},
+				Film:           &films[i],
+			})
+		}
+	}
+	slices.SortFunc(others, func(a, b model.CollectionEntry) int {
+		return cmp.Compare(a.Film.ReleaseYear, b.Film.ReleaseYear)
+	})
+	return collection, append(entries, others...), nil
+}
@@ -0,0 +1,129 @@
+package business_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// collectionMetadata returns the collections it knows, with a new ID every time as TMDB does not give any
+type collectionMetadata struct {
+	fakeMetadata
+	collections map[int64]*model.Collection
+	fetches     *int
+}
+
+func (cm collectionMetadata) GetCollectionDetails(ctx context.Context, collectionID int64) (*model.Collection, error) {
+	collection, ok := cm.collections[collectionID]
+	if !ok {
+		return nil, errors.New("unknown collection")
+	}
+	*cm.fetches++
+	fetched := *collection
+	fetched.ID = primitive.NewObjectID()
+	fetched.LastRefreshed = time.Now()
+	return &fetched, nil
+}
+
+// collectionRecorder records the collections saved, whose ID the memory storage would keep anyway
+type collectionRecorder struct {
+	*infrastructure.Memory
+	saved *[]model.Collection
+}
+
+func (cr collectionRecorder) AddCollection(ctx context.Context, collection *model.Collection) error {
+	*cr.saved = append(*cr.saved, *collection)
+	return cr.Memory.AddCollection(ctx, collection)
+}
+
+// TestCollection lists the films of a collection, owned or not, and the owned ones TMDB no longer lists in it
+func TestCollection(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	metadata := collectionMetadata{
+		collections: map[int64]*model.Collection{8091: {TMDBID: 8091, Name: "Alien Collection", Parts: []model.CollectionPart{
+			{TMDBID: 348, Title: "Alien", ReleaseDate: "1979-05-25"},
+			{TMDBID: 679, Title: "Aliens", ReleaseDate: "1986-07-18"},
+			{TMDBID: 8077, Title: "Alien³", ReleaseDate: "1992-05-22"},
+			{TMDBID: 8078, Title: "Alien Resurrection", ReleaseDate: "1997-11-12"},
+		}}},
+		fetches: new(int),
+	}
+	var saved []model.Collection
+	fm := business.NewFilmManager(collectionRecorder{db, &saved}, fakeCache{}, metadata, business.NewFilterer(), business.NewSearchIndex())
+	cm := business.NewCollectionManager(db)
+
+	films := map[string]*model.Film{}
+	for _, film := range []model.Film{
+		{TMDBID: 126889, Title: "Alien: Covenant", ReleaseYear: 2017},
+		{TMDBID: 8077, Title: "Alien³", ReleaseYear: 1992},
+		{TMDBID: 70981, Title: "Prometheus", ReleaseYear: 2012},
+		{TMDBID: 348, Title: "Alien", ReleaseYear: 1979},
+	} {
+		film := film
+		film.ID, film.CollectionID = primitive.NewObjectID(), 8091
+		require.NoError(t, fm.AddFilm(ctx, &film, false))
+		films[film.Title] = &film
+	}
+	// The collection is only fetched again once it is outdated
+	assert.Equal(t, 1, *metadata.fetches)
+
+	summaries, err := cm.GetCollections(ctx)
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "Alien Collection", summaries[0].Name)
+	assert.Equal(t, 4, summaries[0].OwnedCount)
+	collectionID := summaries[0].ID
+
+	// Parts in release order, then the other owned films by year
+	collection, entries, err := cm.GetCollection(ctx, collectionID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, "Alien Collection", collection.Name)
+	var titles []string
+	var owned []*model.Film
+	for _, entry := range entries {
+		titles = append(titles, entry.Title)
+		owned = append(owned, entry.Film)
+	}
+	assert.Equal(t, []string{"Alien", "Aliens", "Alien³", "Alien Resurrection", "Prometheus", "Alien: Covenant"}, titles)
+	require.Len(t, owned, 6)
+	assert.Equal(t, films["Alien"].ID, owned[0].ID)
+	assert.Nil(t, owned[1])
+	assert.Equal(t, films["Alien³"].ID, owned[2].ID)
+	assert.Nil(t, owned[3])
+	assert.Equal(t, films["Prometheus"].ID, owned[4].ID)
+	assert.Equal(t, films["Alien: Covenant"].ID, owned[5].ID)
+
+	// TMDB now lists Prometheus in the collection, which is fetched again once outdated, keeping its ID used in its link
+	stored, err := db.GetCollectionFromID(ctx, collectionID)
+	require.NoError(t, err)
+	stored.LastRefreshed = time.Now().Add(-48 * time.Hour)
+	require.NoError(t, db.AddCollection(ctx, stored))
+	metadata.collections[8091].Parts = append(metadata.collections[8091].Parts, model.CollectionPart{TMDBID: 70981, Title: "Prometheus", ReleaseDate: "2012-05-30"})
+	aliens := model.Film{ID: primitive.NewObjectID(), TMDBID: 679, Title: "Aliens", ReleaseYear: 1986, CollectionID: 8091}
+	require.NoError(t, fm.AddFilm(ctx, &aliens, false))
+	assert.Equal(t, 2, *metadata.fetches)
+	require.Len(t, saved, 2)
+	assert.Equal(t, collectionID, saved[1].ID)
+
+	_, entries, err = cm.GetCollection(ctx, collectionID.Hex())
+	require.NoError(t, err)
+	titles = nil
+	for _, entry := range entries {
+		titles = append(titles, entry.Title)
+	}
+	assert.Equal(t, []string{"Alien", "Aliens", "Alien³", "Alien Resurrection", "Prometheus", "Alien: Covenant"}, titles)
+	assert.Equal(t, aliens.ID, entries[1].Film.ID)
+
+	_, _, err = cm.GetCollection(ctx, primitive.NewObjectID().Hex())
+	assert.ErrorIs(t, err, model.ErrNotFound)
+}
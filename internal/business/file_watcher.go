@@ -1,7 +1,9 @@
 package business
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"slices"
@@ -16,29 +18,30 @@ import (
 )
 
 type FileStorer interface {
-	GetVolumes() ([]model.Volume, error)
+	GetVolumes(ctx context.Context) ([]model.Volume, error)
 
-	AddSubtitleToFilmPath(filmFilePath string, sub model.Subtitle) error
-	RemoveSubtitleFile(mediaPath, subtitlePath string) error
+	AddSubtitleToFilmPath(ctx context.Context, filmFilePath string, sub model.Subtitle) error
+	RemoveSubtitleFile(ctx context.Context, mediaPath, subtitlePath string) error
 
-	GetFilmFromPath(filmPath string) (film *model.Film, err error)
+	GetFilmFromPath(ctx context.Context, filmPath string) (film *model.Film, err error)
 
-	UpdateFilmVolumeFile(film *model.Film, oldPath string, newVolumeFile model.VolumeFile) error
-	DeleteFilmVolumeFile(path string) error
+	UpdateFilmVolumeFile(ctx context.Context, film *model.Film, oldPath string, newVolumeFile model.VolumeFile) error
+	DeleteFilmVolumeFile(ctx context.Context, path string) error
 
-	IsFilmPathPresent(filmPath string) bool
-	IsSubtitlePathPresent(subPath string) bool
-	GetFilmsFromVolume(id primitive.ObjectID) (films []model.Film)
+	IsFilmPathPresent(ctx context.Context, filmPath string) bool
+	IsSubtitlePathPresent(ctx context.Context, subPath string) bool
+	GetFilmsFromVolume(ctx context.Context, id primitive.ObjectID) (films []model.Film)
 }
 
 type FileWatcherFilmManager interface {
-	AddFilm(film *model.Film, update bool) error
+	AddFilm(ctx context.Context, film *model.Film, update bool) error
+	ReindexFilm(ctx context.Context, filmID primitive.ObjectID)
 }
 
 type WatcherMetadataGetter interface {
-	CreateFilm(file string, volumeID primitive.ObjectID, subFiles []string) *model.Film
-	FetchFilmTMDBID(f *model.Film) error
-	UpdateFilmDetails(film *model.Film)
+	CreateFilm(ctx context.Context, file string, volumeID primitive.ObjectID, subFiles []string) *model.Film
+	FetchFilmTMDBID(ctx context.Context, f *model.Film) error
+	UpdateFilmDetails(ctx context.Context, film *model.Film) error
 }
 
 type FileWatcher struct {
@@ -48,25 +51,62 @@ type FileWatcher struct {
 
 	watcher        *watcher.Watcher
 	watchedVolumes []*model.Volume
+	taskTimeout    time.Duration
+	cancel         context.CancelFunc // Stops the event listener
+	done           chan struct{}      // Closed once the event listener has stopped
 }
 
-func NewFileWatcher(fs FileStorer, fm FileWatcherFilmManager, wmg WatcherMetadataGetter) *FileWatcher {
+// NewFileWatcher instantiates a new FileWatcher, which watches the volumes once they are synchronized.
+// The file events are handled until ctx is done or the watcher is stopped, each of them being cancelled after taskTimeout
+func NewFileWatcher(ctx context.Context, fs FileStorer, fm FileWatcherFilmManager, wmg WatcherMetadataGetter, taskTimeout time.Duration) *FileWatcher {
+	if taskTimeout <= 0 {
+		taskTimeout = DefaultTaskTimeout
+	}
+	listenerCtx, cancel := context.WithCancel(ctx)
 	fileWatcher := &FileWatcher{
 		FileStorer:             fs,
 		FileWatcherFilmManager: fm,
 		WatcherMetadataGetter:  wmg,
 		watcher:                watcher.New(),
+		taskTimeout:            taskTimeout,
+		cancel:                 cancel,
+		done:                   make(chan struct{}),
 	}
 
-	go fileWatcher.eventListener()
+	go func() {
+		defer close(fileWatcher.done)
+		fileWatcher.eventListener(listenerCtx)
+	}()
+
+	return fileWatcher
+}
 
-	volumes, _ := fileWatcher.FileStorer.GetVolumes()
+// SynchronizeVolumes synchronizes the database with the files of every volume, and watches them
+func (fw *FileWatcher) SynchronizeVolumes(ctx context.Context) error {
+	volumes, err := fw.FileStorer.GetVolumes(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get volumes: %w", err)
+	}
 	for _, v := range volumes {
-		fileWatcher.AddVolume(&v)
-		fileWatcher.synchronizeFilesAndDB(&v)
+		v := v
+		fw.SynchronizeVolume(ctx, &v)
 	}
+	return nil
+}
 
-	return fileWatcher
+// SynchronizeVolume synchronizes the database with the files of a volume, and watches it if it is not watched yet
+func (fw *FileWatcher) SynchronizeVolume(ctx context.Context, volume *model.Volume) {
+	if !slices.ContainsFunc(fw.watchedVolumes, func(v *model.Volume) bool { return v.ID == volume.ID }) {
+		fw.AddVolume(volume)
+	}
+	fw.synchronizeFilesAndDB(ctx, volume)
+}
+
+// runTask handles a file event, with a context cancelled after the task timeout
+func (fw *FileWatcher) runTask(ctx context.Context, task func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, fw.taskTimeout)
+	defer cancel()
+	return task(ctx)
 }
 
 func (fw *FileWatcher) Run() error {
@@ -74,8 +114,11 @@ func (fw *FileWatcher) Run() error {
 	return err
 }
 
+// Stop stops watching the volumes, and waits for the file event being handled, if any
 func (fw *FileWatcher) Stop() {
+	fw.cancel()
 	fw.watcher.Close()
+	<-fw.done
 }
 
 func (fw *FileWatcher) AddVolume(v *model.Volume) {
@@ -94,7 +137,7 @@ func (fw *FileWatcher) AddVolume(v *model.Volume) {
 }
 
 // eventListener listens for file creation, renaming and deletion
-func (fw *FileWatcher) eventListener() {
+func (fw *FileWatcher) eventListener(ctx context.Context) {
 	fileWrites := make(map[string]int64)
 
 	createdFilesTicker := time.NewTicker(5 * time.Second)
@@ -118,7 +161,7 @@ func (fw *FileWatcher) eventListener() {
 				log.Debug().Str("path", path).Msg("File has stopped writing")
 				delete(fileWrites, path)
 
-				if err := fw.handleFileCreate(path); err != nil {
+				if err := fw.runTask(ctx, func(ctx context.Context) error { return fw.handleFileCreate(ctx, path) }); err != nil {
 					log.Error().Err(err).Send()
 					continue
 				}
@@ -136,9 +179,14 @@ func (fw *FileWatcher) eventListener() {
 					}
 				}
 			} else if event.Op == watcher.Rename {
-				fw.handleFileRenamed(event.OldPath, event.Path)
+				if err := fw.runTask(ctx, func(ctx context.Context) error { return fw.handleFileRenamed(ctx, event.OldPath, event.Path) }); err != nil {
+					log.Error().Err(err).Send()
+				}
 			} else if event.Op == watcher.Remove {
-				fw.handleFileRemoved(event.Path)
+				fw.runTask(ctx, func(ctx context.Context) error {
+					fw.handleFileRemoved(ctx, event.Path)
+					return nil
+				})
 			}
 		// Error in file watching
 		case err := <-fw.watcher.Error:
@@ -147,18 +195,20 @@ func (fw *FileWatcher) eventListener() {
 		// Stop watching files
 		case <-fw.watcher.Closed:
 			return
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
-func (fw *FileWatcher) handleFileCreate(path string) error {
+func (fw *FileWatcher) handleFileCreate(ctx context.Context, path string) error {
 	ext := filepath.Ext(path)
 
 	// Retrieve volume
 	volume := fw.getVolumeFromFilePath(path)
 
 	if model.IsVideoFileExtension(ext) { // Adding a video
-		if err := fw.addFilmFromPath(path, volume.ID); err != nil {
+		if err := fw.addFilmFromPath(ctx, path, volume.ID); err != nil {
 			return err
 		}
 	} else if model.IsSubtitleFileExtension(ext) { // Adding a subtitle
@@ -166,17 +216,18 @@ func (fw *FileWatcher) handleFileCreate(path string) error {
 		mediaPaths, subtitle := fw.getRelatedMediaFiles(path)
 		for _, mediaPath := range mediaPaths {
 			// Add it to the database
-			err := fw.FileStorer.AddSubtitleToFilmPath(mediaPath, *subtitle)
+			err := fw.FileStorer.AddSubtitleToFilmPath(ctx, mediaPath, *subtitle)
 			if err != nil {
 				log.Error().Str("subtitle", path).Str("media", mediaPath).Err(err).Msg("Cannot add subtitle to media")
 			}
+			fw.reindexFilmFromPath(ctx, mediaPath)
 		}
 	}
 
 	return nil
 }
 
-func (fw *FileWatcher) handleFileRenamed(oldPath, newPath string) error {
+func (fw *FileWatcher) handleFileRenamed(ctx context.Context, oldPath, newPath string) error {
 	ext := filepath.Ext(newPath)
 	// Add it to watch list if video or subtitle
 	if model.IsVideoFileExtension(ext) {
@@ -188,32 +239,34 @@ func (fw *FileWatcher) handleFileRenamed(oldPath, newPath string) error {
 			log.Error().Str("path", newPath).Err(err).Msg("Error with file rename: could not get related subtitles")
 		}
 		// Create film
-		newFilm := fw.WatcherMetadataGetter.CreateFilm(newPath, volume.ID, subFiles)
-		err = fw.WatcherMetadataGetter.FetchFilmTMDBID(newFilm)
+		newFilm := fw.WatcherMetadataGetter.CreateFilm(ctx, newPath, volume.ID, subFiles)
+		err = fw.WatcherMetadataGetter.FetchFilmTMDBID(ctx, newFilm)
 		if err != nil {
 			log.Error().Str("path", newPath).Err(err).Msg("Error with file rename: could not get TMDB ID")
 			// TODO
 		}
 
 		// Get the current film struct from mongo
-		oldFilm, err := fw.FileStorer.GetFilmFromPath(oldPath)
+		oldFilm, err := fw.FileStorer.GetFilmFromPath(ctx, oldPath)
 		if err != nil {
 			return errors.New("could not get film from path")
 		}
 
 		if oldFilm.TMDBID == newFilm.TMDBID {
 			// If they have the same TMDB ID, replace the correct volumeFile
-			if err = fw.FileStorer.UpdateFilmVolumeFile(oldFilm, oldPath, newFilm.VolumeFiles[0]); err != nil {
+			if err = fw.FileStorer.UpdateFilmVolumeFile(ctx, oldFilm, oldPath, newFilm.VolumeFiles[0]); err != nil {
 				log.Error().Str("oldPath", oldPath).Err(err).Send()
 			}
+			fw.FileWatcherFilmManager.ReindexFilm(ctx, oldFilm.ID)
 		} else {
 			// If they don't have the same TMDB ID, remove the path from the previous film
-			if err := fw.FileStorer.DeleteFilmVolumeFile(oldPath); err != nil {
+			if err := fw.FileStorer.DeleteFilmVolumeFile(ctx, oldPath); err != nil {
 				return err
 			}
+			fw.FileWatcherFilmManager.ReindexFilm(ctx, oldFilm.ID)
 
 			// Fetch film details and add it to the database
-			if err := fw.addFilmFromPath(newPath, volume.ID); err != nil {
+			if err := fw.addFilmFromPath(ctx, newPath, volume.ID); err != nil {
 				return err
 			}
 		}
@@ -221,42 +274,61 @@ func (fw *FileWatcher) handleFileRenamed(oldPath, newPath string) error {
 		// Remove old subtitle
 		mediaPaths, _ := fw.getRelatedMediaFiles(oldPath)
 		for _, mediaPath := range mediaPaths {
-			fw.FileStorer.RemoveSubtitleFile(mediaPath, oldPath)
+			fw.FileStorer.RemoveSubtitleFile(ctx, mediaPath, oldPath)
+			fw.reindexFilmFromPath(ctx, mediaPath)
 		}
 
 		// Add new subtitle
 		mediaPaths, subtitle := fw.getRelatedMediaFiles(newPath)
 		for _, mediaPath := range mediaPaths {
 			// Add it to the database
-			err := fw.FileStorer.AddSubtitleToFilmPath(mediaPath, *subtitle)
+			err := fw.FileStorer.AddSubtitleToFilmPath(ctx, mediaPath, *subtitle)
 			if err != nil {
 				log.Error().Err(err).Str("subtitle", newPath).Str("media", mediaPath).Msg("Cannot add subtitle to media")
 			}
+			fw.reindexFilmFromPath(ctx, mediaPath)
 		}
 	}
 	return nil
 }
 
 // handleFileRemoved handles the media and subtitle file removing
-func (fw *FileWatcher) handleFileRemoved(path string) {
+func (fw *FileWatcher) handleFileRemoved(ctx context.Context, path string) {
 	ext := filepath.Ext(path)
 	if model.IsVideoFileExtension(ext) { // If we're deleting a video
-		if err := fw.FileStorer.DeleteFilmVolumeFile(path); err != nil {
+		film, err := fw.FileStorer.GetFilmFromPath(ctx, path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Could not get film from path")
+			return
+		}
+		if err := fw.FileStorer.DeleteFilmVolumeFile(ctx, path); err != nil {
 			log.Error().Err(err).Send()
 		}
+		fw.FileWatcherFilmManager.ReindexFilm(ctx, film.ID)
 	} else if model.IsSubtitleFileExtension(ext) { // If we're deleting a subtitle
 		// Get related media file
 		mediaPaths, _ := fw.getRelatedMediaFiles(path)
 		for _, mediaPath := range mediaPaths {
-			fw.FileStorer.RemoveSubtitleFile(mediaPath, path)
+			fw.FileStorer.RemoveSubtitleFile(ctx, mediaPath, path)
+			fw.reindexFilmFromPath(ctx, mediaPath)
 		}
 	}
 }
 
-// SynchronizeFilesAndDB synchronizes the database to the current files in the volume
+// reindexFilmFromPath updates the filters and the search index after the files of a film changed
+func (fw *FileWatcher) reindexFilmFromPath(ctx context.Context, filmPath string) {
+	film, err := fw.FileStorer.GetFilmFromPath(ctx, filmPath)
+	if err != nil {
+		log.Debug().Err(err).Str("path", filmPath).Msg("Could not get film from path to reindex it")
+		return
+	}
+	fw.FileWatcherFilmManager.ReindexFilm(ctx, film.ID)
+}
+
+// synchronizeFilesAndDB synchronizes the database to the current files in the volume
 // It adds the missing films and subtitles from the database, and removes the films and subtitles
 // that are not currently in the volume
-func (fw *FileWatcher) synchronizeFilesAndDB(volume *model.Volume) {
+func (fw *FileWatcher) synchronizeFilesAndDB(ctx context.Context, volume *model.Volume) {
 	videoFiles, subFiles, err := volume.ListVideoFiles()
 	if err != nil {
 		log.Error().Str("volume", volume.Path).Msg("Could not synchronize volume with database")
@@ -265,31 +337,31 @@ func (fw *FileWatcher) synchronizeFilesAndDB(volume *model.Volume) {
 	// Add to database all new video files
 	for _, videoFile := range videoFiles {
 		// If film is not in database
-		if !fw.FileStorer.IsFilmPathPresent(videoFile) {
-			fw.handleFileCreate(videoFile)
+		if !fw.FileStorer.IsFilmPathPresent(ctx, videoFile) {
+			fw.runTask(ctx, func(ctx context.Context) error { return fw.handleFileCreate(ctx, videoFile) })
 		}
 	}
 
 	// Add to database all new subtitle files
 	for _, subFile := range subFiles {
 		// If film is not in database
-		if !fw.FileStorer.IsSubtitlePathPresent(subFile) {
-			fw.handleFileCreate(subFile)
+		if !fw.FileStorer.IsSubtitlePathPresent(ctx, subFile) {
+			fw.runTask(ctx, func(ctx context.Context) error { return fw.handleFileCreate(ctx, subFile) })
 		}
 	}
 
 	// Get all films from volume
-	films := fw.FileStorer.GetFilmsFromVolume(volume.ID)
+	films := fw.FileStorer.GetFilmsFromVolume(ctx, volume.ID)
 	for _, film := range films {
 		for _, volumeFile := range film.VolumeFiles {
 			// If the film is not in the volume files, remove this film
 			if !slices.Contains(videoFiles, volumeFile.Path) {
-				fw.handleFileRemoved(volumeFile.Path)
+				fw.handleFileRemoved(ctx, volumeFile.Path)
 			}
 			// If the subtitle is not in the volume files, remove this subtitle
 			for _, sub := range volumeFile.ExtSubtitles {
 				if !slices.Contains(subFiles, sub.Path) {
-					fw.handleFileRemoved(sub.Path)
+					fw.handleFileRemoved(ctx, sub.Path)
 				}
 			}
 		}
@@ -349,25 +421,27 @@ func (fw *FileWatcher) getVolumeFromFilePath(path string) *model.Volume {
 }
 
 // addFilmFromPath adds a film from its path and the volume
-func (fw *FileWatcher) addFilmFromPath(path string, volumeID primitive.ObjectID) error {
+func (fw *FileWatcher) addFilmFromPath(ctx context.Context, path string, volumeID primitive.ObjectID) error {
 	// Get subtitle files in same directory
 	subs, err := fw.getRelatedSubFiles(path)
 	if err != nil {
 		log.Debug().Err(err).Str("path", path).Msg("Cannot get related subtitle files")
 	}
-	film := fw.WatcherMetadataGetter.CreateFilm(path, volumeID, subs)
+	film := fw.WatcherMetadataGetter.CreateFilm(ctx, path, volumeID, subs)
 	// Search ID on TMDB
-	if err := fw.WatcherMetadataGetter.FetchFilmTMDBID(film); err != nil {
+	if err := fw.WatcherMetadataGetter.FetchFilmTMDBID(ctx, film); err != nil {
 		log.Warn().Str("file", path).Err(err).Msg("Unable to fetch film ID from TMDB")
 		film.Title = film.Name
 	} else {
 		log.Info().Int("tmdbID", film.TMDBID).Msg("Found media with TMDB ID")
 		// Fill info from TMDB
-		fw.WatcherMetadataGetter.UpdateFilmDetails(film)
+		if err := fw.WatcherMetadataGetter.UpdateFilmDetails(ctx, film); err != nil {
+			log.Error().Err(err).Str("file", path).Msg("Unable to fetch film details from TMDB")
+		}
 	}
 
 	// Add media to DB
-	if err = fw.FileWatcherFilmManager.AddFilm(film, false); err != nil {
+	if err = fw.FileWatcherFilmManager.AddFilm(ctx, film, false); err != nil {
 		log.Error().Err(err).Str("path", film.VolumeFiles[0].Path).Send()
 	}
 
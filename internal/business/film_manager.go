@@ -1,34 +1,44 @@
 package business
 
 import (
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
-	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/Agurato/starfin/internal/model"
 )
 
 type FilmStorer interface {
-	GetFilms() ([]model.Film, error)
-	GetFilmsFiltered(years []int, genre, country string) (films []model.Film)
-	GetFilmsWithActor(actorID int64) (films []model.Film)
-	GetFilmsWithDirector(directorID int64) (films []model.Film)
-	GetFilmsWithWriter(writerID int64) (films []model.Film)
-
-	GetFilmFromID(primitive.ObjectID) (*model.Film, error)
-	GetPersonFromTMDBID(int64) (*model.Person, error)
-
-	IsFilmPresent(film *model.Film) bool
-	AddFilm(film *model.Film) error
-
-	AddVolumeSourceToFilm(film *model.Film) error
-
-	IsPersonPresent(personID int64) bool
-	AddPerson(person *model.Person)
+	GetFilms(ctx context.Context) ([]model.Film, error)
+	GetFilmsFiltered(ctx context.Context, filter model.FilmFilter, listOptions model.ListOptions) (films []model.Film, total int64, err error)
+	GetFilmsWithActor(ctx context.Context, actorID int64) (films []model.Film)
+	GetFilmsWithDirector(ctx context.Context, directorID int64) (films []model.Film)
+	GetFilmsWithWriter(ctx context.Context, writerID int64) (films []model.Film)
+	GetFilmsWithCrewJob(ctx context.Context, personID int64, job string) (films []model.Film)
+
+	GetFilmFromID(ctx context.Context, ID primitive.ObjectID) (*model.Film, error)
+	GetFilmFromPath(ctx context.Context, filmPath string) (*model.Film, error)
+	GetPersonFromTMDBID(ctx context.Context, ID int64) (*model.Person, error)
+	GetPeopleWithName(ctx context.Context, name string) ([]model.Person, error)
+	GetCollectionFromTMDBID(ctx context.Context, tmdbID int64) (*model.Collection, error)
+
+	AddFilm(ctx context.Context, film *model.Film) error
+	MergeFilm(ctx context.Context, film *model.Film) (added bool, err error)
+	UpdateFilmMatch(ctx context.Context, film *model.Film) (merged bool, err error)
+	DeleteFilm(ctx context.Context, ID primitive.ObjectID) error
+	AddCollection(ctx context.Context, collection *model.Collection) error
+
+	IsPersonPresent(ctx context.Context, personID int64) bool
+	AddPerson(ctx context.Context, person *model.Person)
+	AddPersonRetry(ctx context.Context, retry *model.PersonRetry) error
 }
 
 type FilmCacher interface {
@@ -42,52 +52,80 @@ type FilmMetadataGetter interface {
 	GetBackdropLink(key string) string
 	GetPhotoLink(key string) string
 
-	GetTMDBIDFromLink(inputUrl string) (tmdbID int, err error)
-	GetPersonDetails(personID int64) *model.Person
-	UpdateFilmDetails(film *model.Film)
+	GetTMDBIDFromLink(ctx context.Context, inputUrl string) (tmdbID int, err error)
+	FetchFilmTMDBID(ctx context.Context, f *model.Film) error
+	GetPersonDetails(ctx context.Context, personID int64) *model.Person
+	GetCollectionDetails(ctx context.Context, collectionID int64) (*model.Collection, error)
+	UpdateFilmDetails(ctx context.Context, film *model.Film) error
+}
+
+// FilmSearchIndexer keeps the search index up to date, and searches it
+type FilmSearchIndexer interface {
+	IndexFilm(film *model.Film)
+	IndexPerson(person *model.Person)
+	RemoveFilm(filmID primitive.ObjectID)
+
+	SearchFilms(query string) []primitive.ObjectID
 }
 
 // FilmFilterer holds the different filters that can be applied
 type FilmFilterer interface {
 	AddFilm(films *model.Film)
+	RemoveFilm(filmID primitive.ObjectID)
+
+	GetLanguageName(code string) string
+	GetTechnicalFacets() model.TechnicalFacets
 }
 
+// collectionRefreshInterval is the minimum time between two fetches of a collection, whose films are refreshed one by one
+const collectionRefreshInterval = 24 * time.Hour
+
+// relevanceChunkSize is the number of films found by a search that are fetched at once when sorting by relevance
+const relevanceChunkSize = 200
+
 type FilmManager struct {
 	FilmStorer
 	FilmCacher
 	FilmMetadataGetter
 	FilmFilterer
-	specialChars *regexp.Regexp
+	FilmSearchIndexer
 }
 
-func NewFilmManager(fs FilmStorer, fc FilmCacher, fdg FilmMetadataGetter, ff *Filterer) *FilmManager {
+func NewFilmManager(fs FilmStorer, fc FilmCacher, fdg FilmMetadataGetter, ff *Filterer, fsi FilmSearchIndexer) *FilmManager {
 	return &FilmManager{
 		FilmStorer:         fs,
 		FilmCacher:         fc,
 		FilmMetadataGetter: fdg,
 		FilmFilterer:       ff,
-		specialChars:       regexp.MustCompile("[.,\\/#!$%\\^&\\*;:{}=\\-_~()%\\s\\\\]"),
+		FilmSearchIndexer:  fsi,
 	}
 }
 
-func (fm FilmManager) CacheFilms() {
-	films, _ := fm.FilmStorer.GetFilms()
-	for _, film := range films {
-		fm.cachePosterAndBackdrop(&film)
-		for _, personID := range film.GetCastAndCrewIDs() {
-			person, _ := fm.GetPersonFromTMDBID(personID)
-			fm.cachePersonPhoto(person)
+// CacheFilm caches the poster and the backdrop of a film, and the photos of its people.
+// It stops as soon as the context is done
+func (fm FilmManager) CacheFilm(ctx context.Context, film *model.Film) error {
+	fm.cachePosterAndBackdrop(film)
+	for _, personID := range film.GetCastAndCrewIDs() {
+		person, err := fm.GetPersonFromTMDBID(ctx, personID)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Warn().Err(err).Int64("tmdbID", personID).Msg("Unable to get person to cache their photo")
+			continue
 		}
+		fm.cachePersonPhoto(person)
 	}
+	return nil
 }
 
 // GetFilm returns a Film from its hexadecimal ID
-func (fm FilmManager) GetFilm(filmHexID string) (*model.Film, error) {
+func (fm FilmManager) GetFilm(ctx context.Context, filmHexID string) (*model.Film, error) {
 	filmId, err := primitive.ObjectIDFromHex(filmHexID)
 	if err != nil {
 		return nil, fmt.Errorf("incorrect film ID: %w", err)
 	}
-	film, err := fm.FilmStorer.GetFilmFromID(filmId)
+	film, err := fm.FilmStorer.GetFilmFromID(ctx, filmId)
 	if err != nil {
 		return nil, fmt.Errorf("could not get film from ID '%s': %w", filmHexID, err)
 	}
@@ -95,8 +133,8 @@ func (fm FilmManager) GetFilm(filmHexID string) (*model.Film, error) {
 }
 
 // GetFilmPath returns the filepath to a film given its hexadecimal ID and its index in the volume file slice
-func (fm FilmManager) GetFilmPath(filmHexID, filmIndex string) (string, error) {
-	film, err := fm.GetFilm(filmHexID)
+func (fm FilmManager) GetFilmPath(ctx context.Context, filmHexID, filmIndex string) (string, error) {
+	film, err := fm.GetFilm(ctx, filmHexID)
 	if err != nil {
 		return "", err
 	}
@@ -112,8 +150,8 @@ func (fm FilmManager) GetFilmPath(filmHexID, filmIndex string) (string, error) {
 }
 
 // GetFilmSubtitlePath returns the filepath to a subtitle for a film given the film's hexadecimal ID, the film's index in the volume file slice, and the subtitle's index in the external subtitle slice
-func (fm FilmManager) GetFilmSubtitlePath(filmHexID, filmIndex, subtitleIndex string) (string, error) {
-	film, err := fm.GetFilm(filmHexID)
+func (fm FilmManager) GetFilmSubtitlePath(ctx context.Context, filmHexID, filmIndex, subtitleIndex string) (string, error) {
+	film, err := fm.GetFilm(ctx, filmHexID)
 	if err != nil {
 		return "", err
 	}
@@ -138,85 +176,354 @@ func (fm FilmManager) GetFilmSubtitlePath(filmHexID, filmIndex, subtitleIndex st
 }
 
 // GetFilms returns the full slice of films in the database
-func (fm FilmManager) GetFilms() []model.Film {
-	films, _ := fm.FilmStorer.GetFilms()
+func (fm FilmManager) GetFilms(ctx context.Context) []model.Film {
+	films, _ := fm.FilmStorer.GetFilms(ctx)
 	return films
 }
 
-// GetFilmsFiltered returns a slice of films, filtered with years of release date, genre, country, and search terms
-func (fm FilmManager) GetFilmsFiltered(years []int, genre, country, search string) []model.Film {
-	films := fm.FilmStorer.GetFilmsFiltered(years, genre, country)
+// GetFilmsFiltered returns the films matching the filter and the query, sorted and limited by the list options,
+// and the total number of matching films. See ParseFilmQuery for the syntax of the query.
+// Queries containing words to search can also be sorted by relevance
+func (fm FilmManager) GetFilmsFiltered(ctx context.Context, filter model.FilmFilter, query string, listOptions model.ListOptions) ([]model.Film, int64, error) {
+	node, err := ParseFilmQuery(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	search := ""
+	if node != nil {
+		filter.Query = fm.resolveQuery(ctx, node)
+		search = getQuerySearch(node)
+	}
 
-	search = strings.Trim(search, " ")
+	if listOptions.Sort == model.FilmSortRelevance && search == "" {
+		listOptions.Sort = model.FilmSortTitle
+	}
+	if listOptions.Sort != model.FilmSortRelevance {
+		return fm.FilmStorer.GetFilmsFiltered(ctx, filter, listOptions)
+	}
 
-	var filteredFilms []model.Film
+	_, total, err := fm.FilmStorer.GetFilmsFiltered(ctx, filter, model.ListOptions{Limit: 1})
+	if err != nil {
+		return nil, 0, err
+	}
+	films, err := fm.getFilmsByRelevance(ctx, filter, fm.FilmSearchIndexer.SearchFilms(search), listOptions)
+	return films, total, err
+}
 
-	search = fm.specialChars.ReplaceAllString(strings.ToLower(search), "")
-	for _, m := range films {
-		title := fm.specialChars.ReplaceAllString(strings.ToLower(m.Title), "")
-		originalTitle := fm.specialChars.ReplaceAllString(strings.ToLower(m.OriginalTitle), "")
-		if strings.Contains(title, search) || strings.Contains(originalTitle, search) {
-			filteredFilms = append(filteredFilms, m)
+// getFilmsByRelevance returns the page of the films matching the filter, the films found by the search coming first in relevance order.
+// The relevance is only known by the search index, so the films found are fetched by chunks in that order,
+// and only the page and one chunk are held in memory
+func (fm FilmManager) getFilmsByRelevance(ctx context.Context, filter model.FilmFilter, ranked []primitive.ObjectID, listOptions model.ListOptions) ([]model.Film, error) {
+	query := filter.Query
+	skip := listOptions.Skip
+	films := []model.Film{}
+	isFull := func() bool { return listOptions.Limit > 0 && int64(len(films)) >= listOptions.Limit }
+	for start := 0; start < len(ranked) && !isFull(); start += relevanceChunkSize {
+		chunk := ranked[start:min(start+relevanceChunkSize, len(ranked))]
+		filter.Query = model.QueryAnd{Nodes: []model.QueryNode{query, model.QueryText{FilmIDs: chunk}}}
+		found, _, err := fm.FilmStorer.GetFilmsFiltered(ctx, filter, model.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		rank := make(map[primitive.ObjectID]int, len(chunk))
+		for i, filmID := range chunk {
+			rank[filmID] = i
 		}
+		slices.SortFunc(found, func(a, b model.Film) int {
+			return cmp.Compare(rank[a.ID], rank[b.ID])
+		})
+		for _, film := range found {
+			if isFull() {
+				break
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			films = append(films, film)
+		}
+	}
+	if isFull() {
+		return films, nil
 	}
 
-	return filteredFilms
+	// Then come the films that were not found by the search, which can happen when several groups of words were searched separately
+	filter.Query = model.QueryAnd{Nodes: []model.QueryNode{query, model.QueryNot{Node: model.QueryText{FilmIDs: ranked}}}}
+	restOptions := model.ListOptions{Sort: model.FilmSortTitle, Skip: skip}
+	if listOptions.Limit > 0 {
+		restOptions.Limit = listOptions.Limit - int64(len(films))
+	}
+	rest, _, err := fm.FilmStorer.GetFilmsFiltered(ctx, filter, restOptions)
+	if err != nil {
+		return nil, err
+	}
+	return append(films, rest...), nil
+}
+
+// resolveQuery returns a copy of the query where the people are replaced by their TMDB IDs,
+// the words to search by the IDs of the films found, and the language names by their codes
+func (fm FilmManager) resolveQuery(ctx context.Context, node model.QueryNode) model.QueryNode {
+	switch n := node.(type) {
+	case model.QueryAnd:
+		nodes := make([]model.QueryNode, len(n.Nodes))
+		for i, child := range n.Nodes {
+			nodes[i] = fm.resolveQuery(ctx, child)
+		}
+		return model.QueryAnd{Nodes: nodes}
+	case model.QueryOr:
+		nodes := make([]model.QueryNode, len(n.Nodes))
+		for i, child := range n.Nodes {
+			nodes[i] = fm.resolveQuery(ctx, child)
+		}
+		return model.QueryOr{Nodes: nodes}
+	case model.QueryNot:
+		return model.QueryNot{Node: fm.resolveQuery(ctx, n.Node)}
+	case model.QueryPerson:
+		people, err := fm.FilmStorer.GetPeopleWithName(ctx, n.Name)
+		if err != nil {
+			log.Error().Err(err).Str("name", n.Name).Msg("Unable to get people from name")
+		}
+		n.TMDBIDs = []int64{}
+		for _, person := range people {
+			n.TMDBIDs = append(n.TMDBIDs, person.TMDBID)
+		}
+		return n
+	case model.QueryText:
+		n.FilmIDs = fm.FilmSearchIndexer.SearchFilms(n.Text)
+		return n
+	case model.QueryMatch:
+		if n.Field == model.QueryFieldAudio || n.Field == model.QueryFieldSubtitles {
+			n.Value = fm.getLanguageCode(n.Value)
+		}
+		return n
+	}
+	return node
+}
+
+// getLanguageCode returns the code of a language of the library from its English name, e.g. "fr" for "French".
+// Values that are not the name of a language of the library are returned as is
+func (fm FilmManager) getLanguageCode(value string) string {
+	facets := fm.FilmFilterer.GetTechnicalFacets()
+	name := model.NormalizeText(value)
+	for _, languages := range [][]model.FacetValue{facets.AudioLanguages, facets.SubtitleLanguages} {
+		for _, language := range languages {
+			if model.NormalizeText(fm.FilmFilterer.GetLanguageName(language.Value)) == name {
+				return language.Value
+			}
+		}
+	}
+	return value
+}
+
+// getQuerySearch returns the words a film must contain to match the query, which give the relevance of the results
+func getQuerySearch(node model.QueryNode) string {
+	switch n := node.(type) {
+	case model.QueryText:
+		return n.Text
+	case model.QueryAnd:
+		var texts []string
+		for _, child := range n.Nodes {
+			if text, ok := child.(model.QueryText); ok {
+				texts = append(texts, text.Text)
+			}
+		}
+		return strings.Join(texts, " ")
+	}
+	return ""
 }
 
-func (fm FilmManager) GetFilmsWithActor(actorID int64) (films []model.Film) {
-	return fm.FilmStorer.GetFilmsWithActor(actorID)
+func (fm FilmManager) GetFilmsWithActor(ctx context.Context, actorID int64) (films []model.Film) {
+	return fm.FilmStorer.GetFilmsWithActor(ctx, actorID)
 }
-func (fm FilmManager) GetFilmsWithDirector(directorID int64) (films []model.Film) {
-	return fm.FilmStorer.GetFilmsWithDirector(directorID)
+func (fm FilmManager) GetFilmsWithDirector(ctx context.Context, directorID int64) (films []model.Film) {
+	return fm.FilmStorer.GetFilmsWithDirector(ctx, directorID)
 }
-func (fm FilmManager) GetFilmsWithWriter(writerID int64) (films []model.Film) {
-	return fm.FilmStorer.GetFilmsWithWriter(writerID)
+func (fm FilmManager) GetFilmsWithWriter(ctx context.Context, writerID int64) (films []model.Film) {
+	return fm.FilmStorer.GetFilmsWithWriter(ctx, writerID)
+}
+func (fm FilmManager) GetFilmsWithCrewJob(ctx context.Context, personID int64, job string) (films []model.Film) {
+	return fm.FilmStorer.GetFilmsWithCrewJob(ctx, personID, job)
 }
 
-func (fm FilmManager) EditFilmWithLink(filmID, inputUrl string) error {
-	tmdbID, err := fm.FilmMetadataGetter.GetTMDBIDFromLink(inputUrl)
+// EditFilmWithLink matches a film with the TMDB film of the link, and returns its ID,
+// which changes if the film is merged into the one of the library that already had this TMDB ID
+func (fm FilmManager) EditFilmWithLink(ctx context.Context, filmID, inputUrl string) (string, error) {
+	tmdbID, err := fm.FilmMetadataGetter.GetTMDBIDFromLink(ctx, inputUrl)
 	if err != nil {
-		return fmt.Errorf("error getting TMDB ID from URL '%s': %w", inputUrl, err)
+		return "", fmt.Errorf("error getting TMDB ID from URL '%s': %w", inputUrl, err)
 	}
+	return fm.matchFilm(ctx, filmID, tmdbID)
+}
 
-	film, err := fm.GetFilm(filmID)
+// RematchFilm searches TMDB again for a film, from the name and the year read in its file name, and returns its ID,
+// which changes if the film is merged into the one of the library that already had the TMDB ID found
+func (fm FilmManager) RematchFilm(ctx context.Context, filmID string) (string, error) {
+	film, err := fm.GetFilm(ctx, filmID)
 	if err != nil {
-		return fmt.Errorf("error getting film: %w", err)
+		return "", fmt.Errorf("error getting film: %w", err)
 	}
-	film.TMDBID = tmdbID
-	fm.FilmMetadataGetter.UpdateFilmDetails(film)
-	err = fm.AddFilm(film, true)
+	search := &model.Film{Name: film.Name, ReleaseYear: film.ReleaseYear}
+	if err := fm.FilmMetadataGetter.FetchFilmTMDBID(ctx, search); err != nil {
+		return "", fmt.Errorf("error searching film '%s' on TMDB: %w", film.Name, err)
+	}
+	return fm.matchFilm(ctx, filmID, search.TMDBID)
+}
+
+// matchFilm matches a film with a TMDB film, and returns its ID, which changes if the film is merged.
+// The details are fetched first, so that the film is left as it is if they cannot be
+func (fm FilmManager) matchFilm(ctx context.Context, filmID string, tmdbID int) (string, error) {
+	film, err := fm.GetFilm(ctx, filmID)
 	if err != nil {
-		return fmt.Errorf("could not update film in database: %w", err)
+		return "", fmt.Errorf("error getting film: %w", err)
+	}
+	if film.TMDBID == tmdbID {
+		if err := fm.FilmMetadataGetter.UpdateFilmDetails(ctx, film); err != nil {
+			return "", fmt.Errorf("could not fetch film details: %w", err)
+		}
+		if err := fm.AddFilm(ctx, film, true); err != nil {
+			return "", fmt.Errorf("could not update film in database: %w", err)
+		}
+		return filmID, nil
 	}
 
-	return nil
+	// Nothing of the previous TMDB film must be kept if it is not fetched again
+	film.TMDBID = tmdbID
+	film.Classification = ""
+	film.AlternativeTitles = nil
+	film.TranslatedTitles = nil
+	film.Ratings = nil
+	film.RatingsRefreshed = time.Time{}
+	if err := fm.FilmMetadataGetter.UpdateFilmDetails(ctx, film); err != nil {
+		return "", fmt.Errorf("could not fetch film details: %w", err)
+	}
+	merged, err := fm.FilmStorer.UpdateFilmMatch(ctx, film)
+	if err != nil {
+		return "", fmt.Errorf("could not update film in database: %w", err)
+	}
+	if merged {
+		// Removes the merged film from the filters and the search index
+		mergedID, _ := primitive.ObjectIDFromHex(filmID)
+		fm.ReindexFilm(ctx, mergedID)
+	} else {
+		go fm.cachePosterAndBackdrop(film)
+	}
+	fm.ReindexFilm(ctx, film.ID)
+	fm.addMissingPeople(ctx, film)
+	fm.updateCollection(ctx, film)
+	return film.ID.Hex(), nil
 }
 
-func (fm FilmManager) AddFilm(film *model.Film, update bool) error {
-	if update || film.TMDBID == 0 || !fm.FilmStorer.IsFilmPresent(film) {
-		if err := fm.FilmStorer.AddFilm(film); err != nil {
-			return errors.New("cannot add film to database")
-		}
+// AddFilm adds a film to the database, or merges its files into the film having the same TMDB ID.
+// With update, the film replaces the one having the same ID
+func (fm FilmManager) AddFilm(ctx context.Context, film *model.Film, update bool) error {
+	if film.DateAdded.IsZero() {
+		film.DateAdded = time.Now()
+	}
+	added := true
+	var err error
+	if update || film.TMDBID == 0 {
+		err = fm.FilmStorer.AddFilm(ctx, film)
+	} else {
+		added, err = fm.FilmStorer.MergeFilm(ctx, film)
+	}
+	if err != nil {
+		return fmt.Errorf("could not add film to database: %w", err)
+	}
+	if added {
 		fm.FilmFilterer.AddFilm(film)
+		fm.FilmSearchIndexer.IndexFilm(film)
 		// Cache poster, backdrop
 		go fm.cachePosterAndBackdrop(film)
 	} else {
-		if err := fm.FilmStorer.AddVolumeSourceToFilm(film); err != nil {
-			return errors.New("cannot add volume source to film in database")
-		}
+		// The film in the database has new technical characteristics
+		fm.ReindexFilm(ctx, film.ID)
 	}
 
+	fm.addMissingPeople(ctx, film)
+	fm.updateCollection(ctx, film)
+
+	return nil
+}
+
+// addMissingPeople fetches the people of a film who are not in the database yet
+func (fm FilmManager) addMissingPeople(ctx context.Context, film *model.Film) {
 	for _, personID := range film.GetCastAndCrewIDs() {
-		if !fm.FilmStorer.IsPersonPresent(personID) {
-			person := fm.FilmMetadataGetter.GetPersonDetails(personID)
-			fm.FilmStorer.AddPerson(person)
+		if !fm.FilmStorer.IsPersonPresent(ctx, personID) {
+			person := fm.FilmMetadataGetter.GetPersonDetails(ctx, personID)
+			fetched := !person.LastRefreshed.IsZero()
+			if !fetched {
+				// The person is stored with only its TMDB ID until the fetch is retried, and is not refreshed meanwhile
+				person.LastRefreshed = time.Now()
+				if err := fm.FilmStorer.AddPersonRetry(ctx, getPersonRetry(personID, 1)); err != nil {
+					log.Error().Err(err).Int64("tmdbID", personID).Msg("Unable to plan person fetch retry")
+				}
+			}
+			fm.FilmStorer.AddPerson(ctx, person)
+			if !fetched {
+				continue
+			}
+			fm.FilmSearchIndexer.IndexPerson(person)
 			// Cache photos
 			go fm.cachePersonPhoto(person)
 		}
 	}
+}
 
-	return nil
+// GetFilmCollection returns the collection a film belongs to, or nil if it belongs to none
+func (fm FilmManager) GetFilmCollection(ctx context.Context, film *model.Film) (*model.Collection, error) {
+	if film.CollectionID == 0 {
+		return nil, nil
+	}
+	collection, err := fm.FilmStorer.GetCollectionFromTMDBID(ctx, film.CollectionID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get collection of film '%s': %w", film.ID.Hex(), err)
+	}
+	return collection, nil
+}
+
+// updateCollection fetches the collection of a film if it is not in the database yet or if it is outdated,
+// as films may have been added to it since
+func (fm FilmManager) updateCollection(ctx context.Context, film *model.Film) {
+	if film.CollectionID == 0 {
+		return
+	}
+	stored, err := fm.FilmStorer.GetCollectionFromTMDBID(ctx, film.CollectionID)
+	if err == nil && time.Since(stored.LastRefreshed) < collectionRefreshInterval {
+		return
+	}
+
+	collection, fetchErr := fm.FilmMetadataGetter.GetCollectionDetails(ctx, film.CollectionID)
+	if fetchErr != nil {
+		log.Error().Err(fetchErr).Int64("collectionID", film.CollectionID).Msg("Unable to fetch collection")
+		return
+	}
+	if err == nil {
+		// Keep the ID, which is in the link to the collection page
+		collection.ID = stored.ID
+	}
+	if err := fm.FilmStorer.AddCollection(ctx, collection); err != nil {
+		log.Error().Err(err).Int64("collectionID", film.CollectionID).Msg("Unable to add collection to database")
+		return
+	}
+	// Cache poster, backdrop, and the posters of the films of the collection
+	go fm.cacheCollectionImages(collection)
+}
+
+// ReindexFilm updates the filters and the search index after a film changed in the database,
+// or removes the film from them if it was deleted
+func (fm FilmManager) ReindexFilm(ctx context.Context, filmID primitive.ObjectID) {
+	film, err := fm.FilmStorer.GetFilmFromID(ctx, filmID)
+	if errors.Is(err, model.ErrNotFound) {
+		fm.FilmFilterer.RemoveFilm(filmID)
+		fm.FilmSearchIndexer.RemoveFilm(filmID)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("filmID", filmID.Hex()).Msg("Unable to reindex film")
+		return
+	}
+	fm.FilmFilterer.AddFilm(film)
+	fm.FilmSearchIndexer.IndexFilm(film)
 }
 
 // cachePosterAndBackdrop caches the poster and the backdrop image of a film
@@ -237,6 +544,27 @@ func (fm FilmManager) cachePosterAndBackdrop(film *model.Film) {
 	}
 }
 
+// cacheCollectionImages caches the poster and the backdrop of a collection, and the posters of its films
+func (fm FilmManager) cacheCollectionImages(collection *model.Collection) {
+	posters := []string{collection.PosterPath}
+	for _, part := range collection.Parts {
+		posters = append(posters, part.PosterPath)
+	}
+	for _, poster := range posters {
+		if poster == "" {
+			continue
+		}
+		if _, err := fm.FilmCacher.CachePoster(fm.FilmMetadataGetter.GetPosterLink(poster), poster); err != nil {
+			log.Debug().Err(err).Int64("collectionID", collection.TMDBID).Msg("Could not cache poster")
+		}
+	}
+	if collection.BackdropPath != "" {
+		if _, err := fm.FilmCacher.CacheBackdrop(fm.FilmMetadataGetter.GetBackdropLink(collection.BackdropPath), collection.BackdropPath); err != nil {
+			log.Debug().Err(err).Int64("collectionID", collection.TMDBID).Msg("Could not cache backdrop")
+		}
+	}
+}
+
 // cacheCast caches the person's image
 func (fm FilmManager) cachePersonPhoto(person *model.Person) {
 	hasToWait, err := fm.FilmCacher.CachePhoto(fm.FilmMetadataGetter.GetPhotoLink(person.Photo), person.Photo)
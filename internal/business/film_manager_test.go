@@ -0,0 +1,171 @@
+package business_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// TestGetFilmsFilteredByRelevance pages through more films found by a search than are fetched at once
+func TestGetFilmsFilteredByRelevance(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	searchIndex := business.NewSearchIndex()
+	fm := business.NewFilmManager(db, fakeCache{}, fakeMetadata{}, business.NewFilterer(), searchIndex)
+	films := []model.Film{
+		{ID: primitive.NewObjectID(), TMDBID: 348, Title: "Alien", ReleaseYear: 1979},
+		{ID: primitive.NewObjectID(), TMDBID: 679, Title: "Aliens", ReleaseYear: 1986},
+	}
+	for i := 0; i < 450; i++ {
+		films = append(films, model.Film{ID: primitive.NewObjectID(), TMDBID: 1000 + i, Title: fmt.Sprintf("Heat %d", i), ReleaseYear: 1995 + i%2})
+	}
+	for _, film := range films {
+		film := film
+		require.NoError(t, fm.AddFilm(ctx, &film, true))
+	}
+	titles := make(map[primitive.ObjectID]string)
+	years := make(map[primitive.ObjectID]int)
+	for _, film := range films {
+		titles[film.ID] = film.Title
+		years[film.ID] = film.ReleaseYear
+	}
+	var heat, heat1995 []string
+	for _, filmID := range searchIndex.SearchFilms("heat") {
+		heat = append(heat, titles[filmID])
+		if years[filmID] == 1995 {
+			heat1995 = append(heat1995, titles[filmID])
+		}
+	}
+	require.Len(t, heat, 450)
+
+	tests := []struct {
+		query  string
+		titles []string
+	}{
+		{"heat", heat},
+		{"heat year:1995", heat1995},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			var pages []string
+			for skip := int64(0); ; skip += 60 {
+				page, total, err := fm.GetFilmsFiltered(ctx, model.FilmFilter{}, test.query, model.ListOptions{Sort: model.FilmSortRelevance, Skip: skip, Limit: 60})
+				require.NoError(t, err)
+				assert.Equal(t, int64(len(test.titles)), total)
+				if len(page) == 0 {
+					break
+				}
+				for _, film := range page {
+					pages = append(pages, film.Title)
+				}
+			}
+			assert.Equal(t, test.titles, pages)
+
+			all, _, err := fm.GetFilmsFiltered(ctx, model.FilmFilter{}, test.query, model.ListOptions{Sort: model.FilmSortRelevance})
+			require.NoError(t, err)
+			assert.Len(t, all, len(test.titles))
+		})
+	}
+}
+
+// failingMatchStorer fails to save the films matched with another TMDB film
+type failingMatchStorer struct {
+	*infrastructure.Memory
+}
+
+func (failingMatchStorer) UpdateFilmMatch(ctx context.Context, film *model.Film) (bool, error) {
+	return false, errors.New("database is down")
+}
+
+// TestMatchFilm matches films with other TMDB films, which are left as they are if the match fails
+func TestMatchFilm(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	metadata := fakeMetadata{"Heat": 949, "Alien": 348}
+	searchIndex := business.NewSearchIndex()
+	fm := business.NewFilmManager(db, fakeCache{}, metadata, business.NewFilterer(), searchIndex)
+
+	// Both films were matched with the wrong TMDB film
+	heat := metadata.CreateFilm(ctx, "/films/Heat.1995.mkv", primitive.NewObjectID(), nil)
+	heat.TMDBID, heat.Title, heat.Classification = 1000, "Wrong film", "R"
+	heat.Ratings = map[string]model.Rating{model.RatingSourceIMDb: {Value: 2.1, Best: 10}}
+	require.NoError(t, fm.AddFilm(ctx, heat, false))
+	alienCopy := metadata.CreateFilm(ctx, "/other/Alien.1979.mkv", primitive.NewObjectID(), nil)
+	alienCopy.TMDBID, alienCopy.Title = 1001, "Other wrong film"
+	require.NoError(t, fm.AddFilm(ctx, alienCopy, false))
+	alien := metadata.CreateFilm(ctx, "/films/Alien.1979.mkv", primitive.NewObjectID(), nil)
+	require.NoError(t, metadata.FetchFilmTMDBID(ctx, alien))
+	require.NoError(t, metadata.UpdateFilmDetails(ctx, alien))
+	require.NoError(t, fm.AddFilm(ctx, alien, false))
+	stored, err := fm.GetFilm(ctx, heat.ID.Hex())
+	require.NoError(t, err)
+
+	// The details of an unknown TMDB film cannot be fetched
+	_, err = fm.EditFilmWithLink(ctx, heat.ID.Hex(), "https://www.themoviedb.org/movie/5")
+	assert.Error(t, err)
+	saved, err := fm.GetFilm(ctx, heat.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, stored, saved)
+
+	// The film cannot be saved
+	failing := business.NewFilmManager(failingMatchStorer{db}, fakeCache{}, metadata, business.NewFilterer(), business.NewSearchIndex())
+	_, err = failing.EditFilmWithLink(ctx, heat.ID.Hex(), "https://www.themoviedb.org/movie/949")
+	assert.Error(t, err)
+	saved, err = fm.GetFilm(ctx, heat.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, stored, saved)
+
+	// A TMDB film that is not in the library yet keeps the film, without anything of the previous TMDB film
+	filmID, err := fm.EditFilmWithLink(ctx, heat.ID.Hex(), "https://www.themoviedb.org/movie/949")
+	require.NoError(t, err)
+	assert.Equal(t, heat.ID.Hex(), filmID)
+	saved, err = fm.GetFilm(ctx, filmID)
+	require.NoError(t, err)
+	assert.Equal(t, 949, saved.TMDBID)
+	assert.Equal(t, "Heat", saved.Title)
+	assert.Equal(t, []int64{9490}, saved.Directors)
+	assert.Empty(t, saved.Classification)
+	assert.Empty(t, saved.Ratings)
+	assert.Equal(t, stored.DateAdded, saved.DateAdded)
+	assert.Equal(t, []primitive.ObjectID{heat.ID}, searchIndex.SearchFilms("heat"))
+	assert.True(t, db.IsPersonPresent(ctx, 9490))
+
+	// A TMDB film that is already in the library gets the files of the film, which is removed
+	filmID, err = fm.RematchFilm(ctx, alienCopy.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, alien.ID.Hex(), filmID)
+	saved, err = fm.GetFilm(ctx, filmID)
+	require.NoError(t, err)
+	assert.Len(t, saved.VolumeFiles, 2)
+	_, err = fm.GetFilm(ctx, alienCopy.ID.Hex())
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	assert.EqualValues(t, 2, db.GetFilmCount(ctx))
+	assert.Equal(t, []primitive.ObjectID{alien.ID}, searchIndex.SearchFilms("alien"))
+	assert.Empty(t, searchIndex.SearchFilms("other wrong"))
+}
+
+// TestCacheFilm stops caching the images once the context is done
+func TestCacheFilm(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	fm := business.NewFilmManager(db, fakeCache{}, fakeMetadata{}, business.NewFilterer(), business.NewSearchIndex())
+	film := model.Film{ID: primitive.NewObjectID(), TMDBID: 949, Title: "Heat", Directors: []int64{1}, Characters: []model.Character{{ActorID: 2}}}
+	require.NoError(t, db.AddFilm(ctx, &film))
+	db.AddPerson(ctx, &model.Person{ID: primitive.NewObjectID(), TMDBID: 1, Name: "Michael Mann"})
+
+	// The person who is not in the database is skipped
+	assert.NoError(t, fm.CacheFilm(ctx, &film))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, fm.CacheFilm(cancelled, &film), context.Canceled)
+}
@@ -0,0 +1,384 @@
+package business
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pariz/gountries"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// queryFields are the fields of the film query language. Unknown fields are searched as text
+var queryFields = []string{
+	model.QueryFieldGenre, model.QueryFieldCountry, model.QueryFieldYear, model.QueryFieldRuntime, model.QueryFieldRating,
+	model.QueryFieldDirector, model.QueryFieldWriter, model.QueryFieldActor,
+	model.QueryFieldResolution, model.QueryFieldCodec, model.QueryFieldHDR, model.QueryFieldAudio, model.QueryFieldSubtitles,
+}
+
+var (
+	queryDecadeRegex     = regexp.MustCompile(`^\d{3}0s$`)
+	queryResolutionRegex = regexp.MustCompile(`^\d+$`)
+)
+
+type queryTokenType int
+
+const (
+	queryTokenText  queryTokenType = iota // A word or a quoted phrase
+	queryTokenField                       // A field and its comma-separated values
+	queryTokenNot
+	queryTokenOr
+	queryTokenOpen
+	queryTokenClose
+)
+
+type queryToken struct {
+	tokenType queryTokenType
+	text      string // Text, or name of the field
+	values    []string
+}
+
+// ParseFilmQuery parses a film query such as `genre:horror,thriller -genre:comedy year:1970..1989 director:"John Carpenter"`.
+// Terms separated by spaces must all match, whereas comma-separated values and terms separated by OR match if any of them does.
+// Terms prefixed with "-" exclude the films they match, and parentheses group terms.
+// Words without a field are searched in the titles, overviews and credits. An empty query returns a nil node
+func ParseFilmQuery(query string) (model.QueryNode, error) {
+	tokens, err := lexFilmQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", model.ErrInvalidQuery, err)
+	}
+	parser := queryParser{tokens: tokens}
+	node, err := parser.parseAnd()
+	if err == nil && parser.pos < len(tokens) {
+		err = fmt.Errorf("unexpected ')'")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", model.ErrInvalidQuery, err)
+	}
+	return node, nil
+}
+
+// lexFilmQuery splits a film query into tokens
+func lexFilmQuery(query string) (tokens []queryToken, err error) {
+	runes := []rune(query)
+	isSeparator := func(r rune) bool {
+		return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+	}
+
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, queryToken{tokenType: queryTokenOpen})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{tokenType: queryTokenClose})
+			i++
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && runes[i+1] != ')':
+			tokens = append(tokens, queryToken{tokenType: queryTokenNot})
+			i++
+		case r == '"':
+			var phrase string
+			phrase, i, err = readQuotedValue(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			// Punctuation alone cannot be searched
+			if len(model.SearchTokens(phrase)) > 0 {
+				tokens = append(tokens, queryToken{tokenType: queryTokenText, text: phrase})
+			}
+		default:
+			start := i
+			for i < len(runes) && !isSeparator(runes[i]) && runes[i] != ':' {
+				i++
+			}
+			word := string(runes[start:i])
+			field := strings.ToLower(word)
+			if i < len(runes) && runes[i] == ':' && slices.Contains(queryFields, field) {
+				var values []string
+				values, i, err = readFieldValues(runes, i+1, field)
+				if err != nil {
+					return nil, err
+				}
+				tokens = append(tokens, queryToken{tokenType: queryTokenField, text: field, values: values})
+				continue
+			}
+			// Colons in titles such as "Mission:Impossible" are part of the text
+			for i < len(runes) && !isSeparator(runes[i]) {
+				i++
+			}
+			word = string(runes[start:i])
+			if word == "OR" {
+				tokens = append(tokens, queryToken{tokenType: queryTokenOr})
+			} else if len(model.SearchTokens(word)) > 0 {
+				tokens = append(tokens, queryToken{tokenType: queryTokenText, text: word})
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// readFieldValues reads the comma-separated values of a field, quoted or not, starting at index i.
+// It returns the values and the index following them
+func readFieldValues(runes []rune, i int, field string) (values []string, next int, err error) {
+	for {
+		var value string
+		if i < len(runes) && runes[i] == '"' {
+			value, i, err = readQuotedValue(runes, i)
+			if err != nil {
+				return nil, i, err
+			}
+		} else {
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`,()"`, runes[i]) {
+				i++
+			}
+			value = string(runes[start:i])
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return nil, i, fmt.Errorf("missing value for '%s'", field)
+		}
+		values = append(values, value)
+
+		if i >= len(runes) || runes[i] != ',' {
+			return values, i, nil
+		}
+		i++
+	}
+}
+
+// readQuotedValue reads a value between double quotes starting at index i, where backslashes escape quotes.
+// It returns the value and the index following the closing quote
+func readQuotedValue(runes []rune, i int) (value string, next int, err error) {
+	var sb strings.Builder
+	for i++; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+				sb.WriteRune(runes[i])
+			}
+		case '"':
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteRune(runes[i])
+		}
+	}
+	return "", i, fmt.Errorf("missing closing quote")
+}
+
+// queryParser builds the tree of a film query from its tokens.
+// OR takes precedence over the implicit AND between terms, so that `a b OR c` means `a (b OR c)`
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+// parseAnd parses the terms until the end of the query or a closing parenthesis
+func (p *queryParser) parseAnd() (model.QueryNode, error) {
+	var nodes []model.QueryNode
+	for p.pos < len(p.tokens) && p.tokens[p.pos].tokenType != queryTokenClose {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		// Consecutive words are searched together
+		if text, ok := node.(model.QueryText); ok && len(nodes) > 0 {
+			if previous, ok := nodes[len(nodes)-1].(model.QueryText); ok {
+				nodes[len(nodes)-1] = model.QueryText{Text: previous.Text + " " + text.Text}
+				continue
+			}
+		}
+		nodes = append(nodes, node)
+	}
+
+	switch len(nodes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return nodes[0], nil
+	default:
+		return model.QueryAnd{Nodes: nodes}, nil
+	}
+}
+
+// parseOr parses a term, and the following terms separated by OR
+func (p *queryParser) parseOr() (model.QueryNode, error) {
+	node, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []model.QueryNode{node}
+	for p.pos < len(p.tokens) && p.tokens[p.pos].tokenType == queryTokenOr {
+		p.pos++
+		node, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return model.QueryOr{Nodes: nodes}, nil
+}
+
+// parseTerm parses a single term, which may be negated or a group of terms between parentheses
+func (p *queryParser) parseTerm() (model.QueryNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("missing term at the end")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch token.tokenType {
+	case queryTokenNot:
+		node, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		return model.QueryNot{Node: node}, nil
+	case queryTokenOpen:
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		p.pos++
+		if node == nil {
+			return nil, fmt.Errorf("empty parentheses")
+		}
+		return node, nil
+	case queryTokenField:
+		return parseFieldValues(token.text, token.values)
+	case queryTokenText:
+		return model.QueryText{Text: token.text}, nil
+	case queryTokenOr:
+		return nil, fmt.Errorf("OR must be between two terms")
+	default:
+		return nil, fmt.Errorf("unexpected ')'")
+	}
+}
+
+// parseFieldValues returns the node matching any of the values of a field
+func parseFieldValues(field string, values []string) (model.QueryNode, error) {
+	var nodes []model.QueryNode
+	for _, value := range values {
+		node, err := parseFieldValue(field, value)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return model.QueryOr{Nodes: nodes}, nil
+}
+
+// parseFieldValue returns the node matching a single value of a field
+func parseFieldValue(field, value string) (model.QueryNode, error) {
+	switch field {
+	case model.QueryFieldYear, model.QueryFieldRuntime, model.QueryFieldRating:
+		return parseComparison(field, value)
+	case model.QueryFieldDirector, model.QueryFieldWriter, model.QueryFieldActor:
+		return model.QueryPerson{Field: field, Name: value}, nil
+	case model.QueryFieldCountry:
+		code, err := parseQueryCountry(value)
+		if err != nil {
+			return nil, err
+		}
+		return model.QueryMatch{Field: field, Value: code}, nil
+	case model.QueryFieldResolution:
+		// "1080" stands for "1080p"
+		if queryResolutionRegex.MatchString(value) {
+			value += "p"
+		}
+	case model.QueryFieldHDR:
+		if lower := strings.ToLower(value); lower == model.HDRAny || lower == model.HDRNone {
+			value = lower
+		}
+	case model.QueryFieldAudio, model.QueryFieldSubtitles:
+		value = model.NormalizeLanguage(value)
+	}
+	return model.QueryMatch{Field: field, Value: value}, nil
+}
+
+// parseComparison parses the value of a numeric field: a number, a comparison such as "<100" or ">=7.5",
+// a range such as "1970..1989" whose bounds are optional, or a decade such as "1980s" for years
+func parseComparison(field, value string) (model.QueryNode, error) {
+	invalidErr := fmt.Errorf("invalid %s '%s'", field, value)
+
+	if field == model.QueryFieldYear && queryDecadeRegex.MatchString(value) {
+		decade, _ := strconv.Atoi(strings.TrimSuffix(value, "s"))
+		value = fmt.Sprintf("%d..%d", decade, decade+9)
+	}
+
+	if low, high, isRange := strings.Cut(value, ".."); isRange {
+		var nodes []model.QueryNode
+		bounds := []struct {
+			value    string
+			operator string
+		}{{low, model.QueryOperatorGreaterOrEqual}, {high, model.QueryOperatorLessOrEqual}}
+		for _, bound := range bounds {
+			if bound.value == "" {
+				continue
+			}
+			number, err := strconv.ParseFloat(bound.value, 64)
+			if err != nil {
+				return nil, invalidErr
+			}
+			nodes = append(nodes, model.QueryComparison{Field: field, Operator: bound.operator, Value: number})
+		}
+		switch len(nodes) {
+		case 0:
+			return nil, invalidErr
+		case 1:
+			return nodes[0], nil
+		}
+		if nodes[0].(model.QueryComparison).Value > nodes[1].(model.QueryComparison).Value {
+			return nil, fmt.Errorf("invalid %s range '%s'", field, value)
+		}
+		return model.QueryAnd{Nodes: nodes}, nil
+	}
+
+	operator := model.QueryOperatorEqual
+	// Two-character operators first, so that "<=" is not read as "<"
+	for _, op := range []string{
+		model.QueryOperatorLessOrEqual, model.QueryOperatorGreaterOrEqual,
+		model.QueryOperatorLess, model.QueryOperatorGreater, model.QueryOperatorEqual,
+	} {
+		if strings.HasPrefix(value, op) {
+			operator = op
+			value = strings.TrimPrefix(value, op)
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, invalidErr
+	}
+	return model.QueryComparison{Field: field, Operator: operator, Value: number}, nil
+}
+
+// parseQueryCountry returns the code of a country given by its code or its English name
+func parseQueryCountry(value string) (string, error) {
+	query := gountries.New()
+	if len(value) == 2 {
+		if country, err := query.FindCountryByAlpha(strings.ToUpper(value)); err == nil {
+			return country.Alpha2, nil
+		}
+	}
+	if country, err := query.FindCountryByName(value); err == nil {
+		return country.Alpha2, nil
+	}
+	return "", fmt.Errorf("unknown country '%s'", value)
+}
@@ -0,0 +1,95 @@
+package business_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// TestParseFilmQuery checks the precedence of the terms, the negations and the groups
+func TestParseFilmQuery(t *testing.T) {
+	horror := model.QueryMatch{Field: model.QueryFieldGenre, Value: "horror"}
+	comedy := model.QueryMatch{Field: model.QueryFieldGenre, Value: "comedy"}
+	carpenter := model.QueryPerson{Field: model.QueryFieldDirector, Name: "John Carpenter"}
+	eighties := model.QueryAnd{Nodes: []model.QueryNode{
+		model.QueryComparison{Field: model.QueryFieldYear, Operator: model.QueryOperatorGreaterOrEqual, Value: 1980},
+		model.QueryComparison{Field: model.QueryFieldYear, Operator: model.QueryOperatorLessOrEqual, Value: 1989},
+	}}
+
+	tests := []struct {
+		query string
+		node  model.QueryNode
+	}{
+		{"", nil},
+		{"genre:horror", horror},
+		{"genre:horror,comedy", model.QueryOr{Nodes: []model.QueryNode{horror, comedy}}},
+		// OR takes precedence over the implicit AND
+		{`genre:horror genre:comedy OR director:"John Carpenter"`,
+			model.QueryAnd{Nodes: []model.QueryNode{horror, model.QueryOr{Nodes: []model.QueryNode{comedy, carpenter}}}}},
+		{`(genre:horror genre:comedy) OR director:"John Carpenter"`,
+			model.QueryOr{Nodes: []model.QueryNode{model.QueryAnd{Nodes: []model.QueryNode{horror, comedy}}, carpenter}}},
+		// Negations apply to a single term
+		{"-genre:comedy OR year:1980s",
+			model.QueryOr{Nodes: []model.QueryNode{model.QueryNot{Node: comedy}, eighties}}},
+		{"-(genre:horror OR genre:comedy)",
+			model.QueryNot{Node: model.QueryOr{Nodes: []model.QueryNode{horror, comedy}}}},
+		// Consecutive words are searched together, and colons in titles are text
+		{"the thing genre:horror Mission:Impossible",
+			model.QueryAnd{Nodes: []model.QueryNode{model.QueryText{Text: "the thing"}, horror, model.QueryText{Text: "Mission:Impossible"}}}},
+		{"runtime:<100 rating:>=7.5", model.QueryAnd{Nodes: []model.QueryNode{
+			model.QueryComparison{Field: model.QueryFieldRuntime, Operator: model.QueryOperatorLess, Value: 100},
+			model.QueryComparison{Field: model.QueryFieldRating, Operator: model.QueryOperatorGreaterOrEqual, Value: 7.5},
+		}}},
+		{`actor:"Dwayne \"The Rock\" Johnson"`, model.QueryPerson{Field: model.QueryFieldActor, Name: `Dwayne "The Rock" Johnson`}},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			node, err := business.ParseFilmQuery(test.query)
+			require.NoError(t, err)
+			assert.Equal(t, test.node, node)
+		})
+	}
+
+	for _, query := range []string{"(genre:horror", "genre:horror)", "OR genre:horror", "genre:horror OR", "()", `"unclosed`, "year:1990..1980", "country:Atlantis", "director:"} {
+		_, err := business.ParseFilmQuery(query)
+		assert.ErrorIs(t, err, model.ErrInvalidQuery, query)
+	}
+}
+
+// TestFilmQueryString checks that a parsed query is parsed again the same from its normalized string
+func TestFilmQueryString(t *testing.T) {
+	tests := []struct {
+		query      string
+		normalized string
+	}{
+		{"genre:horror", "genre:horror"},
+		{"GENRE:horror,comedy", "genre:horror OR genre:comedy"},
+		{"genre:horror genre:comedy OR year:1980s", "genre:horror (genre:comedy OR (year:>=1980 year:<=1989))"},
+		{"-(genre:horror OR genre:comedy) runtime:=90", "-(genre:horror OR genre:comedy) runtime:90"},
+		{"country:france audio:English", "country:FR audio:english"},
+		{`director:"John Carpenter"`, `director:"John Carpenter"`},
+		{`actor:"Dwayne \"The Rock\" Johnson"`, `actor:"Dwayne \"The Rock\" Johnson"`},
+		{`"back\\slash and tab"`, `"back\\slash and tab"`},
+		{"\"tab\tseparated\"", "\"tab\tseparated\""},
+		{`"-negative"`, `"-negative"`},
+		{`"-negative" "OR"`, `"-negative OR"`},
+		{`"genre:horror"`, `"genre:horror"`},
+		{"Mission:Impossible", `"Mission:Impossible"`},
+		{"Amélie", "Amélie"},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			node, err := business.ParseFilmQuery(test.query)
+			require.NoError(t, err)
+			assert.Equal(t, test.normalized, node.String())
+
+			reparsed, err := business.ParseFilmQuery(node.String())
+			require.NoError(t, err)
+			assert.Equal(t, node, reparsed)
+		})
+	}
+}
@@ -5,23 +5,46 @@ import (
 	"regexp"
 	"slices"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/pariz/gountries"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
 
 	"github.com/Agurato/starfin/internal/model"
 )
 
+// Filterer indexes the values the films can be filtered on, with the number of films having each of them.
+// It is safe for concurrent use
 type Filterer struct {
-	minReleaseYear int
-	maxReleaseYear int
-	Decades        []model.Decade
+	mu sync.RWMutex
 
-	Genres    []string
-	Countries []string
+	films map[primitive.ObjectID]filmFacets // Indexed values of each film, to remove them when it changes
+
+	years     map[int]int // Number of films by release year
+	genres    map[string]int
+	countries map[string]int
+
+	resolutions       map[string]int
+	videoCodecs       map[string]int
+	hdrFormats        map[string]int
+	audioLanguages    map[string]int
+	subtitleLanguages map[string]int
+	hdrFilms          int
 
 	paramsRegex *regexp.Regexp
 }
 
+// filmFacets holds the values of a film that are indexed by the Filterer
+type filmFacets struct {
+	year      int
+	genres    []string
+	countries []string
+	technical model.TechnicalInfo
+}
+
 func NewFilterer() *Filterer {
 	var (
 		paramsYearRegex    = `(\/year\/(?P<year>\d{4}s?))?`
@@ -31,37 +54,42 @@ func NewFilterer() *Filterer {
 	)
 
 	fw := &Filterer{
-		paramsRegex: regexp.MustCompile(paramsYearRegex + paramsGenreRegex + paramsCountryRegex + paramsPageRegex),
+		films:             make(map[primitive.ObjectID]filmFacets),
+		years:             make(map[int]int),
+		genres:            make(map[string]int),
+		countries:         make(map[string]int),
+		resolutions:       make(map[string]int),
+		videoCodecs:       make(map[string]int),
+		hdrFormats:        make(map[string]int),
+		audioLanguages:    make(map[string]int),
+		subtitleLanguages: make(map[string]int),
+		paramsRegex:       regexp.MustCompile(paramsYearRegex + paramsGenreRegex + paramsCountryRegex + paramsPageRegex),
 	}
 
 	return fw
 }
 
-// AddFilms adds release year if new min or max, and missing genres to the filter
+// AddFilms indexes the films, or updates them if they were already indexed
 func (f *Filterer) AddFilms(films []model.Film) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	for _, film := range films {
-		f.addToYears(film.ReleaseYear)
-		f.addToGenres(film.Genres)
-		f.addToCountries(film.ProdCountries)
+		f.setFilm(film.ID, newFilmFacets(&film))
 	}
-	f.computeDecades()
-	slices.Sort(f.Genres)
-	slices.SortFunc(f.Countries, func(a, b string) int {
-		return cmp.Compare(f.GetCountryName(a), f.GetCountryName(b))
-	})
 }
 
-// AddFilm adds release year if new min or max, and missing genres to the filter
+// AddFilm indexes a film, or updates it if it was already indexed
 func (f *Filterer) AddFilm(film *model.Film) {
-	if f.addToYears(film.ReleaseYear) {
-		f.computeDecades()
-	}
-	f.addToGenres(film.Genres)
-	slices.Sort(f.Genres)
-	f.addToCountries(film.ProdCountries)
-	slices.SortFunc(f.Countries, func(a, b string) int {
-		return cmp.Compare(f.GetCountryName(a), f.GetCountryName(b))
-	})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setFilm(film.ID, newFilmFacets(film))
+}
+
+// RemoveFilm removes a film from the index. The values that no film has anymore are removed
+func (f *Filterer) RemoveFilm(filmID primitive.ObjectID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setFilm(filmID, nil)
 }
 
 // ParseParamsFilters parses a params string and returns the filtered years, genre, country and page number
@@ -103,55 +131,178 @@ func (f *Filterer) GetCountryName(code string) string {
 	return country.Name.Common
 }
 
-func (f *Filterer) GetCountries() []string {
-	return f.Countries
+// GetLanguageName returns the English name of a language from its code, or the code if it is unknown
+func (f *Filterer) GetLanguageName(code string) string {
+	tag, err := language.Parse(code)
+	if err != nil {
+		return code
+	}
+	if name := display.English.Languages().Name(tag); name != "" {
+		return name
+	}
+	return code
 }
 
-func (f *Filterer) GetDecades() []model.Decade {
-	return f.Decades
+// GetFilmCount returns the number of indexed films
+func (f *Filterer) GetFilmCount() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.films)
 }
 
-func (f *Filterer) GetGenres() []string {
-	return f.Genres
+// GetCountryFacets returns the production countries of the films sorted by name, with their number of films
+func (f *Filterer) GetCountryFacets() []model.FacetValue {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return getFacetValues(f.countries, func(a, b string) int {
+		return cmp.Compare(f.GetCountryName(a), f.GetCountryName(b))
+	})
 }
 
-func (f *Filterer) computeDecades() {
-	var decade model.Decade
-	for i := f.maxReleaseYear; i >= f.minReleaseYear; i-- {
-		decade.DecadeYear = (i / 10) * 10
-		decade.Years = append(decade.Years, i)
-		if i%10 == 0 || i == f.minReleaseYear {
-			f.Decades = append(f.Decades, decade)
-			decade = model.Decade{}
+// GetDecades returns the release years of the films grouped by decade, from the most recent, with their number of films
+func (f *Filterer) GetDecades() (decades []model.Decade) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	years := make([]int, 0, len(f.years))
+	for year := range f.years {
+		years = append(years, year)
+	}
+	slices.Sort(years)
+	slices.Reverse(years)
+	for _, year := range years {
+		decadeYear := (year / 10) * 10
+		if len(decades) == 0 || decades[len(decades)-1].DecadeYear != decadeYear {
+			decades = append(decades, model.Decade{DecadeYear: decadeYear})
 		}
+		decade := &decades[len(decades)-1]
+		decade.Count += f.years[year]
+		decade.Years = append(decade.Years, model.Year{Year: year, Count: f.years[year]})
+	}
+	return decades
+}
+
+// GetGenres returns the genres of the films, sorted alphabetically
+func (f *Filterer) GetGenres() []string {
+	var genres []string
+	for _, genre := range f.GetGenreFacets() {
+		genres = append(genres, genre.Value)
 	}
+	return genres
 }
 
-func (f *Filterer) addToYears(filmReleaseYear int) bool {
-	computeDecades := false
-	if f.minReleaseYear == 0 || f.minReleaseYear > filmReleaseYear {
-		f.minReleaseYear = filmReleaseYear
-		computeDecades = true
+// GetGenreFacets returns the genres of the films sorted alphabetically, with their number of films
+func (f *Filterer) GetGenreFacets() []model.FacetValue {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return getFacetValues(f.genres, cmp.Compare[string])
+}
+
+// GetTechnicalFacets returns the technical characteristics of the films, with their number of films.
+// Resolutions are sorted from highest to lowest, languages by name, and the rest alphabetically
+func (f *Filterer) GetTechnicalFacets() model.TechnicalFacets {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	byLanguageName := func(a, b string) int {
+		return cmp.Compare(f.GetLanguageName(a), f.GetLanguageName(b))
+	}
+	return model.TechnicalFacets{
+		Resolutions: getFacetValues(f.resolutions, func(a, b string) int {
+			return cmp.Compare(resolutionHeight(b), resolutionHeight(a))
+		}),
+		VideoCodecs:       getFacetValues(f.videoCodecs, cmp.Compare[string]),
+		HDRFormats:        getFacetValues(f.hdrFormats, cmp.Compare[string]),
+		AudioLanguages:    getFacetValues(f.audioLanguages, byLanguageName),
+		SubtitleLanguages: getFacetValues(f.subtitleLanguages, byLanguageName),
+		HDRFilms:          f.hdrFilms,
+	}
+}
+
+// setFilm replaces the indexed values of a film, or removes them if facets is nil. The lock must be held
+func (f *Filterer) setFilm(filmID primitive.ObjectID, facets *filmFacets) {
+	if previous, ok := f.films[filmID]; ok {
+		f.countFilm(previous, -1)
+		delete(f.films, filmID)
+	}
+	if facets != nil {
+		f.countFilm(*facets, 1)
+		f.films[filmID] = *facets
+	}
+}
+
+// countFilm adds delta to the number of films having each value of the film
+func (f *Filterer) countFilm(facets filmFacets, delta int) {
+	if facets.year > 0 {
+		f.years[facets.year] += delta
+		if f.years[facets.year] <= 0 {
+			delete(f.years, facets.year)
+		}
 	}
-	if f.maxReleaseYear == 0 || f.maxReleaseYear < filmReleaseYear {
-		f.maxReleaseYear = filmReleaseYear
-		computeDecades = true
+	addCounts(f.genres, facets.genres, delta)
+	addCounts(f.countries, facets.countries, delta)
+	addCounts(f.resolutions, facets.technical.Resolutions, delta)
+	addCounts(f.videoCodecs, facets.technical.VideoCodecs, delta)
+	addCounts(f.hdrFormats, facets.technical.HDRFormats, delta)
+	addCounts(f.audioLanguages, facets.technical.AudioLanguages, delta)
+	addCounts(f.subtitleLanguages, facets.technical.SubtitleLanguages, delta)
+	if len(facets.technical.HDRFormats) > 0 {
+		f.hdrFilms += delta
 	}
-	return computeDecades
 }
 
-func (f *Filterer) addToGenres(filmGenres []string) {
-	for _, genre := range filmGenres {
-		if !slices.Contains(f.Genres, genre) {
-			f.Genres = append(f.Genres, genre)
+// newFilmFacets returns the values of a film to index, each value appearing once
+func newFilmFacets(film *model.Film) *filmFacets {
+	return &filmFacets{
+		year:      film.ReleaseYear,
+		genres:    uniqueValues(film.Genres),
+		countries: uniqueValues(film.ProdCountries),
+		technical: film.GetTechnicalInfo(),
+	}
+}
+
+// addCounts adds delta to the count of each value, and removes the values whose count drops to 0
+func addCounts(counts map[string]int, values []string, delta int) {
+	for _, value := range values {
+		counts[value] += delta
+		if counts[value] <= 0 {
+			delete(counts, value)
 		}
 	}
 }
 
-func (f *Filterer) addToCountries(filmProdCountries []string) {
-	for _, country := range filmProdCountries {
-		if !slices.Contains(f.Countries, country) {
-			f.Countries = append(f.Countries, country)
+// getFacetValues returns the values and their count, sorted with the compare function
+func getFacetValues(counts map[string]int, compare func(a, b string) int) []model.FacetValue {
+	values := make([]model.FacetValue, 0, len(counts))
+	for value, count := range counts {
+		values = append(values, model.FacetValue{Value: value, Count: count})
+	}
+	slices.SortFunc(values, func(a, b model.FacetValue) int {
+		if c := compare(a.Value, b.Value); c != 0 {
+			return c
 		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+	return values
+}
+
+// uniqueValues returns the non-empty values without duplicates
+func uniqueValues(values []string) (unique []string) {
+	for _, value := range values {
+		if value != "" && !slices.Contains(unique, value) {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// resolutionHeight returns the number of lines of a resolution such as "1080p" or "4K", or 0 if it is unknown
+func resolutionHeight(resolution string) int {
+	switch strings.ToUpper(resolution) {
+	case "4K":
+		return 2160
+	case "8K":
+		return 4320
 	}
+	height, _ := strconv.Atoi(strings.TrimSuffix(strings.ToLower(resolution), "p"))
+	return height
 }
@@ -0,0 +1,127 @@
+package business_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// newFacetFilm returns a film with the given facets, and a file of the given resolution with French subtitles
+func newFacetFilm(year int, resolution string, genres, countries []string) model.Film {
+	return model.Film{ID: primitive.NewObjectID(), ReleaseYear: year, Genres: genres, ProdCountries: countries,
+		VolumeFiles: []model.VolumeFile{{
+			Info:         model.MediaInfo{Resolution: resolution},
+			ExtSubtitles: []model.Subtitle{{Language: "fr"}},
+		}}}
+}
+
+// TestFiltererCounts checks the number of films of each value as films are added, updated and removed
+func TestFiltererCounts(t *testing.T) {
+	heat := newFacetFilm(1995, "1080p", []string{"Crime", "Drama"}, []string{"US"})
+	casino := newFacetFilm(1995, "720p", []string{"Crime", "Drama", "Crime"}, []string{"US"})
+	alien := newFacetFilm(1979, "2160p", []string{"Horror", "Science Fiction"}, []string{"US", "GB"})
+	f := business.NewFilterer()
+	f.AddFilms([]model.Film{heat, casino, alien})
+
+	assert.Equal(t, 3, f.GetFilmCount())
+	assert.Equal(t, []model.FacetValue{{Value: "Crime", Count: 2}, {Value: "Drama", Count: 2}, {Value: "Horror", Count: 1}, {Value: "Science Fiction", Count: 1}},
+		f.GetGenreFacets())
+	assert.Equal(t, []model.FacetValue{{Value: "GB", Count: 1}, {Value: "US", Count: 3}}, f.GetCountryFacets())
+	assert.Equal(t, []model.Decade{
+		{DecadeYear: 1990, Count: 2, Years: []model.Year{{Year: 1995, Count: 2}}},
+		{DecadeYear: 1970, Count: 1, Years: []model.Year{{Year: 1979, Count: 1}}},
+	}, f.GetDecades())
+	technical := f.GetTechnicalFacets()
+	assert.Equal(t, []model.FacetValue{{Value: "2160p", Count: 1}, {Value: "1080p", Count: 1}, {Value: "720p", Count: 1}}, technical.Resolutions)
+	assert.Equal(t, []model.FacetValue{{Value: "fr", Count: 3}}, technical.SubtitleLanguages)
+
+	// An updated film is counted once, with its new values
+	heat.Genres = []string{"Action", "Crime"}
+	heat.ReleaseYear = 1996
+	f.AddFilm(&heat)
+	assert.Equal(t, 3, f.GetFilmCount())
+	assert.Equal(t, []model.FacetValue{{Value: "Action", Count: 1}, {Value: "Crime", Count: 2}, {Value: "Drama", Count: 1}, {Value: "Horror", Count: 1}, {Value: "Science Fiction", Count: 1}},
+		f.GetGenreFacets())
+
+	// The values of a removed film disappear once no film has them
+	f.RemoveFilm(alien.ID)
+	assert.Equal(t, 2, f.GetFilmCount())
+	assert.Equal(t, []model.FacetValue{{Value: "Action", Count: 1}, {Value: "Crime", Count: 2}, {Value: "Drama", Count: 1}}, f.GetGenreFacets())
+	assert.Equal(t, []model.FacetValue{{Value: "US", Count: 2}}, f.GetCountryFacets())
+	assert.Equal(t, []model.Decade{
+		{DecadeYear: 1990, Count: 2, Years: []model.Year{{Year: 1996, Count: 1}, {Year: 1995, Count: 1}}},
+	}, f.GetDecades())
+	technical = f.GetTechnicalFacets()
+	assert.Equal(t, []model.FacetValue{{Value: "1080p", Count: 1}, {Value: "720p", Count: 1}}, technical.Resolutions)
+	assert.Equal(t, []model.FacetValue{{Value: "fr", Count: 2}}, technical.SubtitleLanguages)
+
+	// Removing a film twice, or a film that was never added, changes nothing
+	f.RemoveFilm(alien.ID)
+	f.RemoveFilm(primitive.NewObjectID())
+	assert.Equal(t, 2, f.GetFilmCount())
+	f.RemoveFilm(heat.ID)
+	f.RemoveFilm(casino.ID)
+	assert.Zero(t, f.GetFilmCount())
+	assert.Empty(t, f.GetGenreFacets())
+	assert.Empty(t, f.GetCountryFacets())
+	assert.Empty(t, f.GetDecades())
+	assert.Equal(t, model.TechnicalFacets{
+		Resolutions: []model.FacetValue{}, VideoCodecs: []model.FacetValue{}, HDRFormats: []model.FacetValue{},
+		AudioLanguages: []model.FacetValue{}, SubtitleLanguages: []model.FacetValue{},
+	}, f.GetTechnicalFacets())
+}
+
+// TestFiltererConcurrency adds, updates and removes films while the facets are read, to be run with -race
+func TestFiltererConcurrency(t *testing.T) {
+	f := business.NewFilterer()
+	const writers, filmsPerWriter = 8, 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < filmsPerWriter; j++ {
+				f.GetGenreFacets()
+				f.GetCountryFacets()
+				f.GetDecades()
+				f.GetTechnicalFacets()
+				f.GetFilmCount()
+			}
+		}()
+	}
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < filmsPerWriter; j++ {
+				film := newFacetFilm(1980+j%20, "1080p", []string{"Drama"}, []string{"FR"})
+				f.AddFilm(&film)
+				// Every other film is updated, and the others are removed
+				if j%2 == 0 {
+					film.Genres = []string{"Comedy"}
+					f.AddFilms([]model.Film{film})
+				} else {
+					f.RemoveFilm(film.ID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	kept := writers * filmsPerWriter / 2
+	assert.Equal(t, kept, f.GetFilmCount())
+	assert.Equal(t, []model.FacetValue{{Value: "Comedy", Count: kept}}, f.GetGenreFacets())
+	assert.Equal(t, []model.FacetValue{{Value: "FR", Count: kept}}, f.GetCountryFacets())
+	decadeCount := 0
+	for _, decade := range f.GetDecades() {
+		decadeCount += decade.Count
+	}
+	assert.Equal(t, kept, decadeCount)
+}
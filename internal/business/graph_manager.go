@@ -0,0 +1,171 @@
+package business
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+type GraphStorer interface {
+	GetFilmFromID(ctx context.Context, ID primitive.ObjectID) (*model.Film, error)
+	GetPersonFromID(ctx context.Context, ID primitive.ObjectID) (*model.Person, error)
+	GetPersonFromTMDBID(ctx context.Context, ID int64) (*model.Person, error)
+}
+
+// GraphIndexer searches the people, and links them to the films they worked on.
+// It is kept up to date as films are added, changed and removed
+type GraphIndexer interface {
+	Search(query string) []SearchHit
+	GetPersonFilms(personTMDBID int64) []primitive.ObjectID
+	GetFilmPeople(filmID primitive.ObjectID) []int64
+}
+
+// GraphManager explores the collaborations between the people of the library,
+// two people being linked when they worked on the same film as cast or crew.
+// The links are read from the search index, so that the graph is not built again for each request
+type GraphManager struct {
+	GraphStorer
+	GraphIndexer
+}
+
+// NewGraphManager instantiates a new GraphManager
+func NewGraphManager(gs GraphStorer, gi GraphIndexer) *GraphManager {
+	return &GraphManager{
+		GraphStorer:  gs,
+		GraphIndexer: gi,
+	}
+}
+
+// FindPerson returns a person from its hexadecimal ID, or the person whose name best matches the query
+func (gm GraphManager) FindPerson(ctx context.Context, query string) (*model.Person, error) {
+	if personID, err := primitive.ObjectIDFromHex(query); err == nil {
+		return gm.GraphStorer.GetPersonFromID(ctx, personID)
+	}
+	for _, hit := range gm.GraphIndexer.Search(query) {
+		if hit.IsFilm() {
+			continue
+		}
+		// People that are not in the database anymore may still be indexed
+		if person, err := gm.GraphStorer.GetPersonFromTMDBID(ctx, hit.PersonTMDBID); err == nil {
+			return person, nil
+		}
+	}
+	return nil, fmt.Errorf("could not find person '%s': %w", query, model.ErrNotFound)
+}
+
+// GetShortestPath returns the shortest chain of films linking two people, one connection per film.
+// The path is empty if the people never worked with each other, even indirectly
+func (gm GraphManager) GetShortestPath(ctx context.Context, from, to *model.Person) ([]model.Connection, error) {
+	if from.TMDBID == to.TMDBID {
+		return []model.Connection{}, nil
+	}
+
+	// Breadth-first search, remembering through which person and film each person was reached
+	type step struct {
+		personID int64
+		filmID   primitive.ObjectID
+	}
+	previous := map[int64]step{from.TMDBID: {}}
+	visitedFilms := make(map[primitive.ObjectID]bool)
+	queue := []int64{from.TMDBID}
+	for len(queue) > 0 {
+		if _, ok := previous[to.TMDBID]; ok {
+			break
+		}
+		personID := queue[0]
+		queue = queue[1:]
+		for _, filmID := range gm.GraphIndexer.GetPersonFilms(personID) {
+			// The people of a film are all reached the first time it is visited
+			if visitedFilms[filmID] {
+				continue
+			}
+			visitedFilms[filmID] = true
+			for _, otherID := range gm.GraphIndexer.GetFilmPeople(filmID) {
+				if _, ok := previous[otherID]; !ok {
+					previous[otherID] = step{personID: personID, filmID: filmID}
+					queue = append(queue, otherID)
+				}
+			}
+		}
+	}
+	if _, ok := previous[to.TMDBID]; !ok {
+		return []model.Connection{}, nil
+	}
+
+	var path []model.Connection
+	toPerson := *to
+	for personID := to.TMDBID; personID != from.TMDBID; {
+		s := previous[personID]
+		fromPerson := *from
+		if s.personID != from.TMDBID {
+			person, err := gm.GraphStorer.GetPersonFromTMDBID(ctx, s.personID)
+			if err != nil {
+				return nil, fmt.Errorf("could not get person %d of the path: %w", s.personID, err)
+			}
+			fromPerson = *person
+		}
+		film, err := gm.GraphStorer.GetFilmFromID(ctx, s.filmID)
+		if err != nil {
+			return nil, fmt.Errorf("could not get film '%s' of the path: %w", s.filmID.Hex(), err)
+		}
+		path = append(path, model.Connection{From: fromPerson, Film: *film, To: toPerson})
+		toPerson = fromPerson
+		personID = s.personID
+	}
+	slices.Reverse(path)
+	return path, nil
+}
+
+// GetFrequentCollaborators returns at most number people who worked the most with a person, with the films they made together
+func (gm GraphManager) GetFrequentCollaborators(ctx context.Context, person *model.Person, number int) ([]model.Collaborator, error) {
+	sharedFilms := make(map[int64][]primitive.ObjectID)
+	for _, filmID := range gm.GraphIndexer.GetPersonFilms(person.TMDBID) {
+		for _, otherID := range gm.GraphIndexer.GetFilmPeople(filmID) {
+			if otherID != person.TMDBID {
+				sharedFilms[otherID] = append(sharedFilms[otherID], filmID)
+			}
+		}
+	}
+
+	otherIDs := make([]int64, 0, len(sharedFilms))
+	for otherID := range sharedFilms {
+		otherIDs = append(otherIDs, otherID)
+	}
+	slices.SortFunc(otherIDs, func(a, b int64) int {
+		if c := cmp.Compare(len(sharedFilms[b]), len(sharedFilms[a])); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+
+	// Only the most frequent collaborators and their films are fetched from the database, each film once
+	films := make(map[primitive.ObjectID]*model.Film)
+	collaborators := []model.Collaborator{}
+	for _, otherID := range otherIDs {
+		if len(collaborators) >= number {
+			break
+		}
+		other, err := gm.GraphStorer.GetPersonFromTMDBID(ctx, otherID)
+		if err != nil {
+			continue
+		}
+		collaborator := model.Collaborator{Person: *other}
+		for _, filmID := range sharedFilms[otherID] {
+			film, ok := films[filmID]
+			if !ok {
+				if film, err = gm.GraphStorer.GetFilmFromID(ctx, filmID); err != nil {
+					return nil, fmt.Errorf("could not get film '%s' of person %d: %w", filmID.Hex(), person.TMDBID, err)
+				}
+				films[filmID] = film
+			}
+			collaborator.Films = append(collaborator.Films, *film)
+		}
+		collaborators = append(collaborators, collaborator)
+	}
+	return collaborators, nil
+}
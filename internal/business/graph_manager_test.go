@@ -0,0 +1,117 @@
+package business_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// pathTitles returns the titles of the films of a path, and checks that its connections follow each other
+func pathTitles(t *testing.T, path []model.Connection) []string {
+	titles := []string{}
+	for i, connection := range path {
+		if i > 0 {
+			assert.Equal(t, path[i-1].To.TMDBID, connection.From.TMDBID)
+		}
+		titles = append(titles, connection.Film.Title)
+	}
+	return titles
+}
+
+// TestGraphManager explores the collaborations as the films of the library change
+func TestGraphManager(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	searchIndex := business.NewSearchIndex()
+	people := make(map[int64]*model.Person)
+	for _, person := range []model.Person{
+		{ID: primitive.NewObjectID(), TMDBID: 1, Name: "Al Pacino"},
+		{ID: primitive.NewObjectID(), TMDBID: 2, Name: "Robert De Niro"},
+		{ID: primitive.NewObjectID(), TMDBID: 3, Name: "Michael Mann"},
+		{ID: primitive.NewObjectID(), TMDBID: 4, Name: "Tom Cruise"},
+		{ID: primitive.NewObjectID(), TMDBID: 5, Name: "Sigourney Weaver"},
+		{ID: primitive.NewObjectID(), TMDBID: 6, Name: "Ridley Scott"},
+	} {
+		person := person
+		db.AddPerson(ctx, &person)
+		people[person.TMDBID] = &person
+	}
+	fm := business.NewFilmManager(db, fakeCache{}, fakeMetadata{}, business.NewFilterer(), searchIndex)
+	heat := model.Film{ID: primitive.NewObjectID(), TMDBID: 949, Title: "Heat", Directors: []int64{3}, Writers: []int64{3},
+		Characters: []model.Character{{ActorID: 1}, {ActorID: 2}}}
+	collateral := model.Film{ID: primitive.NewObjectID(), TMDBID: 1538, Title: "Collateral", Directors: []int64{3},
+		Characters: []model.Character{{ActorID: 4}}}
+	alien := model.Film{ID: primitive.NewObjectID(), TMDBID: 348, Title: "Alien", Directors: []int64{6},
+		Characters: []model.Character{{ActorID: 5}}}
+	for _, film := range []*model.Film{&heat, &collateral, &alien} {
+		require.NoError(t, fm.AddFilm(ctx, film, true))
+	}
+	gm := business.NewGraphManager(db, searchIndex)
+
+	tests := []struct {
+		name   string
+		from   int64
+		to     int64
+		titles []string
+	}{
+		{"same film", 1, 2, []string{"Heat"}},
+		{"connected", 1, 4, []string{"Heat", "Collateral"}},
+		{"reversed", 4, 1, []string{"Collateral", "Heat"}},
+		{"disconnected", 1, 5, []string{}},
+		{"same person", 3, 3, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, err := gm.GetShortestPath(ctx, people[test.from], people[test.to])
+			require.NoError(t, err)
+			assert.Equal(t, test.titles, pathTitles(t, path))
+			if len(path) > 0 {
+				assert.Equal(t, test.from, path[0].From.TMDBID)
+				assert.Equal(t, test.to, path[len(path)-1].To.TMDBID)
+			}
+		})
+	}
+
+	t.Run("collaborators", func(t *testing.T) {
+		collaborators, err := gm.GetFrequentCollaborators(ctx, people[3], 2)
+		require.NoError(t, err)
+		require.Len(t, collaborators, 2)
+		assert.Equal(t, int64(1), collaborators[0].Person.TMDBID)
+		assert.Equal(t, int64(2), collaborators[1].Person.TMDBID)
+		assert.Equal(t, "Heat", collaborators[0].Films[0].Title)
+	})
+
+	// The graph follows the films that are added, changed and removed
+	t.Run("film changes", func(t *testing.T) {
+		heat.Characters = append(heat.Characters, model.Character{ActorID: 5})
+		require.NoError(t, fm.AddFilm(ctx, &heat, true))
+		path, err := gm.GetShortestPath(ctx, people[4], people[6])
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Collateral", "Heat", "Alien"}, pathTitles(t, path))
+
+		thief := model.Film{ID: primitive.NewObjectID(), TMDBID: 11524, Title: "Thief", Directors: []int64{3},
+			Characters: []model.Character{{ActorID: 2}, {ActorID: 4}}}
+		require.NoError(t, fm.AddFilm(ctx, &thief, true))
+		collaborators, err := gm.GetFrequentCollaborators(ctx, people[3], 1)
+		require.NoError(t, err)
+		require.Len(t, collaborators, 1)
+		assert.Equal(t, int64(2), collaborators[0].Person.TMDBID)
+		assert.Len(t, collaborators[0].Films, 2)
+
+		require.NoError(t, db.DeleteFilm(ctx, heat.ID))
+		fm.ReindexFilm(ctx, heat.ID)
+		path, err = gm.GetShortestPath(ctx, people[1], people[4])
+		require.NoError(t, err)
+		assert.Empty(t, path)
+		path, err = gm.GetShortestPath(ctx, people[2], people[4])
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Thief"}, pathTitles(t, path))
+	})
+}
@@ -0,0 +1,166 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+const (
+	homeRowSize              = 12 // Number of films in a row of the home dashboard
+	favouriteDirectorsNumber = 10 // Number of directors whose films are picked in the favourite directors row
+)
+
+type HomeStorer interface {
+	GetHomeSettings(ctx context.Context) (*model.HomeSettings, error)
+	SetHomeSettings(ctx context.Context, settings *model.HomeSettings) error
+
+	GetUserFromID(ctx context.Context, id primitive.ObjectID) (*model.User, error)
+	SetUserHiddenHomeRows(ctx context.Context, userID primitive.ObjectID, rowKeys []string) error
+
+	GetFilmsFiltered(ctx context.Context, filter model.FilmFilter, listOptions model.ListOptions) (films []model.Film, total int64, err error)
+	GetRandomFilms(ctx context.Context, filter model.FilmFilter, number int64) ([]model.Film, error)
+	GetDirectorsByFilmCount(ctx context.Context, number int64) ([]int64, error)
+}
+
+type HomeFilterer interface {
+	GetGenres() []string
+}
+
+type HomeManager struct {
+	HomeStorer
+	HomeFilterer
+}
+
+// NewHomeManager instantiates a new HomeManager
+func NewHomeManager(hs HomeStorer, hf HomeFilterer) *HomeManager {
+	return &HomeManager{
+		HomeStorer:   hs,
+		HomeFilterer: hf,
+	}
+}
+
+// GetHomeSettings returns the saved home dashboard settings, or the default ones if none were saved yet
+func (hm HomeManager) GetHomeSettings(ctx context.Context) model.HomeSettings {
+	settings, err := hm.HomeStorer.GetHomeSettings(ctx)
+	if err != nil {
+		log.Debug().Err(err).Msg("No home settings saved, using default ones")
+		var defaultSettings model.HomeSettings
+		for _, rowType := range model.HomeRowTypes {
+			defaultSettings.Rows = append(defaultSettings.Rows, model.HomeRow{Type: rowType})
+		}
+		return defaultSettings
+	}
+	return *settings
+}
+
+// SetHomeRows checks and saves the rows of the home dashboard.
+// rowKeys is a comma-separated list of row keys, e.g. "recently_added,genre:Drama", in display order
+func (hm HomeManager) SetHomeRows(ctx context.Context, rowKeys string) error {
+	var settings model.HomeSettings
+	genres := hm.HomeFilterer.GetGenres()
+	for _, key := range strings.Split(rowKeys, ",") {
+		if strings.TrimSpace(key) == "" {
+			continue
+		}
+		row, err := model.ParseHomeRow(key)
+		if err != nil {
+			return err
+		}
+		if row.Type == model.HomeRowGenre {
+			// Use the spelling of the library, as genres are displayed as is
+			index := slices.IndexFunc(genres, func(genre string) bool {
+				return strings.EqualFold(genre, row.Genre)
+			})
+			if index < 0 {
+				return fmt.Errorf("no film has the genre '%s'", row.Genre)
+			}
+			row.Genre = genres[index]
+		}
+		if slices.ContainsFunc(settings.Rows, func(other model.HomeRow) bool { return other.Key() == row.Key() }) {
+			continue
+		}
+		settings.Rows = append(settings.Rows, row)
+	}
+
+	if err := hm.HomeStorer.SetHomeSettings(ctx, &settings); err != nil {
+		return fmt.Errorf("could not save home settings: %w", err)
+	}
+	return nil
+}
+
+// GetUserHiddenHomeRows returns the keys of the home rows a user does not want to see
+func (hm HomeManager) GetUserHiddenHomeRows(ctx context.Context, userID primitive.ObjectID) []string {
+	user, err := hm.HomeStorer.GetUserFromID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID.Hex()).Msg("Unable to get user")
+		return nil
+	}
+	return user.HiddenHomeRows
+}
+
+// SetUserShownHomeRows hides the home rows that are not in rowKeys for a user.
+// Rows added to the dashboard later are shown until the user hides them
+func (hm HomeManager) SetUserShownHomeRows(ctx context.Context, userID primitive.ObjectID, rowKeys []string) error {
+	hidden := []string{}
+	for _, row := range hm.GetHomeSettings(ctx).Rows {
+		if !slices.Contains(rowKeys, row.Key()) {
+			hidden = append(hidden, row.Key())
+		}
+	}
+	if err := hm.HomeStorer.SetUserHiddenHomeRows(ctx, userID, hidden); err != nil {
+		return fmt.Errorf("could not save hidden home rows: %w", err)
+	}
+	return nil
+}
+
+// GetHomeRowsFilms returns the rows of the home dashboard that the user did not hide, with their films.
+// Rows without any film are left out
+func (hm HomeManager) GetHomeRowsFilms(ctx context.Context, userID primitive.ObjectID) (rows []model.HomeRowFilms) {
+	hidden := hm.GetUserHiddenHomeRows(ctx, userID)
+	for _, row := range hm.GetHomeSettings(ctx).Rows {
+		if slices.Contains(hidden, row.Key()) {
+			continue
+		}
+		films, err := hm.getHomeRowFilms(ctx, row)
+		if err != nil {
+			log.Error().Err(err).Str("row", row.Key()).Msg("Unable to get films of home row")
+			continue
+		}
+		if len(films) > 0 {
+			rows = append(rows, model.HomeRowFilms{HomeRow: row, Films: films})
+		}
+	}
+	return rows
+}
+
+// getHomeRowFilms returns the films of a row of the home dashboard
+func (hm HomeManager) getHomeRowFilms(ctx context.Context, row model.HomeRow) ([]model.Film, error) {
+	switch row.Type {
+	case model.HomeRowRecentlyAdded:
+		films, _, err := hm.HomeStorer.GetFilmsFiltered(ctx, model.FilmFilter{}, model.ListOptions{Sort: model.FilmSortDateAdded, Descending: true, Limit: homeRowSize})
+		return films, err
+	case model.HomeRowRandom:
+		return hm.HomeStorer.GetRandomFilms(ctx, model.FilmFilter{}, homeRowSize)
+	case model.HomeRowTopRated:
+		films, _, err := hm.HomeStorer.GetFilmsFiltered(ctx, model.FilmFilter{}, model.ListOptions{Sort: model.FilmSortRating, Descending: true, Limit: homeRowSize})
+		return films, err
+	case model.HomeRowFavouriteDirectors:
+		// The directors the library has the most films of
+		directorIDs, err := hm.HomeStorer.GetDirectorsByFilmCount(ctx, favouriteDirectorsNumber)
+		if err != nil || len(directorIDs) == 0 {
+			return nil, err
+		}
+		filter := model.FilmFilter{Query: model.QueryPerson{Field: model.QueryFieldDirector, TMDBIDs: directorIDs}}
+		return hm.HomeStorer.GetRandomFilms(ctx, filter, homeRowSize)
+	case model.HomeRowGenre:
+		return hm.HomeStorer.GetRandomFilms(ctx, model.FilmFilter{Genre: row.Genre}, homeRowSize)
+	}
+	return nil, fmt.Errorf("unknown home row type '%s'", row.Type)
+}
@@ -0,0 +1,140 @@
+package business_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// filmIDs returns the IDs of films, in order
+func filmIDs(films []model.Film) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(films))
+	for _, film := range films {
+		ids = append(ids, film.ID)
+	}
+	return ids
+}
+
+// TestHomeRows fills the rows of the home dashboard chosen by the admin, leaving out the ones a user hid
+func TestHomeRows(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	filterer := business.NewFilterer()
+	fm := business.NewFilmManager(db, fakeCache{}, fakeMetadata{}, filterer, business.NewSearchIndex())
+	hm := business.NewHomeManager(db, filterer)
+	user := model.User{ID: primitive.NewObjectID(), Name: "user", Password: "hash"}
+	require.NoError(t, db.CreateUser(ctx, &user))
+
+	// Rows without any film are left out
+	assert.Empty(t, hm.GetHomeRowsFilms(ctx, user.ID))
+
+	// The films are added one hour apart, and the later ones are better rated.
+	// The first two films share the ten directors who have the most films
+	var films []model.Film
+	added := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	for i := 0; i < 15; i++ {
+		film := model.Film{
+			ID: primitive.NewObjectID(), TMDBID: 100 + i, Title: fmt.Sprintf("Film %d", i),
+			VolumeFiles: []model.VolumeFile{{Path: fmt.Sprintf("/films/%d.mkv", i)}},
+			Ratings:     map[string]model.Rating{model.RatingSourceIMDb: {Value: 5 + float64(i)/5, Best: 10}},
+			DateAdded:   added.Add(time.Duration(i) * time.Hour),
+			Genres:      []string{"Comedy"},
+		}
+		switch {
+		case i < 2:
+			film.Directors = []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+		case i == 2:
+			film.Directors = []int64{11}
+		}
+		if i%5 == 0 {
+			film.Genres = []string{"Drama"}
+		}
+		require.NoError(t, fm.AddFilm(ctx, &film, false))
+		films = append(films, film)
+	}
+
+	// Every row is shown by default, in the default order
+	rows := hm.GetHomeRowsFilms(ctx, user.ID)
+	var keys []string
+	for _, row := range rows {
+		keys = append(keys, row.Key())
+	}
+	assert.Equal(t, model.HomeRowTypes, keys)
+	var newest, best []primitive.ObjectID
+	for i := 14; i > 2; i-- {
+		newest = append(newest, films[i].ID)
+		best = append(best, films[i].ID)
+	}
+	assert.Equal(t, newest, filmIDs(rows[0].Films))
+	assert.Len(t, rows[1].Films, 12)
+	assert.Subset(t, filmIDs(films), filmIDs(rows[1].Films))
+	assert.Equal(t, best, filmIDs(rows[2].Films))
+	assert.ElementsMatch(t, []primitive.ObjectID{films[0].ID, films[1].ID}, filmIDs(rows[3].Films))
+
+	// The admin picks the rows and their order, the genres being spelled as in the library
+	assert.EqualError(t, hm.SetHomeRows(ctx, "recently_added,genre:Western"), "no film has the genre 'Western'")
+	assert.EqualError(t, hm.SetHomeRows(ctx, "recently_added,most_viewed"), "unknown home row 'most_viewed'")
+	assert.EqualError(t, hm.SetHomeRows(ctx, "genre: "), "home row 'genre:' needs a genre")
+	require.NoError(t, hm.SetHomeRows(ctx, " genre:drama , top_rated,,genre:Drama"))
+	assert.Equal(t, model.HomeSettings{Rows: []model.HomeRow{{Type: model.HomeRowGenre, Genre: "Drama"}, {Type: model.HomeRowTopRated}}}, hm.GetHomeSettings(ctx))
+	rows = hm.GetHomeRowsFilms(ctx, user.ID)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "Drama", rows[0].Title())
+	assert.ElementsMatch(t, []primitive.ObjectID{films[0].ID, films[5].ID, films[10].ID}, filmIDs(rows[0].Films))
+	assert.Equal(t, model.HomeRowTopRated, rows[1].Type)
+
+	// A user hides rows, and still sees the rows added afterwards
+	require.NoError(t, hm.SetUserShownHomeRows(ctx, user.ID, []string{"genre:Drama"}))
+	assert.Equal(t, []string{model.HomeRowTopRated}, hm.GetUserHiddenHomeRows(ctx, user.ID))
+	require.NoError(t, hm.SetHomeRows(ctx, "recently_added,genre:Drama,top_rated"))
+	rows = hm.GetHomeRowsFilms(ctx, user.ID)
+	require.Len(t, rows, 2)
+	assert.Equal(t, model.HomeRowRecentlyAdded, rows[0].Type)
+	assert.Equal(t, "genre:Drama", rows[1].Key())
+	// Other users see every row
+	assert.Len(t, hm.GetHomeRowsFilms(ctx, primitive.NewObjectID()), 3)
+}
+
+// TestDateAdded sets the date a film is added once, and keeps it when the film gets other files or is refreshed
+func TestDateAdded(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	metadata := fakeMetadata{"Heat": 949}
+	fm := business.NewFilmManager(db, fakeCache{}, metadata, business.NewFilterer(), business.NewSearchIndex())
+
+	heat := metadata.CreateFilm(ctx, "/films/Heat.1995.mkv", primitive.NewObjectID(), nil)
+	require.NoError(t, metadata.FetchFilmTMDBID(ctx, heat))
+	before := time.Now()
+	require.NoError(t, fm.AddFilm(ctx, heat, false))
+	stored, err := db.GetFilmFromID(ctx, heat.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.DateAdded.Before(before))
+	assert.False(t, stored.DateAdded.After(time.Now()))
+
+	// Second file of the film, added later
+	other := metadata.CreateFilm(ctx, "/other/Heat.1995.mkv", primitive.NewObjectID(), nil)
+	require.NoError(t, metadata.FetchFilmTMDBID(ctx, other))
+	time.Sleep(time.Millisecond)
+	require.NoError(t, fm.AddFilm(ctx, other, false))
+	assert.Equal(t, heat.ID, other.ID)
+	merged, err := db.GetFilmFromID(ctx, heat.ID)
+	require.NoError(t, err)
+	assert.Len(t, merged.VolumeFiles, 2)
+	assert.True(t, stored.DateAdded.Equal(merged.DateAdded))
+
+	// Refresh of the film
+	require.NoError(t, metadata.UpdateFilmDetails(ctx, merged))
+	require.NoError(t, fm.AddFilm(ctx, merged, true))
+	refreshed, err := db.GetFilmFromID(ctx, heat.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.DateAdded.Equal(refreshed.DateAdded))
+}
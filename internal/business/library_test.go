@@ -0,0 +1,256 @@
+package business_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// fakeMetadata matches the films on their name, instead of searching TMDB
+type fakeMetadata map[string]int
+
+func (fm fakeMetadata) CreateFilm(ctx context.Context, file string, volumeID primitive.ObjectID, subFiles []string) *model.Film {
+	// Files are named like "Heat.1995.mkv"
+	name, _, _ := strings.Cut(filepath.Base(file), ".")
+	return &model.Film{
+		ID:   primitive.NewObjectID(),
+		Name: name,
+		VolumeFiles: []model.VolumeFile{{
+			Path:         file,
+			FromVolume:   volumeID,
+			ExtSubtitles: model.GetExternalSubtitles(file, subFiles),
+		}},
+	}
+}
+
+func (fm fakeMetadata) FetchFilmTMDBID(ctx context.Context, f *model.Film) error {
+	tmdbID, ok := fm[f.Name]
+	if !ok {
+		return fmt.Errorf("no film named %q", f.Name)
+	}
+	f.TMDBID = tmdbID
+	return nil
+}
+
+// UpdateFilmDetails fails for the TMDB IDs of no known film
+func (fm fakeMetadata) UpdateFilmDetails(ctx context.Context, film *model.Film) error {
+	known := false
+	for _, tmdbID := range fm {
+		known = known || tmdbID == film.TMDBID
+	}
+	if !known {
+		return fmt.Errorf("no film with TMDB ID %d", film.TMDBID)
+	}
+	film.Title = film.Name
+	film.Directors = []int64{int64(film.TMDBID) * 10}
+	film.LastRefreshed = time.Now()
+	return nil
+}
+
+func (fm fakeMetadata) GetPosterLink(key string) string   { return "" }
+func (fm fakeMetadata) GetBackdropLink(key string) string { return "" }
+func (fm fakeMetadata) GetPhotoLink(key string) string    { return "" }
+
+func (fm fakeMetadata) GetTMDBIDFromLink(ctx context.Context, inputUrl string) (int, error) {
+	tmdbID, ok := strings.CutPrefix(inputUrl, "https://www.themoviedb.org/movie/")
+	if !ok {
+		return 0, fmt.Errorf("not a TMDB link: %q", inputUrl)
+	}
+	return strconv.Atoi(tmdbID)
+}
+
+func (fm fakeMetadata) GetPersonDetails(ctx context.Context, personID int64) *model.Person {
+	return &model.Person{ID: primitive.NewObjectID(), TMDBID: personID, Name: fmt.Sprintf("Person %d", personID), LastRefreshed: time.Now()}
+}
+
+func (fm fakeMetadata) GetCollectionDetails(ctx context.Context, collectionID int64) (*model.Collection, error) {
+	return nil, errors.New("not implemented")
+}
+
+// fakeCache caches nothing
+type fakeCache struct{}
+
+func (fakeCache) CachePoster(link, key string) (bool, error)   { return false, nil }
+func (fakeCache) CacheBackdrop(link, key string) (bool, error) { return false, nil }
+func (fakeCache) CachePhoto(link, key string) (bool, error)    { return false, nil }
+func (fakeCache) IsPosterCached(key string) bool               { return false }
+
+// createFiles creates empty files in a directory
+func createFiles(t *testing.T, dir string, names ...string) {
+	for _, name := range names {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+}
+
+// TestLibrary follows the files of volumes from the disk to the in-memory storage, the filters and the search index
+func TestLibrary(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	createFiles(t, dir, "Heat.1995.mkv", "Heat.1995.fr.srt", "Alien.1979.mkv")
+	db := infrastructure.NewMemory()
+	volume := model.Volume{ID: primitive.NewObjectID(), Name: "Films", Path: dir, IsRecursive: true, MediaType: "Movie"}
+	require.NoError(t, db.AddVolume(ctx, &volume))
+	// Film whose file was removed while the server was stopped
+	gone := model.Film{ID: primitive.NewObjectID(), TMDBID: 1, Title: "Gone",
+		VolumeFiles: []model.VolumeFile{{Path: filepath.Join(dir, "Gone.2000.mkv"), FromVolume: volume.ID}}}
+	require.NoError(t, db.AddFilm(ctx, &gone))
+
+	metadata := fakeMetadata{"Heat": 949, "Alien": 348}
+	filterer, searchIndex := business.NewFilterer(), business.NewSearchIndex()
+	fm := business.NewFilmManager(db, fakeCache{}, metadata, filterer, searchIndex)
+	fw := business.NewFileWatcher(ctx, db, fm, metadata, time.Minute)
+	t.Cleanup(fw.Stop)
+	require.NoError(t, fw.SynchronizeVolumes(ctx))
+	go fw.Run()
+
+	// The volume is synchronized with its files before the watcher starts
+	assert.EqualValues(t, 2, db.GetFilmCount(ctx))
+	assert.False(t, db.IsFilmPathPresent(ctx, gone.VolumeFiles[0].Path))
+	heat, err := db.GetFilmFromPath(ctx, filepath.Join(dir, "Heat.1995.mkv"))
+	require.NoError(t, err)
+	assert.Equal(t, 949, heat.TMDBID)
+	assert.Equal(t, []string{"fr"}, heat.Technical.SubtitleLanguages)
+	assert.True(t, db.IsPersonPresent(ctx, 9490))
+	assert.Equal(t, []primitive.ObjectID{heat.ID}, searchIndex.SearchFilms("heat"))
+
+	// Removed files are watched
+	alienPath := filepath.Join(dir, "Alien.1979.mkv")
+	require.NoError(t, os.Remove(alienPath))
+	require.Eventually(t, func() bool { return !db.IsFilmPathPresent(ctx, alienPath) }, 10*time.Second, 100*time.Millisecond)
+	assert.Empty(t, searchIndex.SearchFilms("alien"))
+
+	// The files of a new volume are scanned, and added to the films already in the library
+	other := t.TempDir()
+	createFiles(t, other, "Heat.1995.mkv")
+	vm := business.NewVolumeManager(db, fw, fm, metadata, time.Minute)
+	require.NoError(t, vm.CreateVolume(ctx, "Other films", other, true, "Movie"))
+	require.Eventually(t, func() bool { return db.IsFilmPathPresent(ctx, filepath.Join(other, "Heat.1995.mkv")) }, 10*time.Second, 100*time.Millisecond)
+	heat, err = db.GetFilmFromID(ctx, heat.ID)
+	require.NoError(t, err)
+	assert.Len(t, heat.VolumeFiles, 2)
+
+	// The films of a deleted volume lose its files
+	require.NoError(t, vm.DeleteVolume(ctx, volume.ID.Hex()))
+	assert.EqualValues(t, 1, db.GetFilmCount(ctx))
+	heat, err = db.GetFilmFromID(ctx, heat.ID)
+	require.NoError(t, err)
+	assert.Equal(t, other, filepath.Dir(heat.VolumeFiles[0].Path))
+	assert.Empty(t, heat.Technical.SubtitleLanguages)
+}
+
+// TestScanVolume synchronizes a single volume with its files, without watching the others
+func TestScanVolume(t *testing.T) {
+	ctx := context.Background()
+	dir, other := t.TempDir(), t.TempDir()
+	createFiles(t, dir, "Heat.1995.mkv")
+	createFiles(t, other, "Alien.1979.mkv")
+	db := infrastructure.NewMemory()
+	volume := model.Volume{ID: primitive.NewObjectID(), Name: "Films", Path: dir, IsRecursive: true, MediaType: "Movie"}
+	require.NoError(t, db.AddVolume(ctx, &volume))
+	require.NoError(t, db.AddVolume(ctx, &model.Volume{ID: primitive.NewObjectID(), Name: "Other films", Path: other, IsRecursive: true, MediaType: "Movie"}))
+
+	// Heat is found once its name is known, the first search having failed
+	metadata := fakeMetadata{"Alien": 348}
+	fm := business.NewFilmManager(db, fakeCache{}, metadata, business.NewFilterer(), business.NewSearchIndex())
+	fw := business.NewFileWatcher(ctx, db, fm, metadata, time.Minute)
+	t.Cleanup(fw.Stop)
+	vm := business.NewVolumeManager(db, fw, fm, metadata, time.Minute)
+
+	require.NoError(t, vm.ScanVolume(ctx, volume.ID.Hex()))
+	assert.EqualValues(t, 1, db.GetFilmCount(ctx))
+	heat, err := db.GetFilmFromPath(ctx, filepath.Join(dir, "Heat.1995.mkv"))
+	require.NoError(t, err)
+	assert.Zero(t, heat.TMDBID)
+
+	metadata["Heat"] = 949
+	filmID, err := fm.RematchFilm(ctx, heat.ID.Hex())
+	require.NoError(t, err)
+	heat, err = fm.GetFilm(ctx, filmID)
+	require.NoError(t, err)
+	assert.Equal(t, 949, heat.TMDBID)
+
+	// A volume whose disk is not mounted keeps its films
+	require.NoError(t, os.RemoveAll(dir))
+	assert.Error(t, vm.ScanVolume(ctx, volume.ID.Hex()))
+	assert.EqualValues(t, 1, db.GetFilmCount(ctx))
+}
+
+// TestAddFilmConcurrently adds the files of a film from concurrent scans, which must all end up in a single film
+func TestAddFilmConcurrently(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	metadata := fakeMetadata{"Heat": 949}
+	searchIndex := business.NewSearchIndex()
+	fm := business.NewFilmManager(db, fakeCache{}, metadata, business.NewFilterer(), searchIndex)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		film := metadata.CreateFilm(ctx, fmt.Sprintf("/films/%d/Heat.1995.mkv", i), primitive.NewObjectID(), nil)
+		require.NoError(t, metadata.FetchFilmTMDBID(ctx, film))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, fm.AddFilm(ctx, film, false))
+		}()
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 1, db.GetFilmCount(ctx))
+	films, err := db.GetFilms(ctx)
+	require.NoError(t, err)
+	assert.Len(t, films[0].VolumeFiles, 10)
+	assert.Equal(t, []primitive.ObjectID{films[0].ID}, searchIndex.SearchFilms("heat"))
+}
+
+// slowMetadata takes some time to search the films, unless its context is done first
+type slowMetadata struct {
+	fakeMetadata
+	fetching chan string
+}
+
+func (sm slowMetadata) FetchFilmTMDBID(ctx context.Context, f *model.Film) error {
+	sm.fetching <- f.Name
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(100 * time.Millisecond):
+		return sm.fakeMetadata.FetchFilmTMDBID(ctx, f)
+	}
+}
+
+// TestDeleteVolumeDuringScan deletes a volume while it is being scanned, whose files must not be added afterwards
+func TestDeleteVolumeDuringScan(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	createFiles(t, dir, "Heat.1995.mkv")
+	db := infrastructure.NewMemory()
+	metadata := slowMetadata{fakeMetadata: fakeMetadata{"Heat": 949}, fetching: make(chan string, 1)}
+	fm := business.NewFilmManager(db, fakeCache{}, metadata, business.NewFilterer(), business.NewSearchIndex())
+	fw := business.NewFileWatcher(ctx, db, fm, metadata, time.Minute)
+	t.Cleanup(fw.Stop)
+	vm := business.NewVolumeManager(db, fw, fm, metadata, time.Minute)
+
+	require.NoError(t, vm.CreateVolume(ctx, "Films", dir, true, "Movie"))
+	assert.Equal(t, "Heat", <-metadata.fetching)
+	volumes, err := db.GetVolumes(ctx)
+	require.NoError(t, err)
+	require.Len(t, volumes, 1)
+
+	require.NoError(t, vm.DeleteVolume(ctx, volumes[0].ID.Hex()))
+	assert.Never(t, func() bool { return db.GetFilmCount(ctx) > 0 }, 500*time.Millisecond, 10*time.Millisecond)
+}
@@ -2,26 +2,43 @@ package business
 
 import (
 	"math"
+	"sync/atomic"
 
 	"github.com/Agurato/starfin/internal/model"
 )
 
-// Paginater implements the GetPagination method
-type Paginater[T any] struct {
-	itemsPerPage int64
+// Paginater computes the range of items of a page, and the links to the other pages
+type Paginater struct {
+	itemsPerPage atomic.Int64 // Changed while the server runs, see SetItemsPerPage
 }
 
 // NewPaginater instantiates a new Paginater
-func NewPaginater[T any](itemsPerPage int64) *Paginater[T] {
-	return &Paginater[T]{
-		itemsPerPage: itemsPerPage,
+func NewPaginater(itemsPerPage int64) *Paginater {
+	p := &Paginater{}
+	p.itemsPerPage.Store(itemsPerPage)
+	return p
+}
+
+// SetItemsPerPage changes the number of items on a page
+func (p *Paginater) SetItemsPerPage(itemsPerPage int64) {
+	p.itemsPerPage.Store(itemsPerPage)
+}
+
+// GetListOptions returns the list options to fetch the items of the current page, in the given sort order
+func (p *Paginater) GetListOptions(currentPage int64, sort string, descending bool) model.ListOptions {
+	itemsPerPage := p.itemsPerPage.Load()
+	return model.ListOptions{
+		Sort:       sort,
+		Descending: descending,
+		Skip:       (max(currentPage, 1) - 1) * itemsPerPage,
+		Limit:      itemsPerPage,
 	}
 }
 
-// GetPagination creates a slice of the input elements and Pagination slice
-func (p *Paginater[T]) GetPagination(currentPage int64, items []T) ([]T, []model.Pagination) {
+// GetPagination creates the Pagination slice, from the total number of items
+func (p *Paginater) GetPagination(currentPage, totalItems int64) []model.Pagination {
 	var pages []model.Pagination
-	pageMax := int64(math.Ceil(float64(len(items)) / float64(p.itemsPerPage)))
+	pageMax := int64(math.Ceil(float64(totalItems) / float64(p.itemsPerPage.Load())))
 
 	pages = append(pages, model.Pagination{
 		Number: 1,
@@ -61,14 +78,5 @@ func (p *Paginater[T]) GetPagination(currentPage int64, items []T) ([]T, []model
 		})
 	}
 
-	// Return only part of the items (corresponding to the current page)
-	itemsIndexStart := (currentPage - 1) * p.itemsPerPage
-	itemsIndexEnd := itemsIndexStart + p.itemsPerPage
-
-	var pagedItems []T
-	for i := itemsIndexStart; i < itemsIndexEnd && i < int64(len(items)); i++ {
-		pagedItems = append(pagedItems, items[i])
-	}
-
-	return pagedItems, pages
+	return pages
 }
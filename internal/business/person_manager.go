@@ -1,68 +1,219 @@
 package business
 
 import (
+	"cmp"
+	"context"
 	"fmt"
+	"slices"
+	"strconv"
 
+	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/Agurato/starfin/internal/model"
 )
 
 type PersonStorer interface {
-	GetPeople() ([]model.Person, error)
-	GetPersonFromID(ID primitive.ObjectID) (*model.Person, error)
-	GetPersonFromTMDBID(ID int64) (*model.Person, error)
+	GetPeopleFiltered(ctx context.Context, filter model.PersonFilter, listOptions model.ListOptions) (people []model.PersonFilmCount, total int64, err error)
+	GetPersonFromID(ctx context.Context, ID primitive.ObjectID) (*model.Person, error)
+	GetPersonFromTMDBID(ctx context.Context, ID int64) (*model.Person, error)
+}
+
+type PersonCacher interface {
+	CachePoster(link, key string) (bool, error)
+	IsPosterCached(key string) bool
+}
+
+type PersonMetadataGetter interface {
+	GetPosterLink(key string) string
+}
+
+type PersonSearcher interface {
+	Search(query string) []SearchHit
 }
 
 type PersonManager struct {
 	PersonStorer
+	PersonCacher
+	PersonMetadataGetter
+	PersonSearcher
 }
 
-func NewPersonManager(ps PersonStorer) *PersonManager {
+func NewPersonManager(ps PersonStorer, pc PersonCacher, pmg PersonMetadataGetter, psr PersonSearcher) *PersonManager {
 	return &PersonManager{
-		PersonStorer: ps,
+		PersonStorer:         ps,
+		PersonCacher:         pc,
+		PersonMetadataGetter: pmg,
+		PersonSearcher:       psr,
 	}
 }
 
-func (pm PersonManager) GetPeople() []model.Person {
-	people, _ := pm.PersonStorer.GetPeople()
-	return people
+// GetPeopleFiltered returns the people of the library matching the filter and whose name matches the search,
+// with their number of films, sorted and limited by the list options, and the total number of matching people.
+// The search ignores accents and case, and tolerates a few typos
+func (pm PersonManager) GetPeopleFiltered(ctx context.Context, filter model.PersonFilter, search string, listOptions model.ListOptions) ([]model.PersonFilmCount, int64, error) {
+	if search != "" {
+		filter.TMDBIDs = []int64{}
+		for _, hit := range pm.PersonSearcher.Search(search) {
+			if !hit.IsFilm() {
+				filter.TMDBIDs = append(filter.TMDBIDs, hit.PersonTMDBID)
+			}
+		}
+	}
+	people, total, err := pm.PersonStorer.GetPeopleFiltered(ctx, filter, listOptions)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not get people: %w", err)
+	}
+	return people, total, nil
 }
 
 // GetPerson returns a Person from its hexadecimal ID
-func (pm PersonManager) GetPerson(personHexID string) (*model.Person, error) {
+func (pm PersonManager) GetPerson(ctx context.Context, personHexID string) (*model.Person, error) {
 	personId, err := primitive.ObjectIDFromHex(personHexID)
 	if err != nil {
 		return nil, fmt.Errorf("incorrect person ID: %w", err)
 	}
-	person, err := pm.PersonStorer.GetPersonFromID(personId)
+	person, err := pm.PersonStorer.GetPersonFromID(ctx, personId)
 	if err != nil {
 		return nil, fmt.Errorf("could not get person from ID '%s': %w", personHexID, err)
 	}
 	return person, nil
 }
 
-// GetFilmStaff returns slices of cast, directors, and writers who worked on the film
-func (pm PersonManager) GetFilmStaff(film *model.Film) (cast []model.Cast, directors []model.Person, writers []model.Person, err error) {
+// GetFilmography returns the films where the person had a job (actor, director, writer, or the slug of a crew role),
+// newest first, whether they are in the library or not. The films of the library are matched against the person's credits
+func (pm PersonManager) GetFilmography(person *model.Person, job string, films []model.Film) []model.FilmographyEntry {
+	var entries []model.FilmographyEntry
+	for _, credit := range person.Filmography {
+		// A person may have several credits on the same film with a job, e.g. as screenwriter and as author of the novel
+		if !creditHasJob(credit, job) || slices.ContainsFunc(entries, func(entry model.FilmographyEntry) bool { return entry.TMDBID == credit.TMDBID }) {
+			continue
+		}
+		entry := model.FilmographyEntry{PersonCredit: credit}
+		if index := slices.IndexFunc(films, func(film model.Film) bool { return film.TMDBID == credit.TMDBID }); index >= 0 {
+			entry.Film = &films[index]
+		}
+		entries = append(entries, entry)
+	}
+	// Films of the library missing from the credits, e.g. if the filmography was not fetched yet
+	for i, film := range films {
+		if !slices.ContainsFunc(entries, func(entry model.FilmographyEntry) bool { return entry.TMDBID == film.TMDBID }) {
+			var releaseDate string
+			if film.Year > 0 {
+				releaseDate = strconv.Itoa(film.Year)
+			}
+			entries = append(entries, model.FilmographyEntry{
+				PersonCredit: model.PersonCredit{TMDBID: film.TMDBID, Title: film.Title, ReleaseDate: releaseDate, PosterPath: film.PosterPath},
+				Film:         &films[i],
+			})
+		}
+	}
+	// Films without a release date are upcoming, so they come first
+	slices.SortStableFunc(entries, func(a, b model.FilmographyEntry) int {
+		if (a.ReleaseDate == "") != (b.ReleaseDate == "") {
+			if a.ReleaseDate == "" {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(b.ReleaseDate, a.ReleaseDate)
+	})
+
+	go pm.cacheFilmographyPosters(entries)
+	return entries
+}
+
+// creditHasJob returns true if the credit is for a job (actor, director, writer, or the slug of a crew role)
+func creditHasJob(credit model.PersonCredit, job string) bool {
+	switch job {
+	case "actor":
+		return credit.Department == "Acting"
+	case "director":
+		return credit.Job == "Director"
+	case "writer":
+		return credit.Department == "Writing"
+	}
+	for _, role := range model.CrewRoles {
+		if role.Slug == job {
+			return credit.Job == role.Job
+		}
+	}
+	return false
+}
+
+// cacheFilmographyPosters caches the posters of the films missing from the library, which are not cached when adding films
+func (pm PersonManager) cacheFilmographyPosters(entries []model.FilmographyEntry) {
+	for _, entry := range entries {
+		if entry.Film != nil || entry.PosterPath == "" || pm.PersonCacher.IsPosterCached(entry.PosterPath) {
+			continue
+		}
+		if _, err := pm.PersonCacher.CachePoster(pm.PersonMetadataGetter.GetPosterLink(entry.PosterPath), entry.PosterPath); err != nil {
+			log.Debug().Err(err).Int("tmdbID", entry.TMDBID).Msg("Could not cache poster")
+		}
+	}
+}
+
+// GetFilmStaff returns slices of cast, directors, and writers who worked on the film, and its crew grouped by job
+func (pm PersonManager) GetFilmStaff(ctx context.Context, film *model.Film) (cast []model.Cast, directors []model.Person, writers []model.Person, crew []model.CrewGroup, err error) {
 	for _, character := range film.Characters {
-		actor, err := pm.PersonStorer.GetPersonFromTMDBID(character.ActorID)
+		actor, err := pm.PersonStorer.GetPersonFromTMDBID(ctx, character.ActorID)
 		if err != nil {
 			actor = &model.Person{}
 		}
 		cast = append(cast, model.Cast{CharacterName: character.CharacterName, Actor: *actor})
 	}
 	for _, directorID := range film.Directors {
-		person, err := pm.PersonStorer.GetPersonFromTMDBID(directorID)
+		person, err := pm.PersonStorer.GetPersonFromTMDBID(ctx, directorID)
 		if err == nil {
 			directors = append(directors, *person)
 		}
 	}
 	for _, writerID := range film.Writers {
-		person, err := pm.PersonStorer.GetPersonFromTMDBID(writerID)
+		person, err := pm.PersonStorer.GetPersonFromTMDBID(ctx, writerID)
 		if err == nil {
 			writers = append(writers, *person)
 		}
 	}
 
-	return cast, directors, writers, nil
+	return cast, directors, writers, pm.getCrewGroups(ctx, film), nil
+}
+
+// getCrewGroups returns the crew of a film grouped by job.
+// The jobs with a role page come first in the order of the roles, then the others by department and job
+func (pm PersonManager) getCrewGroups(ctx context.Context, film *model.Film) (groups []model.CrewGroup) {
+	for _, credit := range film.Crew {
+		index := slices.IndexFunc(groups, func(group model.CrewGroup) bool {
+			return group.Department == credit.Department && group.Job == credit.Job
+		})
+		if index < 0 {
+			role, _ := model.GetCrewRoleFromJob(credit.Job)
+			groups = append(groups, model.CrewGroup{Department: credit.Department, Job: credit.Job, Role: role.Slug})
+			index = len(groups) - 1
+		}
+		person := model.Person{TMDBID: credit.PersonID, Name: credit.Name}
+		// Only the people with a role page are in the database
+		if groups[index].Role != "" {
+			if stored, err := pm.PersonStorer.GetPersonFromTMDBID(ctx, credit.PersonID); err == nil {
+				person = *stored
+			}
+		}
+		groups[index].People = append(groups[index].People, person)
+	}
+
+	roleIndex := func(group model.CrewGroup) int {
+		if index := slices.IndexFunc(model.CrewRoles, func(role model.CrewRole) bool { return role.Slug == group.Role }); index >= 0 {
+			return index
+		}
+		return len(model.CrewRoles)
+	}
+	slices.SortStableFunc(groups, func(a, b model.CrewGroup) int {
+		if c := cmp.Compare(roleIndex(a), roleIndex(b)); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Department, b.Department); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Job, b.Job)
+	})
+	return groups
 }
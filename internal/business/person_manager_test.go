@@ -0,0 +1,208 @@
+package business_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// TestGetPeopleFiltered searches the people by name, ignoring accents and case, among the people having a role
+func TestGetPeopleFiltered(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	searchIndex := business.NewSearchIndex()
+	people := []model.Person{
+		{ID: primitive.NewObjectID(), TMDBID: 1, Name: "Zoë Saldaña"},
+		{ID: primitive.NewObjectID(), TMDBID: 2, Name: "Zoe Kazan"},
+		{ID: primitive.NewObjectID(), TMDBID: 3, Name: "Sigourney Weaver"},
+		{ID: primitive.NewObjectID(), TMDBID: 4, Name: "James Cameron"},
+	}
+	for _, person := range people {
+		person := person
+		db.AddPerson(ctx, &person)
+	}
+	searchIndex.IndexPeople(people)
+	for _, film := range []model.Film{
+		{ID: primitive.NewObjectID(), TMDBID: 19995, Title: "Avatar", Directors: []int64{4}, Writers: []int64{4},
+			Characters: []model.Character{{ActorID: 1}, {ActorID: 3}}},
+		{ID: primitive.NewObjectID(), TMDBID: 679, Title: "Aliens", Directors: []int64{4}, Characters: []model.Character{{ActorID: 3}}},
+		{ID: primitive.NewObjectID(), TMDBID: 416477, Title: "The Big Sick", Characters: []model.Character{{ActorID: 2}}},
+	} {
+		film := film
+		require.NoError(t, db.AddFilm(ctx, &film))
+	}
+	pm := business.NewPersonManager(db, fakeCache{}, fakeMetadata{}, searchIndex)
+
+	tests := []struct {
+		search string
+		role   string
+		names  []string
+	}{
+		{"zoe saldana", "", []string{"Zoë Saldaña"}},
+		{"ZOË SALDAÑA", "", []string{"Zoë Saldaña"}},
+		{"Zoe", "", []string{"Zoe Kazan", "Zoë Saldaña"}},
+		{"zoe", model.PersonRoleActor, []string{"Zoe Kazan", "Zoë Saldaña"}},
+		{"zoe", model.PersonRoleDirector, nil},
+		{"cameron", model.PersonRoleWriter, []string{"James Cameron"}},
+		{"sigourny", "", []string{"Sigourney Weaver"}},
+		{"", model.PersonRoleActor, []string{"Sigourney Weaver", "Zoe Kazan", "Zoë Saldaña"}},
+		{"avatar", "", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.search+" "+test.role, func(t *testing.T) {
+			list, total, err := pm.GetPeopleFiltered(ctx, model.PersonFilter{Role: test.role}, test.search, model.ListOptions{Sort: model.PersonSortName})
+			require.NoError(t, err)
+			var names []string
+			for _, person := range list {
+				names = append(names, person.Name)
+			}
+			assert.Equal(t, test.names, names)
+			assert.EqualValues(t, len(test.names), total)
+		})
+	}
+
+	// The number of films of each person is counted
+	list, _, err := pm.GetPeopleFiltered(ctx, model.PersonFilter{}, "weaver", model.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, 2, list[0].FilmCount)
+}
+
+// TestCrewRoles lists the films of a person by crew job, and groups the crew of a film by job
+func TestCrewRoles(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	fm := business.NewFilmManager(db, fakeCache{}, fakeMetadata{}, business.NewFilterer(), business.NewSearchIndex())
+	pm := business.NewPersonManager(db, fakeCache{}, fakeMetadata{}, business.NewSearchIndex())
+
+	// Elliot Goldenthal (1) scored both films, Dante Spinotti (2) shot Heat, Pietro Scalia (3) edited Alien³
+	heat := model.Film{ID: primitive.NewObjectID(), TMDBID: 949, Title: "Heat", Directors: []int64{10}, Crew: []model.CrewCredit{
+		{PersonID: 4, Name: "Gaffer", Department: "Lighting", Job: "Gaffer"},
+		{PersonID: 2, Name: "Dante Spinotti", Department: "Camera", Job: "Director of Photography"},
+		{PersonID: 10, Name: "Michael Mann", Department: "Directing", Job: "Director"},
+		{PersonID: 1, Name: "Elliot Goldenthal", Department: "Sound", Job: "Original Music Composer"},
+		{PersonID: 5, Name: "Sound Mixer", Department: "Sound", Job: "Sound Mixer"},
+		{PersonID: 6, Name: "Other Gaffer", Department: "Lighting", Job: "Gaffer"},
+	}}
+	alien3 := model.Film{ID: primitive.NewObjectID(), TMDBID: 8077, Title: "Alien³", Crew: []model.CrewCredit{
+		{PersonID: 1, Name: "Elliot Goldenthal", Department: "Sound", Job: "Original Music Composer"},
+		{PersonID: 3, Name: "Pietro Scalia", Department: "Editing", Job: "Editor"},
+	}}
+	require.NoError(t, fm.AddFilm(ctx, &heat, false))
+	require.NoError(t, fm.AddFilm(ctx, &alien3, false))
+
+	titles := func(films []model.Film) (titles []string) {
+		for _, film := range films {
+			titles = append(titles, film.Title)
+		}
+		return titles
+	}
+	assert.ElementsMatch(t, []string{"Heat", "Alien³"}, titles(fm.GetFilmsWithCrewJob(ctx, 1, model.CrewRoles[0].Job)))
+	assert.Equal(t, []string{"Heat"}, titles(fm.GetFilmsWithCrewJob(ctx, 2, "Director of Photography")))
+	assert.Equal(t, []string{"Alien³"}, titles(fm.GetFilmsWithCrewJob(ctx, 3, "Editor")))
+	assert.Empty(t, fm.GetFilmsWithCrewJob(ctx, 2, "Editor"))
+	assert.Empty(t, fm.GetFilmsWithCrewJob(ctx, 3, "Producer"))
+
+	// Only the people with a role page are fetched
+	for _, personID := range []int64{1, 2, 3, 10} {
+		assert.True(t, db.IsPersonPresent(ctx, personID), "person %d", personID)
+	}
+	for _, personID := range []int64{4, 5, 6} {
+		assert.False(t, db.IsPersonPresent(ctx, personID), "person %d", personID)
+	}
+	for _, role := range model.CrewRoles {
+		found, ok := model.GetCrewRoleFromJob(role.Job)
+		assert.True(t, ok, role.Slug)
+		assert.Equal(t, role, found)
+	}
+	_, ok := model.GetCrewRoleFromJob("Gaffer")
+	assert.False(t, ok)
+
+	// The jobs with a role page come first, in the order of the roles, then the others by department and job
+	_, directors, _, crew, err := pm.GetFilmStaff(ctx, &heat)
+	require.NoError(t, err)
+	require.Len(t, directors, 1)
+	assert.EqualValues(t, 10, directors[0].TMDBID)
+	var jobs []string
+	for _, group := range crew {
+		jobs = append(jobs, group.Job)
+	}
+	assert.Equal(t, []string{"Original Music Composer", "Director of Photography", "Director", "Gaffer", "Sound Mixer"}, jobs)
+	assert.Equal(t, "composer", crew[0].Role)
+	assert.Empty(t, crew[2].Role)
+	require.Len(t, crew[0].People, 1)
+	assert.False(t, crew[0].People[0].ID.IsZero(), "stored person")
+	require.Len(t, crew[3].People, 2)
+	assert.True(t, crew[3].People[0].ID.IsZero(), "person only known by name")
+	assert.Equal(t, []string{"Gaffer", "Other Gaffer"}, []string{crew[3].People[0].Name, crew[3].People[1].Name})
+}
+
+// TestGetFilmography lists the films of a person for a job, newest first, whether they are in the library or not
+func TestGetFilmography(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	fm := business.NewFilmManager(db, fakeCache{}, fakeMetadata{}, business.NewFilterer(), business.NewSearchIndex())
+	pm := business.NewPersonManager(db, fakeCache{}, fakeMetadata{}, business.NewSearchIndex())
+
+	scott := model.Person{ID: primitive.NewObjectID(), TMDBID: 578, Name: "Ridley Scott", Filmography: []model.PersonCredit{
+		{TMDBID: 62, Title: "The Duellists", ReleaseDate: "1977-08-31", Department: "Directing", Job: "Director"},
+		{TMDBID: 348, Title: "Alien", ReleaseDate: "1979-05-25", Department: "Directing", Job: "Director"},
+		{TMDBID: 348, Title: "Alien", ReleaseDate: "1979-05-25", Department: "Production", Job: "Producer"},
+		{TMDBID: 78, Title: "Blade Runner", ReleaseDate: "1982-06-25", Department: "Directing", Job: "Director"},
+		{TMDBID: 70981, Title: "Prometheus", ReleaseDate: "2012-05-30", Department: "Directing", Job: "Director"},
+		{TMDBID: 1000, Title: "Upcoming", Department: "Directing", Job: "Director"},
+		{TMDBID: 2000, Title: "The Dueling Duellists", ReleaseDate: "1990-01-01", Department: "Writing", Job: "Screenplay"},
+		{TMDBID: 2000, Title: "The Dueling Duellists", ReleaseDate: "1990-01-01", Department: "Writing", Job: "Novel"},
+		{TMDBID: 3000, Title: "Documentary", ReleaseDate: "2005-01-01", Department: "Acting", Job: "Himself"},
+	}}
+	db.AddPerson(ctx, &scott)
+	// Gladiator is missing from the credits fetched before it was released
+	for _, film := range []model.Film{
+		{ID: primitive.NewObjectID(), TMDBID: 348, Title: "Alien", Year: 1979, Directors: []int64{578},
+			Crew: []model.CrewCredit{{PersonID: 578, Name: "Ridley Scott", Department: "Production", Job: "Producer"}}},
+		{ID: primitive.NewObjectID(), TMDBID: 70981, Title: "Prometheus", Year: 2012, Directors: []int64{578}},
+		{ID: primitive.NewObjectID(), TMDBID: 98, Title: "Gladiator", Year: 2000, Directors: []int64{578}},
+	} {
+		film := film
+		require.NoError(t, fm.AddFilm(ctx, &film, false))
+	}
+	person, err := pm.GetPerson(ctx, scott.ID.Hex())
+	require.NoError(t, err)
+
+	type entry struct {
+		Title string
+		Year  string
+		Owned bool
+	}
+	filmography := func(job string, films []model.Film) (entries []entry) {
+		for _, e := range pm.GetFilmography(person, job, films) {
+			if e.Film != nil {
+				assert.Equal(t, e.TMDBID, e.Film.TMDBID)
+			}
+			entries = append(entries, entry{e.Title, e.Year(), e.Film != nil})
+		}
+		return entries
+	}
+
+	// The upcoming films come first
+	assert.Equal(t, []entry{
+		{"Upcoming", "", false},
+		{"Prometheus", "2012", true},
+		{"Gladiator", "2000", true},
+		{"Blade Runner", "1982", false},
+		{"Alien", "1979", true},
+		{"The Duellists", "1977", false},
+	}, filmography("director", fm.GetFilmsWithDirector(ctx, 578)))
+	// A person credited several times on a film with a job has a single entry
+	assert.Equal(t, []entry{{"The Dueling Duellists", "1990", false}}, filmography("writer", fm.GetFilmsWithWriter(ctx, 578)))
+	assert.Equal(t, []entry{{"Documentary", "2005", false}}, filmography("actor", fm.GetFilmsWithActor(ctx, 578)))
+	assert.Equal(t, []entry{{"Alien", "1979", true}}, filmography("producer", fm.GetFilmsWithCrewJob(ctx, 578, "Producer")))
+	assert.Empty(t, filmography("composer", fm.GetFilmsWithCrewJob(ctx, 578, "Original Music Composer")))
+}
@@ -0,0 +1,133 @@
+package business
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// Weights of each thing two films have in common in their similarity score
+const (
+	similarityGenreWeight    = 1.0
+	similarityDirectorWeight = 3.0
+	similarityWriterWeight   = 2.0
+	similarityActorWeight    = 1.5
+	similarityCountryWeight  = 0.5
+	similarityDecadeWeight   = 1.0
+)
+
+// topBilledCastSize is the number of actors of a film, by billing order, compared to find similar films
+const topBilledCastSize = 5
+
+// similarCandidatesLimit is the maximum number of films sharing people, and then genres, that are compared to a film.
+// The best rated films are compared first, so that large libraries are not loaded entirely
+const similarCandidatesLimit = 200
+
+// scoredFilm is a film with its similarity score to another film
+type scoredFilm struct {
+	film  model.Film
+	score float64
+}
+
+// GetSimilarFilms returns at most number films of the library that are the most similar to a film, from the most similar.
+// Films are similar when they share genres, directors, writers, top-billed actors, production countries or their decade.
+// Only the films sharing people or genres are candidates, production countries and decades only adding to their score
+func (fm FilmManager) GetSimilarFilms(ctx context.Context, film *model.Film, number int) ([]model.Film, error) {
+	var scored []scoredFilm
+	compared := map[primitive.ObjectID]bool{film.ID: true}
+	for _, query := range getSimilarFilmsQueries(film) {
+		candidates, _, err := fm.FilmStorer.GetFilmsFiltered(ctx, model.FilmFilter{Query: query},
+			model.ListOptions{Sort: model.FilmSortRating, Descending: true, Limit: similarCandidatesLimit})
+		if err != nil {
+			return nil, fmt.Errorf("error while getting films similar to '%s': %w", film.ID.Hex(), err)
+		}
+		for _, candidate := range candidates {
+			if compared[candidate.ID] {
+				continue
+			}
+			compared[candidate.ID] = true
+			if score := getSimilarityScore(film, &candidate); score > 0 {
+				scored = append(scored, scoredFilm{film: candidate, score: score})
+			}
+		}
+	}
+	// Most similar first, then the best rated
+	slices.SortFunc(scored, func(a, b scoredFilm) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.film.Rating(model.RatingSourceIMDb).Value, a.film.Rating(model.RatingSourceIMDb).Value); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.film.Title, b.film.Title)
+	})
+
+	films := make([]model.Film, 0, min(number, len(scored)))
+	for _, s := range scored[:min(number, len(scored))] {
+		films = append(films, s.film)
+	}
+	return films, nil
+}
+
+// getSimilarFilmsQueries returns the queries matching the films sharing people with a film, and then the ones sharing genres.
+// A query is omitted when the film has nothing to compare
+func getSimilarFilmsQueries(film *model.Film) (queries []model.QueryNode) {
+	var people []model.QueryNode
+	for _, person := range []model.QueryPerson{
+		{Field: model.QueryFieldDirector, TMDBIDs: film.Directors},
+		{Field: model.QueryFieldWriter, TMDBIDs: film.Writers},
+		{Field: model.QueryFieldActor, TMDBIDs: getTopBilledCast(film)},
+	} {
+		if len(person.TMDBIDs) > 0 {
+			people = append(people, person)
+		}
+	}
+	if len(people) > 0 {
+		queries = append(queries, model.QueryOr{Nodes: people})
+	}
+	var genres []model.QueryNode
+	for _, genre := range film.Genres {
+		genres = append(genres, model.QueryMatch{Field: model.QueryFieldGenre, Value: genre})
+	}
+	if len(genres) > 0 {
+		queries = append(queries, model.QueryOr{Nodes: genres})
+	}
+	return queries
+}
+
+// getSimilarityScore returns how similar two films are, 0 if they have nothing in common
+func getSimilarityScore(a, b *model.Film) (score float64) {
+	score += similarityGenreWeight * float64(countShared(a.Genres, b.Genres))
+	score += similarityDirectorWeight * float64(countShared(a.Directors, b.Directors))
+	score += similarityWriterWeight * float64(countShared(a.Writers, b.Writers))
+	score += similarityActorWeight * float64(countShared(getTopBilledCast(a), getTopBilledCast(b)))
+	score += similarityCountryWeight * float64(countShared(a.ProdCountries, b.ProdCountries))
+	if a.ReleaseYear > 0 && a.ReleaseYear/10 == b.ReleaseYear/10 {
+		score += similarityDecadeWeight
+	}
+	return score
+}
+
+// getTopBilledCast returns the TMDB IDs of the first actors of a film by billing order
+func getTopBilledCast(film *model.Film) []int64 {
+	var actorIDs []int64
+	for _, character := range film.Characters[:min(topBilledCastSize, len(film.Characters))] {
+		actorIDs = append(actorIDs, character.ActorID)
+	}
+	return actorIDs
+}
+
+// countShared returns the number of distinct values that are in both slices
+func countShared[T comparable](a, b []T) (count int) {
+	for i, value := range a {
+		if !slices.Contains(a[:i], value) && slices.Contains(b, value) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,65 @@
+package business_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// TestGetSimilarFilms orders the films by their similarity score, and then by their rating
+func TestGetSimilarFilms(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	newFilm := func(title string, year int, rating float64, directors, writers, actors []int64, genres ...string) *model.Film {
+		film := &model.Film{ID: primitive.NewObjectID(), Title: title, ReleaseYear: year, Directors: directors, Writers: writers,
+			Genres: genres, ProdCountries: []string{"US"},
+			Ratings: map[string]model.Rating{model.RatingSourceIMDb: {Value: rating, Best: 10}}}
+		for _, actor := range actors {
+			film.Characters = append(film.Characters, model.Character{ActorID: actor})
+		}
+		require.NoError(t, db.AddFilm(ctx, film))
+		return film
+	}
+	heat := newFilm("Heat", 1995, 8.3, []int64{1}, []int64{1}, []int64{10, 11}, "Crime", "Drama")
+	// Same director and writer, same genres and country: 3 + 2 + 2 + 0.5
+	thief := newFilm("Thief", 1981, 7.3, []int64{1}, []int64{1}, nil, "Crime", "Drama")
+	// Same director, one genre and the country: 3 + 1 + 0.5, the best rated first
+	collateral := newFilm("Collateral", 2004, 7.5, []int64{1}, nil, nil, "Crime", "Thriller")
+	publicEnemies := newFilm("Public Enemies", 2009, 7.0, []int64{1}, nil, nil, "Crime", "History")
+	// Same actor, one genre, the country and the decade: 1.5 + 1 + 0.5 + 1
+	ronin := newFilm("Ronin", 1998, 7.2, nil, nil, []int64{10}, "Crime", "Action")
+	// Same genres, the country and the decade: 2 + 0.5 + 1
+	casino := newFilm("Casino", 1995, 8.2, []int64{2}, nil, nil, "Crime", "Drama")
+	// Same genres and the country: 2 + 0.5
+	godfather := newFilm("The Godfather", 1972, 9.2, []int64{3}, nil, nil, "Crime", "Drama")
+	// Only the country and the decade are shared, which is not enough to be compared
+	newFilm("Toy Story", 1995, 8.3, []int64{4}, nil, nil, "Animation")
+
+	fm := business.NewFilmManager(db, fakeCache{}, fakeMetadata{}, business.NewFilterer(), business.NewSearchIndex())
+	titles := func(films []model.Film) (titles []string) {
+		for _, film := range films {
+			titles = append(titles, film.Title)
+		}
+		return titles
+	}
+
+	similar, err := fm.GetSimilarFilms(ctx, heat, 10)
+	require.NoError(t, err)
+	assert.Equal(t, titles([]model.Film{*thief, *collateral, *publicEnemies, *ronin, *casino, *godfather}), titles(similar))
+
+	similar, err = fm.GetSimilarFilms(ctx, heat, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{thief.Title, collateral.Title}, titles(similar))
+
+	// A film with neither people nor genres has no similar films
+	similar, err = fm.GetSimilarFilms(ctx, &model.Film{ID: primitive.NewObjectID(), ReleaseYear: 1995, ProdCountries: []string{"US"}}, 10)
+	require.NoError(t, err)
+	assert.Empty(t, similar)
+}
@@ -0,0 +1,522 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+type RefreshStorer interface {
+	GetFilms(ctx context.Context) ([]model.Film, error)
+	GetFilmFromID(ctx context.Context, ID primitive.ObjectID) (*model.Film, error)
+	GetFilmsToRefresh(ctx context.Context, before time.Time, limit int64) ([]model.Film, error)
+	GetFilmsToRefreshRatings(ctx context.Context, before time.Time, limit int64) ([]model.Film, error)
+	GetPeopleToRefresh(ctx context.Context, before time.Time, limit int64) ([]model.Person, error)
+	GetPeople(ctx context.Context) ([]model.Person, error)
+	GetPersonFromTMDBID(ctx context.Context, ID int64) (*model.Person, error)
+	GetPersonRetries(ctx context.Context, before time.Time, limit int64) ([]model.PersonRetry, error)
+
+	UpdateFilmRatings(ctx context.Context, film *model.Film) error
+	UpdatePerson(ctx context.Context, person *model.Person) error
+	DeletePerson(ctx context.Context, personTMDBID int64) error
+	AddPersonRetry(ctx context.Context, retry *model.PersonRetry) error
+	DeletePersonRetry(ctx context.Context, personTMDBID int64) error
+}
+
+type RefreshMetadataGetter interface {
+	UpdateFilmDetails(ctx context.Context, film *model.Film) error
+	UpdateFilmRatings(ctx context.Context, film *model.Film)
+	GetPersonDetails(ctx context.Context, personID int64) *model.Person
+	GetPhotoLink(key string) string
+}
+
+type RefreshFilmManager interface {
+	AddFilm(ctx context.Context, film *model.Film, update bool) error
+	CacheFilm(ctx context.Context, film *model.Film) error
+}
+
+type RefreshSearchIndexer interface {
+	IndexPerson(person *model.Person)
+	RemovePerson(personTMDBID int64)
+}
+
+type RefreshCacher interface {
+	CachePhoto(link, key string) (bool, error)
+	RemovePhoto(key string) error
+}
+
+// RefreshSettings holds the intervals at which the metadata is considered stale, and the pace of the refreshes
+type RefreshSettings struct {
+	FilmInterval    time.Duration // Age after which the film details are fetched again from TMDB
+	PersonInterval  time.Duration // Age after which the person details are fetched again from TMDB
+	RatingsInterval time.Duration // Age after which the ratings are scraped again
+	RateLimit       time.Duration // Minimum delay between two refreshes
+	BatchSize       int64         // Maximum number of items picked at each check
+	TaskTimeout     time.Duration // Maximum duration of a single refresh
+}
+
+// DefaultRefreshSettings are used for the settings that are not set
+var DefaultRefreshSettings = RefreshSettings{
+	FilmInterval:    30 * 24 * time.Hour,
+	PersonInterval:  90 * 24 * time.Hour,
+	RatingsInterval: 7 * 24 * time.Hour,
+	RateLimit:       2 * time.Second,
+	BatchSize:       50,
+	TaskTimeout:     DefaultTaskTimeout,
+}
+
+// refreshCheckInterval is the maximum time between two checks for stale metadata
+const refreshCheckInterval = time.Hour
+
+const (
+	personRetryCheckInterval = time.Minute    // Time between two checks for person fetches to retry
+	personRetryBaseDelay     = time.Minute    // Delay before retrying a person fetch after the first failure, doubled at each failure
+	personRetryMaxDelay      = 24 * time.Hour // Maximum delay between two attempts to fetch a person
+	orphanCleanupInterval    = 24 * time.Hour // Time between two removals of the people no longer in any film
+)
+
+// Refresher periodically refreshes the film details, person details and ratings
+type Refresher struct {
+	RefreshStorer
+	RefreshMetadataGetter
+	RefreshFilmManager
+	RefreshSearchIndexer
+	RefreshCacher
+
+	settings  RefreshSettings
+	rateLimit atomic.Int64 // Changed while the refreshes run, see SetRateLimit
+	jobs      chan refreshJob
+	stopped   chan struct{} // Closed when Run returns, so that no job is queued anymore
+}
+
+// refreshJob is a queued refresh, run with its own timeout
+type refreshJob func(ctx context.Context)
+
+// NewRefresher instantiates a new Refresher
+func NewRefresher(rs RefreshStorer, rmg RefreshMetadataGetter, rfm RefreshFilmManager, rsi RefreshSearchIndexer, rc RefreshCacher, settings RefreshSettings) *Refresher {
+	if settings.FilmInterval <= 0 {
+		settings.FilmInterval = DefaultRefreshSettings.FilmInterval
+	}
+	if settings.PersonInterval <= 0 {
+		settings.PersonInterval = DefaultRefreshSettings.PersonInterval
+	}
+	if settings.RatingsInterval <= 0 {
+		settings.RatingsInterval = DefaultRefreshSettings.RatingsInterval
+	}
+	if settings.RateLimit <= 0 {
+		settings.RateLimit = DefaultRefreshSettings.RateLimit
+	}
+	if settings.BatchSize <= 0 {
+		settings.BatchSize = DefaultRefreshSettings.BatchSize
+	}
+	if settings.TaskTimeout <= 0 {
+		settings.TaskTimeout = DefaultRefreshSettings.TaskTimeout
+	}
+	r := &Refresher{
+		RefreshStorer:         rs,
+		RefreshMetadataGetter: rmg,
+		RefreshFilmManager:    rfm,
+		RefreshSearchIndexer:  rsi,
+		RefreshCacher:         rc,
+		settings:              settings,
+		jobs:                  make(chan refreshJob, settings.BatchSize),
+		stopped:               make(chan struct{}),
+	}
+	r.rateLimit.Store(int64(settings.RateLimit))
+	return r
+}
+
+// SetRateLimit changes the minimum delay between two refreshes, from the next one
+func (r *Refresher) SetRateLimit(rateLimit time.Duration) {
+	if rateLimit <= 0 {
+		rateLimit = DefaultRefreshSettings.RateLimit
+	}
+	r.rateLimit.Store(int64(rateLimit))
+}
+
+// Run starts the scheduled refreshes. It blocks until the context is done, and must only be called once
+func (r *Refresher) Run(ctx context.Context) {
+	defer close(r.stopped)
+	go r.schedule(ctx, "films", r.settings.FilmInterval, r.queueStaleFilms)
+	go r.schedule(ctx, "people", r.settings.PersonInterval, r.queueStalePeople)
+	go r.schedule(ctx, "ratings", r.settings.RatingsInterval, r.queueStaleRatings)
+	go r.schedule(ctx, "person retries", personRetryCheckInterval, func(ctx context.Context, _ time.Time) int {
+		return r.queueDuePersonRetries(ctx, time.Now())
+	})
+	go r.cleanOrphanPeoplePeriodically(ctx)
+
+	// Jobs are run one at a time, and not faster than the rate limit
+	rateLimit := time.Duration(r.rateLimit.Load())
+	limiter := time.NewTicker(rateLimit)
+	defer limiter.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-r.jobs:
+			if current := time.Duration(r.rateLimit.Load()); current != rateLimit {
+				rateLimit = current
+				limiter.Reset(rateLimit)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-limiter.C:
+			}
+			r.run(ctx, job)
+		}
+	}
+}
+
+// run runs a job, which is cancelled after the task timeout
+func (r *Refresher) run(ctx context.Context, job refreshJob) {
+	ctx, cancel := context.WithTimeout(ctx, r.settings.TaskTimeout)
+	defer cancel()
+	job(ctx)
+}
+
+// RefreshFilm queues the refresh of a single film, given its hexadecimal ID
+func (r *Refresher) RefreshFilm(ctx context.Context, filmHexID string) error {
+	filmID, err := primitive.ObjectIDFromHex(filmHexID)
+	if err != nil {
+		return fmt.Errorf("incorrect film ID: %w", err)
+	}
+	film, err := r.RefreshStorer.GetFilmFromID(ctx, filmID)
+	if err != nil {
+		return fmt.Errorf("could not get film from ID '%s': %w", filmHexID, err)
+	}
+	if film.TMDBID == 0 {
+		return fmt.Errorf("film '%s' is not matched on TMDB", filmHexID)
+	}
+	// The refresh outlives the request, so it does not use its context
+	go r.queue(func(ctx context.Context) { r.refreshFilm(ctx, filmID) })
+	return nil
+}
+
+// RefreshLibrary queues the refresh of every film in the library
+func (r *Refresher) RefreshLibrary(ctx context.Context) error {
+	films, err := r.RefreshStorer.GetFilms(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get films: %w", err)
+	}
+	go func() {
+		for _, film := range films {
+			if film.TMDBID == 0 {
+				continue
+			}
+			filmID := film.ID
+			if !r.queue(func(ctx context.Context) { r.refreshFilm(ctx, filmID) }) {
+				return
+			}
+		}
+		log.Info().Int("films", len(films)).Msg("Library refresh queued")
+	}()
+	return nil
+}
+
+// ReloadCache queues the caching of the images of every film and of their people.
+// The reload outlives the request, and is stopped along with the refreshes
+func (r *Refresher) ReloadCache(ctx context.Context) error {
+	films, err := r.RefreshStorer.GetFilms(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get films: %w", err)
+	}
+	go func() {
+		for _, film := range films {
+			film := film
+			if !r.queue(func(ctx context.Context) {
+				if err := r.RefreshFilmManager.CacheFilm(ctx, &film); err != nil {
+					log.Error().Err(err).Str("filmID", film.ID.Hex()).Msg("Unable to cache film images")
+				}
+			}) {
+				return
+			}
+		}
+		log.Info().Int("films", len(films)).Msg("Cache reload queued")
+	}()
+	return nil
+}
+
+// CleanOrphanPeople removes the people who are no longer in the cast or crew of any film, along with their cached photo.
+// It returns the number of removed people
+func (r *Refresher) CleanOrphanPeople(ctx context.Context) (int, error) {
+	// People are read before the films, so that the people of a film being added are not considered orphans
+	people, err := r.RefreshStorer.GetPeople(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not get people: %w", err)
+	}
+	films, err := r.RefreshStorer.GetFilms(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not get films: %w", err)
+	}
+	referenced := make(map[int64]bool)
+	for _, film := range films {
+		for _, personID := range film.GetCastAndCrewIDs() {
+			referenced[personID] = true
+		}
+	}
+
+	removed := 0
+	for _, person := range people {
+		if referenced[person.TMDBID] {
+			continue
+		}
+		if err := r.RefreshStorer.DeletePerson(ctx, person.TMDBID); err != nil {
+			log.Error().Err(err).Int64("tmdbID", person.TMDBID).Msg("Unable to remove orphan person")
+			continue
+		}
+		r.RefreshSearchIndexer.RemovePerson(person.TMDBID)
+		if err := r.RefreshStorer.DeletePersonRetry(ctx, person.TMDBID); err != nil {
+			log.Error().Err(err).Int64("tmdbID", person.TMDBID).Msg("Unable to remove retry of orphan person")
+		}
+		if person.Photo != "" {
+			if err := r.RefreshCacher.RemovePhoto(person.Photo); err != nil {
+				log.Error().Err(err).Int64("tmdbID", person.TMDBID).Msg("Unable to remove photo of orphan person")
+			}
+		}
+		removed++
+	}
+	log.Info().Int("removed", removed).Msg("Orphan people removed")
+	return removed, nil
+}
+
+// cleanOrphanPeoplePeriodically removes the orphan people at a regular interval
+func (r *Refresher) cleanOrphanPeoplePeriodically(ctx context.Context) {
+	ticker := time.NewTicker(orphanCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := r.CleanOrphanPeople(ctx); err != nil {
+			log.Error().Err(err).Msg("Unable to remove orphan people")
+		}
+	}
+}
+
+// schedule calls queueStale at a regular interval until the context is done,
+// after a random delay so that all schedules do not start at the same time
+func (r *Refresher) schedule(ctx context.Context, name string, interval time.Duration, queueStale func(ctx context.Context, before time.Time) int) {
+	checkInterval := min(interval, refreshCheckInterval)
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Duration(rand.Int63n(int64(checkInterval)))):
+	}
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		if queued := queueStale(ctx, time.Now().Add(-interval)); queued > 0 {
+			log.Debug().Str("schedule", name).Int("queued", queued).Msg("Queued stale metadata refreshes")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// queue adds a job to the refresh queue, blocking while the queue is full.
+// It returns false, without queuing the job, once the refreshes are stopped
+func (r *Refresher) queue(job refreshJob) bool {
+	select {
+	case r.jobs <- job:
+		return true
+	case <-r.stopped:
+		return false
+	}
+}
+
+func (r *Refresher) queueStaleFilms(ctx context.Context, before time.Time) int {
+	films, err := r.RefreshStorer.GetFilmsToRefresh(ctx, before, r.settings.BatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get films to refresh")
+		return 0
+	}
+	for _, film := range films {
+		filmID := film.ID
+		if !r.queue(func(ctx context.Context) { r.refreshFilm(ctx, filmID) }) {
+			break
+		}
+	}
+	return len(films)
+}
+
+func (r *Refresher) queueStaleRatings(ctx context.Context, before time.Time) int {
+	films, err := r.RefreshStorer.GetFilmsToRefreshRatings(ctx, before, r.settings.BatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get films with ratings to refresh")
+		return 0
+	}
+	for _, film := range films {
+		filmID := film.ID
+		if !r.queue(func(ctx context.Context) { r.refreshRatings(ctx, filmID) }) {
+			break
+		}
+	}
+	return len(films)
+}
+
+func (r *Refresher) queueStalePeople(ctx context.Context, before time.Time) int {
+	people, err := r.RefreshStorer.GetPeopleToRefresh(ctx, before, r.settings.BatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get people to refresh")
+		return 0
+	}
+	for _, person := range people {
+		person := person
+		if !r.queue(func(ctx context.Context) { r.refreshPerson(ctx, &person) }) {
+			break
+		}
+	}
+	return len(people)
+}
+
+// queueDuePersonRetries queues the person fetches to retry whose next attempt is due
+func (r *Refresher) queueDuePersonRetries(ctx context.Context, now time.Time) int {
+	retries, err := r.RefreshStorer.GetPersonRetries(ctx, now, r.settings.BatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get person fetches to retry")
+		return 0
+	}
+	for _, retry := range retries {
+		// The next attempt is planned before fetching, so that the retry is not queued again while it waits in the queue
+		next := getPersonRetry(retry.TMDBID, retry.Attempts+1)
+		if err := r.RefreshStorer.AddPersonRetry(ctx, next); err != nil {
+			log.Error().Err(err).Int64("tmdbID", retry.TMDBID).Msg("Unable to plan next person fetch")
+			continue
+		}
+		if !r.queue(func(ctx context.Context) { r.retryPerson(ctx, next) }) {
+			break
+		}
+	}
+	return len(retries)
+}
+
+// getPersonRetry returns the retry of a person fetch after a number of failed attempts, with an exponential backoff
+func getPersonRetry(personTMDBID int64, attempts int) *model.PersonRetry {
+	delay := personRetryMaxDelay
+	// Avoid overflowing the delay after many attempts, the maximum delay being reached long before
+	if shift := attempts - 1; shift < 16 {
+		delay = min(personRetryBaseDelay<<shift, personRetryMaxDelay)
+	}
+	return &model.PersonRetry{
+		TMDBID:      personTMDBID,
+		Attempts:    attempts,
+		NextAttempt: time.Now().Add(delay),
+	}
+}
+
+// retryPerson fetches the details of a person that could not be fetched before, and updates the person
+func (r *Refresher) retryPerson(ctx context.Context, retry *model.PersonRetry) {
+	person, err := r.RefreshStorer.GetPersonFromTMDBID(ctx, retry.TMDBID)
+	if err != nil {
+		// The person was removed since, as none of their films are in the library anymore
+		if err := r.RefreshStorer.DeletePersonRetry(ctx, retry.TMDBID); err != nil {
+			log.Error().Err(err).Int64("tmdbID", retry.TMDBID).Msg("Unable to remove person retry")
+		}
+		return
+	}
+	fetched := r.RefreshMetadataGetter.GetPersonDetails(ctx, retry.TMDBID)
+	if fetched.LastRefreshed.IsZero() {
+		log.Debug().Int64("tmdbID", retry.TMDBID).Int("attempts", retry.Attempts).Time("nextAttempt", retry.NextAttempt).Msg("Unable to fetch person details, will retry")
+		return
+	}
+	fetched.ID = person.ID
+	if err := r.RefreshStorer.UpdatePerson(ctx, fetched); err != nil {
+		log.Error().Err(err).Int64("tmdbID", retry.TMDBID).Msg("Unable to update person")
+		return
+	}
+	r.RefreshSearchIndexer.IndexPerson(fetched)
+	if fetched.Photo != "" {
+		if _, err := r.RefreshCacher.CachePhoto(r.RefreshMetadataGetter.GetPhotoLink(fetched.Photo), fetched.Photo); err != nil {
+			log.Debug().Err(err).Int64("tmdbID", retry.TMDBID).Msg("Could not cache photo")
+		}
+	}
+	if err := r.RefreshStorer.DeletePersonRetry(ctx, retry.TMDBID); err != nil {
+		log.Error().Err(err).Int64("tmdbID", retry.TMDBID).Msg("Unable to remove person retry")
+	}
+	log.Debug().Int64("tmdbID", retry.TMDBID).Int("attempts", retry.Attempts).Msg("Person details fetched after retrying")
+}
+
+// refreshFilm fetches the film details and ratings again, and updates the film.
+// The film is read when the refresh runs, so that the files added while it was queued are kept
+func (r *Refresher) refreshFilm(ctx context.Context, filmID primitive.ObjectID) {
+	film, ok := r.getFilmToRefresh(ctx, filmID)
+	if !ok {
+		return
+	}
+	// The film is not saved half refreshed
+	if err := r.RefreshMetadataGetter.UpdateFilmDetails(ctx, film); err != nil {
+		log.Error().Err(err).Str("filmID", film.ID.Hex()).Msg("Unable to refresh film details")
+		return
+	}
+	if err := r.RefreshFilmManager.AddFilm(ctx, film, true); err != nil {
+		log.Error().Err(err).Str("filmID", film.ID.Hex()).Msg("Unable to refresh film")
+		return
+	}
+	log.Debug().Int("tmdbID", film.TMDBID).Msg("Film details refreshed")
+}
+
+// refreshRatings scrapes the film ratings again, and only updates the ratings of the film
+func (r *Refresher) refreshRatings(ctx context.Context, filmID primitive.ObjectID) {
+	film, ok := r.getFilmToRefresh(ctx, filmID)
+	if !ok {
+		return
+	}
+	r.RefreshMetadataGetter.UpdateFilmRatings(ctx, film)
+	if err := r.RefreshStorer.UpdateFilmRatings(ctx, film); err != nil {
+		log.Error().Err(err).Str("filmID", film.ID.Hex()).Msg("Unable to refresh film ratings")
+		return
+	}
+	log.Debug().Int("tmdbID", film.TMDBID).Msg("Film ratings refreshed")
+}
+
+// getFilmToRefresh returns the film to refresh, or false if it was removed since the refresh was queued
+func (r *Refresher) getFilmToRefresh(ctx context.Context, filmID primitive.ObjectID) (*model.Film, bool) {
+	film, err := r.RefreshStorer.GetFilmFromID(ctx, filmID)
+	if errors.Is(err, model.ErrNotFound) {
+		log.Debug().Str("filmID", filmID.Hex()).Msg("Film removed before being refreshed")
+		return nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Str("filmID", filmID.Hex()).Msg("Unable to get film to refresh")
+		return nil, false
+	}
+	return film, true
+}
+
+// refreshPerson fetches the person details again, and updates the person
+func (r *Refresher) refreshPerson(ctx context.Context, person *model.Person) {
+	refreshed := r.RefreshMetadataGetter.GetPersonDetails(ctx, person.TMDBID)
+	// Keep the previous details if they could not be fetched, and try again at the next interval.
+	// The people whose details were never fetched are retried sooner
+	if refreshed.LastRefreshed.IsZero() {
+		person.LastRefreshed = time.Now()
+		refreshed = person
+		if person.Name == "" {
+			if err := r.RefreshStorer.AddPersonRetry(ctx, getPersonRetry(person.TMDBID, 1)); err != nil {
+				log.Error().Err(err).Int64("tmdbID", person.TMDBID).Msg("Unable to plan person fetch retry")
+			}
+		}
+	}
+	refreshed.ID = person.ID
+	if err := r.RefreshStorer.UpdatePerson(ctx, refreshed); err != nil {
+		log.Error().Err(err).Int64("tmdbID", person.TMDBID).Msg("Unable to refresh person")
+		return
+	}
+	r.RefreshSearchIndexer.IndexPerson(refreshed)
+	log.Debug().Int64("tmdbID", person.TMDBID).Msg("Person details refreshed")
+}
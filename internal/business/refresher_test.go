@@ -0,0 +1,306 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// fakeRefreshMetadata sets fixed details and ratings, and fails to fetch the people it does not know
+type fakeRefreshMetadata struct {
+	people map[int64]string
+}
+
+func (fakeRefreshMetadata) UpdateFilmDetails(ctx context.Context, film *model.Film) error {
+	film.Title += " (refreshed)"
+	film.LastRefreshed = time.Now()
+	return nil
+}
+
+func (fakeRefreshMetadata) UpdateFilmRatings(ctx context.Context, film *model.Film) {
+	film.Ratings = map[string]model.Rating{model.RatingSourceIMDb: {Value: 8.3, Best: 10}}
+	film.RatingsRefreshed = time.Now()
+}
+
+func (m fakeRefreshMetadata) GetPersonDetails(ctx context.Context, personID int64) *model.Person {
+	name, ok := m.people[personID]
+	if !ok {
+		return &model.Person{TMDBID: personID}
+	}
+	return &model.Person{TMDBID: personID, Name: name, LastRefreshed: time.Now()}
+}
+
+func (fakeRefreshMetadata) GetPhotoLink(key string) string { return "" }
+
+// fakeRefreshFilmManager stores the refreshed films
+type fakeRefreshFilmManager struct {
+	*infrastructure.Memory
+}
+
+func (fm fakeRefreshFilmManager) AddFilm(ctx context.Context, film *model.Film, update bool) error {
+	return fm.Memory.AddFilm(ctx, film)
+}
+
+func (fm fakeRefreshFilmManager) CacheFilm(ctx context.Context, film *model.Film) error {
+	return nil
+}
+
+// fakeRefreshIndex indexes nothing
+type fakeRefreshIndex struct{}
+
+func (fakeRefreshIndex) IndexPerson(person *model.Person) {}
+func (fakeRefreshIndex) RemovePerson(personTMDBID int64)  {}
+
+// fakeRefreshCache caches nothing, and records the removed photos
+type fakeRefreshCache struct {
+	removed *[]string
+}
+
+func (fakeRefreshCache) CachePhoto(link, key string) (bool, error) { return false, nil }
+func (c fakeRefreshCache) RemovePhoto(key string) error {
+	*c.removed = append(*c.removed, key)
+	return nil
+}
+
+// newTestRefresher returns a refresher of the films and people stored in memory
+func newTestRefresher(db *infrastructure.Memory, settings RefreshSettings) *Refresher {
+	metadata := fakeRefreshMetadata{people: map[int64]string{1: "Michael Mann"}}
+	return NewRefresher(db, metadata, fakeRefreshFilmManager{db}, fakeRefreshIndex{}, fakeRefreshCache{new([]string)}, settings)
+}
+
+// TestRefreshJobsReadFilm checks that the queued refreshes read the film when they run, to keep the files added meanwhile
+func TestRefreshJobsReadFilm(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	r := newTestRefresher(db, RefreshSettings{})
+	film := model.Film{ID: primitive.NewObjectID(), TMDBID: 949, Title: "Heat",
+		VolumeFiles: []model.VolumeFile{{Path: "/films/heat.mkv"}}}
+	require.NoError(t, db.AddFilm(ctx, &film))
+
+	assert.Equal(t, 1, r.queueStaleRatings(ctx, time.Now()))
+	assert.Equal(t, 1, r.queueStaleFilms(ctx, time.Now()))
+	require.Len(t, r.jobs, 2)
+	film.VolumeFiles = append(film.VolumeFiles, model.VolumeFile{Path: "/other/heat.mkv"})
+	require.NoError(t, db.AddFilm(ctx, &film))
+
+	r.run(ctx, <-r.jobs)
+	r.run(ctx, <-r.jobs)
+	refreshed, err := db.GetFilmFromID(ctx, film.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Heat (refreshed)", refreshed.Title)
+	assert.Equal(t, 8.3, refreshed.Rating(model.RatingSourceIMDb).Value)
+	assert.Len(t, refreshed.VolumeFiles, 2)
+
+	// A film removed while its refresh was queued is not added again
+	assert.Equal(t, 1, r.queueStaleRatings(ctx, time.Now().Add(time.Hour)))
+	require.NoError(t, db.DeleteFilm(ctx, film.ID))
+	r.run(ctx, <-r.jobs)
+	assert.Zero(t, db.GetFilmCount(ctx))
+}
+
+// TestRefreshQueue checks that the jobs are run in order, and that queuing stops blocking once the refresher stops
+func TestRefreshQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newTestRefresher(infrastructure.NewMemory(), RefreshSettings{RateLimit: time.Millisecond, BatchSize: 1})
+	runDone := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(runDone)
+	}()
+
+	var mu sync.Mutex
+	var ran []int
+	for i := 0; i < 3; i++ {
+		i := i
+		require.True(t, r.queue(func(ctx context.Context) {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, i)
+		}))
+	}
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ran) == 3
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, []int{0, 1, 2}, ran)
+
+	// The job being run blocks the queue until the refresher stops, and the single slot of the queue is then taken
+	require.True(t, r.queue(func(ctx context.Context) { <-ctx.Done() }))
+	require.Eventually(t, func() bool { return len(r.jobs) == 0 }, 5*time.Second, time.Millisecond)
+	require.True(t, r.queue(func(ctx context.Context) {}))
+	queued := make(chan bool)
+	go func() { queued <- r.queue(func(ctx context.Context) {}) }()
+	select {
+	case <-queued:
+		t.Fatal("job queued while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	<-runDone
+	select {
+	case <-queued:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queue still blocked after the refresher stopped")
+	}
+	for len(r.jobs) < cap(r.jobs) {
+		r.jobs <- func(ctx context.Context) {}
+	}
+	assert.False(t, r.queue(func(ctx context.Context) {}))
+}
+
+// TestRefreshSchedule checks that the stale metadata is looked for at each interval, until the context is done
+func TestRefreshSchedule(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newTestRefresher(infrastructure.NewMemory(), RefreshSettings{})
+	interval := 10 * time.Millisecond
+
+	var mu sync.Mutex
+	var checks []time.Time
+	var befores []time.Time
+	done := make(chan struct{})
+	go func() {
+		r.schedule(ctx, "test", interval, func(ctx context.Context, before time.Time) int {
+			mu.Lock()
+			defer mu.Unlock()
+			checks = append(checks, time.Now())
+			befores = append(befores, before)
+			return 0
+		})
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(checks) >= 3
+	}, 5*time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range checks {
+		// The stale metadata is the one refreshed more than an interval ago
+		assert.WithinDuration(t, checks[i].Add(-interval), befores[i], interval)
+		if i > 0 {
+			assert.GreaterOrEqual(t, checks[i].Sub(checks[i-1]), interval/2)
+		}
+	}
+	count := len(checks)
+	time.Sleep(3 * interval)
+	assert.Equal(t, count, len(checks))
+}
+
+func TestGetPersonRetry(t *testing.T) {
+	tests := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{11, 1024 * time.Minute},
+		// The delay is capped from the 12th failure, and does not overflow afterwards
+		{12, 24 * time.Hour},
+		{17, 24 * time.Hour},
+		{100, 24 * time.Hour},
+	}
+	for _, test := range tests {
+		retry := getPersonRetry(42, test.attempts)
+		assert.Equal(t, int64(42), retry.TMDBID)
+		assert.Equal(t, test.attempts, retry.Attempts)
+		assert.WithinDuration(t, time.Now().Add(test.delay), retry.NextAttempt, time.Second, "attempts: %d", test.attempts)
+	}
+}
+
+// TestQueueDuePersonRetries checks that the due retries are planned again before being fetched, and removed once fetched
+func TestQueueDuePersonRetries(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	r := newTestRefresher(db, RefreshSettings{})
+	now := time.Now()
+	// Michael Mann can be fetched now, whereas person 2 still cannot
+	for _, retry := range []model.PersonRetry{
+		{TMDBID: 1, Attempts: 2, NextAttempt: now.Add(-time.Minute)},
+		{TMDBID: 2, Attempts: 12, NextAttempt: now.Add(-time.Second)},
+		{TMDBID: 3, Attempts: 1, NextAttempt: now.Add(time.Minute)},
+	} {
+		retry := retry
+		db.AddPerson(ctx, &model.Person{ID: primitive.NewObjectID(), TMDBID: retry.TMDBID})
+		require.NoError(t, db.AddPersonRetry(ctx, &retry))
+	}
+
+	assert.Equal(t, 2, r.queueDuePersonRetries(ctx, now))
+	require.Len(t, r.jobs, 2)
+	retries, err := db.GetPersonRetries(ctx, now.Add(48*time.Hour), 10)
+	require.NoError(t, err)
+	require.Len(t, retries, 3)
+	// The retries are sorted by next attempt
+	assert.Equal(t, model.PersonRetry{TMDBID: 3, Attempts: 1, NextAttempt: retries[0].NextAttempt}, retries[0])
+	assert.Equal(t, int64(1), retries[1].TMDBID)
+	assert.Equal(t, 3, retries[1].Attempts)
+	assert.WithinDuration(t, now.Add(4*time.Minute), retries[1].NextAttempt, time.Second)
+	assert.Equal(t, int64(2), retries[2].TMDBID)
+	assert.Equal(t, 13, retries[2].Attempts)
+	assert.WithinDuration(t, now.Add(24*time.Hour), retries[2].NextAttempt, time.Second)
+	// The planned retries are not due anymore
+	assert.Zero(t, r.queueDuePersonRetries(ctx, now))
+
+	r.run(ctx, <-r.jobs)
+	r.run(ctx, <-r.jobs)
+	person, err := db.GetPersonFromTMDBID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "Michael Mann", person.Name)
+	retries, err = db.GetPersonRetries(ctx, now.Add(48*time.Hour), 10)
+	require.NoError(t, err)
+	require.Len(t, retries, 2)
+	assert.Equal(t, int64(3), retries[0].TMDBID)
+	assert.Equal(t, int64(2), retries[1].TMDBID)
+}
+
+// TestCleanOrphanPeople checks that only the people in no film are removed, along with their retries and photos
+func TestCleanOrphanPeople(t *testing.T) {
+	ctx := context.Background()
+	db := infrastructure.NewMemory()
+	removedPhotos := new([]string)
+	r := NewRefresher(db, fakeRefreshMetadata{}, fakeRefreshFilmManager{db}, fakeRefreshIndex{}, fakeRefreshCache{removedPhotos}, RefreshSettings{})
+	film := model.Film{ID: primitive.NewObjectID(), TMDBID: 949, Title: "Heat", Directors: []int64{1}, Writers: []int64{1},
+		Characters: []model.Character{{ActorID: 2}}, Crew: []model.CrewCredit{{PersonID: 3, Job: "Editor"}}}
+	require.NoError(t, db.AddFilm(ctx, &film))
+	for tmdbID := int64(1); tmdbID <= 5; tmdbID++ {
+		db.AddPerson(ctx, &model.Person{ID: primitive.NewObjectID(), TMDBID: tmdbID, Photo: fmt.Sprintf("/%d.jpg", tmdbID)})
+	}
+	require.NoError(t, db.AddPersonRetry(ctx, &model.PersonRetry{TMDBID: 4, Attempts: 1, NextAttempt: time.Now()}))
+
+	removed, err := r.CleanOrphanPeople(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	for tmdbID := int64(1); tmdbID <= 5; tmdbID++ {
+		assert.Equal(t, tmdbID <= 3, db.IsPersonPresent(ctx, tmdbID), "person %d", tmdbID)
+	}
+	assert.ElementsMatch(t, []string{"/4.jpg", "/5.jpg"}, *removedPhotos)
+	retries, err := db.GetPersonRetries(ctx, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, retries)
+
+	// The people of a removed film are orphans at the next cleanup
+	require.NoError(t, db.DeleteFilm(ctx, film.ID))
+	removed, err = r.CleanOrphanPeople(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	people, err := db.GetPeople(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, people)
+}
@@ -0,0 +1,384 @@
+package business
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/agnivade/levenshtein"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// Weights of the indexed fields in the relevance of a result
+const (
+	searchWeightTitle    = 10.0 // Film titles and person names
+	searchWeightCredit   = 4.0  // Names of the cast and crew of a film
+	searchWeightTagline  = 2.0
+	searchWeightOverview = 1.0
+)
+
+// Quality of a match between a searched word and an indexed word
+const (
+	searchQualityExact  = 1.0
+	searchQualityPrefix = 0.75
+	searchQualityTypo   = 0.5
+)
+
+type searchDocType int
+
+const (
+	searchDocFilm searchDocType = iota
+	searchDocPerson
+)
+
+// searchDoc identifies an indexed film or person
+type searchDoc struct {
+	docType searchDocType
+	id      string // Hexadecimal ID of a film, or TMDB ID of a person
+}
+
+// SearchHit is a film or a person matching a search
+type SearchHit struct {
+	FilmID       primitive.ObjectID
+	PersonTMDBID int64
+	Score        float64
+}
+
+// IsFilm returns true if the hit is a film, false if it is a person
+func (sh SearchHit) IsFilm() bool {
+	return !sh.FilmID.IsZero()
+}
+
+// SearchIndex is an in-memory inverted index of the films and people, used for full-text search
+type SearchIndex struct {
+	mu sync.RWMutex
+
+	postings      map[string]map[searchDoc]float64 // Weight of each document for each word
+	words         []string                         // Sorted indexed words, for prefix matching
+	wordsByLength map[int]map[string]bool          // Indexed words by number of runes, for typo matching
+	docWords      map[searchDoc][]string           // Words of each document, to remove them when it is indexed again
+
+	personFilms map[int64][]string // Hexadecimal IDs of the films of each person, by TMDB ID
+	filmPeople  map[string][]int64 // TMDB IDs of the cast and crew of each film, by hexadecimal ID
+}
+
+// NewSearchIndex instantiates an empty SearchIndex
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		postings:      make(map[string]map[searchDoc]float64),
+		wordsByLength: make(map[int]map[string]bool),
+		docWords:      make(map[searchDoc][]string),
+		personFilms:   make(map[int64][]string),
+		filmPeople:    make(map[string][]int64),
+	}
+}
+
+// IndexFilm adds a film to the index, or updates it if it was already indexed
+func (si *SearchIndex) IndexFilm(film *model.Film) {
+	si.IndexFilms([]model.Film{*film})
+}
+
+// IndexFilms adds films to the index, or updates them if they were already indexed.
+// The words they add are sorted once for all the films
+func (si *SearchIndex) IndexFilms(films []model.Film) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	var newWords []string
+	for i := range films {
+		newWords = append(newWords, si.indexFilm(&films[i])...)
+	}
+	si.addWords(newWords)
+}
+
+// indexFilm sets the words and the cast and crew of a film, and returns the words that were not indexed yet
+func (si *SearchIndex) indexFilm(film *model.Film) (newWords []string) {
+	doc := searchDoc{docType: searchDocFilm, id: film.ID.Hex()}
+	weights := make(map[string]float64)
+	addSearchWeights(weights, searchWeightTitle, film.Title, film.OriginalTitle, film.Name)
+	addSearchWeights(weights, searchWeightTitle, film.AlternativeTitles...)
+	addSearchWeights(weights, searchWeightTitle, film.TranslatedTitles...)
+	addSearchWeights(weights, searchWeightTagline, film.Tagline)
+	addSearchWeights(weights, searchWeightOverview, film.Overview)
+	newWords = si.setDocWeights(doc, weights)
+
+	// The names of the cast and crew are indexed with the people, and matched with the film at search time
+	for _, personID := range si.filmPeople[doc.id] {
+		si.personFilms[personID] = slices.DeleteFunc(si.personFilms[personID], func(id string) bool { return id == doc.id })
+	}
+	si.filmPeople[doc.id] = nil
+	for _, personID := range film.GetCastAndCrewIDs() {
+		if !slices.Contains(si.filmPeople[doc.id], personID) {
+			si.filmPeople[doc.id] = append(si.filmPeople[doc.id], personID)
+			si.personFilms[personID] = append(si.personFilms[personID], doc.id)
+		}
+	}
+	return newWords
+}
+
+// IndexPerson adds a person to the index, or updates them if they were already indexed
+func (si *SearchIndex) IndexPerson(person *model.Person) {
+	si.IndexPeople([]model.Person{*person})
+}
+
+// IndexPeople adds people to the index, or updates them if they were already indexed.
+// The words they add are sorted once for all the people
+func (si *SearchIndex) IndexPeople(people []model.Person) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	var newWords []string
+	for _, person := range people {
+		doc := searchDoc{docType: searchDocPerson, id: strconv.FormatInt(person.TMDBID, 10)}
+		weights := make(map[string]float64)
+		addSearchWeights(weights, searchWeightTitle, person.Name)
+		newWords = append(newWords, si.setDocWeights(doc, weights)...)
+	}
+	si.addWords(newWords)
+}
+
+// RemoveFilm removes a film from the index
+func (si *SearchIndex) RemoveFilm(filmID primitive.ObjectID) {
+	doc := searchDoc{docType: searchDocFilm, id: filmID.Hex()}
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.setDocWeights(doc, nil)
+	for _, personID := range si.filmPeople[doc.id] {
+		si.personFilms[personID] = slices.DeleteFunc(si.personFilms[personID], func(id string) bool { return id == doc.id })
+	}
+	delete(si.filmPeople, doc.id)
+}
+
+// RemovePerson removes a person from the index
+func (si *SearchIndex) RemovePerson(personTMDBID int64) {
+	doc := searchDoc{docType: searchDocPerson, id: strconv.FormatInt(personTMDBID, 10)}
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.setDocWeights(doc, nil)
+}
+
+// Search returns the films and people matching every word of the query, sorted by relevance.
+// Words match indexed words exactly, as a prefix, or with a few typos
+func (si *SearchIndex) Search(query string) []SearchHit {
+	tokens := model.SearchTokens(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+
+	var scores map[searchDoc]float64
+	for _, token := range tokens {
+		tokenScores := si.searchToken(token)
+		if scores == nil {
+			scores = tokenScores
+			continue
+		}
+		// Every word of the query must match
+		for doc, score := range scores {
+			if tokenScore, ok := tokenScores[doc]; ok {
+				scores[doc] = score + tokenScore
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+
+	hits := make([]SearchHit, 0, len(scores))
+	for doc, score := range scores {
+		hit := SearchHit{Score: score}
+		if doc.docType == searchDocFilm {
+			hit.FilmID, _ = primitive.ObjectIDFromHex(doc.id)
+		} else {
+			hit.PersonTMDBID, _ = strconv.ParseInt(doc.id, 10, 64)
+		}
+		hits = append(hits, hit)
+	}
+	slices.SortFunc(hits, func(a, b SearchHit) int {
+		if a.Score != b.Score {
+			return cmp.Compare(b.Score, a.Score)
+		}
+		// Keep a stable order between identical scores
+		if c := cmp.Compare(a.FilmID.Hex(), b.FilmID.Hex()); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.PersonTMDBID, b.PersonTMDBID)
+	})
+	return hits
+}
+
+// SearchFilms returns the IDs of the films matching the query, sorted by relevance
+func (si *SearchIndex) SearchFilms(query string) (filmIDs []primitive.ObjectID) {
+	for _, hit := range si.Search(query) {
+		if hit.IsFilm() {
+			filmIDs = append(filmIDs, hit.FilmID)
+		}
+	}
+	return filmIDs
+}
+
+// GetPersonFilms returns the IDs of the films a person worked on as cast or crew
+func (si *SearchIndex) GetPersonFilms(personTMDBID int64) []primitive.ObjectID {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	filmIDs := make([]primitive.ObjectID, 0, len(si.personFilms[personTMDBID]))
+	for _, hexID := range si.personFilms[personTMDBID] {
+		filmID, _ := primitive.ObjectIDFromHex(hexID)
+		filmIDs = append(filmIDs, filmID)
+	}
+	return filmIDs
+}
+
+// GetFilmPeople returns the TMDB IDs of the cast and crew of a film, each person once
+func (si *SearchIndex) GetFilmPeople(filmID primitive.ObjectID) []int64 {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return slices.Clone(si.filmPeople[filmID.Hex()])
+}
+
+// searchToken returns the score of every document matching a single word.
+// Films also match through the names of their cast and crew
+func (si *SearchIndex) searchToken(token string) map[searchDoc]float64 {
+	scores := make(map[searchDoc]float64)
+	addScore := func(doc searchDoc, score float64) {
+		scores[doc] = max(scores[doc], score)
+	}
+	for word, quality := range si.matchingWords(token) {
+		for doc, weight := range si.postings[word] {
+			addScore(doc, quality*weight)
+			if doc.docType != searchDocPerson {
+				continue
+			}
+			personID, _ := strconv.ParseInt(doc.id, 10, 64)
+			for _, filmID := range si.personFilms[personID] {
+				addScore(searchDoc{docType: searchDocFilm, id: filmID}, quality*searchWeightCredit)
+			}
+		}
+	}
+	return scores
+}
+
+// matchingWords returns the indexed words matching a searched word, with the quality of the match
+func (si *SearchIndex) matchingWords(token string) map[string]float64 {
+	matches := make(map[string]float64)
+	if _, ok := si.postings[token]; ok {
+		matches[token] = searchQualityExact
+	}
+
+	// Words starting with the token are contiguous in the sorted words
+	if len([]rune(token)) >= 2 {
+		start, _ := slices.BinarySearch(si.words, token)
+		for _, word := range si.words[start:] {
+			if !strings.HasPrefix(word, token) {
+				break
+			}
+			if word != token {
+				matches[word] = searchQualityPrefix
+			}
+		}
+	}
+
+	maxTypos := maxSearchTypos(token)
+	if maxTypos == 0 {
+		return matches
+	}
+	// Only the words whose length differs by at most the number of typos can be close enough
+	tokenLength := len([]rune(token))
+	for length := tokenLength - maxTypos; length <= tokenLength+maxTypos; length++ {
+		for word := range si.wordsByLength[length] {
+			if _, ok := matches[word]; ok {
+				continue
+			}
+			if distance := levenshtein.ComputeDistance(token, word); distance <= maxTypos {
+				matches[word] = searchQualityTypo / float64(distance)
+			}
+		}
+	}
+	return matches
+}
+
+// setDocWeights replaces the indexed words of a document.
+// It returns the words that were not indexed yet, which must then be added to the sorted words with addWords
+func (si *SearchIndex) setDocWeights(doc searchDoc, weights map[string]float64) (newWords []string) {
+	for _, word := range si.docWords[doc] {
+		delete(si.postings[word], doc)
+		if len(si.postings[word]) == 0 {
+			delete(si.postings, word)
+			if i, found := slices.BinarySearch(si.words, word); found {
+				si.words = slices.Delete(si.words, i, i+1)
+			}
+			length := len([]rune(word))
+			delete(si.wordsByLength[length], word)
+			if len(si.wordsByLength[length]) == 0 {
+				delete(si.wordsByLength, length)
+			}
+		}
+	}
+	delete(si.docWords, doc)
+
+	for word, weight := range weights {
+		if _, ok := si.postings[word]; !ok {
+			si.postings[word] = make(map[searchDoc]float64)
+			length := len([]rune(word))
+			if si.wordsByLength[length] == nil {
+				si.wordsByLength[length] = make(map[string]bool)
+			}
+			si.wordsByLength[length][word] = true
+			newWords = append(newWords, word)
+		}
+		si.postings[word][doc] = weight
+		si.docWords[doc] = append(si.docWords[doc], word)
+	}
+	return newWords
+}
+
+// addWords merges new words into the sorted words in a single pass.
+// The words removed since they were added, or added twice, are skipped
+func (si *SearchIndex) addWords(words []string) {
+	words = slices.DeleteFunc(words, func(word string) bool {
+		_, ok := si.postings[word]
+		return !ok
+	})
+	if len(words) == 0 {
+		return
+	}
+	slices.Sort(words)
+	words = slices.Compact(words)
+
+	merged := make([]string, 0, len(si.words)+len(words))
+	i := 0
+	for _, word := range si.words {
+		for i < len(words) && words[i] < word {
+			merged = append(merged, words[i])
+			i++
+		}
+		merged = append(merged, word)
+	}
+	si.words = append(merged, words[i:]...)
+}
+
+// addSearchWeights sets the weight of every word of the texts, keeping the highest weight of a word found in several fields
+func addSearchWeights(weights map[string]float64, weight float64, texts ...string) {
+	for _, text := range texts {
+		for _, word := range model.SearchTokens(text) {
+			weights[word] = max(weights[word], weight)
+		}
+	}
+}
+
+// maxSearchTypos returns the number of typos tolerated in a searched word, depending on its length
+func maxSearchTypos(token string) int {
+	switch length := len([]rune(token)); {
+	case length < 4:
+		return 0
+	case length < 8:
+		return 1
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,167 @@
+package business
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// newTestSearchIndex returns an index of a few films and their directors
+func newTestSearchIndex() (si *SearchIndex, alien, aliens, heat model.Film) {
+	alien = model.Film{ID: primitive.NewObjectID(), Title: "Alien", Tagline: "In space no one can hear you scream", Directors: []int64{1}}
+	aliens = model.Film{ID: primitive.NewObjectID(), Title: "Aliens", Overview: "Ripley goes back to space", Directors: []int64{2}}
+	heat = model.Film{ID: primitive.NewObjectID(), Title: "Heat", Overview: "A thief and a detective in Los Angeles", Directors: []int64{3}}
+	si = NewSearchIndex()
+	si.IndexFilms([]model.Film{alien, aliens, heat})
+	si.IndexPeople([]model.Person{{TMDBID: 1, Name: "Ridley Scott"}, {TMDBID: 2, Name: "James Cameron"}, {TMDBID: 3, Name: "Michael Mann"}})
+	return si, alien, aliens, heat
+}
+
+func TestSearchIndexRanking(t *testing.T) {
+	si, alien, aliens, heat := newTestSearchIndex()
+
+	tests := []struct {
+		query string
+		films []primitive.ObjectID
+	}{
+		// A title matching exactly ranks before a title matching as a prefix
+		{"alien", []primitive.ObjectID{alien.ID, aliens.ID}},
+		{"aliens", []primitive.ObjectID{aliens.ID, alien.ID}},
+		// Titles rank before taglines, which rank before overviews
+		{"space", []primitive.ObjectID{alien.ID, aliens.ID}},
+		// Films are found by the names of their cast and crew
+		{"cameron", []primitive.ObjectID{aliens.ID}},
+		{"michael mann", []primitive.ObjectID{heat.ID}},
+		// Every word must match
+		{"alien cameron", []primitive.ObjectID{aliens.ID}},
+		{"alien mann", nil},
+		{"", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			assert.Equal(t, test.films, si.SearchFilms(test.query))
+		})
+	}
+
+	hits := si.Search("scott")
+	if assert.Len(t, hits, 2) {
+		assert.Equal(t, int64(1), hits[0].PersonTMDBID)
+		assert.Equal(t, alien.ID, hits[1].FilmID)
+		assert.Greater(t, hits[0].Score, hits[1].Score)
+	}
+}
+
+func TestSearchIndexMatching(t *testing.T) {
+	si, alien, aliens, heat := newTestSearchIndex()
+
+	tests := []struct {
+		query string
+		films []primitive.ObjectID
+	}{
+		// Prefixes of at least two letters
+		{"al", []primitive.ObjectID{alien.ID, aliens.ID}},
+		{"x", nil},
+		{"detect", []primitive.ObjectID{heat.ID}},
+		// One typo in words of 4 to 7 letters, two in longer words
+		{"alian", []primitive.ObjectID{alien.ID}},
+		{"heet", []primitive.ObjectID{heat.ID}},
+		{"hiit", nil},
+		{"detectuve", []primitive.ObjectID{heat.ID}},
+		{"dutectuve", []primitive.ObjectID{heat.ID}},
+		{"dutuctuve", nil},
+		// No typo in short words
+		{"het", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			assert.Equal(t, test.films, si.SearchFilms(test.query))
+		})
+	}
+
+	// An exact match ranks before a prefix, which ranks before a typo
+	exact, prefix, typo := si.matchingWords("alien")["alien"], si.matchingWords("alie")["alien"], si.matchingWords("alian")["alien"]
+	assert.Greater(t, exact, prefix)
+	assert.Greater(t, prefix, typo)
+}
+
+// TestSearchIndexWords checks that the sorted words and the words by length follow the indexed documents
+func TestSearchIndexWords(t *testing.T) {
+	si, alien, aliens, heat := newTestSearchIndex()
+	incremental := NewSearchIndex()
+	for _, film := range []model.Film{heat, aliens, alien} {
+		film := film
+		incremental.IndexFilm(&film)
+	}
+	for _, person := range []model.Person{{TMDBID: 3, Name: "Michael Mann"}, {TMDBID: 1, Name: "Ridley Scott"}, {TMDBID: 2, Name: "James Cameron"}} {
+		person := person
+		incremental.IndexPerson(&person)
+	}
+	assert.Equal(t, si.words, incremental.words)
+	assert.Equal(t, si.wordsByLength, incremental.wordsByLength)
+
+	checkWords := func(si *SearchIndex) {
+		t.Helper()
+		assert.True(t, slices.IsSorted(si.words))
+		var postingWords []string
+		for word := range si.postings {
+			postingWords = append(postingWords, word)
+		}
+		assert.ElementsMatch(t, postingWords, si.words)
+		var byLength []string
+		for length, words := range si.wordsByLength {
+			for word := range words {
+				assert.Len(t, []rune(word), length)
+				byLength = append(byLength, word)
+			}
+		}
+		assert.ElementsMatch(t, si.words, byLength)
+	}
+	checkWords(si)
+
+	// A film indexed twice in a batch only keeps its last words
+	heat.Title = "Heat Wave"
+	retitled := heat
+	retitled.Title = "Collateral"
+	si.IndexFilms([]model.Film{heat, retitled})
+	si.RemovePerson(2)
+	si.RemoveFilm(aliens.ID)
+	checkWords(si)
+	assert.NotContains(t, si.words, "wave")
+	assert.NotContains(t, si.words, "cameron")
+	assert.Contains(t, si.words, "collateral")
+	assert.Equal(t, []primitive.ObjectID{heat.ID}, si.SearchFilms("collateral"))
+	assert.Empty(t, si.SearchFilms("goes back"))
+	assert.Empty(t, si.SearchFilms("cameron"))
+}
+
+// TestSearchIndexTitles checks that the films are found by any of their titles, ignoring accents and case
+func TestSearchIndexTitles(t *testing.T) {
+	amelie := model.Film{ID: primitive.NewObjectID(), Title: "Amélie", OriginalTitle: "Le Fabuleux Destin d'Amélie Poulain"}
+	hate := model.Film{ID: primitive.NewObjectID(), Title: "Hate", AlternativeTitles: []string{"La Haine"}}
+	yourName := model.Film{ID: primitive.NewObjectID(), Title: "Your Name.", OriginalTitle: "君の名は。", TranslatedTitles: []string{"Kimi no Na wa."}}
+	si := NewSearchIndex()
+	si.IndexFilms([]model.Film{amelie, hate, yourName})
+
+	tests := []struct {
+		query string
+		films []primitive.ObjectID
+	}{
+		{"amelie", []primitive.ObjectID{amelie.ID}},
+		{"AMÉLIE", []primitive.ObjectID{amelie.ID}},
+		{"AmElIe", []primitive.ObjectID{amelie.ID}},
+		{"destin d'amelie", []primitive.ObjectID{amelie.ID}},
+		{"la haine", []primitive.ObjectID{hate.ID}},
+		{"LA HAÏNE", []primitive.ObjectID{hate.ID}},
+		{"kimi no na wa", []primitive.ObjectID{yourName.ID}},
+		{"君の名は", []primitive.ObjectID{yourName.ID}},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			assert.Equal(t, test.films, si.SearchFilms(test.query))
+		})
+	}
+}
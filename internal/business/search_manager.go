@@ -0,0 +1,86 @@
+package business
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// searchResultsLimit is the maximum number of results of a search
+const searchResultsLimit = 100
+
+type SearchStorer interface {
+	GetFilms(ctx context.Context) ([]model.Film, error)
+	GetPeople(ctx context.Context) ([]model.Person, error)
+
+	GetFilmFromID(ctx context.Context, ID primitive.ObjectID) (*model.Film, error)
+	GetPersonFromTMDBID(ctx context.Context, ID int64) (*model.Person, error)
+}
+
+type SearchIndexer interface {
+	IndexFilms(films []model.Film)
+	IndexPeople(people []model.Person)
+	Search(query string) []SearchHit
+}
+
+type SearchManager struct {
+	SearchStorer
+	SearchIndexer
+}
+
+// NewSearchManager instantiates a new SearchManager
+func NewSearchManager(ss SearchStorer, si SearchIndexer) *SearchManager {
+	return &SearchManager{
+		SearchStorer:  ss,
+		SearchIndexer: si,
+	}
+}
+
+// BuildIndex indexes every film and person of the database
+func (sm SearchManager) BuildIndex(ctx context.Context) error {
+	films, err := sm.SearchStorer.GetFilms(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get films to index: %w", err)
+	}
+	sm.SearchIndexer.IndexFilms(films)
+
+	people, err := sm.SearchStorer.GetPeople(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get people to index: %w", err)
+	}
+	sm.SearchIndexer.IndexPeople(people)
+
+	log.Info().Int("films", len(films)).Int("people", len(people)).Msg("Search index built")
+	return nil
+}
+
+// Search returns the films and people matching the query, sorted by relevance.
+// Only the most relevant results are returned
+func (sm SearchManager) Search(ctx context.Context, query string) (results []model.SearchResult) {
+	for _, hit := range sm.SearchIndexer.Search(query) {
+		if len(results) >= searchResultsLimit {
+			break
+		}
+		// Films and people that are not in the database anymore may still be indexed
+		if hit.IsFilm() {
+			film, err := sm.SearchStorer.GetFilmFromID(ctx, hit.FilmID)
+			if err != nil {
+				log.Debug().Err(err).Str("filmID", hit.FilmID.Hex()).Msg("Indexed film not found")
+				continue
+			}
+			results = append(results, model.SearchResult{Film: film, Score: hit.Score})
+		} else {
+			person, err := sm.SearchStorer.GetPersonFromTMDBID(ctx, hit.PersonTMDBID)
+			if err != nil {
+				log.Debug().Err(err).Int64("tmdbID", hit.PersonTMDBID).Msg("Indexed person not found")
+				continue
+			}
+			results = append(results, model.SearchResult{Person: person, Score: hit.Score})
+		}
+	}
+	return results
+}
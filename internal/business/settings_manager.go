@@ -0,0 +1,239 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pariz/gountries"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/text/language"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+type SettingsStorer interface {
+	GetMetadataSettings(ctx context.Context) (*model.MetadataSettings, error)
+	SetMetadataSettings(ctx context.Context, settings *model.MetadataSettings) error
+	GetServerSettings(ctx context.Context) (*model.ServerSettings, error)
+	SetServerSettings(ctx context.Context, settings *model.ServerSettings) error
+}
+
+type SettingsMetadataSetter interface {
+	SetMetadataSettings(settings model.MetadataSettings)
+	SetTMDBAPIKey(apiKey string) error
+}
+
+type SettingsRefresher interface {
+	RefreshLibrary(ctx context.Context) error
+	SetRateLimit(rateLimit time.Duration)
+}
+
+type SettingsPaginater interface {
+	SetItemsPerPage(itemsPerPage int64)
+}
+
+type SettingsManager struct {
+	SettingsStorer
+	SettingsMetadataSetter
+	SettingsRefresher
+	SettingsPaginater
+
+	defaultMetadataSettings model.MetadataSettings
+	defaultServerSettings   model.ServerSettings
+}
+
+// maxItemsPerPage is the maximum number of films or people on a page
+const maxItemsPerPage = 500
+
+// NewSettingsManager instantiates a new SettingsManager.
+// The default metadata and server settings are used as long as no settings were saved from the admin panel
+func NewSettingsManager(ss SettingsStorer, sms SettingsMetadataSetter, sr SettingsRefresher, sp SettingsPaginater,
+	defaultMetadataSettings model.MetadataSettings, defaultServerSettings model.ServerSettings) *SettingsManager {
+	return &SettingsManager{
+		SettingsStorer:          ss,
+		SettingsMetadataSetter:  sms,
+		SettingsRefresher:       sr,
+		SettingsPaginater:       sp,
+		defaultMetadataSettings: defaultMetadataSettings,
+		defaultServerSettings:   defaultServerSettings,
+	}
+}
+
+// LoadMetadataSettings applies the saved metadata settings
+func (sm SettingsManager) LoadMetadataSettings(ctx context.Context) {
+	sm.SettingsMetadataSetter.SetMetadataSettings(sm.GetMetadataSettings(ctx))
+}
+
+// GetMetadataSettings returns the saved metadata settings, or the default ones if none were saved yet
+func (sm SettingsManager) GetMetadataSettings(ctx context.Context) model.MetadataSettings {
+	settings, err := sm.SettingsStorer.GetMetadataSettings(ctx)
+	if err != nil {
+		log.Debug().Err(err).Msg("No metadata settings saved, using default ones")
+		return sm.defaultMetadataSettings
+	}
+	return *settings
+}
+
+// SetMetadataSettings checks and saves the metadata settings.
+// countries is a comma-separated list of country codes.
+// If the settings changed, the metadata of the whole library is fetched again
+func (sm SettingsManager) SetMetadataSettings(ctx context.Context, metadataLanguage, fallbackLanguage, countries string) error {
+	settings, err := ParseMetadataSettings(metadataLanguage, fallbackLanguage, countries)
+	if err != nil {
+		return err
+	}
+
+	if sm.GetMetadataSettings(ctx).Equal(*settings) {
+		return nil
+	}
+
+	if err := sm.SettingsStorer.SetMetadataSettings(ctx, settings); err != nil {
+		log.Error().Err(err).Send()
+		return errors.New("metadata settings could not be saved")
+	}
+	sm.SettingsMetadataSetter.SetMetadataSettings(*settings)
+
+	// Fetch the metadata again, in the new language
+	if err := sm.SettingsRefresher.RefreshLibrary(ctx); err != nil {
+		return fmt.Errorf("metadata settings were saved but the library could not be refreshed: %w", err)
+	}
+	return nil
+}
+
+// ParseMetadataSettings checks the languages and comma-separated country codes, and returns the corresponding settings
+func ParseMetadataSettings(metadataLanguage, fallbackLanguage, countries string) (*model.MetadataSettings, error) {
+	settings := &model.MetadataSettings{}
+
+	tag, err := language.Parse(strings.TrimSpace(metadataLanguage))
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata language '%s'", metadataLanguage)
+	}
+	settings.Language = tag.String()
+
+	if fallbackLanguage = strings.TrimSpace(fallbackLanguage); fallbackLanguage != "" {
+		tag, err = language.Parse(fallbackLanguage)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fallback language '%s'", fallbackLanguage)
+		}
+		settings.FallbackLanguage = tag.String()
+	}
+
+	query := gountries.New()
+	for _, country := range strings.Split(countries, ",") {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if country == "" {
+			continue
+		}
+		if _, err := query.FindCountryByAlpha(country); err != nil || len(country) != 2 {
+			return nil, fmt.Errorf("invalid certification country '%s'", country)
+		}
+		settings.CertificationCountries = append(settings.CertificationCountries, country)
+	}
+	if len(settings.CertificationCountries) == 0 {
+		return nil, errors.New("at least one certification country is needed")
+	}
+
+	return settings, nil
+}
+
+// LoadServerSettings applies the saved server settings
+func (sm SettingsManager) LoadServerSettings(ctx context.Context) error {
+	settings := sm.GetServerSettings(ctx)
+	if err := ValidateServerSettings(settings); err != nil {
+		return fmt.Errorf("invalid server settings: %w", err)
+	}
+	if settings.TMDBAPIKey == "" {
+		return errors.New("the TMDB API key is neither configured nor saved from the admin panel")
+	}
+	return sm.applyServerSettings(settings)
+}
+
+// GetServerSettings returns the saved server settings, or the default ones if none were saved yet
+func (sm SettingsManager) GetServerSettings(ctx context.Context) model.ServerSettings {
+	settings, err := sm.SettingsStorer.GetServerSettings(ctx)
+	if err != nil {
+		log.Debug().Err(err).Msg("No server settings saved, using default ones")
+		return sm.defaultServerSettings
+	}
+	return *settings
+}
+
+// SetServerSettings checks, saves and applies the server settings.
+// The TMDB API key is kept if tmdbAPIKey is empty
+func (sm SettingsManager) SetServerSettings(ctx context.Context, logLevel, tmdbAPIKey, itemsPerPage, refreshRateLimit string) error {
+	if tmdbAPIKey = strings.TrimSpace(tmdbAPIKey); tmdbAPIKey == "" {
+		tmdbAPIKey = sm.GetServerSettings(ctx).TMDBAPIKey
+	}
+	settings, err := ParseServerSettings(logLevel, tmdbAPIKey, itemsPerPage, refreshRateLimit)
+	if err != nil {
+		return err
+	}
+	if settings.TMDBAPIKey == "" {
+		return errors.New("the TMDB API key is needed")
+	}
+
+	if err := sm.SettingsStorer.SetServerSettings(ctx, settings); err != nil {
+		log.Error().Err(err).Send()
+		return errors.New("server settings could not be saved")
+	}
+	if err := sm.applyServerSettings(*settings); err != nil {
+		return fmt.Errorf("server settings were saved but could not be applied: %w", err)
+	}
+	return nil
+}
+
+// applyServerSettings changes the settings of the running server
+func (sm SettingsManager) applyServerSettings(settings model.ServerSettings) error {
+	level, err := zerolog.ParseLevel(settings.LogLevel)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(level)
+	if err := sm.SettingsMetadataSetter.SetTMDBAPIKey(settings.TMDBAPIKey); err != nil {
+		return err
+	}
+	sm.SettingsPaginater.SetItemsPerPage(settings.ItemsPerPage)
+	sm.SettingsRefresher.SetRateLimit(settings.RefreshRateLimit)
+	return nil
+}
+
+// ParseServerSettings checks the log level, the number of items per page and the refresh rate limit given as a Go
+// duration, and returns the corresponding settings
+func ParseServerSettings(logLevel, tmdbAPIKey, itemsPerPage, refreshRateLimit string) (*model.ServerSettings, error) {
+	settings := &model.ServerSettings{
+		LogLevel:   strings.ToLower(strings.TrimSpace(logLevel)),
+		TMDBAPIKey: strings.TrimSpace(tmdbAPIKey),
+	}
+	var err error
+	if settings.ItemsPerPage, err = strconv.ParseInt(strings.TrimSpace(itemsPerPage), 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid number of items per page '%s'", itemsPerPage)
+	}
+	if settings.RefreshRateLimit, err = time.ParseDuration(strings.TrimSpace(refreshRateLimit)); err != nil {
+		return nil, fmt.Errorf("invalid refresh rate limit '%s'", refreshRateLimit)
+	}
+	if err := ValidateServerSettings(*settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// ValidateServerSettings checks the log level, the number of items per page and the refresh rate limit.
+// The TMDB API key is not checked, as it may be missing from the configuration once it is saved from the admin panel
+func ValidateServerSettings(settings model.ServerSettings) error {
+	var errs []error
+	if _, err := zerolog.ParseLevel(settings.LogLevel); err != nil || settings.LogLevel == "" {
+		errs = append(errs, fmt.Errorf("invalid log level '%s'", settings.LogLevel))
+	}
+	if settings.ItemsPerPage < 1 || settings.ItemsPerPage > maxItemsPerPage {
+		errs = append(errs, fmt.Errorf("the number of items per page must be between 1 and %d", maxItemsPerPage))
+	}
+	if settings.RefreshRateLimit <= 0 {
+		errs = append(errs, errors.New("the refresh rate limit must be positive"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,153 @@
+package business_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Agurato/starfin/internal/business"
+	"github.com/Agurato/starfin/internal/infrastructure"
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// TestParseMetadataSettings normalizes the languages and the countries edited from the admin panel, and rejects the invalid ones
+func TestParseMetadataSettings(t *testing.T) {
+	tests := []struct {
+		name                                  string
+		language, fallbackLanguage, countries string
+		want                                  *model.MetadataSettings
+		err                                   string
+	}{
+		{"languages and countries", "fr-FR", "en-US", "FR,BE", &model.MetadataSettings{Language: "fr-FR", FallbackLanguage: "en-US", CertificationCountries: []string{"FR", "BE"}}, ""},
+		{"normalized", " fr-fr ", " EN ", " fr , be ,", &model.MetadataSettings{Language: "fr-FR", FallbackLanguage: "en", CertificationCountries: []string{"FR", "BE"}}, ""},
+		{"no fallback language", "en-US", "", "US", &model.MetadataSettings{Language: "en-US", CertificationCountries: []string{"US"}}, ""},
+		{"no language", "", "en-US", "US", nil, "invalid metadata language ''"},
+		{"bad language", "english!", "", "US", nil, "invalid metadata language 'english!'"},
+		{"bad fallback language", "en-US", "french!", "US", nil, "invalid fallback language 'french!'"},
+		{"unknown country", "en-US", "", "US,XX", nil, "invalid certification country 'XX'"},
+		{"three-letter country", "en-US", "", "FRA", nil, "invalid certification country 'FRA'"},
+		{"no country", "en-US", "", " , ", nil, "at least one certification country is needed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings, err := business.ParseMetadataSettings(test.language, test.fallbackLanguage, test.countries)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, settings)
+		})
+	}
+}
+
+// TestParseServerSettings parses the server settings edited from the admin panel, and rejects the invalid ones
+func TestParseServerSettings(t *testing.T) {
+	settings, err := business.ParseServerSettings(" Debug ", " key ", " 50 ", " 1m30s ")
+	require.NoError(t, err)
+	assert.Equal(t, &model.ServerSettings{LogLevel: "debug", TMDBAPIKey: "key", ItemsPerPage: 50, RefreshRateLimit: 90 * time.Second}, settings)
+
+	tests := []struct {
+		name                                                 string
+		logLevel, tmdbAPIKey, itemsPerPage, refreshRateLimit string
+		err                                                  string
+	}{
+		{"items per page not a number", "info", "key", "many", "2s", "invalid number of items per page 'many'"},
+		{"rate limit not a duration", "info", "key", "20", "2", "invalid refresh rate limit '2'"},
+		{"bad log level", "verbose", "key", "20", "2s", "invalid log level 'verbose'"},
+		{"too many items per page", "info", "key", "501", "2s", "the number of items per page must be between 1 and 500"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := business.ParseServerSettings(test.logLevel, test.tmdbAPIKey, test.itemsPerPage, test.refreshRateLimit)
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
+
+// TestValidateServerSettings rejects each invalid value, and reports them all together
+func TestValidateServerSettings(t *testing.T) {
+	valid := model.ServerSettings{LogLevel: "info", ItemsPerPage: 20, RefreshRateLimit: 2 * time.Second}
+	// The TMDB API key may only be saved from the admin panel
+	assert.NoError(t, business.ValidateServerSettings(valid))
+
+	tests := []struct {
+		name   string
+		change func(s *model.ServerSettings)
+		err    string
+	}{
+		{"no log level", func(s *model.ServerSettings) { s.LogLevel = "" }, "invalid log level ''"},
+		{"bad log level", func(s *model.ServerSettings) { s.LogLevel = "verbose" }, "invalid log level 'verbose'"},
+		{"no items per page", func(s *model.ServerSettings) { s.ItemsPerPage = 0 }, "the number of items per page must be between 1 and 500"},
+		{"too many items per page", func(s *model.ServerSettings) { s.ItemsPerPage = 501 }, "the number of items per page must be between 1 and 500"},
+		{"no rate limit", func(s *model.ServerSettings) { s.RefreshRateLimit = 0 }, "the refresh rate limit must be positive"},
+		{"negative rate limit", func(s *model.ServerSettings) { s.RefreshRateLimit = -time.Second }, "the refresh rate limit must be positive"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := valid
+			test.change(&settings)
+			assert.EqualError(t, business.ValidateServerSettings(settings), test.err)
+		})
+	}
+
+	err := business.ValidateServerSettings(model.ServerSettings{LogLevel: "verbose"})
+	assert.ErrorContains(t, err, "invalid log level 'verbose'")
+	assert.ErrorContains(t, err, "the number of items per page must be between 1 and 500")
+	assert.ErrorContains(t, err, "the refresh rate limit must be positive")
+}
+
+// fakeSettingsServer records the settings applied to the running server
+type fakeSettingsServer struct {
+	tmdbAPIKey   *string
+	refreshes    *int
+	itemsPerPage *int64
+}
+
+func (fs fakeSettingsServer) SetMetadataSettings(settings model.MetadataSettings) {}
+func (fs fakeSettingsServer) SetTMDBAPIKey(apiKey string) error {
+	*fs.tmdbAPIKey = apiKey
+	return nil
+}
+func (fs fakeSettingsServer) RefreshLibrary(ctx context.Context) error {
+	*fs.refreshes++
+	return nil
+}
+func (fs fakeSettingsServer) SetRateLimit(rateLimit time.Duration) {}
+func (fs fakeSettingsServer) SetItemsPerPage(itemsPerPage int64)   { *fs.itemsPerPage = itemsPerPage }
+
+// TestSetSettings saves and applies the settings edited from the admin panel, unless they are invalid
+func TestSetSettings(t *testing.T) {
+	ctx := context.Background()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(zerolog.InfoLevel) })
+	db := infrastructure.NewMemory()
+	server := fakeSettingsServer{tmdbAPIKey: new(string), refreshes: new(int), itemsPerPage: new(int64)}
+	defaultMetadata := model.MetadataSettings{Language: "en-US", CertificationCountries: []string{"US"}}
+	defaultServer := model.ServerSettings{LogLevel: "info", ItemsPerPage: 20, RefreshRateLimit: 2 * time.Second}
+	sm := business.NewSettingsManager(db, server, server, server, defaultMetadata, defaultServer)
+
+	// The API key is needed until one is saved, and then kept when none is given
+	assert.EqualError(t, sm.SetServerSettings(ctx, "warn", "", "50", "1s"), "the TMDB API key is needed")
+	assert.Equal(t, defaultServer, sm.GetServerSettings(ctx))
+	require.NoError(t, sm.SetServerSettings(ctx, "warn", "key", "50", "1s"))
+	require.NoError(t, sm.SetServerSettings(ctx, "debug", " ", "40", "1s"))
+	assert.Equal(t, model.ServerSettings{LogLevel: "debug", TMDBAPIKey: "key", ItemsPerPage: 40, RefreshRateLimit: time.Second}, sm.GetServerSettings(ctx))
+	assert.Equal(t, "key", *server.tmdbAPIKey)
+	assert.EqualValues(t, 40, *server.itemsPerPage)
+	assert.Equal(t, zerolog.DebugLevel, zerolog.GlobalLevel())
+	assert.Error(t, sm.SetServerSettings(ctx, "info", "other key", "0", "1s"))
+	assert.Equal(t, "key", sm.GetServerSettings(ctx).TMDBAPIKey)
+
+	// The library is fetched again only when the metadata settings change
+	assert.Error(t, sm.SetMetadataSettings(ctx, "fr-FR", "", "XX"))
+	assert.Equal(t, defaultMetadata, sm.GetMetadataSettings(ctx))
+	require.NoError(t, sm.SetMetadataSettings(ctx, "en-us", "", "us"))
+	assert.Zero(t, *server.refreshes)
+	require.NoError(t, sm.SetMetadataSettings(ctx, "fr-FR", "en-US", "FR,US"))
+	assert.Equal(t, 1, *server.refreshes)
+	assert.Equal(t, model.MetadataSettings{Language: "fr-FR", FallbackLanguage: "en-US", CertificationCountries: []string{"FR", "US"}}, sm.GetMetadataSettings(ctx))
+}
@@ -0,0 +1,118 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+type SmartCollectionStorer interface {
+	AddSmartCollection(ctx context.Context, collection *model.SmartCollection) error
+	UpdateSmartCollection(ctx context.Context, collection *model.SmartCollection) error
+	DeleteSmartCollection(ctx context.Context, collectionID primitive.ObjectID) error
+	GetSmartCollectionFromID(ctx context.Context, collectionID primitive.ObjectID) (*model.SmartCollection, error)
+	GetSmartCollections(ctx context.Context, userID primitive.ObjectID) ([]model.SmartCollection, error)
+}
+
+type SmartCollectionFilmGetter interface {
+	GetFilmsFiltered(ctx context.Context, filter model.FilmFilter, query string, listOptions model.ListOptions) ([]model.Film, int64, error)
+}
+
+type SmartCollectionManager struct {
+	SmartCollectionStorer
+	SmartCollectionFilmGetter
+}
+
+// NewSmartCollectionManager instantiates a new SmartCollectionManager
+func NewSmartCollectionManager(scs SmartCollectionStorer, scfg SmartCollectionFilmGetter) *SmartCollectionManager {
+	return &SmartCollectionManager{
+		SmartCollectionStorer:     scs,
+		SmartCollectionFilmGetter: scfg,
+	}
+}
+
+// GetSmartCollections returns the smart collections of a user
+func (scm SmartCollectionManager) GetSmartCollections(ctx context.Context, userID primitive.ObjectID) ([]model.SmartCollection, error) {
+	return scm.SmartCollectionStorer.GetSmartCollections(ctx, userID)
+}
+
+// GetSmartCollection returns a smart collection of a user from its hexadecimal ID
+func (scm SmartCollectionManager) GetSmartCollection(ctx context.Context, userID primitive.ObjectID, collectionHexID string) (*model.SmartCollection, error) {
+	collectionID, err := primitive.ObjectIDFromHex(collectionHexID)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect smart collection ID: %w", err)
+	}
+	collection, err := scm.SmartCollectionStorer.GetSmartCollectionFromID(ctx, collectionID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get smart collection from ID '%s': %w", collectionHexID, err)
+	}
+	// Smart collections are private
+	if collection.UserID != userID {
+		return nil, fmt.Errorf("smart collection '%s' belongs to another user", collectionHexID)
+	}
+	return collection, nil
+}
+
+// CreateSmartCollection checks the query and saves it as a smart collection of the user
+func (scm SmartCollectionManager) CreateSmartCollection(ctx context.Context, userID primitive.ObjectID, name, query string) (*model.SmartCollection, error) {
+	collection := &model.SmartCollection{UserID: userID}
+	if err := setSmartCollection(collection, name, query); err != nil {
+		return nil, err
+	}
+	if err := scm.SmartCollectionStorer.AddSmartCollection(ctx, collection); err != nil {
+		return nil, fmt.Errorf("could not add smart collection to database: %w", err)
+	}
+	return collection, nil
+}
+
+// EditSmartCollection checks the query and updates the name and the query of a smart collection of the user
+func (scm SmartCollectionManager) EditSmartCollection(ctx context.Context, userID primitive.ObjectID, collectionHexID, name, query string) error {
+	collection, err := scm.GetSmartCollection(ctx, userID, collectionHexID)
+	if err != nil {
+		return err
+	}
+	if err := setSmartCollection(collection, name, query); err != nil {
+		return err
+	}
+	if err := scm.SmartCollectionStorer.UpdateSmartCollection(ctx, collection); err != nil {
+		return fmt.Errorf("could not update smart collection in database: %w", err)
+	}
+	return nil
+}
+
+// DeleteSmartCollection deletes a smart collection of the user
+func (scm SmartCollectionManager) DeleteSmartCollection(ctx context.Context, userID primitive.ObjectID, collectionHexID string) error {
+	collection, err := scm.GetSmartCollection(ctx, userID, collectionHexID)
+	if err != nil {
+		return err
+	}
+	return scm.SmartCollectionStorer.DeleteSmartCollection(ctx, collection.ID)
+}
+
+// GetSmartCollectionFilms returns the films currently matching the query of a smart collection, and their total number
+func (scm SmartCollectionManager) GetSmartCollectionFilms(ctx context.Context, collection *model.SmartCollection, listOptions model.ListOptions) ([]model.Film, int64, error) {
+	return scm.SmartCollectionFilmGetter.GetFilmsFiltered(ctx, model.FilmFilter{}, collection.Query, listOptions)
+}
+
+// setSmartCollection checks the name and the query, and sets them in the smart collection
+func setSmartCollection(collection *model.SmartCollection, name, query string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("the smart collection needs a name")
+	}
+	node, err := ParseFilmQuery(query)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return errors.New("the smart collection needs a query")
+	}
+	collection.Name = name
+	collection.Query = strings.TrimSpace(query)
+	return nil
+}
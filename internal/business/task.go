@@ -0,0 +1,7 @@
+package business
+
+import "time"
+
+// DefaultTaskTimeout is the maximum duration of a background task, such as adding a scanned file or refreshing a film,
+// if none is configured
+const DefaultTaskTimeout = 5 * time.Minute
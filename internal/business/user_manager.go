@@ -1,6 +1,7 @@
 package business
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -12,18 +13,18 @@ import (
 )
 
 type UserStorer interface {
-	IsOwnerPresent() (bool, error)
-	IsUsernameAvailable(username string) (bool, error)
+	IsOwnerPresent(ctx context.Context) (bool, error)
+	IsUsernameAvailable(ctx context.Context, username string) (bool, error)
 
-	GetUserFromName(username string, user *model.User) error
-	GetUserFromID(id primitive.ObjectID) (*model.User, error)
-	GetUserNb() (int64, error)
-	GetUsers() ([]model.User, error)
+	GetUserFromName(ctx context.Context, username string, user *model.User) error
+	GetUserFromID(ctx context.Context, id primitive.ObjectID) (*model.User, error)
+	GetUserNb(ctx context.Context) (int64, error)
+	GetUsers(ctx context.Context) ([]model.User, error)
 
-	CreateUser(user *model.User) error
-	DeleteUser(userId primitive.ObjectID) error
+	CreateUser(ctx context.Context, user *model.User) error
+	DeleteUser(ctx context.Context, userId primitive.ObjectID) error
 
-	SetUserPassword(userID primitive.ObjectID, newPassword string) error
+	SetUserPassword(ctx context.Context, userID primitive.ObjectID, newPassword string) error
 }
 
 type UserManager struct {
@@ -37,15 +38,15 @@ func NewUserManager(us UserStorer) *UserManager {
 	}
 }
 
-func (um UserManager) CreateOwner(username, password1, password2 string) (*model.User, error) {
-	if ownerPresent, err := um.UserStorer.IsOwnerPresent(); err != nil {
+func (um UserManager) CreateOwner(ctx context.Context, username, password1, password2 string) (*model.User, error) {
+	if ownerPresent, err := um.UserStorer.IsOwnerPresent(ctx); err != nil {
 		log.Error().Err(err).Send()
 		return nil, errors.New("an error occurred …")
 	} else if ownerPresent {
 		return nil, model.ErrOwnerAlreadyExists
 	}
 
-	user, err := um.CreateUser(username, password1, password2, true, true)
+	user, err := um.CreateUser(ctx, username, password1, password2, true, true)
 	if err != nil {
 		return nil, fmt.Errorf("error adding user: %w", err)
 	}
@@ -54,12 +55,12 @@ func (um UserManager) CreateOwner(username, password1, password2 string) (*model
 	return user, nil
 }
 
-func (um UserManager) GetUserNb() (int64, error) {
-	return um.UserStorer.GetUserNb()
+func (um UserManager) GetUserNb(ctx context.Context) (int64, error) {
+	return um.UserStorer.GetUserNb(ctx)
 }
 
 // CreateUser checks that the user and password follow specific rules and adds it to the database
-func (um UserManager) CreateUser(username, password1, password2 string, isAdmin, isOwner bool) (*model.User, error) {
+func (um UserManager) CreateUser(ctx context.Context, username, password1, password2 string, isAdmin, isOwner bool) (*model.User, error) {
 	argon := argon2.DefaultConfig()
 
 	// Check username length
@@ -68,7 +69,7 @@ func (um UserManager) CreateUser(username, password1, password2 string, isAdmin,
 	}
 
 	// Check if username is not already taken
-	if available, err := um.UserStorer.IsUsernameAvailable(username); err != nil {
+	if available, err := um.UserStorer.IsUsernameAvailable(ctx, username); err != nil {
 		return nil, err
 	} else if !available {
 		return nil, errors.New("this username is already taken")
@@ -99,7 +100,7 @@ func (um UserManager) CreateUser(username, password1, password2 string, isAdmin,
 		IsAdmin:  isAdmin,
 	}
 
-	err = um.UserStorer.CreateUser(user)
+	err = um.UserStorer.CreateUser(ctx, user)
 	if err != nil {
 		return nil, fmt.Errorf("error adding user: %w", err)
 	}
@@ -107,17 +108,17 @@ func (um UserManager) CreateUser(username, password1, password2 string, isAdmin,
 	return user, nil
 }
 
-func (um UserManager) DeleteUser(userHexID string) error {
+func (um UserManager) DeleteUser(ctx context.Context, userHexID string) error {
 	userId, err := primitive.ObjectIDFromHex(userHexID)
 	if err != nil {
 		return fmt.Errorf("incorrect user ID: %w", err)
 	}
 
-	return um.UserStorer.DeleteUser(userId)
+	return um.UserStorer.DeleteUser(ctx, userId)
 }
 
 // CheckLogin checks that the login is correct and returns the user it corresponds to
-func (um UserManager) CheckLogin(username, password string) (user *model.User, err error) {
+func (um UserManager) CheckLogin(ctx context.Context, username, password string) (user *model.User, err error) {
 	// Check username length
 	if len(username) < 2 || len(username) > 25 {
 		return nil, errors.New("username must be between 2 and 25 characters")
@@ -125,7 +126,7 @@ func (um UserManager) CheckLogin(username, password string) (user *model.User, e
 
 	// Fetch encoded password from DB
 	user = &model.User{}
-	if err := um.UserStorer.GetUserFromName(username, user); err != nil {
+	if err := um.UserStorer.GetUserFromName(ctx, username, user); err != nil {
 		return nil, errors.New("authentication failed")
 	}
 
@@ -140,7 +141,7 @@ func (um UserManager) CheckLogin(username, password string) (user *model.User, e
 }
 
 // SetUserPassword checks that the password change follows specific rules and updates it in the database
-func (um UserManager) SetUserPassword(username, oldPassword, password1, password2 string) error {
+func (um UserManager) SetUserPassword(ctx context.Context, username, oldPassword, password1, password2 string) error {
 	argon := argon2.DefaultConfig()
 
 	// Check new passwords match
@@ -155,7 +156,7 @@ func (um UserManager) SetUserPassword(username, oldPassword, password1, password
 
 	// Fetch encoded password from DB
 	var userDB model.User
-	if err := um.UserStorer.GetUserFromName(username, &userDB); err != nil {
+	if err := um.UserStorer.GetUserFromName(ctx, username, &userDB); err != nil {
 		return errors.New("an error occurred while checking for your password")
 	}
 
@@ -174,25 +175,57 @@ func (um UserManager) SetUserPassword(username, oldPassword, password1, password
 		return errors.New("an error occurred while saving your password")
 	}
 
-	if err := um.UserStorer.SetUserPassword(userDB.ID, string(encoded)); err != nil {
+	if err := um.UserStorer.SetUserPassword(ctx, userDB.ID, string(encoded)); err != nil {
 		return errors.New("an error occurred while saving your password")
 	}
 
 	return nil
 }
 
-func (um UserManager) GetUser(userHexID string) (*model.User, error) {
+// ResetUserPassword replaces the password of a user without checking the previous one, such as a forgotten password
+func (um UserManager) ResetUserPassword(ctx context.Context, username, password1, password2 string) error {
+	argon := argon2.DefaultConfig()
+
+	// Check new passwords match
+	if password1 != password2 {
+		return errors.New("new passwords don't match")
+	}
+
+	// Check password length
+	if len(password1) < 8 {
+		return errors.New("passwords must be at least 8 characters long")
+	}
+
+	var userDB model.User
+	if err := um.UserStorer.GetUserFromName(ctx, username, &userDB); err != nil {
+		return fmt.Errorf("could not get user '%s': %w", username, err)
+	}
+
+	// Hash & encode password
+	encoded, err := argon.HashEncoded([]byte(password1))
+	if err != nil {
+		return fmt.Errorf("could not hash password: %w", err)
+	}
+
+	if err := um.UserStorer.SetUserPassword(ctx, userDB.ID, string(encoded)); err != nil {
+		return fmt.Errorf("could not save password: %w", err)
+	}
+
+	return nil
+}
+
+func (um UserManager) GetUser(ctx context.Context, userHexID string) (*model.User, error) {
 	userId, err := primitive.ObjectIDFromHex(userHexID)
 	if err != nil {
 		return nil, fmt.Errorf("incorrect user ID: %w", err)
 	}
-	user, err := um.UserStorer.GetUserFromID(userId)
+	user, err := um.UserStorer.GetUserFromID(ctx, userId)
 	if err != nil {
 		return nil, fmt.Errorf("could not get user from ID '%s': %w", userHexID, err)
 	}
 	return user, nil
 }
 
-func (um UserManager) GetUsers() ([]model.User, error) {
-	return um.UserStorer.GetUsers()
+func (um UserManager) GetUsers(ctx context.Context) ([]model.User, error) {
+	return um.UserStorer.GetUsers(ctx)
 }
@@ -1,9 +1,12 @@
 package business
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,20 +15,21 @@ import (
 )
 
 type VolumeStorer interface {
-	GetVolumes() ([]model.Volume, error)
-	GetVolumeFromID(id primitive.ObjectID) (volume *model.Volume, err error)
-	AddVolume(volume *model.Volume) error
-	DeleteVolume(volumeId primitive.ObjectID) error
+	GetVolumes(ctx context.Context) ([]model.Volume, error)
+	GetVolumeFromID(ctx context.Context, id primitive.ObjectID) (volume *model.Volume, err error)
+	AddVolume(ctx context.Context, volume *model.Volume) error
+	DeleteVolume(ctx context.Context, volumeId primitive.ObjectID) error
 }
 
 type VolumeMetadataGetter interface {
-	CreateFilm(file string, volumeID primitive.ObjectID, subFiles []string) *model.Film
-	FetchFilmTMDBID(f *model.Film) error
-	UpdateFilmDetails(film *model.Film)
+	CreateFilm(ctx context.Context, file string, volumeID primitive.ObjectID, subFiles []string) *model.Film
+	FetchFilmTMDBID(ctx context.Context, f *model.Film) error
+	UpdateFilmDetails(ctx context.Context, film *model.Film) error
 }
 
 type VolumeFilmManager interface {
-	AddFilm(film *model.Film, update bool) error
+	AddFilm(ctx context.Context, film *model.Film, update bool) error
+	ReindexFilm(ctx context.Context, filmID primitive.ObjectID)
 }
 
 type VolumeManager struct {
@@ -33,35 +37,55 @@ type VolumeManager struct {
 	VolumeMetadataGetter
 	VolumeFilmManager
 	*FileWatcher
+
+	taskTimeout time.Duration
+	scans       *volumeScans
+}
+
+// volumeScans are the scans of the volumes being added, which are cancelled if their volume is deleted
+type volumeScans struct {
+	sync.Mutex
+	running map[primitive.ObjectID]*volumeScan
 }
 
-// NewVolumeManager instantiates a new VolumeManager
-func NewVolumeManager(vs VolumeStorer, fw *FileWatcher, fm VolumeFilmManager, m VolumeMetadataGetter) *VolumeManager {
+type volumeScan struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewVolumeManager instantiates a new VolumeManager.
+// Adding a scanned file to the library is cancelled after taskTimeout
+func NewVolumeManager(vs VolumeStorer, fw *FileWatcher, fm VolumeFilmManager, m VolumeMetadataGetter, taskTimeout time.Duration) *VolumeManager {
+	if taskTimeout <= 0 {
+		taskTimeout = DefaultTaskTimeout
+	}
 	return &VolumeManager{
 		VolumeStorer:         vs,
 		VolumeMetadataGetter: m,
 		VolumeFilmManager:    fm,
 		FileWatcher:          fw,
+		taskTimeout:          taskTimeout,
+		scans:                &volumeScans{running: make(map[primitive.ObjectID]*volumeScan)},
 	}
 }
 
-func (vm VolumeManager) GetVolumes() ([]model.Volume, error) {
-	return vm.VolumeStorer.GetVolumes()
+func (vm VolumeManager) GetVolumes(ctx context.Context) ([]model.Volume, error) {
+	return vm.VolumeStorer.GetVolumes(ctx)
 }
 
-func (vm VolumeManager) GetVolume(volumeHexID string) (*model.Volume, error) {
+func (vm VolumeManager) GetVolume(ctx context.Context, volumeHexID string) (*model.Volume, error) {
 	volumeId, err := primitive.ObjectIDFromHex(volumeHexID)
 	if err != nil {
 		return nil, fmt.Errorf("incorrect volume ID: %w", err)
 	}
-	volume, err := vm.VolumeStorer.GetVolumeFromID(volumeId)
+	volume, err := vm.VolumeStorer.GetVolumeFromID(ctx, volumeId)
 	if err != nil {
 		return nil, fmt.Errorf("could not get volume from ID '%s': %w", volumeHexID, err)
 	}
 	return volume, nil
 }
 
-func (vm VolumeManager) CreateVolume(name, path string, isRecursive bool, mediaType string) error {
+func (vm VolumeManager) CreateVolume(ctx context.Context, name, path string, isRecursive bool, mediaType string) error {
 	volume := &model.Volume{
 		ID:          primitive.NewObjectID(),
 		Name:        name,
@@ -85,28 +109,131 @@ func (vm VolumeManager) CreateVolume(name, path string, isRecursive bool, mediaT
 	}
 
 	// Add volume to the database
-	err = vm.VolumeStorer.AddVolume(volume)
+	err = vm.VolumeStorer.AddVolume(ctx, volume)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return errors.New("volume could not be added")
 	}
 
-	// Search for media files in a separate goroutine to return the page asap
-	go vm.scanVolume(volume)
+	// Search for media files in a separate goroutine to return the page asap.
+	// The scan outlives the request, so it does not use its context
+	scanCtx, cancel := context.WithCancel(context.Background())
+	scan := &volumeScan{cancel: cancel, done: make(chan struct{})}
+	vm.scans.Lock()
+	vm.scans.running[volume.ID] = scan
+	vm.scans.Unlock()
+	go func() {
+		defer close(scan.done)
+		defer cancel()
+		vm.scanVolume(scanCtx, volume)
+		vm.scans.Lock()
+		delete(vm.scans.running, volume.ID)
+		vm.scans.Unlock()
+	}()
+
+	return nil
+}
+
+// cancelScan cancels the scan of a volume if it is running, and waits for it to stop
+func (vm VolumeManager) cancelScan(ctx context.Context, volumeID primitive.ObjectID) error {
+	vm.scans.Lock()
+	scan, ok := vm.scans.running[volumeID]
+	vm.scans.Unlock()
+	if !ok {
+		return nil
+	}
+	scan.cancel()
+	select {
+	case <-scan.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("could not wait for the volume scan to stop: %w", ctx.Err())
+	}
+}
+
+// CancelScans cancels the scans of the volumes being added, and waits for them to stop
+func (vm VolumeManager) CancelScans(ctx context.Context) error {
+	for _, volumeID := range vm.runningScans() {
+		if err := vm.cancelScan(ctx, volumeID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitScans waits for the scans of the volumes being added to end
+func (vm VolumeManager) WaitScans(ctx context.Context) error {
+	for _, volumeID := range vm.runningScans() {
+		vm.scans.Lock()
+		scan, ok := vm.scans.running[volumeID]
+		vm.scans.Unlock()
+		if !ok {
+			continue
+		}
+		select {
+		case <-scan.done:
+		case <-ctx.Done():
+			return fmt.Errorf("could not wait for the volume scan to end: %w", ctx.Err())
+		}
+	}
+	return nil
+}
+
+// runningScans returns the IDs of the volumes being scanned
+func (vm VolumeManager) runningScans() []primitive.ObjectID {
+	vm.scans.Lock()
+	defer vm.scans.Unlock()
+	volumeIDs := make([]primitive.ObjectID, 0, len(vm.scans.running))
+	for volumeID := range vm.scans.running {
+		volumeIDs = append(volumeIDs, volumeID)
+	}
+	return volumeIDs
+}
 
+// ScanVolume synchronizes the library with the files of a volume: the new files are added,
+// and the missing ones are removed from their film
+func (vm VolumeManager) ScanVolume(ctx context.Context, volumeHexID string) error {
+	volume, err := vm.GetVolume(ctx, volumeHexID)
+	if err != nil {
+		return err
+	}
+	// Every film of the volume would be removed if its disk is not mounted
+	fileInfo, err := os.Stat(volume.Path)
+	if err != nil {
+		return fmt.Errorf("could not scan volume '%s': %w", volume.Name, err)
+	}
+	if !fileInfo.IsDir() {
+		return fmt.Errorf("could not scan volume '%s': its path is not a directory", volume.Name)
+	}
+	vm.FileWatcher.SynchronizeVolume(ctx, volume)
 	return nil
 }
 
-func (vm VolumeManager) DeleteVolume(volumeHexID string) error {
+func (vm VolumeManager) DeleteVolume(ctx context.Context, volumeHexID string) error {
 	volumeId, err := primitive.ObjectIDFromHex(volumeHexID)
 	if err != nil {
 		return fmt.Errorf("incorrect volume ID: %w", err)
 	}
 
-	return vm.VolumeStorer.DeleteVolume(volumeId)
+	// The scan must not add files of the volume once it is deleted
+	if err := vm.cancelScan(ctx, volumeId); err != nil {
+		return err
+	}
+
+	// The films of the volume are deleted, or lose the files of the volume
+	films := vm.FileWatcher.FileStorer.GetFilmsFromVolume(ctx, volumeId)
+	if err := vm.VolumeStorer.DeleteVolume(ctx, volumeId); err != nil {
+		return err
+	}
+	for _, film := range films {
+		vm.VolumeFilmManager.ReindexFilm(ctx, film.ID)
+	}
+	return nil
 }
 
-func (vm VolumeManager) scanVolume(volume *model.Volume) {
+// scanVolume adds the video files of a volume to the library, and watches the volume afterwards.
+// It stops as soon as the context is done
+func (vm VolumeManager) scanVolume(ctx context.Context, volume *model.Volume) {
 	videoFiles, subFiles, err := volume.ListVideoFiles()
 	if err != nil {
 		log.Warn().Str("volumePath", volume.Path).Msg("Unable to scan folder for video files")
@@ -117,17 +244,24 @@ func (vm VolumeManager) scanVolume(volume *model.Volume) {
 	// Worker function
 	getFilmsFromFiles := func(files <-chan string, films chan<- *model.Film) {
 		for file := range files {
-			film := vm.CreateFilm(file, volume.ID, subFiles)
+			if ctx.Err() != nil {
+				continue
+			}
+			taskCtx, cancel := context.WithTimeout(ctx, vm.taskTimeout)
+			film := vm.CreateFilm(taskCtx, file, volume.ID, subFiles)
 
 			// Search ID on TMDB
-			if err = vm.VolumeMetadataGetter.FetchFilmTMDBID(film); err != nil {
+			if err := vm.VolumeMetadataGetter.FetchFilmTMDBID(taskCtx, film); err != nil {
 				log.Warn().Str("file", file).Err(err).Msg("Unable to fetch film ID from TMDB")
 				film.Title = film.Name
 			} else {
 				log.Info().Str("file", file).Int("tmdb_id", film.TMDBID).Msg("Found TMDB ID for file")
 				// Fill info from TMDB
-				vm.VolumeMetadataGetter.UpdateFilmDetails(film)
+				if err := vm.VolumeMetadataGetter.UpdateFilmDetails(taskCtx, film); err != nil {
+					log.Error().Err(err).Str("file", file).Msg("Unable to fetch film details from TMDB")
+				}
 			}
+			cancel()
 
 			films <- film
 		}
@@ -148,8 +282,19 @@ func (vm VolumeManager) scanVolume(volume *model.Volume) {
 	}
 	close(files)
 
-	for film := range films {
-		vm.VolumeFilmManager.AddFilm(film, false)
+	// Every file gives a film until the scan is cancelled, and the channel is never closed
+	for range videoFiles {
+		select {
+		case <-ctx.Done():
+			log.Info().Str("volumePath", volume.Path).Msg("Volume scan cancelled")
+			return
+		case film := <-films:
+			taskCtx, cancel := context.WithTimeout(ctx, vm.taskTimeout)
+			if err := vm.VolumeFilmManager.AddFilm(taskCtx, film, false); err != nil {
+				log.Error().Err(err).Str("path", film.VolumeFiles[0].Path).Msg("Unable to add scanned film")
+			}
+			cancel()
+		}
 	}
 
 	// Add file watch to the volume
@@ -1,12 +1,15 @@
 package infrastructure
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -14,6 +17,19 @@ import (
 
 type Cache struct {
 	cachePath string
+	retries   *cacheRetries
+}
+
+// cacheRetries are the files whose source asked to wait before fetching them again
+type cacheRetries struct {
+	sync.Mutex
+	pending map[string]*cacheRetry // Indexed by file path
+	flushed bool                   // No retry is scheduled once the retries are flushed
+}
+
+type cacheRetry struct {
+	sourceUrl string
+	timer     *time.Timer
 }
 
 // NewCache initializes the cache folder
@@ -30,6 +46,7 @@ func NewCache(cachePath string) *Cache {
 
 	return &Cache{
 		cachePath: cachePath,
+		retries:   &cacheRetries{pending: make(map[string]*cacheRetry)},
 	}
 }
 
@@ -53,49 +70,123 @@ func (c Cache) CachePhoto(sourceUrl, key string) (hasToWait bool, err error) {
 	return c.CacheFile(sourceUrl, "photo"+key)
 }
 
+// IsPosterCached returns true if the poster with this unique key is in the cache
+func (c Cache) IsPosterCached(key string) bool {
+	return c.isCached("poster" + key)
+}
+
+// RemovePhoto removes a photo from the cache, if it is cached
+func (c Cache) RemovePhoto(key string) error {
+	err := os.Remove(c.GetCachedPath("photo" + key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
 // CacheFile caches a file from a sourceUrl to the filePath in the cache folder
 // Returns true if the URL returns a Status TooManyRequests (429) and will retry at a later moment
 // Returns false if the file was immediately cached
 func (c Cache) CacheFile(sourceUrl string, filePath string) (hasToWait bool, err error) {
-	// Create directories in the requested path if needed
-	parent := c.GetCachedPath(filepath.Dir(filePath))
-	if _, err := os.Stat(parent); errors.Is(err, os.ErrNotExist) {
-		err = os.MkdirAll(parent, 0755)
-		if err != nil {
-			return false, err
-		}
-	}
-	// Get file as buffer
-	resp, err := http.Get(sourceUrl)
+	resp, err := c.fetchFile(context.Background(), sourceUrl, filePath)
 	if err != nil {
 		return false, err
 	}
 	if resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
 		waitSeconds, err := strconv.Atoi(resp.Header.Get("retry-after"))
 		if err != nil {
 			waitSeconds = 300 // Wait 5 minutes by default
 		}
-		time.AfterFunc(time.Duration(waitSeconds)*time.Second, func() {
-			c.CacheFile(sourceUrl, filePath)
-		})
+		if err := c.scheduleRetry(sourceUrl, filePath, time.Duration(waitSeconds)*time.Second); err != nil {
+			return false, err
+		}
 		return true, nil
 	}
+	return false, c.writeFile(resp, filePath)
+}
+
+// scheduleRetry caches a file again after a delay, unless the retries were flushed
+func (c Cache) scheduleRetry(sourceUrl, filePath string, delay time.Duration) error {
+	c.retries.Lock()
+	defer c.retries.Unlock()
+	if c.retries.flushed {
+		return errors.New("source file is rate limited, and the cache retries are flushed")
+	}
+	if retry, ok := c.retries.pending[filePath]; ok {
+		retry.timer.Stop()
+	}
+	c.retries.pending[filePath] = &cacheRetry{
+		sourceUrl: sourceUrl,
+		timer: time.AfterFunc(delay, func() {
+			c.retries.Lock()
+			delete(c.retries.pending, filePath)
+			c.retries.Unlock()
+			c.CacheFile(sourceUrl, filePath)
+		}),
+	}
+	return nil
+}
+
+// FlushRetries caches the files waiting for a retry right away, and stops scheduling retries.
+// Files that cannot be fetched before ctx is done are left out of the cache
+func (c Cache) FlushRetries(ctx context.Context) error {
+	c.retries.Lock()
+	c.retries.flushed = true
+	pending := make(map[string]string)
+	for filePath, retry := range c.retries.pending {
+		// A retry whose timer already fired is running, and will not schedule another one
+		if retry.timer.Stop() {
+			pending[filePath] = retry.sourceUrl
+		}
+	}
+	c.retries.pending = make(map[string]*cacheRetry)
+	c.retries.Unlock()
+
+	var errs []error
+	for filePath, sourceUrl := range pending {
+		resp, err := c.fetchFile(ctx, sourceUrl, filePath)
+		if err == nil {
+			err = c.writeFile(resp, filePath)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while caching %s: %w", filePath, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fetchFile creates the directories of a file in the cache folder, and requests it from its source
+func (c Cache) fetchFile(ctx context.Context, sourceUrl, filePath string) (*http.Response, error) {
+	// Create directories in the requested path if needed
+	parent := c.GetCachedPath(filepath.Dir(filePath))
+	if _, err := os.Stat(parent); errors.Is(err, os.ErrNotExist) {
+		err = os.MkdirAll(parent, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// writeFile writes the body of a response to a file in the cache folder
+func (c Cache) writeFile(resp *http.Response, filePath string) error {
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return false, errors.New("could not fetch source file")
+		return errors.New("could not fetch source file")
 	}
 	// Write file
 	out, err := os.Create(c.GetCachedPath(filePath))
 	if err != nil {
-		return false, err
+		return err
 	}
 	defer out.Close()
 	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return false, err
-	}
-
-	return false, nil
+	return err
 }
 
 // isCached returns true if a filepath is in the cache
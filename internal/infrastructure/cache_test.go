@@ -1,11 +1,15 @@
 package infrastructure_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/Agurato/starfin/internal/infrastructure"
 )
@@ -31,3 +35,34 @@ func TestCache(t *testing.T) {
 		assert.Greater(t, info.Size(), int64(0))
 	})
 }
+
+func TestFlushCacheRetries(t *testing.T) {
+	rateLimited := true
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimited {
+			w.Header().Set("retry-after", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("image"))
+	}))
+	t.Cleanup(source.Close)
+	cache := infrastructure.NewCache(t.TempDir())
+
+	hasToWait, err := cache.CachePoster(source.URL, "/poster.jpg")
+	require.NoError(t, err)
+	assert.True(t, hasToWait)
+	assert.False(t, cache.IsPosterCached("/poster.jpg"))
+
+	// The pending retry is cached right away instead of an hour later
+	rateLimited = false
+	require.NoError(t, cache.FlushRetries(context.Background()))
+	content, err := os.ReadFile(cache.GetCachedPath("poster/poster.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "image", string(content))
+
+	// No retry is scheduled afterwards
+	rateLimited = true
+	_, err = cache.CacheBackdrop(source.URL, "/backdrop.jpg")
+	assert.Error(t, err)
+}
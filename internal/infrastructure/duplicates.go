@@ -0,0 +1,169 @@
+package infrastructure
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// addVolumeFiles adds the volume files whose path is not in the film yet, and returns whether some were added
+func addVolumeFiles(film *model.Film, volumeFiles []model.VolumeFile) (added bool) {
+	for _, volumeFile := range volumeFiles {
+		if !slices.ContainsFunc(film.VolumeFiles, func(vf model.VolumeFile) bool { return vf.Path == volumeFile.Path }) {
+			film.VolumeFiles = append(film.VolumeFiles, volumeFile)
+			added = true
+		}
+	}
+	if added {
+		film.Technical = film.GetTechnicalInfo()
+	}
+	return added
+}
+
+// filmMerge is the change of the stored films that removes their duplicates
+type filmMerge struct {
+	duplicates []model.Duplicate    // Films having the same TMDB ID, and then the files that are in several films
+	saved      []model.Film         // Films whose files changed
+	deleted    []primitive.ObjectID // Films merged into others
+}
+
+// mergeDuplicateFilms merges the films having the same TMDB ID into the first one added. The films not matched on TMDB,
+// whose ID is 0, are never merged. The files that are still in several films afterwards are only reported, as these
+// films are different: which one has the file must be decided by hand
+func mergeDuplicateFilms(films []model.Film) (merge filmMerge) {
+	slices.SortFunc(films, func(a, b model.Film) int { return compareIDs(a.ID, b.ID) })
+	kept := make(map[int]*model.Film)
+	duplicates := make(map[int]*model.Duplicate)
+	changed := make(map[primitive.ObjectID]*model.Film)
+	deleted := make(map[primitive.ObjectID]bool)
+	for i := range films {
+		film := &films[i]
+		// A film listing a file twice keeps it once
+		if volumeFiles := uniqueVolumeFiles(film.VolumeFiles); len(volumeFiles) < len(film.VolumeFiles) {
+			film.VolumeFiles = volumeFiles
+			film.Technical = film.GetTechnicalInfo()
+			changed[film.ID] = film
+		}
+		target, ok := kept[film.TMDBID]
+		if film.TMDBID == 0 || !ok {
+			kept[film.TMDBID] = film
+			continue
+		}
+
+		if duplicates[film.TMDBID] == nil {
+			duplicates[film.TMDBID] = &model.Duplicate{Kind: model.DuplicateFilm, TMDBID: int64(film.TMDBID), Name: target.Title, IDs: []primitive.ObjectID{target.ID}}
+		}
+		duplicates[film.TMDBID].IDs = append(duplicates[film.TMDBID].IDs, film.ID)
+		merge.deleted = append(merge.deleted, film.ID)
+		deleted[film.ID] = true
+		delete(changed, film.ID)
+		if film.DateAdded.Before(target.DateAdded) {
+			target.DateAdded = film.DateAdded
+			changed[target.ID] = target
+		}
+		if addVolumeFiles(target, film.VolumeFiles) {
+			changed[target.ID] = target
+		}
+	}
+
+	for _, film := range changed {
+		merge.saved = append(merge.saved, *film)
+	}
+	for _, duplicate := range duplicates {
+		merge.duplicates = append(merge.duplicates, *duplicate)
+	}
+	slices.SortFunc(merge.duplicates, func(a, b model.Duplicate) int { return cmp.Compare(a.TMDBID, b.TMDBID) })
+
+	// The files in several films once they are merged
+	filmIDs := make(map[string][]primitive.ObjectID)
+	for _, film := range films {
+		if deleted[film.ID] {
+			continue
+		}
+		for _, volumeFile := range film.VolumeFiles {
+			filmIDs[volumeFile.Path] = append(filmIDs[volumeFile.Path], film.ID)
+		}
+	}
+	var conflicts []model.Duplicate
+	for path, ids := range filmIDs {
+		if len(ids) > 1 {
+			conflicts = append(conflicts, model.Duplicate{Kind: model.DuplicateFile, Name: path, IDs: ids})
+		}
+	}
+	slices.SortFunc(conflicts, func(a, b model.Duplicate) int { return cmp.Compare(a.Name, b.Name) })
+	merge.duplicates = append(merge.duplicates, conflicts...)
+	return merge
+}
+
+// uniqueVolumeFiles returns the volume files without the ones whose path is already in a previous one
+func uniqueVolumeFiles(volumeFiles []model.VolumeFile) []model.VolumeFile {
+	var unique []model.VolumeFile
+	for _, volumeFile := range volumeFiles {
+		if !slices.ContainsFunc(unique, func(vf model.VolumeFile) bool { return vf.Path == volumeFile.Path }) {
+			unique = append(unique, volumeFile)
+		}
+	}
+	return unique
+}
+
+// checkNoDuplicates returns an error if there are duplicates, which are never merged by a migration:
+// the merge deletes documents, so it is only done on demand, after the duplicates were reviewed
+func checkNoDuplicates(duplicates []model.Duplicate, err error) error {
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%d films, people, collections or files are stored several times: list them with 'starfin merge-duplicates -dry-run', and merge them with 'starfin merge-duplicates' before starting starfin again", len(duplicates))
+	}
+	return nil
+}
+
+// duplicateKey is what identifies a stored document, and tells which of its duplicates is kept
+type duplicateKey struct {
+	id            primitive.ObjectID
+	tmdbID        int64
+	name          string
+	lastRefreshed time.Time
+}
+
+// mergeDuplicateDocuments keeps the most recently refreshed of the documents having the same TMDB ID,
+// and returns the duplicates along with the IDs of the documents to delete
+func mergeDuplicateDocuments(kind string, keys []duplicateKey) (duplicates []model.Duplicate, deleted []primitive.ObjectID) {
+	slices.SortFunc(keys, func(a, b duplicateKey) int {
+		return compareFirst(cmp.Compare(a.tmdbID, b.tmdbID), b.lastRefreshed.Compare(a.lastRefreshed), compareIDs(a.id, b.id))
+	})
+	for i := 1; i < len(keys); i++ {
+		if keys[i].tmdbID != keys[i-1].tmdbID {
+			continue
+		}
+		if len(duplicates) == 0 || duplicates[len(duplicates)-1].TMDBID != keys[i].tmdbID {
+			duplicates = append(duplicates, model.Duplicate{Kind: kind, TMDBID: keys[i].tmdbID, Name: keys[i-1].name, IDs: []primitive.ObjectID{keys[i-1].id}})
+		}
+		duplicates[len(duplicates)-1].IDs = append(duplicates[len(duplicates)-1].IDs, keys[i].id)
+		deleted = append(deleted, keys[i].id)
+	}
+	return duplicates, deleted
+}
+
+// personKeys returns the keys of the people, to merge their duplicates
+func personKeys(people []model.Person) []duplicateKey {
+	keys := make([]duplicateKey, len(people))
+	for i, person := range people {
+		keys[i] = duplicateKey{id: person.ID, tmdbID: person.TMDBID, name: person.Name, lastRefreshed: person.LastRefreshed}
+	}
+	return keys
+}
+
+// collectionKeys returns the keys of the collections, to merge their duplicates
+func collectionKeys(collections []model.Collection) []duplicateKey {
+	keys := make([]duplicateKey, len(collections))
+	for i, collection := range collections {
+		keys[i] = duplicateKey{id: collection.ID, tmdbID: collection.TMDBID, name: collection.Name, lastRefreshed: collection.LastRefreshed}
+	}
+	return keys
+}
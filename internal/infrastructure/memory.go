@@ -0,0 +1,1328 @@
+package infrastructure
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// errMemoryTorrents is returned by the torrent methods of the in-memory storage, which has no RARBG dump
+var errMemoryTorrents = errors.New("torrents are not available with the in-memory storage")
+
+// Memory stores the library in memory, to run a demo without any database or to test the business logic.
+// Documents are copied when they are stored and when they are returned, like they would be by a database
+type Memory struct {
+	mu sync.Mutex
+
+	users            []model.User
+	settings         map[string][]byte
+	smartCollections []model.SmartCollection
+	collections      []model.Collection
+	volumes          []model.Volume
+	films            []model.Film
+	people           []model.Person
+	personRetries    map[int64]model.PersonRetry
+
+	// Collators are not safe for concurrent use, they are guarded by mu
+	listCollator *collate.Collator
+	nameCollator *collate.Collator
+}
+
+// MemoryFixture is a library seeding the in-memory storage, decoded from JSON with the field names of the model
+type MemoryFixture struct {
+	Users            []model.User
+	Volumes          []model.Volume
+	Films            []model.Film
+	People           []model.Person
+	Collections      []model.Collection
+	SmartCollections []model.SmartCollection
+	MetadataSettings *model.MetadataSettings
+	HomeSettings     *model.HomeSettings
+	ServerSettings   *model.ServerSettings
+}
+
+// NewMemory returns an empty in-memory storage
+func NewMemory() *Memory {
+	return &Memory{
+		settings:      make(map[string][]byte),
+		personRetries: make(map[int64]model.PersonRetry),
+		// Sorts like the list collation of MongoDB: case-insensitive, and with numbers sorted by value
+		listCollator: collate.New(language.English, collate.IgnoreCase, collate.Numeric),
+		// Compares names ignoring their case and their accents, like the name collation of MongoDB
+		nameCollator: collate.New(language.English, collate.Loose),
+	}
+}
+
+// LoadFixture adds the documents of the JSON fixture at path to the storage.
+// Missing IDs are generated, and the technical info of the films is computed from their files
+func (m *Memory) LoadFixture(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error while reading fixture: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var fixture MemoryFixture
+	if err := decoder.Decode(&fixture); err != nil {
+		return fmt.Errorf("error while decoding fixture %q: %w", path, err)
+	}
+
+	for _, user := range fixture.Users {
+		user := user
+		setMissingID(&user.ID)
+		if err := m.CreateUser(ctx, &user); err != nil {
+			return err
+		}
+	}
+	for _, volume := range fixture.Volumes {
+		volume := volume
+		setMissingID(&volume.ID)
+		if err := m.AddVolume(ctx, &volume); err != nil {
+			return err
+		}
+	}
+	for _, film := range fixture.Films {
+		film := film
+		setMissingID(&film.ID)
+		if err := m.AddFilm(ctx, &film); err != nil {
+			return err
+		}
+	}
+	for _, person := range fixture.People {
+		person := person
+		setMissingID(&person.ID)
+		m.AddPerson(ctx, &person)
+	}
+	for _, collection := range fixture.Collections {
+		collection := collection
+		setMissingID(&collection.ID)
+		if err := m.AddCollection(ctx, &collection); err != nil {
+			return err
+		}
+	}
+	for _, collection := range fixture.SmartCollections {
+		collection := collection
+		m.mu.Lock()
+		setMissingID(&collection.ID)
+		m.smartCollections = append(m.smartCollections, memoryClone(collection))
+		m.mu.Unlock()
+	}
+	if fixture.MetadataSettings != nil {
+		if err := m.SetMetadataSettings(ctx, fixture.MetadataSettings); err != nil {
+			return err
+		}
+	}
+	if fixture.HomeSettings != nil {
+		if err := m.SetHomeSettings(ctx, fixture.HomeSettings); err != nil {
+			return err
+		}
+	}
+	if fixture.ServerSettings != nil {
+		if err := m.SetServerSettings(ctx, fixture.ServerSettings); err != nil {
+			return err
+		}
+	}
+	log.Info().Str("fixture", path).Int("films", len(fixture.Films)).Int("people", len(fixture.People)).Msg("Fixture loaded in memory")
+	return nil
+}
+
+// setMissingID generates the ID of a fixture document if it has none
+func setMissingID(id *primitive.ObjectID) {
+	if id.IsZero() {
+		*id = primitive.NewObjectID()
+	}
+}
+
+// Close does nothing, the storage is lost when the process exits
+func (m *Memory) Close() error {
+	return nil
+}
+
+// Migrate does nothing, as the documents in memory were never stored by a previous version of starfin
+func (m *Memory) Migrate(ctx context.Context, dryRun bool) ([]model.Migration, error) {
+	return nil, nil
+}
+
+// memoryClone returns a deep copy of a document, which only holds values that can be encoded to JSON
+func memoryClone[T any](doc T) T {
+	var clone T
+	data, err := json.Marshal(doc)
+	if err != nil {
+		log.Error().Err(err).Type("document", doc).Msg("Unable to copy document")
+		return clone
+	}
+	if err := json.Unmarshal(data, &clone); err != nil {
+		log.Error().Err(err).Type("document", doc).Msg("Unable to copy document")
+	}
+	return clone
+}
+
+// memoryFind returns copies of the documents matching the predicate, or nil if there is none
+func memoryFind[T any](docs []T, match func(doc *T) bool) (found []T) {
+	for i := range docs {
+		if match(&docs[i]) {
+			found = append(found, memoryClone(docs[i]))
+		}
+	}
+	return found
+}
+
+// memoryPage returns the documents in the range of the list options
+func memoryPage[T any](docs []T, listOptions model.ListOptions) []T {
+	start := min(max(listOptions.Skip, 0), int64(len(docs)))
+	end := int64(len(docs))
+	if listOptions.Limit > 0 {
+		end = min(start+listOptions.Limit, end)
+	}
+	return docs[start:end]
+}
+
+// memoryLimit returns at most limit documents
+func memoryLimit[T any](docs []T, limit int64) []T {
+	if limit >= 0 && int64(len(docs)) > limit {
+		return docs[:limit]
+	}
+	return docs
+}
+
+// memoryOrder applies the order of the list options to a comparison
+func memoryOrder(listOptions model.ListOptions, c int) int {
+	if listOptions.Descending {
+		return -c
+	}
+	return c
+}
+
+// compareFirst returns the first of the comparisons of several sort keys that is not equal
+func compareFirst(comparisons ...int) int {
+	for _, c := range comparisons {
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareIDs sorts documents by ID, like SQLite and MongoDB do to break ties
+func compareIDs(a, b primitive.ObjectID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+// IsOwnerPresent checks if there is an owner in the server
+func (m *Memory) IsOwnerPresent(ctx context.Context) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.ContainsFunc(m.users, func(user model.User) bool { return user.IsOwner }), nil
+}
+
+// CreateUser adds a user to the database after checking parameter
+func (m *Memory) CreateUser(ctx context.Context, user *model.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.userIndex(user.ID) != -1 {
+		return fmt.Errorf("user '%s' already exists", user.ID.Hex())
+	}
+	m.users = append(m.users, memoryClone(*user))
+	return nil
+}
+
+// userIndex returns the index of a user, or -1 if it is not stored
+func (m *Memory) userIndex(id primitive.ObjectID) int {
+	return slices.IndexFunc(m.users, func(user model.User) bool { return user.ID == id })
+}
+
+// DeleteUser deletes the user from the DB
+func (m *Memory) DeleteUser(ctx context.Context, userId primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.userIndex(userId)
+	if i == -1 {
+		return errors.New("unable to delete user")
+	}
+	m.users = slices.Delete(m.users, i, i+1)
+	m.smartCollections = slices.DeleteFunc(m.smartCollections, func(collection model.SmartCollection) bool {
+		return collection.UserID == userId
+	})
+	return nil
+}
+
+// IsUsernameAvailable returns true if the username (case-insensitive) is not in use yet
+func (m *Memory) IsUsernameAvailable(ctx context.Context, username string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !slices.ContainsFunc(m.users, func(user model.User) bool { return strings.EqualFold(user.Name, username) }), nil
+}
+
+// GetUserFromID gets user from its ID
+func (m *Memory) GetUserFromID(ctx context.Context, id primitive.ObjectID) (*model.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.userIndex(id)
+	if i == -1 {
+		return nil, fmt.Errorf("user '%s': %w", id.Hex(), model.ErrNotFound)
+	}
+	user := memoryClone(m.users[i])
+	return &user, nil
+}
+
+// GetUserFromName gets user from it name
+func (m *Memory) GetUserFromName(ctx context.Context, username string, user *model.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.users, func(user model.User) bool { return user.Name == username })
+	if i == -1 {
+		return fmt.Errorf("user %q: %w", username, model.ErrNotFound)
+	}
+	*user = memoryClone(m.users[i])
+	return nil
+}
+
+// GetUserNb returns the number of users from the DB
+func (m *Memory) GetUserNb(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.users)), nil
+}
+
+// GetUsers returns the list of users in the DB
+func (m *Memory) GetUsers(ctx context.Context) ([]model.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return memoryFind(m.users, func(*model.User) bool { return true }), nil
+}
+
+// SetUserPassword set a new password for a specific user
+func (m *Memory) SetUserPassword(ctx context.Context, userID primitive.ObjectID, newPassword string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i := m.userIndex(userID); i != -1 {
+		m.users[i].Password = newPassword
+	}
+	return nil
+}
+
+// SetUserHiddenHomeRows sets the keys of the home rows a user does not want to see
+func (m *Memory) SetUserHiddenHomeRows(ctx context.Context, userID primitive.ObjectID, rowKeys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i := m.userIndex(userID); i != -1 {
+		m.users[i].HiddenHomeRows = slices.Clone(rowKeys)
+	}
+	return nil
+}
+
+// getSettings decodes the settings saved with the given ID, or returns model.ErrNotFound if they were never saved
+func (m *Memory) getSettings(id string, settings any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.settings[id]
+	if !ok {
+		return fmt.Errorf("%s settings: %w", id, model.ErrNotFound)
+	}
+	return json.Unmarshal(data, settings)
+}
+
+// setSettings saves settings with the given ID
+func (m *Memory) setSettings(id string, settings any) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("error while encoding settings: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settings[id] = data
+	return nil
+}
+
+// GetMetadataSettings returns the metadata settings, or model.ErrNotFound if they were never saved
+func (m *Memory) GetMetadataSettings(ctx context.Context) (*model.MetadataSettings, error) {
+	var settings model.MetadataSettings
+	if err := m.getSettings(metadataSettingsID, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetMetadataSettings saves the metadata settings
+func (m *Memory) SetMetadataSettings(ctx context.Context, settings *model.MetadataSettings) error {
+	return m.setSettings(metadataSettingsID, settings)
+}
+
+// GetHomeSettings returns the home dashboard settings, or model.ErrNotFound if they were never saved
+func (m *Memory) GetHomeSettings(ctx context.Context) (*model.HomeSettings, error) {
+	var settings model.HomeSettings
+	if err := m.getSettings(homeSettingsID, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetHomeSettings saves the home dashboard settings
+func (m *Memory) SetHomeSettings(ctx context.Context, settings *model.HomeSettings) error {
+	return m.setSettings(homeSettingsID, settings)
+}
+
+// GetServerSettings returns the server settings, or model.ErrNotFound if they were never saved
+func (m *Memory) GetServerSettings(ctx context.Context) (*model.ServerSettings, error) {
+	var settings model.ServerSettings
+	if err := m.getSettings(serverSettingsID, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetServerSettings saves the server settings
+func (m *Memory) SetServerSettings(ctx context.Context, settings *model.ServerSettings) error {
+	return m.setSettings(serverSettingsID, settings)
+}
+
+// smartCollectionIndex returns the index of a smart collection, or -1 if it is not stored
+func (m *Memory) smartCollectionIndex(id primitive.ObjectID) int {
+	return slices.IndexFunc(m.smartCollections, func(collection model.SmartCollection) bool { return collection.ID == id })
+}
+
+// AddSmartCollection adds a smart collection to the DB
+func (m *Memory) AddSmartCollection(ctx context.Context, collection *model.SmartCollection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	collection.ID = primitive.NewObjectID()
+	m.smartCollections = append(m.smartCollections, memoryClone(*collection))
+	return nil
+}
+
+// UpdateSmartCollection updates the name and the query of a smart collection
+func (m *Memory) UpdateSmartCollection(ctx context.Context, collection *model.SmartCollection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i := m.smartCollectionIndex(collection.ID); i != -1 {
+		m.smartCollections[i].Name = collection.Name
+		m.smartCollections[i].Query = collection.Query
+	}
+	return nil
+}
+
+// DeleteSmartCollection deletes a smart collection from the DB
+func (m *Memory) DeleteSmartCollection(ctx context.Context, collectionID primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.smartCollectionIndex(collectionID)
+	if i == -1 {
+		return errors.New("unable to delete smart collection")
+	}
+	m.smartCollections = slices.Delete(m.smartCollections, i, i+1)
+	return nil
+}
+
+// GetSmartCollectionFromID returns a smart collection from its ID
+func (m *Memory) GetSmartCollectionFromID(ctx context.Context, collectionID primitive.ObjectID) (*model.SmartCollection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.smartCollectionIndex(collectionID)
+	if i == -1 {
+		return nil, fmt.Errorf("smart collection '%s': %w", collectionID.Hex(), model.ErrNotFound)
+	}
+	collection := memoryClone(m.smartCollections[i])
+	return &collection, nil
+}
+
+// GetSmartCollections returns the smart collections of a user, sorted by name
+func (m *Memory) GetSmartCollections(ctx context.Context, userID primitive.ObjectID) ([]model.SmartCollection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	collections := memoryFind(m.smartCollections, func(collection *model.SmartCollection) bool { return collection.UserID == userID })
+	slices.SortFunc(collections, func(a, b model.SmartCollection) int {
+		return compareFirst(m.listCollator.CompareString(a.Name, b.Name), compareIDs(a.ID, b.ID))
+	})
+	return collections, nil
+}
+
+// AddCollection adds a collection to the DB
+// If the collection is already in the database, updates it
+// A collection stored with another ID keeps it
+func (m *Memory) AddCollection(ctx context.Context, collection *model.Collection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.collections, func(c model.Collection) bool { return c.ID == collection.ID || c.TMDBID == collection.TMDBID })
+	if i == -1 {
+		m.collections = append(m.collections, memoryClone(*collection))
+	} else {
+		stored := memoryClone(*collection)
+		stored.ID = m.collections[i].ID
+		m.collections[i] = stored
+	}
+	return nil
+}
+
+// getCollection returns the first collection matching the predicate
+func (m *Memory) getCollection(match func(collection *model.Collection) bool) (*model.Collection, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	collections := memoryFind(m.collections, match)
+	if len(collections) == 0 {
+		return nil, false
+	}
+	return &collections[0], true
+}
+
+// GetCollectionFromID returns a collection from its ID
+func (m *Memory) GetCollectionFromID(ctx context.Context, collectionID primitive.ObjectID) (*model.Collection, error) {
+	collection, ok := m.getCollection(func(collection *model.Collection) bool { return collection.ID == collectionID })
+	if !ok {
+		return nil, fmt.Errorf("collection '%s': %w", collectionID.Hex(), model.ErrNotFound)
+	}
+	return collection, nil
+}
+
+// GetCollectionFromTMDBID returns a collection from its TMDB ID
+func (m *Memory) GetCollectionFromTMDBID(ctx context.Context, tmdbID int64) (*model.Collection, error) {
+	collection, ok := m.getCollection(func(collection *model.Collection) bool { return collection.TMDBID == tmdbID })
+	if !ok {
+		return nil, fmt.Errorf("collection with TMDB ID %d: %w", tmdbID, model.ErrNotFound)
+	}
+	return collection, nil
+}
+
+// GetCollectionSummaries returns the collections having films in the library, sorted by name, with their number of films
+func (m *Memory) GetCollectionSummaries(ctx context.Context) (summaries []model.CollectionSummary, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[int64]int)
+	for _, film := range m.films {
+		if film.CollectionID > 0 {
+			counts[film.CollectionID]++
+		}
+	}
+	for _, collection := range m.collections {
+		if count := counts[collection.TMDBID]; count > 0 {
+			summaries = append(summaries, model.CollectionSummary{Collection: memoryClone(collection), OwnedCount: count})
+		}
+	}
+	slices.SortFunc(summaries, func(a, b model.CollectionSummary) int {
+		return compareFirst(m.listCollator.CompareString(a.Name, b.Name), compareIDs(a.ID, b.ID))
+	})
+	return summaries, nil
+}
+
+// GetFilmsFromCollection returns the films of the library belonging to a collection
+func (m *Memory) GetFilmsFromCollection(ctx context.Context, tmdbID int64) ([]model.Film, error) {
+	return m.findFilms(func(film *model.Film) bool { return film.CollectionID == tmdbID }), nil
+}
+
+// volumeIndex returns the index of a volume, or -1 if it is not stored
+func (m *Memory) volumeIndex(id primitive.ObjectID) int {
+	return slices.IndexFunc(m.volumes, func(volume model.Volume) bool { return volume.ID == id })
+}
+
+// GetVolumeFromID fetches volume from DB using specified ID and returns it via pointer
+func (m *Memory) GetVolumeFromID(ctx context.Context, id primitive.ObjectID) (*model.Volume, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.volumeIndex(id)
+	if i == -1 {
+		return nil, fmt.Errorf("volume '%s': %w", id.Hex(), model.ErrNotFound)
+	}
+	volume := memoryClone(m.volumes[i])
+	return &volume, nil
+}
+
+// GetVolumes returns the list of volumes in the DB
+func (m *Memory) GetVolumes(ctx context.Context) ([]model.Volume, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return memoryFind(m.volumes, func(*model.Volume) bool { return true }), nil
+}
+
+// AddVolume adds a volume to the DB
+func (m *Memory) AddVolume(ctx context.Context, volume *model.Volume) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.volumeIndex(volume.ID) != -1 {
+		return fmt.Errorf("volume '%s' already exists", volume.ID.Hex())
+	}
+	m.volumes = append(m.volumes, memoryClone(*volume))
+	return nil
+}
+
+// DeleteVolume deletes the volume from the DB and all the film which originated only from this volume
+func (m *Memory) DeleteVolume(ctx context.Context, volumeId primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.volumeIndex(volumeId)
+	if i == -1 {
+		return errors.New("unable to delete volume")
+	}
+
+	concerned, deleted := 0, 0
+	m.films = slices.DeleteFunc(m.films, func(film model.Film) bool {
+		if !slices.ContainsFunc(film.VolumeFiles, func(vf model.VolumeFile) bool { return vf.FromVolume == volumeId }) {
+			return false
+		}
+		concerned++
+		if slices.ContainsFunc(film.VolumeFiles, func(vf model.VolumeFile) bool { return vf.FromVolume != volumeId }) {
+			return false
+		}
+		deleted++
+		return true
+	})
+	for j := range m.films {
+		film := &m.films[j]
+		if slices.ContainsFunc(film.VolumeFiles, func(vf model.VolumeFile) bool { return vf.FromVolume == volumeId }) {
+			film.VolumeFiles = slices.DeleteFunc(film.VolumeFiles, func(vf model.VolumeFile) bool { return vf.FromVolume == volumeId })
+			film.Technical = film.GetTechnicalInfo()
+		}
+	}
+	log.Info().Any("volumeId", volumeId).Msgf("%d films are concerned with this volume deletion\n", concerned)
+	log.Info().Any("volumeId", volumeId).Msgf("%d films were removed from database\n", deleted)
+
+	m.volumes = slices.Delete(m.volumes, i, i+1)
+	log.Info().Any("volumeId", volumeId).Msg("Volume removed from database")
+	return nil
+}
+
+// filmIndexFromPath returns the index of the film having a file at the path, and the index of the file,
+// or -1 if no film has this file
+func (m *Memory) filmIndexFromPath(path string) (filmIndex, fileIndex int) {
+	for i, film := range m.films {
+		if j := slices.IndexFunc(film.VolumeFiles, func(vf model.VolumeFile) bool { return vf.Path == path }); j != -1 {
+			return i, j
+		}
+	}
+	return -1, -1
+}
+
+// findFilms returns copies of the films matching the predicate
+func (m *Memory) findFilms(match func(film *model.Film) bool) []model.Film {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return memoryFind(m.films, match)
+}
+
+// IsFilmPathPresent checks if a film path is present in the database
+func (m *Memory) IsFilmPathPresent(ctx context.Context, filmPath string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, _ := m.filmIndexFromPath(filmPath)
+	return i != -1
+}
+
+// IsSubtitlePathPresent checks if a subtitle path is present in the database
+func (m *Memory) IsSubtitlePathPresent(ctx context.Context, subPath string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, film := range m.films {
+		for _, volumeFile := range film.VolumeFiles {
+			if slices.ContainsFunc(volumeFile.ExtSubtitles, func(sub model.Subtitle) bool { return sub.Path == subPath }) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AddFilm adds a given film to the DB
+// If the film is already in the database, updates it
+func (m *Memory) AddFilm(ctx context.Context, film *model.Film) error {
+	film.Technical = film.GetTechnicalInfo()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.films, func(f model.Film) bool { return f.ID == film.ID })
+	if i == -1 {
+		m.films = append(m.films, memoryClone(*film))
+	} else {
+		m.films[i] = memoryClone(*film)
+	}
+	return nil
+}
+
+// MergeFilm adds the film to the DB, or adds its files to the film having the same TMDB ID, which then replaces it.
+// It returns whether the film was added
+func (m *Memory) MergeFilm(ctx context.Context, film *model.Film) (added bool, err error) {
+	film.Technical = film.GetTechnicalInfo()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.films, func(f model.Film) bool { return f.TMDBID == film.TMDBID })
+	if i == -1 {
+		m.films = append(m.films, memoryClone(*film))
+		return true, nil
+	}
+	addVolumeFiles(&m.films[i], memoryClone(film.VolumeFiles))
+	*film = memoryClone(m.films[i])
+	log.Debug().Str("path", film.VolumeFiles[len(film.VolumeFiles)-1].Path).Msg("Added volume as source of film to database")
+	return false, nil
+}
+
+// UpdateFilmMatch saves a film matched with another TMDB film: it replaces the film having the same ID, or, if another film
+// has its TMDB ID, its files are added to that film, which then replaces it, and it is deleted. It returns whether it was merged
+func (m *Memory) UpdateFilmMatch(ctx context.Context, film *model.Film) (merged bool, err error) {
+	film.Technical = film.GetTechnicalInfo()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.films, func(f model.Film) bool { return f.ID == film.ID })
+	if i == -1 {
+		return false, fmt.Errorf("film '%s': %w", film.ID.Hex(), model.ErrNotFound)
+	}
+	j := slices.IndexFunc(m.films, func(f model.Film) bool { return film.TMDBID > 0 && f.TMDBID == film.TMDBID && f.ID != film.ID })
+	if j == -1 {
+		m.films[i] = memoryClone(*film)
+		return false, nil
+	}
+	addVolumeFiles(&m.films[j], memoryClone(film.VolumeFiles))
+	*film = memoryClone(m.films[j])
+	m.films = slices.Delete(m.films, i, i+1)
+	return true, nil
+}
+
+// MergeDuplicates merges the films, people and collections stored several times with the same TMDB ID, and returns them
+// along with the files that are in several films, which are left as they are. With dryRun, nothing is merged
+func (m *Memory) MergeDuplicates(ctx context.Context, dryRun bool) ([]model.Duplicate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	filmMerge := mergeDuplicateFilms(memoryClone(m.films))
+	personDuplicates, deletedPeople := mergeDuplicateDocuments(model.DuplicatePerson, personKeys(m.people))
+	collectionDuplicates, deletedCollections := mergeDuplicateDocuments(model.DuplicateCollection, collectionKeys(m.collections))
+	duplicates := append(append(filmMerge.duplicates, personDuplicates...), collectionDuplicates...)
+	if dryRun {
+		return duplicates, nil
+	}
+
+	m.films = slices.DeleteFunc(m.films, func(film model.Film) bool { return slices.Contains(filmMerge.deleted, film.ID) })
+	for _, film := range filmMerge.saved {
+		i := slices.IndexFunc(m.films, func(f model.Film) bool { return f.ID == film.ID })
+		m.films[i] = film
+	}
+	m.people = slices.DeleteFunc(m.people, func(person model.Person) bool { return slices.Contains(deletedPeople, person.ID) })
+	m.collections = slices.DeleteFunc(m.collections, func(collection model.Collection) bool {
+		return slices.Contains(deletedCollections, collection.ID)
+	})
+	return duplicates, nil
+}
+
+// GetFilmFromPath retrieves a film from a path
+func (m *Memory) GetFilmFromPath(ctx context.Context, filmPath string) (*model.Film, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, _ := m.filmIndexFromPath(filmPath)
+	if i == -1 {
+		return nil, errors.New("could not get film from path")
+	}
+	film := memoryClone(m.films[i])
+	return &film, nil
+}
+
+// UpdateFilmVolumeFile updates the path to a film.
+// film: Film struct that has its path changed
+// oldPath: file path of the volumeFile that will be changed
+// newVolumeFile: VolumeFile struct that replaces the previous one
+func (m *Memory) UpdateFilmVolumeFile(ctx context.Context, film *model.Film, oldPath string, newVolumeFile model.VolumeFile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, j := m.filmIndexFromPath(oldPath)
+	if i == -1 {
+		return errors.New("could not update the volume file")
+	}
+	m.films[i].VolumeFiles[j] = memoryClone(newVolumeFile)
+	m.films[i].Technical = m.films[i].GetTechnicalInfo()
+	return nil
+}
+
+// UpdateFilmRatings saves the ratings of a film and the time they were scraped, leaving the rest of the stored film as it is.
+// It returns model.ErrNotFound if the film is not in the DB
+func (m *Memory) UpdateFilmRatings(ctx context.Context, film *model.Film) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.films, func(f model.Film) bool { return f.ID == film.ID })
+	if i == -1 {
+		return fmt.Errorf("film '%s': %w", film.ID.Hex(), model.ErrNotFound)
+	}
+	m.films[i].Ratings = memoryClone(film.Ratings)
+	m.films[i].RatingsRefreshed = film.RatingsRefreshed
+	return nil
+}
+
+// DeleteFilm deletes a film
+func (m *Memory) DeleteFilm(ctx context.Context, ID primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := len(m.films)
+	m.films = slices.DeleteFunc(m.films, func(film model.Film) bool { return film.ID == ID })
+	if len(m.films) == count {
+		return errors.New("could not delete film")
+	}
+	return nil
+}
+
+// DeleteFilmVolumeFile removes a film from the database
+// If the film has only 1 volume file, then the film is entirely deleted
+func (m *Memory) DeleteFilmVolumeFile(ctx context.Context, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, j := m.filmIndexFromPath(path)
+	if i == -1 {
+		return errors.New("could not get film from path")
+	}
+	// If it only had 1 volumeFile, remove the film entirely
+	if len(m.films[i].VolumeFiles) == 1 {
+		m.films = slices.Delete(m.films, i, i+1)
+		return nil
+	}
+	m.films[i].VolumeFiles = slices.Delete(m.films[i].VolumeFiles, j, j+1)
+	m.films[i].Technical = m.films[i].GetTechnicalInfo()
+	return nil
+}
+
+// RemoveSubtitleFile removes a film subtitle from the database
+func (m *Memory) RemoveSubtitleFile(ctx context.Context, mediaPath, subtitlePath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, j := m.filmIndexFromPath(mediaPath)
+	if i == -1 {
+		return errors.New("could not get film from path")
+	}
+	volumeFile := &m.films[i].VolumeFiles[j]
+	subtitleIndex := slices.IndexFunc(volumeFile.ExtSubtitles, func(sub model.Subtitle) bool {
+		return sub.Path == subtitlePath
+	})
+	if subtitleIndex == -1 {
+		return errors.New("cannot remove subtitle from film (no matching subtitle file")
+	}
+	volumeFile.ExtSubtitles = slices.Delete(volumeFile.ExtSubtitles, subtitleIndex, subtitleIndex+1)
+	m.films[i].Technical = m.films[i].GetTechnicalInfo()
+	return nil
+}
+
+// AddSubtitleToFilmPath adds the subtitle to a film given the film path
+func (m *Memory) AddSubtitleToFilmPath(ctx context.Context, filmFilePath string, sub model.Subtitle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, j := m.filmIndexFromPath(filmFilePath)
+	if i == -1 {
+		return errors.New("could not get film from path")
+	}
+	volumeFile := &m.films[i].VolumeFiles[j]
+	if slices.Contains(volumeFile.ExtSubtitles, sub) {
+		return errors.New("subtitle is already added to media")
+	}
+	volumeFile.ExtSubtitles = append(volumeFile.ExtSubtitles, sub)
+	m.films[i].Technical = m.films[i].GetTechnicalInfo()
+	return nil
+}
+
+// GetFilmFromExternalSubtitle returns a film from its external subtitle path
+func (m *Memory) GetFilmFromExternalSubtitle(ctx context.Context, subtitlePath string) (model.Film, error) {
+	films := m.findFilms(func(film *model.Film) bool {
+		for _, volumeFile := range film.VolumeFiles {
+			if slices.ContainsFunc(volumeFile.ExtSubtitles, func(sub model.Subtitle) bool { return sub.Path == subtitlePath }) {
+				return true
+			}
+		}
+		return false
+	})
+	if len(films) == 0 {
+		return model.Film{}, fmt.Errorf("film with subtitle %q: %w", subtitlePath, model.ErrNotFound)
+	}
+	return films[0], nil
+}
+
+// savePerson adds the person, or replaces the one stored with the same ID
+func (m *Memory) savePerson(person *model.Person) {
+	i := slices.IndexFunc(m.people, func(p model.Person) bool { return p.ID == person.ID })
+	if i == -1 {
+		m.people = append(m.people, memoryClone(*person))
+	} else {
+		m.people[i] = memoryClone(*person)
+	}
+}
+
+// IsPersonPresent checks if a person is already registered in the DB
+func (m *Memory) IsPersonPresent(ctx context.Context, personID int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.ContainsFunc(m.people, func(person model.Person) bool { return person.TMDBID == personID })
+}
+
+// AddPerson adds a person to the DB, unless a person with the same TMDB ID is already there
+func (m *Memory) AddPerson(ctx context.Context, person *model.Person) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !slices.ContainsFunc(m.people, func(p model.Person) bool { return p.TMDBID == person.TMDBID }) {
+		m.savePerson(person)
+	}
+}
+
+// UpdatePerson updates a person in the DB, adding it if it is not present yet
+func (m *Memory) UpdatePerson(ctx context.Context, person *model.Person) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// People are identified by their TMDB ID, the person replaces the one stored with another ID
+	m.people = slices.DeleteFunc(m.people, func(p model.Person) bool { return p.TMDBID == person.TMDBID && p.ID != person.ID })
+	m.savePerson(person)
+	return nil
+}
+
+// DeletePerson removes a person from the DB
+func (m *Memory) DeletePerson(ctx context.Context, personTMDBID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.people = slices.DeleteFunc(m.people, func(person model.Person) bool { return person.TMDBID == personTMDBID })
+	return nil
+}
+
+// AddPersonRetry adds a person whose details will be fetched again, or updates its next attempt
+func (m *Memory) AddPersonRetry(ctx context.Context, retry *model.PersonRetry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.personRetries[retry.TMDBID] = memoryClone(*retry)
+	return nil
+}
+
+// GetPersonRetries returns at most limit retries whose next attempt is before the given time, earliest first
+func (m *Memory) GetPersonRetries(ctx context.Context, before time.Time, limit int64) (retries []model.PersonRetry, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, retry := range m.personRetries {
+		if !retry.NextAttempt.After(before) {
+			retries = append(retries, memoryClone(retry))
+		}
+	}
+	slices.SortFunc(retries, func(a, b model.PersonRetry) int {
+		return compareFirst(a.NextAttempt.Compare(b.NextAttempt), cmp.Compare(a.TMDBID, b.TMDBID))
+	})
+	return memoryLimit(retries, limit), nil
+}
+
+// DeletePersonRetry removes the retry of a person, if there is one
+func (m *Memory) DeletePersonRetry(ctx context.Context, personTMDBID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.personRetries, personTMDBID)
+	return nil
+}
+
+// findPeople returns copies of the people matching the predicate
+func (m *Memory) findPeople(match func(person *model.Person) bool) []model.Person {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return memoryFind(m.people, match)
+}
+
+// GetPeopleToRefresh returns at most limit people whose details were fetched before the given time or without a filmography, oldest first
+func (m *Memory) GetPeopleToRefresh(ctx context.Context, before time.Time, limit int64) ([]model.Person, error) {
+	people := m.findPeople(func(person *model.Person) bool {
+		return person.LastRefreshed.Before(before) || person.Filmography == nil
+	})
+	slices.SortStableFunc(people, func(a, b model.Person) int {
+		return a.LastRefreshed.Compare(b.LastRefreshed)
+	})
+	return memoryLimit(people, limit), nil
+}
+
+// GetPersonFromID returns the Person struct
+func (m *Memory) GetPersonFromID(ctx context.Context, ID primitive.ObjectID) (*model.Person, error) {
+	people := m.findPeople(func(person *model.Person) bool { return person.ID == ID })
+	if len(people) == 0 {
+		return &model.Person{}, fmt.Errorf("person '%s': %w", ID.Hex(), model.ErrNotFound)
+	}
+	return &people[0], nil
+}
+
+// GetPersonFromTMDBID returns the Person struct, which is empty if the person is not in the DB
+func (m *Memory) GetPersonFromTMDBID(ctx context.Context, TMDBID int64) (*model.Person, error) {
+	people := m.findPeople(func(person *model.Person) bool { return person.TMDBID == TMDBID })
+	if len(people) == 0 {
+		return &model.Person{}, fmt.Errorf("person with TMDB ID %d: %w", TMDBID, model.ErrNotFound)
+	}
+	return &people[0], nil
+}
+
+// GetPeopleFiltered returns the people of the library's films matching the filter with their number of films,
+// sorted and limited by the list options, and the total number of matching people
+func (m *Memory) GetPeopleFiltered(ctx context.Context, filter model.PersonFilter, listOptions model.ListOptions) ([]model.PersonFilmCount, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Number of films and roles of every person credited in a film, the crew being limited to the jobs of the crew roles
+	type personFilms struct {
+		filmCount int
+		roles     map[string]bool
+	}
+	credits := make(map[int64]*personFilms)
+	for _, film := range m.films {
+		filmRoles := make(map[int64][]string)
+		for _, character := range film.Characters {
+			filmRoles[character.ActorID] = append(filmRoles[character.ActorID], model.PersonRoleActor)
+		}
+		for _, directorID := range film.Directors {
+			filmRoles[directorID] = append(filmRoles[directorID], model.PersonRoleDirector)
+		}
+		for _, writerID := range film.Writers {
+			filmRoles[writerID] = append(filmRoles[writerID], model.PersonRoleWriter)
+		}
+		for _, credit := range film.Crew {
+			if role, ok := model.GetCrewRoleFromJob(credit.Job); ok {
+				filmRoles[credit.PersonID] = append(filmRoles[credit.PersonID], role.Slug)
+			}
+		}
+		for personID, roles := range filmRoles {
+			if filter.TMDBIDs != nil && !slices.Contains(filter.TMDBIDs, personID) {
+				continue
+			}
+			if credits[personID] == nil {
+				credits[personID] = &personFilms{roles: make(map[string]bool)}
+			}
+			credits[personID].filmCount++
+			for _, role := range roles {
+				credits[personID].roles[role] = true
+			}
+		}
+	}
+
+	var people []model.PersonFilmCount
+	for _, person := range m.people {
+		credit, ok := credits[person.TMDBID]
+		if !ok || (filter.Role != "" && !credit.roles[filter.Role]) {
+			continue
+		}
+		people = append(people, model.PersonFilmCount{Person: person, FilmCount: credit.filmCount})
+	}
+
+	var compare func(a, b model.PersonFilmCount) int
+	switch listOptions.Sort {
+	case model.PersonSortFilms:
+		compare = func(a, b model.PersonFilmCount) int {
+			return compareFirst(memoryOrder(listOptions, cmp.Compare(a.FilmCount, b.FilmCount)), m.listCollator.CompareString(a.Name, b.Name))
+		}
+	case model.PersonSortBirthday:
+		// People without a known birthday come last
+		compare = func(a, b model.PersonFilmCount) int {
+			if (a.Birthday == "") != (b.Birthday == "") {
+				return strings.Compare(b.Birthday, a.Birthday)
+			}
+			return memoryOrder(listOptions, strings.Compare(a.Birthday, b.Birthday))
+		}
+	default:
+		compare = func(a, b model.PersonFilmCount) int {
+			return memoryOrder(listOptions, m.listCollator.CompareString(a.Name, b.Name))
+		}
+	}
+	slices.SortFunc(people, func(a, b model.PersonFilmCount) int {
+		return compareFirst(compare(a, b), memoryOrder(listOptions, cmp.Compare(a.TMDBID, b.TMDBID)))
+	})
+
+	page := memoryPage(people, listOptions)
+	for i := range page {
+		page[i].Person = memoryClone(page[i].Person)
+	}
+	return page, int64(len(people)), nil
+}
+
+// GetPeopleWithName returns the people with a name, ignoring its case and its accents
+func (m *Memory) GetPeopleWithName(ctx context.Context, name string) ([]model.Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return memoryFind(m.people, func(person *model.Person) bool {
+		return m.nameCollator.CompareString(person.Name, name) == 0
+	}), nil
+}
+
+func (m *Memory) GetPeople(ctx context.Context) ([]model.Person, error) {
+	return m.findPeople(func(*model.Person) bool { return true }), nil
+}
+
+// GetFilmFromID returns a film from its ID, or model.ErrNotFound if it is not in the DB
+func (m *Memory) GetFilmFromID(ctx context.Context, id primitive.ObjectID) (*model.Film, error) {
+	films := m.findFilms(func(film *model.Film) bool { return film.ID == id })
+	if len(films) == 0 {
+		return nil, fmt.Errorf("film '%s': %w", id.Hex(), model.ErrNotFound)
+	}
+	return &films[0], nil
+}
+
+func (m *Memory) GetFilmCount(ctx context.Context) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.films))
+}
+
+// GetFilms returns a slice of Film
+func (m *Memory) GetFilms(ctx context.Context) ([]model.Film, error) {
+	films := m.findFilms(func(*model.Film) bool { return true })
+	m.sortFilms(films, model.ListOptions{Sort: model.FilmSortTitle})
+	return films, nil
+}
+
+// GetFilmsToRefresh returns at most limit films whose details were fetched before the given time, oldest first
+func (m *Memory) GetFilmsToRefresh(ctx context.Context, before time.Time, limit int64) ([]model.Film, error) {
+	return m.getStaleFilms(func(film *model.Film) time.Time { return film.LastRefreshed }, before, limit), nil
+}
+
+// GetFilmsToRefreshRatings returns at most limit films whose ratings were scraped before the given time, oldest first
+func (m *Memory) GetFilmsToRefreshRatings(ctx context.Context, before time.Time, limit int64) ([]model.Film, error) {
+	return m.getStaleFilms(func(film *model.Film) time.Time { return film.RatingsRefreshed }, before, limit), nil
+}
+
+// getStaleFilms returns at most limit films matched on TMDB, whose date is before the given time
+func (m *Memory) getStaleFilms(date func(film *model.Film) time.Time, before time.Time, limit int64) []model.Film {
+	films := m.findFilms(func(film *model.Film) bool {
+		return film.TMDBID != 0 && date(film).Before(before)
+	})
+	slices.SortStableFunc(films, func(a, b model.Film) int {
+		return date(&a).Compare(date(&b))
+	})
+	return memoryLimit(films, limit)
+}
+
+// sortFilms sorts the films by the sort order of the list options
+func (m *Memory) sortFilms(films []model.Film, listOptions model.ListOptions) {
+	var compare func(a, b *model.Film) int
+	switch listOptions.Sort {
+	case model.FilmSortYear:
+		compare = func(a, b *model.Film) int { return cmp.Compare(a.ReleaseYear, b.ReleaseYear) }
+	case model.FilmSortDateAdded:
+		compare = func(a, b *model.Film) int { return a.DateAdded.Compare(b.DateAdded) }
+	case model.FilmSortRuntime:
+		compare = func(a, b *model.Film) int { return cmp.Compare(a.Runtime, b.Runtime) }
+	case model.FilmSortRating:
+		compare = func(a, b *model.Film) int {
+			return cmp.Compare(a.Rating(model.RatingSourceIMDb).Value, b.Rating(model.RatingSourceIMDb).Value)
+		}
+	default:
+		compare = func(a, b *model.Film) int { return m.listCollator.CompareString(a.Title, b.Title) }
+	}
+	slices.SortFunc(films, func(a, b model.Film) int {
+		return memoryOrder(listOptions, compareFirst(compare(&a, &b), compareIDs(a.ID, b.ID)))
+	})
+}
+
+// GetFilmsFiltered returns the films matching the filter, sorted and limited by the list options, and the total number of matching films
+func (m *Memory) GetFilmsFiltered(ctx context.Context, filter model.FilmFilter, listOptions model.ListOptions) ([]model.Film, int64, error) {
+	films := m.findFilms(func(film *model.Film) bool { return matchFilmFilter(film, filter) })
+	m.mu.Lock()
+	m.sortFilms(films, listOptions)
+	m.mu.Unlock()
+	return memoryPage(films, listOptions), int64(len(films)), nil
+}
+
+// GetRandomFilms returns at most number films picked at random among the ones matching the filter
+func (m *Memory) GetRandomFilms(ctx context.Context, filter model.FilmFilter, number int64) ([]model.Film, error) {
+	films := m.findFilms(func(film *model.Film) bool { return matchFilmFilter(film, filter) })
+	rand.Shuffle(len(films), func(i, j int) {
+		films[i], films[j] = films[j], films[i]
+	})
+	return memoryLimit(films, number), nil
+}
+
+// GetDirectorsByFilmCount returns the TMDB IDs of the directors with the most films, from the one with the most
+func (m *Memory) GetDirectorsByFilmCount(ctx context.Context, number int64) ([]int64, error) {
+	m.mu.Lock()
+	counts := make(map[int64]int)
+	for _, film := range m.films {
+		for _, directorID := range film.Directors {
+			counts[directorID]++
+		}
+	}
+	m.mu.Unlock()
+
+	directorIDs := make([]int64, 0, len(counts))
+	for directorID := range counts {
+		directorIDs = append(directorIDs, directorID)
+	}
+	slices.SortFunc(directorIDs, func(a, b int64) int {
+		return compareFirst(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+	return memoryLimit(directorIDs, number), nil
+}
+
+// containsFold returns true if the values contain the value, compared case-insensitively
+func containsFold(values []string, value string) bool {
+	return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, value) })
+}
+
+// matchFilmFilter returns true if the film matches the filter
+func matchFilmFilter(film *model.Film, filter model.FilmFilter) bool {
+	switch {
+	case len(filter.Years) > 0 && !slices.Contains(filter.Years, film.ReleaseYear),
+		filter.Genre != "" && !containsFold(film.Genres, filter.Genre),
+		filter.Country != "" && !containsFold(film.ProdCountries, filter.Country),
+		filter.IDs != nil && !slices.Contains(filter.IDs, film.ID),
+		filter.Resolution != "" && !containsFold(film.Technical.Resolutions, filter.Resolution),
+		filter.VideoCodec != "" && !containsFold(film.Technical.VideoCodecs, filter.VideoCodec),
+		filter.HDR != "" && !matchFilmHDR(film, filter.HDR),
+		filter.AudioLanguage != "" && !containsFold(film.Technical.AudioLanguages, filter.AudioLanguage),
+		filter.SubtitleLanguage != "" && !containsFold(film.Technical.SubtitleLanguages, filter.SubtitleLanguage),
+		filter.NoSubtitleLanguage != "" && containsFold(film.Technical.SubtitleLanguages, filter.NoSubtitleLanguage),
+		filter.Query != nil && !matchFilmQuery(film, filter.Query):
+		return false
+	}
+	return true
+}
+
+// matchFilmHDR returns true if the film has the HDR format, or any or no HDR format for HDRAny and HDRNone
+func matchFilmHDR(film *model.Film, hdr string) bool {
+	switch hdr {
+	case model.HDRAny:
+		return len(film.Technical.HDRFormats) > 0
+	case model.HDRNone:
+		return len(film.Technical.HDRFormats) == 0
+	}
+	return containsFold(film.Technical.HDRFormats, hdr)
+}
+
+// filmQueryValues returns the values of a list field of the query language
+func filmQueryValues(film *model.Film, field string) ([]string, bool) {
+	switch field {
+	case model.QueryFieldGenre:
+		return film.Genres, true
+	case model.QueryFieldCountry:
+		return film.ProdCountries, true
+	case model.QueryFieldResolution:
+		return film.Technical.Resolutions, true
+	case model.QueryFieldCodec:
+		return film.Technical.VideoCodecs, true
+	case model.QueryFieldHDR:
+		return film.Technical.HDRFormats, true
+	case model.QueryFieldAudio:
+		return film.Technical.AudioLanguages, true
+	case model.QueryFieldSubtitles:
+		return film.Technical.SubtitleLanguages, true
+	}
+	return nil, false
+}
+
+// filmQueryNumber returns the value of a numeric field of the query language, 0 if it is unknown
+func filmQueryNumber(film *model.Film, field string) (float64, bool) {
+	switch field {
+	case model.QueryFieldYear:
+		return float64(film.ReleaseYear), true
+	case model.QueryFieldRuntime:
+		return float64(film.Runtime), true
+	case model.QueryFieldRating:
+		return film.Rating(model.RatingSourceIMDb).Value, true
+	}
+	return 0, false
+}
+
+// filmQueryPeople returns the TMDB IDs of the people of a film in a role of the query language
+func filmQueryPeople(film *model.Film, field string) ([]int64, bool) {
+	switch field {
+	case model.QueryFieldDirector:
+		return film.Directors, true
+	case model.QueryFieldWriter:
+		return film.Writers, true
+	case model.QueryFieldActor:
+		actorIDs := make([]int64, 0, len(film.Characters))
+		for _, character := range film.Characters {
+			actorIDs = append(actorIDs, character.ActorID)
+		}
+		return actorIDs, true
+	}
+	return nil, false
+}
+
+// matchFilmQuery returns true if the film matches a resolved film query
+func matchFilmQuery(film *model.Film, node model.QueryNode) bool {
+	switch n := node.(type) {
+	case model.QueryAnd:
+		return !slices.ContainsFunc(n.Nodes, func(node model.QueryNode) bool { return !matchFilmQuery(film, node) })
+	case model.QueryOr:
+		return slices.ContainsFunc(n.Nodes, func(node model.QueryNode) bool { return matchFilmQuery(film, node) })
+	case model.QueryNot:
+		return !matchFilmQuery(film, n.Node)
+	case model.QueryMatch:
+		if n.Field == model.QueryFieldHDR {
+			return matchFilmHDR(film, n.Value)
+		}
+		if values, ok := filmQueryValues(film, n.Field); ok {
+			return containsFold(values, n.Value)
+		}
+	case model.QueryComparison:
+		if value, ok := filmQueryNumber(film, n.Field); ok {
+			// Unknown values are 0, and must not match
+			if value <= 0 {
+				return false
+			}
+			switch n.Operator {
+			case model.QueryOperatorEqual:
+				return value == n.Value
+			case model.QueryOperatorLess:
+				return value < n.Value
+			case model.QueryOperatorLessOrEqual:
+				return value <= n.Value
+			case model.QueryOperatorGreater:
+				return value > n.Value
+			case model.QueryOperatorGreaterOrEqual:
+				return value >= n.Value
+			}
+		}
+	case model.QueryPerson:
+		if personIDs, ok := filmQueryPeople(film, n.Field); ok {
+			return slices.ContainsFunc(personIDs, func(personID int64) bool { return slices.Contains(n.TMDBIDs, personID) })
+		}
+	case model.QueryText:
+		return slices.Contains(n.FilmIDs, film.ID)
+	}
+	log.Error().Type("node", node).Msg("Unknown film query node")
+	return false
+}
+
+// GetFilmsFromVolume retrieves all films from a specific volume ID
+func (m *Memory) GetFilmsFromVolume(ctx context.Context, id primitive.ObjectID) []model.Film {
+	return m.findFilms(func(film *model.Film) bool {
+		return slices.ContainsFunc(film.VolumeFiles, func(vf model.VolumeFile) bool { return vf.FromVolume == id })
+	})
+}
+
+// GetFilmsWithActor returns a list of films starring desired actor ID
+func (m *Memory) GetFilmsWithActor(ctx context.Context, actorID int64) []model.Film {
+	return m.findFilms(func(film *model.Film) bool {
+		return slices.ContainsFunc(film.Characters, func(character model.Character) bool { return character.ActorID == actorID })
+	})
+}
+
+// GetFilmsWithDirector returns a list of films directed by desired director ID
+func (m *Memory) GetFilmsWithDirector(ctx context.Context, directorID int64) []model.Film {
+	return m.findFilms(func(film *model.Film) bool { return slices.Contains(film.Directors, directorID) })
+}
+
+// GetFilmsWithWriter returns a list of films written by desired writer ID
+func (m *Memory) GetFilmsWithWriter(ctx context.Context, writerID int64) []model.Film {
+	return m.findFilms(func(film *model.Film) bool { return slices.Contains(film.Writers, writerID) })
+}
+
+// GetFilmsWithCrewJob returns a list of films where a person had a job in the crew
+func (m *Memory) GetFilmsWithCrewJob(ctx context.Context, personID int64, job string) []model.Film {
+	return m.findFilms(func(film *model.Film) bool {
+		return slices.ContainsFunc(film.Crew, func(credit model.CrewCredit) bool { return credit.PersonID == personID && credit.Job == job })
+	})
+}
+
+func (m *Memory) SearchTorrents(ctx context.Context, search, category string, page uint) ([]model.RarbgTorrent, error) {
+	return nil, errMemoryTorrents
+}
+
+func (m *Memory) GetTorrents(ctx context.Context, imdbID string, offset, limit int64) ([]model.RarbgTorrent, error) {
+	return nil, errMemoryTorrents
+}
+
+func (m *Memory) GetAllTVTorrents(ctx context.Context, offset, limit int64) ([]model.RarbgTorrent, error) {
+	return nil, errMemoryTorrents
+}
+
+func (m *Memory) GetTVTorrents(ctx context.Context, imdbID, season, episode string, offset, limit int64) ([]model.RarbgTorrent, error) {
+	return nil, errMemoryTorrents
+}
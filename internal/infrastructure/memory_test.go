@@ -0,0 +1,37 @@
+package infrastructure
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+func TestMemoryFixture(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	require.NoError(t, m.LoadFixture(ctx, "testdata/library.json"))
+
+	volumes, err := m.GetVolumes(ctx)
+	require.NoError(t, err)
+	require.Len(t, volumes, 1)
+	films := m.GetFilmsFromVolume(ctx, volumes[0].ID)
+	require.Len(t, films, 1)
+	assert.False(t, films[0].ID.IsZero())
+	assert.Equal(t, []string{"1080p"}, films[0].Technical.Resolutions)
+	assert.Equal(t, []string{"fr"}, films[0].Technical.SubtitleLanguages)
+	assert.True(t, m.IsSubtitlePathPresent(ctx, "/films/Heat.1995.fr.srt"))
+
+	people, total, err := m.GetPeopleFiltered(ctx, model.PersonFilter{Role: model.PersonRoleDirector}, model.ListOptions{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, total)
+	assert.Equal(t, "Michael Mann", people[0].Name)
+	home, err := m.GetHomeSettings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []model.HomeRow{{Type: model.HomeRowRandom}}, home.Rows)
+
+	assert.Error(t, m.LoadFixture(ctx, "testdata/imdb_no_rating.html"))
+}
@@ -1,19 +1,21 @@
 package infrastructure
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
 	"net/url"
-	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/agnivade/levenshtein"
@@ -29,26 +31,121 @@ type Metadata interface {
 	GetBackdropLink(key string) string
 	GetPhotoLink(key string) string
 
-	GetTMDBIDFromLink(inputUrl string) (tmdbID int, err error)
-	GetPersonDetails(personID int64) *model.Person
-	CreateFilm(file string, volumeID primitive.ObjectID, subFiles []string) *model.Film
-	FetchFilmTMDBID(f *model.Film) error
-	UpdateFilmDetails(film *model.Film)
+	GetTMDBIDFromLink(ctx context.Context, inputUrl string) (tmdbID int, err error)
+	GetPersonDetails(ctx context.Context, personID int64) *model.Person
+	GetCollectionDetails(ctx context.Context, collectionID int64) (*model.Collection, error)
+	CreateFilm(ctx context.Context, file string, volumeID primitive.ObjectID, subFiles []string) *model.Film
+	FetchFilmTMDBID(ctx context.Context, f *model.Film) error
+	UpdateFilmDetails(ctx context.Context, film *model.Film) error
+	UpdateFilmRatings(ctx context.Context, film *model.Film)
 }
 
 type MetadataWrapper struct {
-	client *tmdb.Client
+	tmdb             *tmdbClient
+	ratingsProviders []RatingsProvider
+	locale           *metadataLocale
+	mediaInfoPath    string
 }
 
-// NewMetadataWrapper initializes a MetadataWrapper
-func NewMetadataWrapper(tmdbAPIKey string) (*MetadataWrapper, error) {
-	client, err := tmdb.Init(tmdbAPIKey)
+// tmdbClient holds the TMDB client, whose API key can be changed while the server runs
+type tmdbClient struct {
+	sync.RWMutex
+	client  *tmdb.Client // nil as long as no API key is set
+	timeout time.Duration
+}
+
+// metadataLocale holds the metadata settings, which can be changed while the server runs
+type metadataLocale struct {
+	sync.RWMutex
+	settings model.MetadataSettings
+}
+
+// DefaultMetadataSettings are used until other settings are set
+var DefaultMetadataSettings = model.MetadataSettings{
+	Language:               "en-US",
+	FallbackLanguage:       "en-US",
+	CertificationCountries: []string{"US"},
+}
+
+// NewMetadataWrapper initializes a MetadataWrapper, whose requests to TMDB time out after tmdbTimeout.
+// The technical details of the files are read with the mediainfo executable at mediaInfoPath.
+// TMDB cannot be queried until its API key is set with SetTMDBAPIKey
+func NewMetadataWrapper(mediaInfoPath string, tmdbTimeout time.Duration) *MetadataWrapper {
+	return &MetadataWrapper{
+		tmdb: &tmdbClient{timeout: tmdbTimeout},
+		ratingsProviders: []RatingsProvider{
+			NewIMDbRatingsProvider(""),
+			NewLetterboxdRatingsProvider(""),
+		},
+		locale:        &metadataLocale{settings: DefaultMetadataSettings},
+		mediaInfoPath: mediaInfoPath,
+	}
+}
+
+// SetTMDBAPIKey sets the API key used for the next requests to TMDB
+func (mw MetadataWrapper) SetTMDBAPIKey(apiKey string) error {
+	client, err := tmdb.Init(apiKey)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("error while initializing the TMDB client: %w", err)
+	}
+	mw.tmdb.Lock()
+	defer mw.tmdb.Unlock()
+	client.SetClientConfig(http.Client{Timeout: mw.tmdb.timeout})
+	mw.tmdb.client = client
+	return nil
+}
+
+// CheckTMDBAPIKey checks that TMDB accepts the API key, by fetching its configuration
+func (mw MetadataWrapper) CheckTMDBAPIKey(ctx context.Context) error {
+	_, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.ConfigurationAPI, error) {
+		return client.GetConfigurationAPI()
+	})
+	if err != nil {
+		return fmt.Errorf("error while checking the TMDB API key: %w", err)
+	}
+	return nil
+}
+
+// SetMetadataSettings sets the language and certification countries used for the next fetches
+func (mw MetadataWrapper) SetMetadataSettings(settings model.MetadataSettings) {
+	mw.locale.Lock()
+	defer mw.locale.Unlock()
+	mw.locale.settings = settings
+}
+
+// getMetadataSettings returns the current language and certification countries
+func (mw MetadataWrapper) getMetadataSettings() model.MetadataSettings {
+	mw.locale.RLock()
+	defer mw.locale.RUnlock()
+	return mw.locale.settings
+}
+
+// withClient returns the result of a TMDB call, or the error of the context if it is done first.
+// The TMDB client does not take a context, so the call itself is only stopped by the timeout of its HTTP client
+func withClient[T any](ctx context.Context, tc *tmdbClient, call func(client *tmdb.Client) (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	tc.RLock()
+	client := tc.client
+	tc.RUnlock()
+	if client == nil {
+		var zero T
+		return zero, errors.New("the TMDB API key is not set")
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := call(client)
+		done <- result{value, err}
+	}()
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
 	}
-	return &MetadataWrapper{
-		client: client,
-	}, nil
 }
 
 const (
@@ -73,9 +170,9 @@ func (mw MetadataWrapper) GetPhotoLink(key string) string {
 	return tmdbImageURL + tmdb.W342 + key
 }
 
-func (mw MetadataWrapper) CreateFilm(file string, volumeID primitive.ObjectID, subFiles []string) *model.Film {
+func (mw MetadataWrapper) CreateFilm(ctx context.Context, file string, volumeID primitive.ObjectID, subFiles []string) *model.Film {
 	filename := filepath.Base(file)
-	mediaInfo, err := mw.getMediaInfo(os.Getenv("MEDIAINFO_PATH"), file)
+	mediaInfo, err := mw.getMediaInfo(ctx, mw.mediaInfoPath, file)
 	if err != nil {
 		log.Error().Str("file", file).Msg("Could not get media info")
 	}
@@ -139,12 +236,14 @@ func (mw MetadataWrapper) CreateFilm(file string, volumeID primitive.ObjectID, s
 }
 
 // FetchFilmTMDBID fetches media ID from TMDB and stores it
-func (mw MetadataWrapper) FetchFilmTMDBID(f *model.Film) error {
+func (mw MetadataWrapper) FetchFilmTMDBID(ctx context.Context, f *model.Film) error {
 	urlOptions := make(map[string]string)
 	if f.ReleaseYear != 0 {
 		urlOptions["year"] = strconv.Itoa(f.ReleaseYear)
 	}
-	tmdbSearchRes, err := mw.client.GetSearchMovies(f.Name, urlOptions)
+	tmdbSearchRes, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.SearchMovies, error) {
+		return client.GetSearchMovies(f.Name, urlOptions)
+	})
 	if err != nil {
 		return err
 	}
@@ -165,23 +264,33 @@ func (mw MetadataWrapper) FetchFilmTMDBID(f *model.Film) error {
 	return nil
 }
 
-func (mw MetadataWrapper) UpdateFilmDetails(film *model.Film) {
+// UpdateFilmDetails fetches the details, the titles, the ratings and the credits of a film.
+// It fails if the details or the credits cannot be fetched, the film being then partly updated
+func (mw MetadataWrapper) UpdateFilmDetails(ctx context.Context, film *model.Film) error {
+	settings := mw.getMetadataSettings()
+
 	// Get details
-	details, err := mw.client.GetMovieDetails(film.TMDBID, nil)
+	details, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.MovieDetails, error) {
+		return client.GetMovieDetails(film.TMDBID, map[string]string{"language": settings.Language})
+	})
 	if err != nil {
-		log.Error().Err(err).Int("tmdbID", film.TMDBID).Msg("Unable to fetch film details from TMDB")
+		return fmt.Errorf("unable to fetch details of film %d from TMDB: %w", film.TMDBID, err)
 	}
+	mw.fillUntranslatedDetails(ctx, details, settings)
+
 	film.IMDbID = details.IMDbID
 	film.Title = details.Title
 	film.OriginalTitle = details.OriginalTitle
-	film.Year = details.ReleaseDate[:4]
-	film.Runtime = strconv.Itoa(details.Runtime)
+	film.Year, _ = strconv.Atoi(details.ReleaseDate[:min(4, len(details.ReleaseDate))])
+	film.Runtime = details.Runtime
 	film.Tagline = details.Tagline
 	film.Overview = details.Overview
 	film.PosterPath = details.PosterPath
 	film.BackdropPath = details.BackdropPath
-	film.IMDbRating = mw.getIMDbRating(film.IMDbID)
-	film.LetterboxdRating = mw.getLetterboxdRating(film.IMDbID)
+	film.CollectionID = details.BelongsToCollection.ID
+	film.LastRefreshed = time.Now()
+	mw.updateFilmTitles(ctx, film)
+	mw.UpdateFilmRatings(ctx, film)
 
 	// Set genres
 	film.Genres = nil
@@ -190,53 +299,173 @@ func (mw MetadataWrapper) UpdateFilmDetails(film *model.Film) {
 	}
 
 	// Set classification
-	releaseDates, err := mw.client.GetMovieReleaseDates(film.TMDBID)
+	releaseDates, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.MovieReleaseDates, error) {
+		return client.GetMovieReleaseDates(film.TMDBID)
+	})
 	if err != nil {
 		log.Error().Err(err).Int("tmdbID", film.TMDBID).Msg("Unable to fetch film release dates from TMDB")
 	} else {
-		for _, releasesCountry := range releaseDates.Results {
-			if releasesCountry.Iso3166_1 == "US" {
-				film.Classification = releasesCountry.ReleaseDates[0].Certification
-				break
-			}
-		}
+		film.Classification = getCertification(releaseDates, settings.CertificationCountries)
 	}
 
 	// Set cast and crew
-	credits, err := mw.client.GetMovieCredits(film.TMDBID, nil)
+	credits, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.MovieCredits, error) {
+		return client.GetMovieCredits(film.TMDBID, nil)
+	})
 	if err != nil {
-		log.Error().Err(err).Int("tmdbID", film.TMDBID).Msg("Unable to fetch film credits from TMDB")
-	} else {
-		film.Directors = nil
-		film.Writers = nil
-		film.Characters = nil
-		for _, crew := range credits.Crew {
-			if crew.Job == "Director" {
-				film.Directors = append(film.Directors, crew.ID)
-			}
-			if crew.Department == "Writing" {
-				if !slices.Contains(film.Writers, crew.ID) {
-					film.Writers = append(film.Writers, crew.ID)
-				}
-			}
+		return fmt.Errorf("unable to fetch credits of film %d from TMDB: %w", film.TMDBID, err)
+	}
+	film.Directors = nil
+	film.Writers = nil
+	film.Characters = nil
+	film.Crew = nil
+	for _, crew := range credits.Crew {
+		film.Crew = append(film.Crew, model.CrewCredit{PersonID: crew.ID, Name: crew.Name, Department: crew.Department, Job: crew.Job})
+		if crew.Job == "Director" {
+			film.Directors = append(film.Directors, crew.ID)
 		}
-		for _, cast := range credits.Cast {
-			film.Characters = append(film.Characters, model.Character{CharacterName: cast.Character, ActorID: cast.ID})
+		if crew.Department == "Writing" {
+			if !slices.Contains(film.Writers, crew.ID) {
+				film.Writers = append(film.Writers, crew.ID)
+			}
 		}
 	}
+	for _, cast := range credits.Cast {
+		film.Characters = append(film.Characters, model.Character{CharacterName: cast.Character, ActorID: cast.ID})
+	}
 
 	film.ProdCountries = nil
 	// Set production countries
 	for _, country := range details.ProductionCountries {
 		film.ProdCountries = append(film.ProdCountries, country.Iso3166_1)
 	}
+	return nil
+}
+
+// updateFilmTitles fetches the alternative titles and the translated titles of a film, so that it can be searched with any of them.
+// Titles that only differ from the main titles by accents or case are left out
+func (mw MetadataWrapper) updateFilmTitles(ctx context.Context, film *model.Film) {
+	known := map[string]bool{
+		model.NormalizeText(film.Title):         true,
+		model.NormalizeText(film.OriginalTitle): true,
+	}
+	addTitle := func(titles []string, title string) []string {
+		normalized := model.NormalizeText(title)
+		if normalized == "" || known[normalized] {
+			return titles
+		}
+		known[normalized] = true
+		return append(titles, title)
+	}
+
+	alternativeTitles, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.MovieAlternativeTitles, error) {
+		return client.GetMovieAlternativeTitles(film.TMDBID, nil)
+	})
+	if err != nil {
+		log.Error().Err(err).Int("tmdbID", film.TMDBID).Msg("Unable to fetch film alternative titles from TMDB")
+	} else {
+		film.AlternativeTitles = nil
+		for _, title := range alternativeTitles.Titles {
+			film.AlternativeTitles = addTitle(film.AlternativeTitles, title.Title)
+		}
+	}
+
+	translations, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.MovieTranslations, error) {
+		return client.GetMovieTranslations(film.TMDBID, nil)
+	})
+	if err != nil {
+		log.Error().Err(err).Int("tmdbID", film.TMDBID).Msg("Unable to fetch film translations from TMDB")
+	} else {
+		film.TranslatedTitles = nil
+		for _, translation := range translations.Translations {
+			film.TranslatedTitles = addTitle(film.TranslatedTitles, translation.Data.Title)
+		}
+	}
+}
+
+// UpdateFilmRatings fetches the ratings of a film from every ratings provider.
+// The previous rating of a provider is kept if it cannot be fetched
+func (mw MetadataWrapper) UpdateFilmRatings(ctx context.Context, film *model.Film) {
+	if film.IMDbID == "" {
+		return
+	}
+	if film.Ratings == nil {
+		film.Ratings = make(map[string]model.Rating)
+	}
+	for _, provider := range mw.ratingsProviders {
+		rating, err := provider.GetRating(ctx, film.IMDbID)
+		if err != nil {
+			log.Error().Err(err).Str("imdb_id", film.IMDbID).Str("source", provider.Source()).Msg("Cannot fetch rating")
+			continue
+		}
+		film.Ratings[provider.Source()] = rating
+	}
+	film.RatingsRefreshed = time.Now()
 }
 
-func (mw MetadataWrapper) getMediaInfo(mediaInfoPath, filePath string) (model.MediaInfo, error) {
+// fillUntranslatedDetails fills the texts that are not translated in the metadata language with the fallback language
+func (mw MetadataWrapper) fillUntranslatedDetails(ctx context.Context, details *tmdb.MovieDetails, settings model.MetadataSettings) {
+	if settings.FallbackLanguage == "" || settings.FallbackLanguage == settings.Language {
+		return
+	}
+	untranslatedGenre := false
+	for _, genre := range details.Genres {
+		untranslatedGenre = untranslatedGenre || genre.Name == ""
+	}
+	if details.Title != "" && details.Overview != "" && !untranslatedGenre {
+		return
+	}
+
+	fallback, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.MovieDetails, error) {
+		return client.GetMovieDetails(int(details.ID), map[string]string{"language": settings.FallbackLanguage})
+	})
+	if err != nil {
+		log.Warn().Err(err).Int64("tmdbID", details.ID).Str("language", settings.FallbackLanguage).Msg("Unable to fetch film details in fallback language")
+		return
+	}
+	if details.Title == "" {
+		details.Title = fallback.Title
+	}
+	if details.Overview == "" {
+		details.Overview = fallback.Overview
+	}
+	if details.Tagline == "" {
+		details.Tagline = fallback.Tagline
+	}
+	for i, genre := range details.Genres {
+		if genre.Name != "" {
+			continue
+		}
+		for _, fallbackGenre := range fallback.Genres {
+			if fallbackGenre.ID == genre.ID {
+				details.Genres[i].Name = fallbackGenre.Name
+			}
+		}
+	}
+}
+
+// getCertification returns the first certification found in the countries, by order of preference
+func getCertification(releaseDates *tmdb.MovieReleaseDates, countries []string) string {
+	for _, country := range countries {
+		for _, releasesCountry := range releaseDates.Results {
+			if !strings.EqualFold(releasesCountry.Iso3166_1, country) {
+				continue
+			}
+			for _, releaseDate := range releasesCountry.ReleaseDates {
+				if releaseDate.Certification != "" {
+					return releaseDate.Certification
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (mw MetadataWrapper) getMediaInfo(ctx context.Context, mediaInfoPath, filePath string) (model.MediaInfo, error) {
 	var mediaInfo model.MediaInfo
 	var mediaInfoJSONOutput model.MediaInfoJSONOutput
 
-	out, err := exec.Command(mediaInfoPath, filePath).Output()
+	out, err := exec.CommandContext(ctx, mediaInfoPath, filePath).Output()
 	if err != nil {
 		return mediaInfo, err
 	}
@@ -252,7 +481,7 @@ func (mw MetadataWrapper) getMediaInfo(mediaInfoPath, filePath string) (model.Me
 	}
 	mediaInfo.FullOutput = template.HTML(strings.Join(fullOutputLines, "<br>"))
 
-	out, err = exec.Command(mediaInfoPath, "--Output=JSON", filePath).Output()
+	out, err = exec.CommandContext(ctx, mediaInfoPath, "--Output=JSON", filePath).Output()
 	if err != nil {
 		return mediaInfo, err
 	}
@@ -280,11 +509,14 @@ func (mw MetadataWrapper) getMediaInfo(mediaInfoPath, filePath string) (model.Me
 		// Fill Video info
 		case "Video":
 			mediaInfo.Video = append(mediaInfo.Video, model.VideoInfo{
-				CodecID:    track["CodecID"],
-				Profile:    track["Format_Profile"],
-				Resolution: fmt.Sprintf("%sx%s", track["Width"], track["Height"]),
-				FrameRate:  track["FrameRate"],
-				BitDepth:   track["BitDepth"],
+				CodecID:                 track["CodecID"],
+				Format:                  track["Format"],
+				Profile:                 track["Format_Profile"],
+				Resolution:              fmt.Sprintf("%sx%s", track["Width"], track["Height"]),
+				FrameRate:               track["FrameRate"],
+				BitDepth:                track["BitDepth"],
+				HDRFormat:               track["HDR_Format"],
+				TransferCharacteristics: track["transfer_characteristics"],
 			})
 			// Compute resolution on first video stream
 			if mediaInfo.Resolution == "" {
@@ -326,76 +558,68 @@ func (mw MetadataWrapper) getMediaInfo(mediaInfoPath, filePath string) (model.Me
 	return mediaInfo, nil
 }
 
-// getIMDbRating fetchs rating from IMDbID
-func (mw MetadataWrapper) getIMDbRating(imdbId string) string {
-	client := http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://www.imdb.com/title/%s/", imdbId), nil)
-	req.Header.Add("user-agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/111.0.0.0 Safari/537.36")
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Error().Str("imdb_id", imdbId).Msg("Cannot fetch rating from IMDb")
-		return ""
-	}
-	defer func() { _ = resp.Body.Close() }()
-	if resp.StatusCode != 200 {
-		log.Error().Str("imdb_id", imdbId).Msg("Cannot fetch rating from IMDb")
-		return ""
-	}
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		log.Error().Str("imdb_id", imdbId).Msg("Cannot fetch rating from IMDb")
-		return ""
-	}
-
-	return doc.Find("#__next > main > div > section.ipc-page-background.ipc-page-background--base > section > div:nth-child(4) > section > section > div > div > div > div > div > div:nth-child(1) > a > span > div > div > div > span:nth-child(1)").First().Text()
-}
-
-// getLetterboxdRating fetchs rating from letterboxd using IMDbID
-func (mw MetadataWrapper) getLetterboxdRating(imdbId string) string {
-	resp, err := http.Get(fmt.Sprintf("https://letterboxd.com/search/films/%s/", imdbId))
-	if err != nil {
-		log.Error().Str("imdb_id", imdbId).Msg("Cannot fetch rating from Letterboxd")
-		return ""
-	}
-	defer func() { _ = resp.Body.Close() }()
-	if resp.StatusCode != 200 {
-		log.Error().Str("imdb_id", imdbId).Msg("Cannot fetch rating from Letterboxd")
-		return ""
-	}
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		log.Error().Str("imdb_id", imdbId).Msg("Cannot fetch rating from Letterboxd")
-		return ""
-	}
-
-	filmUrl, exists := doc.Find("#content > div > div > section > ul > li:nth-child(1) > div").First().Attr("data-target-link")
-	if !exists {
-		log.Error().Str("imdb_id", imdbId).Msg("Cannot fetch rating from Letterboxd")
-		return ""
-	}
-
-	resp, err = http.Get(fmt.Sprintf("https://letterboxd.com/csi%srating-histogram/", filmUrl))
-	if err != nil {
-		log.Error().Str("imdb_id", imdbId).Msg("Cannot fetch rating from Letterboxd")
-		return ""
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		log.Error().Str("imdb_id", imdbId).Msg("Cannot fetch rating from Letterboxd")
-		return ""
-	}
-	doc, err = goquery.NewDocumentFromReader(resp.Body)
+// GetCollectionDetails fetches details about a collection and its films from TMDB. The films are sorted by release date
+func (mw MetadataWrapper) GetCollectionDetails(ctx context.Context, collectionID int64) (*model.Collection, error) {
+	settings := mw.getMetadataSettings()
+	details, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.CollectionDetails, error) {
+		return client.GetCollectionDetails(int(collectionID), map[string]string{"language": settings.Language})
+	})
 	if err != nil {
-		log.Error().Str("imdb_id", imdbId).Msg("Cannot fetch rating from Letterboxd")
-		return ""
+		return nil, fmt.Errorf("error while fetching collection %d from TMDB: %w", collectionID, err)
+	}
+	if details.Overview == "" && settings.FallbackLanguage != "" && settings.FallbackLanguage != settings.Language {
+		// Get the overview in the fallback language if it is not translated
+		if fallback, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.CollectionDetails, error) {
+			return client.GetCollectionDetails(int(collectionID), map[string]string{"language": settings.FallbackLanguage})
+		}); err == nil {
+			details.Overview = fallback.Overview
+		}
 	}
 
-	return doc.Find("a.display-rating").First().Text()
+	collection := &model.Collection{
+		ID:            primitive.NewObjectID(),
+		TMDBID:        details.ID,
+		Name:          details.Name,
+		Overview:      details.Overview,
+		PosterPath:    details.PosterPath,
+		BackdropPath:  details.BackdropPath,
+		LastRefreshed: time.Now(),
+	}
+	for _, part := range details.Parts {
+		collection.Parts = append(collection.Parts, model.CollectionPart{
+			TMDBID:      int(part.ID),
+			Title:       part.Title,
+			ReleaseDate: part.ReleaseDate,
+			PosterPath:  part.PosterPath,
+		})
+	}
+	// Unreleased films without a date come last
+	slices.SortStableFunc(collection.Parts, func(a, b model.CollectionPart) int {
+		if (a.ReleaseDate == "") != (b.ReleaseDate == "") {
+			if a.ReleaseDate == "" {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a.ReleaseDate, b.ReleaseDate)
+	})
+	return collection, nil
 }
 
 // GetPersonDetails fetches details about a person from TMDB
-func (mw MetadataWrapper) GetPersonDetails(personID int64) *model.Person {
-	details, err := mw.client.GetPersonDetails(int(personID), nil)
+func (mw MetadataWrapper) GetPersonDetails(ctx context.Context, personID int64) *model.Person {
+	settings := mw.getMetadataSettings()
+	details, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.PersonDetails, error) {
+		return client.GetPersonDetails(int(personID), map[string]string{"language": settings.Language, "append_to_response": "combined_credits"})
+	})
+	if err == nil && details.Biography == "" && settings.FallbackLanguage != "" && settings.FallbackLanguage != settings.Language {
+		// Get the biography in the fallback language if it is not translated
+		if fallback, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.PersonDetails, error) {
+			return client.GetPersonDetails(int(personID), map[string]string{"language": settings.FallbackLanguage})
+		}); err == nil {
+			details.Biography = fallback.Biography
+		}
+	}
 	if err != nil {
 		log.Error().Int64("personID", personID).Err(err).Send()
 		return &model.Person{
@@ -405,19 +629,54 @@ func (mw MetadataWrapper) GetPersonDetails(personID int64) *model.Person {
 	}
 
 	return &model.Person{
-		ID:       primitive.NewObjectID(),
-		TMDBID:   personID,
-		Name:     details.Name,
-		Photo:    details.ProfilePath,
-		Bio:      template.HTML(strings.ReplaceAll(details.Biography, "\n", "<br>")),
-		Birthday: details.Birthday,
-		Deathday: details.Deathday,
-		IMDbID:   details.IMDbID,
+		ID:            primitive.NewObjectID(),
+		TMDBID:        personID,
+		Name:          details.Name,
+		Photo:         details.ProfilePath,
+		Bio:           template.HTML(strings.ReplaceAll(details.Biography, "\n", "<br>")),
+		Birthday:      details.Birthday,
+		Deathday:      details.Deathday,
+		IMDbID:        details.IMDbID,
+		Filmography:   getPersonFilmography(details.PersonCombinedCreditsAppend),
+		LastRefreshed: time.Now(),
+	}
+}
+
+// getPersonFilmography returns the film credits of a person, without the TV shows
+func getPersonFilmography(credits *tmdb.PersonCombinedCreditsAppend) []model.PersonCredit {
+	filmography := []model.PersonCredit{}
+	if credits == nil || credits.CombinedCredits == nil {
+		return filmography
+	}
+	for _, cast := range credits.CombinedCredits.Cast {
+		if cast.MediaType == "movie" {
+			filmography = append(filmography, model.PersonCredit{
+				TMDBID:      int(cast.ID),
+				Title:       cast.Title,
+				ReleaseDate: cast.ReleaseDate,
+				PosterPath:  cast.PosterPath,
+				Department:  "Acting",
+				Job:         cast.Character,
+			})
+		}
+	}
+	for _, crew := range credits.CombinedCredits.Crew {
+		if crew.MediaType == "movie" {
+			filmography = append(filmography, model.PersonCredit{
+				TMDBID:      int(crew.ID),
+				Title:       crew.Title,
+				ReleaseDate: crew.ReleaseDate,
+				PosterPath:  crew.PosterPath,
+				Department:  crew.Department,
+				Job:         crew.Job,
+			})
+		}
 	}
+	return filmography
 }
 
 // GetTMDBIDFromLink returns the TMDB ID from a TMDB, IMDb, or Letterboxd URL
-func (mw MetadataWrapper) GetTMDBIDFromLink(inputUrl string) (tmdbID int, err error) {
+func (mw MetadataWrapper) GetTMDBIDFromLink(ctx context.Context, inputUrl string) (tmdbID int, err error) {
 	urlParsed, err := url.Parse(inputUrl)
 	if err != nil {
 		return tmdbID, err
@@ -426,9 +685,9 @@ func (mw MetadataWrapper) GetTMDBIDFromLink(inputUrl string) (tmdbID int, err er
 	case "www.themoviedb.org":
 		tmdbID, err = mw.getTMDBIDFromTheMovieDB(inputUrl)
 	case "www.imdb.com":
-		tmdbID, err = mw.getTMDBIDFromIMDB(inputUrl)
+		tmdbID, err = mw.getTMDBIDFromIMDB(ctx, inputUrl)
 	case "letterboxd.com":
-		tmdbID, err = mw.getTMDBIDFromLetterboxd(inputUrl)
+		tmdbID, err = mw.getTMDBIDFromLetterboxd(ctx, inputUrl)
 	default:
 		err = errors.New("the host could not be found")
 	}
@@ -463,9 +722,13 @@ func (mw MetadataWrapper) getTMDBIDFromTheMovieDB(inputUrl string) (TMDBID int,
 }
 
 // getTMDBIDFromLetterboxd returns the TMDB ID from a Letterboxd URL
-func (mw MetadataWrapper) getTMDBIDFromLetterboxd(inputUrl string) (TMDBID int, err error) {
+func (mw MetadataWrapper) getTMDBIDFromLetterboxd(ctx context.Context, inputUrl string) (TMDBID int, err error) {
 	// Get the page's HTML
-	res, err := http.Get(inputUrl)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, inputUrl, nil)
+	if err != nil {
+		return TMDBID, err
+	}
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Error().Str("url", inputUrl).Msg("Cannot fetch TMDB ID from Letterboxd")
 		return TMDBID, err
@@ -489,7 +752,7 @@ func (mw MetadataWrapper) getTMDBIDFromLetterboxd(inputUrl string) (TMDBID int,
 }
 
 // getTMDBIDFromIMDB returns the TMDB ID from an IMDb URL
-func (mw MetadataWrapper) getTMDBIDFromIMDB(inputUrl string) (TMDBID int, err error) {
+func (mw MetadataWrapper) getTMDBIDFromIMDB(ctx context.Context, inputUrl string) (TMDBID int, err error) {
 	// Parse URL
 	urlParsed, err := url.Parse(inputUrl)
 	if err != nil {
@@ -499,7 +762,7 @@ func (mw MetadataWrapper) getTMDBIDFromIMDB(inputUrl string) (TMDBID int, err er
 	if strings.HasPrefix(urlParsed.Path, "/title/") {
 		imdbID := urlParsed.Path[7 : len(urlParsed.Path)-1]
 		// Get TMDB ID using the TMDB API
-		tmdbIDInt64, err := mw.getTMDBIDFromIMDBID(imdbID)
+		tmdbIDInt64, err := mw.getTMDBIDFromIMDBID(ctx, imdbID)
 		if err != nil {
 			return TMDBID, err
 		}
@@ -511,10 +774,12 @@ func (mw MetadataWrapper) getTMDBIDFromIMDB(inputUrl string) (TMDBID int, err er
 }
 
 // getTMDBIDFromIMDBID retrieves the TMDB ID from an IMDb ID
-func (mw MetadataWrapper) getTMDBIDFromIMDBID(imdbID string) (TMDBID int64, err error) {
+func (mw MetadataWrapper) getTMDBIDFromIMDBID(ctx context.Context, imdbID string) (TMDBID int64, err error) {
 	urlOptions := make(map[string]string)
 	urlOptions["external_source"] = "imdb_id"
-	res, err := mw.client.GetFindByID(imdbID, urlOptions)
+	res, err := withClient(ctx, mw.tmdb, func(client *tmdb.Client) (*tmdb.FindByID, error) {
+		return client.GetFindByID(imdbID, urlOptions)
+	})
 	if err != nil {
 		return TMDBID, err
 	}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
@@ -16,35 +17,151 @@ import (
 )
 
 type MongoDB struct {
-	ctx context.Context
-
 	client *mongo.Client
 
-	usersColl   *mongo.Collection
-	volumesColl *mongo.Collection
-	filmsColl   *mongo.Collection
-	peopleColl  *mongo.Collection
-	rarbgColl   *mongo.Collection
+	usersColl    *mongo.Collection
+	volumesColl  *mongo.Collection
+	filmsColl    *mongo.Collection
+	peopleColl   *mongo.Collection
+	settingsColl *mongo.Collection
+	rarbgColl    *mongo.Collection
+
+	smartCollectionsColl *mongo.Collection
+	collectionsColl      *mongo.Collection
+	personRetriesColl    *mongo.Collection
+	migrationsColl       *mongo.Collection
+}
+
+// mongoIndexNotFound is the code of the error returned when dropping an index that does not exist
+const mongoIndexNotFound = 27
+
+const (
+	metadataSettingsID = "metadata"
+	homeSettingsID     = "home"
+	serverSettingsID   = "server"
+)
+
+// listCollation is used to list films and people: case-insensitive, and with the numbers in names sorted by value
+var listCollation = &options.Collation{Locale: "en", Strength: 2, NumericOrdering: true}
+
+// nameCollation compares names ignoring their case and their accents
+var nameCollation = &options.Collation{Locale: "en", Strength: 1}
+
+// queryFields are the fields on which the films are queried, by field of the query language
+var queryFields = map[string]string{
+	model.QueryFieldGenre:      "genres",
+	model.QueryFieldCountry:    "prod_countries",
+	model.QueryFieldYear:       "release_year",
+	model.QueryFieldRuntime:    "runtime",
+	model.QueryFieldRating:     "ratings." + model.RatingSourceIMDb + ".value",
+	model.QueryFieldDirector:   "directors",
+	model.QueryFieldWriter:     "writers",
+	model.QueryFieldActor:      "characters.actor_id",
+	model.QueryFieldResolution: "technical.resolutions",
+	model.QueryFieldCodec:      "technical.video_codecs",
+	model.QueryFieldHDR:        "technical.hdr_formats",
+	model.QueryFieldAudio:      "technical.audio_languages",
+	model.QueryFieldSubtitles:  "technical.subtitle_languages",
+}
+
+// queryOperators are the Mongo comparison operators, by operator of the query language
+var queryOperators = map[string]string{
+	model.QueryOperatorEqual:          "$eq",
+	model.QueryOperatorLess:           "$lt",
+	model.QueryOperatorLessOrEqual:    "$lte",
+	model.QueryOperatorGreater:        "$gt",
+	model.QueryOperatorGreaterOrEqual: "$gte",
 }
 
-// NewMongoDB initializes a mongo db client
+// filmSortFields are the fields on which the films are sorted, by sort order
+var filmSortFields = map[string]string{
+	model.FilmSortTitle:     "title",
+	model.FilmSortYear:      "release_year",
+	model.FilmSortDateAdded: "date_added",
+	model.FilmSortRuntime:   "runtime",
+	model.FilmSortRating:    "ratings." + model.RatingSourceIMDb + ".value",
+}
+
+// NewMongoDB initializes a mongo db client.
+// The documents must be updated with Migrate before they are used
 func NewMongoDB(dbUser, dbPassword, dbURL, dbPort, dbName string) *MongoDB {
-	mongoCtx := context.Background()
-	// defer cancel()
-	mongoClient, err := mongo.Connect(mongoCtx, options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%s@%s:%s", dbUser, dbPassword, dbURL, dbPort)))
+	mongoClient, err := mongo.Connect(context.Background(), options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%s@%s:%s", dbUser, dbPassword, dbURL, dbPort)))
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
 
 	mongoDb := mongoClient.Database(dbName)
-	return &MongoDB{
-		ctx:         mongoCtx,
-		client:      mongoClient,
-		usersColl:   mongoDb.Collection("users"),
-		volumesColl: mongoDb.Collection("volumes"),
-		filmsColl:   mongoDb.Collection("films"),
-		peopleColl:  mongoDb.Collection("people"),
+	m := &MongoDB{
+		client:       mongoClient,
+		usersColl:    mongoDb.Collection("users"),
+		volumesColl:  mongoDb.Collection("volumes"),
+		filmsColl:    mongoDb.Collection("films"),
+		peopleColl:   mongoDb.Collection("people"),
+		settingsColl: mongoDb.Collection("settings"),
+
+		smartCollectionsColl: mongoDb.Collection("smart_collections"),
+		collectionsColl:      mongoDb.Collection("collections"),
+		personRetriesColl:    mongoDb.Collection("person_retries"),
+		migrationsColl:       mongoDb.Collection("migrations"),
 	}
+	return m
+}
+
+// createListIndexes creates the indexes used to filter and sort the film and people listings
+func (m *MongoDB) createListIndexes(ctx context.Context) error {
+	indexOptions := options.Index().SetCollation(listCollation)
+	var filmIndexes []mongo.IndexModel
+	for _, field := range []string{
+		"release_year", "genres", "prod_countries",
+		"technical.resolutions", "technical.video_codecs", "technical.hdr_formats", "technical.audio_languages", "technical.subtitle_languages",
+	} {
+		filmIndexes = append(filmIndexes, mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}}, Options: indexOptions})
+	}
+	for _, field := range filmSortFields {
+		if field != "_id" {
+			filmIndexes = append(filmIndexes, mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}, {Key: "_id", Value: 1}}, Options: indexOptions})
+		}
+	}
+	if _, err := m.filmsColl.Indexes().CreateMany(ctx, filmIndexes); err != nil {
+		return fmt.Errorf("error while creating film indexes: %w", err)
+	}
+
+	personIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}, Options: indexOptions},
+		// The people of the listing are looked up from the credits of the films
+		{Keys: bson.D{{Key: "tmdb_id", Value: 1}}, Options: indexOptions},
+	}
+	if _, err := m.peopleColl.Indexes().CreateMany(ctx, personIndexes); err != nil {
+		return fmt.Errorf("error while creating people indexes: %w", err)
+	}
+	return nil
+}
+
+// createUniqueIndexes creates the indexes that keep films, people and collections from being stored several times,
+// which fails if there are duplicates
+func (m *MongoDB) createUniqueIndexes(ctx context.Context) error {
+	filmIndexes := []mongo.IndexModel{
+		// The films not matched on TMDB all have the ID 0
+		{Keys: bson.D{{Key: "tmdb_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"tmdb_id": bson.M{"$gt": 0}})},
+		{Keys: bson.D{{Key: "volume_files.path", Value: 1}}, Options: options.Index().SetUnique(true)},
+	}
+	if _, err := m.filmsColl.Indexes().CreateMany(ctx, filmIndexes); err != nil {
+		return fmt.Errorf("error while creating film unique indexes: %w", err)
+	}
+
+	// Replaces the lookup index of the people listing
+	var cmdErr mongo.CommandError
+	if _, err := m.peopleColl.Indexes().DropOne(ctx, "tmdb_id_1"); err != nil && !(errors.As(err, &cmdErr) && cmdErr.Code == mongoIndexNotFound) {
+		return fmt.Errorf("error while dropping people index: %w", err)
+	}
+	uniqueIndex := mongo.IndexModel{Keys: bson.D{{Key: "tmdb_id", Value: 1}}, Options: options.Index().SetUnique(true)}
+	if _, err := m.peopleColl.Indexes().CreateOne(ctx, uniqueIndex); err != nil {
+		return fmt.Errorf("error while creating people unique index: %w", err)
+	}
+	if _, err := m.collectionsColl.Indexes().CreateOne(ctx, uniqueIndex); err != nil {
+		return fmt.Errorf("error while creating collection unique index: %w", err)
+	}
+	return nil
 }
 
 func getFilmPathFilter(path string) primitive.M {
@@ -53,12 +170,12 @@ func getFilmPathFilter(path string) primitive.M {
 
 // Close closes the MongoDB connection
 func (m *MongoDB) Close() error {
-	return m.client.Disconnect(m.ctx)
+	return m.client.Disconnect(context.Background())
 }
 
 // IsOwnerPresent checks if there is an owner in the server
-func (m *MongoDB) IsOwnerPresent() (bool, error) {
-	countOwners, err := m.usersColl.CountDocuments(m.ctx, bson.M{"is_owner": true})
+func (m *MongoDB) IsOwnerPresent(ctx context.Context) (bool, error) {
+	countOwners, err := m.usersColl.CountDocuments(ctx, bson.M{"is_owner": true})
 	if err != nil {
 		return false, err
 	}
@@ -66,14 +183,14 @@ func (m *MongoDB) IsOwnerPresent() (bool, error) {
 }
 
 // CreateUser adds a user to the database after checking parameter
-func (m *MongoDB) CreateUser(user *model.User) error {
-	_, err := m.usersColl.InsertOne(m.ctx, user)
+func (m *MongoDB) CreateUser(ctx context.Context, user *model.User) error {
+	_, err := m.usersColl.InsertOne(ctx, user)
 	return err
 }
 
 // DeleteUser deletes the user from the DB
-func (m *MongoDB) DeleteUser(userId primitive.ObjectID) error {
-	res, err := m.usersColl.DeleteOne(m.ctx, bson.M{"_id": userId})
+func (m *MongoDB) DeleteUser(ctx context.Context, userId primitive.ObjectID) error {
+	res, err := m.usersColl.DeleteOne(ctx, bson.M{"_id": userId})
 	if err != nil {
 		return err
 	}
@@ -81,12 +198,15 @@ func (m *MongoDB) DeleteUser(userId primitive.ObjectID) error {
 		return errors.New("unable to delete user")
 	}
 
+	if _, err := m.smartCollectionsColl.DeleteMany(ctx, bson.M{"user_id": userId}); err != nil {
+		return fmt.Errorf("error while deleting smart collections of user: %w", err)
+	}
 	return nil
 }
 
 // IsUsernameAvailable returns true if the username (case-insensitive) is not in use yet
-func (m *MongoDB) IsUsernameAvailable(username string) (bool, error) {
-	count, err := m.usersColl.CountDocuments(m.ctx, bson.M{"name": primitive.Regex{Pattern: fmt.Sprintf("^%s$", username), Options: "i"}})
+func (m *MongoDB) IsUsernameAvailable(ctx context.Context, username string) (bool, error) {
+	count, err := m.usersColl.CountDocuments(ctx, bson.M{"name": primitive.Regex{Pattern: fmt.Sprintf("^%s$", username), Options: "i"}})
 	if err != nil {
 		return false, err
 	}
@@ -94,29 +214,29 @@ func (m *MongoDB) IsUsernameAvailable(username string) (bool, error) {
 }
 
 // GetUserFromID gets user from its ID
-func (m *MongoDB) GetUserFromID(id primitive.ObjectID) (*model.User, error) {
+func (m *MongoDB) GetUserFromID(ctx context.Context, id primitive.ObjectID) (*model.User, error) {
 	var user model.User
-	err := m.usersColl.FindOne(m.ctx, bson.M{"_id": id}).Decode(&user)
+	err := m.usersColl.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
 	return &user, err
 }
 
 // GetUserFromName gets user from it name
-func (m *MongoDB) GetUserFromName(username string, user *model.User) error {
-	return m.usersColl.FindOne(m.ctx, bson.M{"name": username}).Decode(user)
+func (m *MongoDB) GetUserFromName(ctx context.Context, username string, user *model.User) error {
+	return m.usersColl.FindOne(ctx, bson.M{"name": username}).Decode(user)
 }
 
 // GetUserNb returns the number of users from the DB
-func (m *MongoDB) GetUserNb() (int64, error) {
-	return m.usersColl.CountDocuments(m.ctx, bson.M{})
+func (m *MongoDB) GetUserNb(ctx context.Context) (int64, error) {
+	return m.usersColl.CountDocuments(ctx, bson.M{})
 }
 
 // GetUsers returns the list of users in the DB
-func (m *MongoDB) GetUsers() (users []model.User, err error) {
-	usersCur, err := m.usersColl.Find(m.ctx, bson.M{})
+func (m *MongoDB) GetUsers(ctx context.Context) (users []model.User, err error) {
+	usersCur, err := m.usersColl.Find(ctx, bson.M{})
 	if err != nil {
 		return nil, fmt.Errorf("error while retrieving users from DB: %w", err)
 	}
-	for usersCur.Next(m.ctx) {
+	for usersCur.Next(ctx) {
 		var user model.User
 		err = usersCur.Decode(&user)
 		if err != nil {
@@ -128,25 +248,215 @@ func (m *MongoDB) GetUsers() (users []model.User, err error) {
 }
 
 // SetUserPassword set a new password for a specific user
-func (m *MongoDB) SetUserPassword(userID primitive.ObjectID, newPassword string) error {
-	_, err := m.usersColl.UpdateOne(m.ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"password": newPassword}})
+func (m *MongoDB) SetUserPassword(ctx context.Context, userID primitive.ObjectID, newPassword string) error {
+	_, err := m.usersColl.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"password": newPassword}})
+	return err
+}
+
+// SetUserHiddenHomeRows sets the keys of the home rows a user does not want to see
+func (m *MongoDB) SetUserHiddenHomeRows(ctx context.Context, userID primitive.ObjectID, rowKeys []string) error {
+	_, err := m.usersColl.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"hidden_home_rows": rowKeys}})
+	return err
+}
+
+// GetMetadataSettings returns the metadata settings, or mongo.ErrNoDocuments if they were never saved
+func (m *MongoDB) GetMetadataSettings(ctx context.Context) (*model.MetadataSettings, error) {
+	var settings model.MetadataSettings
+	err := m.settingsColl.FindOne(ctx, bson.M{"_id": metadataSettingsID}).Decode(&settings)
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetMetadataSettings saves the metadata settings
+func (m *MongoDB) SetMetadataSettings(ctx context.Context, settings *model.MetadataSettings) error {
+	_, err := m.settingsColl.UpdateOne(ctx, bson.M{"_id": metadataSettingsID}, bson.M{"$set": settings}, options.Update().SetUpsert(true))
 	return err
 }
 
+// GetHomeSettings returns the home dashboard settings, or mongo.ErrNoDocuments if they were never saved
+func (m *MongoDB) GetHomeSettings(ctx context.Context) (*model.HomeSettings, error) {
+	var settings model.HomeSettings
+	err := m.settingsColl.FindOne(ctx, bson.M{"_id": homeSettingsID}).Decode(&settings)
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetHomeSettings saves the home dashboard settings
+func (m *MongoDB) SetHomeSettings(ctx context.Context, settings *model.HomeSettings) error {
+	_, err := m.settingsColl.UpdateOne(ctx, bson.M{"_id": homeSettingsID}, bson.M{"$set": settings}, options.Update().SetUpsert(true))
+	return err
+}
+
+// GetServerSettings returns the server settings, or mongo.ErrNoDocuments if they were never saved
+func (m *MongoDB) GetServerSettings(ctx context.Context) (*model.ServerSettings, error) {
+	var settings model.ServerSettings
+	err := m.settingsColl.FindOne(ctx, bson.M{"_id": serverSettingsID}).Decode(&settings)
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetServerSettings saves the server settings
+func (m *MongoDB) SetServerSettings(ctx context.Context, settings *model.ServerSettings) error {
+	_, err := m.settingsColl.UpdateOne(ctx, bson.M{"_id": serverSettingsID}, bson.M{"$set": settings}, options.Update().SetUpsert(true))
+	return err
+}
+
+// AddSmartCollection adds a smart collection to the DB
+func (m *MongoDB) AddSmartCollection(ctx context.Context, collection *model.SmartCollection) error {
+	collection.ID = primitive.NewObjectID()
+	_, err := m.smartCollectionsColl.InsertOne(ctx, collection)
+	return err
+}
+
+// UpdateSmartCollection updates the name and the query of a smart collection
+func (m *MongoDB) UpdateSmartCollection(ctx context.Context, collection *model.SmartCollection) error {
+	_, err := m.smartCollectionsColl.UpdateOne(ctx, bson.M{"_id": collection.ID}, bson.M{"$set": bson.M{"name": collection.Name, "query": collection.Query}})
+	return err
+}
+
+// DeleteSmartCollection deletes a smart collection from the DB
+func (m *MongoDB) DeleteSmartCollection(ctx context.Context, collectionID primitive.ObjectID) error {
+	res, err := m.smartCollectionsColl.DeleteOne(ctx, bson.M{"_id": collectionID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount != 1 {
+		return errors.New("unable to delete smart collection")
+	}
+	return nil
+}
+
+// GetSmartCollectionFromID returns a smart collection from its ID
+func (m *MongoDB) GetSmartCollectionFromID(ctx context.Context, collectionID primitive.ObjectID) (*model.SmartCollection, error) {
+	var collection model.SmartCollection
+	err := m.smartCollectionsColl.FindOne(ctx, bson.M{"_id": collectionID}).Decode(&collection)
+	return &collection, err
+}
+
+// GetSmartCollections returns the smart collections of a user, sorted by name
+func (m *MongoDB) GetSmartCollections(ctx context.Context, userID primitive.ObjectID) (collections []model.SmartCollection, err error) {
+	opt := options.Find().SetSort(bson.M{"name": 1}).SetCollation(listCollation)
+	collectionsCur, err := m.smartCollectionsColl.Find(ctx, bson.M{"user_id": userID}, opt)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving smart collections from DB: %w", err)
+	}
+	for collectionsCur.Next(ctx) {
+		var collection model.SmartCollection
+		if err := collectionsCur.Decode(&collection); err != nil {
+			return nil, fmt.Errorf("error while decoding smart collection from DB: %w", err)
+		}
+		collections = append(collections, collection)
+	}
+	return collections, nil
+}
+
+// AddCollection adds a collection to the DB
+// If the collection is already in the database, updates it
+// A collection stored with another ID keeps it
+func (m *MongoDB) AddCollection(ctx context.Context, collection *model.Collection) error {
+	fields, err := toBSONDocument(collection)
+	if err != nil {
+		return err
+	}
+	delete(fields, "_id")
+	_, err = m.collectionsColl.UpdateOne(ctx,
+		bson.M{"tmdb_id": collection.TMDBID},
+		bson.M{"$set": fields, "$setOnInsert": bson.M{"_id": collection.ID}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// GetCollectionFromID returns a collection from its ID
+func (m *MongoDB) GetCollectionFromID(ctx context.Context, collectionID primitive.ObjectID) (*model.Collection, error) {
+	var collection model.Collection
+	err := m.collectionsColl.FindOne(ctx, bson.M{"_id": collectionID}).Decode(&collection)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = fmt.Errorf("collection '%s': %w", collectionID.Hex(), model.ErrNotFound)
+	}
+	return &collection, err
+}
+
+// GetCollectionFromTMDBID returns a collection from its TMDB ID
+func (m *MongoDB) GetCollectionFromTMDBID(ctx context.Context, tmdbID int64) (*model.Collection, error) {
+	var collection model.Collection
+	err := m.collectionsColl.FindOne(ctx, bson.M{"tmdb_id": tmdbID}).Decode(&collection)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = fmt.Errorf("collection with TMDB ID %d: %w", tmdbID, model.ErrNotFound)
+	}
+	return &collection, err
+}
+
+// GetCollectionSummaries returns the collections having films in the library, sorted by name, with their number of films
+func (m *MongoDB) GetCollectionSummaries(ctx context.Context) (summaries []model.CollectionSummary, err error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"collection_id": bson.M{"$gt": 0}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$collection_id", "count": bson.M{"$sum": 1}}}},
+	}
+	countsCur, err := m.filmsColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("error while counting films by collection in DB: %w", err)
+	}
+	var counts []struct {
+		TMDBID int64 `bson:"_id"`
+		Count  int   `bson:"count"`
+	}
+	if err := countsCur.All(ctx, &counts); err != nil {
+		return nil, fmt.Errorf("error while decoding film counts from DB: %w", err)
+	}
+	ownedCounts := make(map[int64]int, len(counts))
+	tmdbIDs := make([]int64, 0, len(counts))
+	for _, count := range counts {
+		ownedCounts[count.TMDBID] = count.Count
+		tmdbIDs = append(tmdbIDs, count.TMDBID)
+	}
+
+	opt := options.Find().SetSort(bson.M{"name": 1}).SetCollation(listCollation)
+	collectionsCur, err := m.collectionsColl.Find(ctx, bson.M{"tmdb_id": bson.M{"$in": tmdbIDs}}, opt)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving collections from DB: %w", err)
+	}
+	for collectionsCur.Next(ctx) {
+		var collection model.Collection
+		if err := collectionsCur.Decode(&collection); err != nil {
+			return nil, fmt.Errorf("error while decoding collection from DB: %w", err)
+		}
+		summaries = append(summaries, model.CollectionSummary{Collection: collection, OwnedCount: ownedCounts[collection.TMDBID]})
+	}
+	return summaries, nil
+}
+
+// GetFilmsFromCollection returns the films of the library belonging to a collection
+func (m *MongoDB) GetFilmsFromCollection(ctx context.Context, tmdbID int64) (films []model.Film, err error) {
+	filmsCur, err := m.filmsColl.Find(ctx, bson.M{"collection_id": tmdbID})
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving films of collection from DB: %w", err)
+	}
+	if err := filmsCur.All(ctx, &films); err != nil {
+		return nil, fmt.Errorf("error while decoding films from DB: %w", err)
+	}
+	return films, nil
+}
+
 // GetVolumeFromID fetches volume from DB using specified ID and returns it via pointer
-func (m *MongoDB) GetVolumeFromID(id primitive.ObjectID) (*model.Volume, error) {
+func (m *MongoDB) GetVolumeFromID(ctx context.Context, id primitive.ObjectID) (*model.Volume, error) {
 	var volume model.Volume
-	err := m.volumesColl.FindOne(m.ctx, bson.M{"_id": id}).Decode(&volume)
+	err := m.volumesColl.FindOne(ctx, bson.M{"_id": id}).Decode(&volume)
 	return &volume, err
 }
 
 // GetVolumes returns the list of volumes in the DB
-func (m *MongoDB) GetVolumes() (volumes []model.Volume, err error) {
-	volumeCur, err := m.volumesColl.Find(m.ctx, bson.M{})
+func (m *MongoDB) GetVolumes(ctx context.Context) (volumes []model.Volume, err error) {
+	volumeCur, err := m.volumesColl.Find(ctx, bson.M{})
 	if err != nil {
 		return nil, fmt.Errorf("error while retrieving volumes from DB: %w", err)
 	}
-	for volumeCur.Next(m.ctx) {
+	for volumeCur.Next(ctx) {
 		var vol model.Volume
 		err = volumeCur.Decode(&vol)
 		if err != nil {
@@ -158,31 +468,39 @@ func (m *MongoDB) GetVolumes() (volumes []model.Volume, err error) {
 }
 
 // AddVolume adds a volume to the DB and start scanning the volume
-func (m *MongoDB) AddVolume(volume *model.Volume) error {
-	_, err := m.volumesColl.InsertOne(m.ctx, *volume)
+func (m *MongoDB) AddVolume(ctx context.Context, volume *model.Volume) error {
+	_, err := m.volumesColl.InsertOne(ctx, *volume)
 	return err
 }
 
 // DeleteVolume deletes the volume from the DB and all the film which originated only from this volume
-func (m *MongoDB) DeleteVolume(volumeId primitive.ObjectID) error {
+func (m *MongoDB) DeleteVolume(ctx context.Context, volumeId primitive.ObjectID) error {
+	concernedIDs := []primitive.ObjectID{}
+	for _, film := range m.GetFilmsFromVolume(ctx, volumeId) {
+		concernedIDs = append(concernedIDs, film.ID)
+	}
 	// Remove specified volume from all film source
-	update, err := m.filmsColl.UpdateMany(m.ctx,
-		bson.M{},
+	update, err := m.filmsColl.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": concernedIDs}},
 		bson.D{
-			{Key: "$pull", Value: bson.D{{Key: "volume_files", Value: bson.D{{Key: "fromvolume", Value: volumeId}}}}},
+			{Key: "$pull", Value: bson.D{{Key: "volume_files", Value: bson.D{{Key: "from_volume", Value: volumeId}}}}},
 		})
 	if err != nil {
 		return err
 	}
 	log.Info().Any("volumeId", volumeId).Msgf("%d films are concerned with this volume deletion\n", update.ModifiedCount)
-	del, err := m.filmsColl.DeleteMany(m.ctx, bson.M{"volume_files": bson.D{{Key: "$size", Value: 0}}})
+	del, err := m.filmsColl.DeleteMany(ctx, bson.M{"volume_files": bson.D{{Key: "$size", Value: 0}}})
 	if err != nil {
 		return err
 	}
 	log.Info().Any("volumeId", volumeId).Msgf("%d films were removed from database\n", del.DeletedCount)
+	// The films that are still in other volumes lost the files of this one
+	if err := m.updateTechnicalInfos(ctx, bson.M{"_id": bson.M{"$in": concernedIDs}}); err != nil {
+		return err
+	}
 
 	// Remove specified volume from "volumes" collection
-	res, err := m.volumesColl.DeleteOne(m.ctx, bson.M{"_id": volumeId})
+	res, err := m.volumesColl.DeleteOne(ctx, bson.M{"_id": volumeId})
 	if err != nil {
 		return err
 	}
@@ -195,46 +513,209 @@ func (m *MongoDB) DeleteVolume(volumeId primitive.ObjectID) error {
 }
 
 // IsFilmPathPresent checks if a film path is present in the database
-func (m *MongoDB) IsFilmPathPresent(filmPath string) bool {
+func (m *MongoDB) IsFilmPathPresent(ctx context.Context, filmPath string) bool {
 	film := model.Film{}
-	return m.filmsColl.FindOne(m.ctx, getFilmPathFilter(filmPath)).Decode(&film) == nil
+	return m.filmsColl.FindOne(ctx, getFilmPathFilter(filmPath)).Decode(&film) == nil
 }
 
 // IsSubtitlePathPresent checks if a subtitle path is present in the database
-func (m *MongoDB) IsSubtitlePathPresent(subPath string) bool {
-	_, err := m.GetFilmFromExternalSubtitle(subPath)
+func (m *MongoDB) IsSubtitlePathPresent(ctx context.Context, subPath string) bool {
+	_, err := m.GetFilmFromExternalSubtitle(ctx, subPath)
 	return err == nil
 }
 
-// IsFilmPresent checks if a given film is already present in DB
-func (m *MongoDB) IsFilmPresent(film *model.Film) bool {
-	res := m.filmsColl.FindOne(m.ctx, bson.M{"tmdb_id": film.TMDBID})
-	return res.Err() != mongo.ErrNoDocuments
-}
-
 // AddFilm adds a given film to the DB
 // If the film is already in the database, updates it
-func (m *MongoDB) AddFilm(film *model.Film) error {
-	_, err := m.filmsColl.UpdateOne(m.ctx, bson.M{"_id": film.ID}, bson.M{"$set": film}, options.Update().SetUpsert(true))
+func (m *MongoDB) AddFilm(ctx context.Context, film *model.Film) error {
+	film.Technical = film.GetTechnicalInfo()
+	_, err := m.filmsColl.UpdateOne(ctx, bson.M{"_id": film.ID}, bson.M{"$set": film}, options.Update().SetUpsert(true))
 	return err
 }
 
-// AddVolumeSourceToFilm adds the volume as a source to the given media
-func (m *MongoDB) AddVolumeSourceToFilm(film *model.Film) error {
-	res, err := m.filmsColl.UpdateOne(m.ctx, bson.M{"tmdb_id": film.TMDBID}, bson.M{"$addToSet": bson.M{"volume_files": film.VolumeFiles[0]}})
+// MergeFilm adds the film to the DB, or adds its files to the film having the same TMDB ID, which then replaces it.
+// It returns whether the film was added
+func (m *MongoDB) MergeFilm(ctx context.Context, film *model.Film) (added bool, err error) {
+	film.Technical = film.GetTechnicalInfo()
+	fields, err := toBSONDocument(film)
 	if err != nil {
-		return err
-	} else if res.ModifiedCount == 0 {
-		return errors.New("unable to add volume as source of film to database")
+		return false, err
+	}
+	// Both fields are set by the update itself
+	delete(fields, "tmdb_id")
+	delete(fields, "volume_files")
+
+	// The upsert is atomic thanks to the unique index on the TMDB ID
+	var stored model.Film
+	err = m.filmsColl.FindOneAndUpdate(ctx,
+		bson.M{"tmdb_id": film.TMDBID},
+		bson.M{"$setOnInsert": fields, "$addToSet": bson.M{"volume_files": bson.M{"$each": film.VolumeFiles}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&stored)
+	if err != nil {
+		return false, fmt.Errorf("error while merging film: %w", err)
+	}
+	if stored.ID == film.ID {
+		return true, nil
+	}
+	if err := m.updateTechnicalInfo(ctx, bson.M{"_id": stored.ID}); err != nil {
+		return false, err
+	}
+	stored.Technical = stored.GetTechnicalInfo()
+	*film = stored
+	log.Debug().Str("path", film.VolumeFiles[len(film.VolumeFiles)-1].Path).Msg("Added volume as source of film to database")
+	return false, nil
+}
+
+// UpdateFilmMatch saves a film matched with another TMDB film: it replaces the film having the same ID, or, if another film
+// has its TMDB ID, its files are added to that film, which then replaces it, and it is deleted. It returns whether it was merged
+func (m *MongoDB) UpdateFilmMatch(ctx context.Context, film *model.Film) (merged bool, err error) {
+	film.Technical = film.GetTechnicalInfo()
+	var stored model.Film
+	err = m.filmsColl.FindOne(ctx, bson.M{"tmdb_id": bson.M{"$eq": film.TMDBID, "$gt": 0}, "_id": bson.M{"$ne": film.ID}}).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// The unique index on the TMDB ID makes the replacement fail if a film with this TMDB ID was added meanwhile
+		res, err := m.filmsColl.ReplaceOne(ctx, bson.M{"_id": film.ID}, film)
+		if err != nil {
+			return false, fmt.Errorf("error while updating film: %w", err)
+		}
+		if res.MatchedCount == 0 {
+			return false, fmt.Errorf("film '%s': %w", film.ID.Hex(), model.ErrNotFound)
+		}
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error while retrieving film from DB: %w", err)
+	}
+
+	// The unique index on the paths forbids the files to be in both films, and there is no transaction on a standalone server:
+	// the film is deleted first, and inserted back if its files cannot be added to the other film
+	del, err := m.filmsColl.DeleteOne(ctx, bson.M{"_id": film.ID})
+	if err != nil {
+		return false, fmt.Errorf("error while deleting film: %w", err)
+	}
+	if del.DeletedCount == 0 {
+		return false, fmt.Errorf("film '%s': %w", film.ID.Hex(), model.ErrNotFound)
+	}
+	err = m.filmsColl.FindOneAndUpdate(ctx,
+		bson.M{"_id": stored.ID},
+		bson.M{"$addToSet": bson.M{"volume_files": bson.M{"$each": film.VolumeFiles}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&stored)
+	if err != nil {
+		// The context may be done, which must not prevent the film from being restored
+		if _, insertErr := m.filmsColl.InsertOne(context.WithoutCancel(ctx), film); insertErr != nil {
+			log.Error().Err(insertErr).Str("filmID", film.ID.Hex()).Msg("Unable to restore film after a failed merge")
+		}
+		return false, fmt.Errorf("error while merging film: %w", err)
+	}
+	if err := m.updateTechnicalInfo(ctx, bson.M{"_id": stored.ID}); err != nil {
+		return false, err
+	}
+	stored.Technical = stored.GetTechnicalInfo()
+	*film = stored
+	return true, nil
+}
+
+// toBSONDocument returns the fields of a document, as they are stored
+func toBSONDocument(doc any) (bson.M, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error while encoding document: %w", err)
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("error while decoding document: %w", err)
+	}
+	return fields, nil
+}
+
+// updateTechnicalInfo computes again the technical info of a film from its volume files, after they changed
+func (m *MongoDB) updateTechnicalInfo(ctx context.Context, filter bson.M) error {
+	var film model.Film
+	if err := m.filmsColl.FindOne(ctx, filter).Decode(&film); err != nil {
+		return fmt.Errorf("error while retrieving film from DB: %w", err)
+	}
+	_, err := m.filmsColl.UpdateOne(ctx, bson.M{"_id": film.ID}, bson.M{"$set": bson.M{"technical": film.GetTechnicalInfo()}})
+	if err != nil {
+		return fmt.Errorf("error while updating film technical info: %w", err)
+	}
+	return nil
+}
+
+// updateTechnicalInfos computes again the technical info of the films matching the filter
+func (m *MongoDB) updateTechnicalInfos(ctx context.Context, filter bson.M) error {
+	filmsCur, err := m.filmsColl.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return fmt.Errorf("error while retrieving films from DB: %w", err)
+	}
+	var films []model.Film
+	if err := filmsCur.All(ctx, &films); err != nil {
+		return fmt.Errorf("error while decoding films from DB: %w", err)
+	}
+	for _, film := range films {
+		if err := m.updateTechnicalInfo(ctx, bson.M{"_id": film.ID}); err != nil {
+			return err
+		}
 	}
-	log.Debug().Str("path", film.VolumeFiles[0].Path).Msg("Added volume as source of film to database")
 	return nil
 }
 
+// MergeDuplicates merges the films, people and collections stored several times with the same TMDB ID, and returns them
+// along with the files that are in several films, which are left as they are. With dryRun, nothing is merged
+func (m *MongoDB) MergeDuplicates(ctx context.Context, dryRun bool) ([]model.Duplicate, error) {
+	films, err := m.GetFilms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	people, err := m.GetPeople(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collectionsCur, err := m.collectionsColl.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving collections from DB: %w", err)
+	}
+	var collections []model.Collection
+	if err := collectionsCur.All(ctx, &collections); err != nil {
+		return nil, fmt.Errorf("error while decoding collections from DB: %w", err)
+	}
+
+	filmMerge := mergeDuplicateFilms(films)
+	personDuplicates, deletedPeople := mergeDuplicateDocuments(model.DuplicatePerson, personKeys(people))
+	collectionDuplicates, deletedCollections := mergeDuplicateDocuments(model.DuplicateCollection, collectionKeys(collections))
+	duplicates := append(append(filmMerge.duplicates, personDuplicates...), collectionDuplicates...)
+	if dryRun {
+		return duplicates, nil
+	}
+
+	// The duplicates are only deleted once their files are in the films kept, so that an interrupted merge loses nothing
+	for _, film := range filmMerge.saved {
+		if _, err := m.filmsColl.ReplaceOne(ctx, bson.M{"_id": film.ID}, film); err != nil {
+			return nil, fmt.Errorf("error while merging film '%s': %w", film.ID.Hex(), err)
+		}
+	}
+	if err := m.deleteIDs(ctx, m.filmsColl, filmMerge.deleted); err != nil {
+		return nil, fmt.Errorf("error while deleting duplicate films: %w", err)
+	}
+	if err := m.deleteIDs(ctx, m.peopleColl, deletedPeople); err != nil {
+		return nil, fmt.Errorf("error while deleting duplicate people: %w", err)
+	}
+	if err := m.deleteIDs(ctx, m.collectionsColl, deletedCollections); err != nil {
+		return nil, fmt.Errorf("error while deleting duplicate collections: %w", err)
+	}
+	return duplicates, nil
+}
+
+// deleteIDs deletes the documents of the collection having the given IDs
+func (m *MongoDB) deleteIDs(ctx context.Context, coll *mongo.Collection, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
 // GetFilmFromPath retrieves a film from a path
-func (m *MongoDB) GetFilmFromPath(filmPath string) (film *model.Film, err error) {
+func (m *MongoDB) GetFilmFromPath(ctx context.Context, filmPath string) (film *model.Film, err error) {
 	film = &model.Film{}
-	err = m.filmsColl.FindOne(m.ctx, getFilmPathFilter(filmPath)).Decode(film)
+	err = m.filmsColl.FindOne(ctx, getFilmPathFilter(filmPath)).Decode(film)
 	if err != nil {
 		return nil, errors.New("could not get film from path")
 	}
@@ -245,23 +726,37 @@ func (m *MongoDB) GetFilmFromPath(filmPath string) (film *model.Film, err error)
 // film: Film struct that has its path changed
 // oldPath: file path of the volumeFile that will be changed
 // newVolumeFile: VolumeFile struct that replaces the previous one
-func (m *MongoDB) UpdateFilmVolumeFile(film *model.Film, oldPath string, newVolumeFile model.VolumeFile) error {
+func (m *MongoDB) UpdateFilmVolumeFile(ctx context.Context, film *model.Film, oldPath string, newVolumeFile model.VolumeFile) error {
 	oldPathIndex := slices.IndexFunc(film.VolumeFiles, func(vf model.VolumeFile) bool {
 		return vf.Path == oldPath
 	})
-	update, err := m.filmsColl.UpdateOne(m.ctx, getFilmPathFilter(oldPath), bson.M{"$set": bson.M{fmt.Sprintf("volume_files.%d", oldPathIndex): newVolumeFile}})
+	update, err := m.filmsColl.UpdateOne(ctx, getFilmPathFilter(oldPath), bson.M{"$set": bson.M{fmt.Sprintf("volume_files.%d", oldPathIndex): newVolumeFile}})
 	if err != nil {
 		return err
 	}
 	if update.ModifiedCount == 0 {
 		return errors.New("could not update the volume file")
 	}
+	return m.updateTechnicalInfo(ctx, bson.M{"_id": film.ID})
+}
+
+// UpdateFilmRatings saves the ratings of a film and the time they were scraped, leaving the rest of the stored film as it is.
+// It returns model.ErrNotFound if the film is not in the DB
+func (m *MongoDB) UpdateFilmRatings(ctx context.Context, film *model.Film) error {
+	res, err := m.filmsColl.UpdateOne(ctx, bson.M{"_id": film.ID},
+		bson.M{"$set": bson.M{"ratings": film.Ratings, "ratings_refreshed": film.RatingsRefreshed}})
+	if err != nil {
+		return fmt.Errorf("error while updating film ratings: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("film '%s': %w", film.ID.Hex(), model.ErrNotFound)
+	}
 	return nil
 }
 
 // DeleteFilm deletes a film
-func (m *MongoDB) DeleteFilm(ID primitive.ObjectID) error {
-	del, err := m.filmsColl.DeleteOne(m.ctx, bson.M{"_id": ID})
+func (m *MongoDB) DeleteFilm(ctx context.Context, ID primitive.ObjectID) error {
+	del, err := m.filmsColl.DeleteOne(ctx, bson.M{"_id": ID})
 	if err != nil {
 		return err
 	}
@@ -273,19 +768,19 @@ func (m *MongoDB) DeleteFilm(ID primitive.ObjectID) error {
 
 // DeleteFilmVolumeFile removes a film from the database
 // If the film has only 1 volume file, then the film is entirely deleted
-func (m *MongoDB) DeleteFilmVolumeFile(path string) error {
-	film, err := m.GetFilmFromPath(path)
+func (m *MongoDB) DeleteFilmVolumeFile(ctx context.Context, path string) error {
+	film, err := m.GetFilmFromPath(ctx, path)
 	if err != nil {
 		return err
 	}
 	// If it only had 1 volumeFile, remove the film entirely
 	if len(film.VolumeFiles) == 1 {
-		err = m.DeleteFilm(film.ID)
+		err = m.DeleteFilm(ctx, film.ID)
 		if err != nil {
 			return err
 		}
 	} else {
-		update, err := m.filmsColl.UpdateOne(m.ctx,
+		update, err := m.filmsColl.UpdateOne(ctx,
 			getFilmPathFilter(path),
 			bson.D{{Key: "$pull", Value: bson.D{{Key: "volume_files", Value: bson.D{{Key: "path", Value: path}}}}}})
 		if err != nil {
@@ -294,14 +789,15 @@ func (m *MongoDB) DeleteFilmVolumeFile(path string) error {
 		if update.ModifiedCount == 0 {
 			return errors.New("could not update film when replacing with a new one")
 		}
+		return m.updateTechnicalInfo(ctx, bson.M{"_id": film.ID})
 	}
 	return nil
 }
 
 // RemoveSubtitleFile removes a film subtitle from the database
-func (m *MongoDB) RemoveSubtitleFile(mediaPath, subtitlePath string) error {
+func (m *MongoDB) RemoveSubtitleFile(ctx context.Context, mediaPath, subtitlePath string) error {
 	var film model.Film
-	err := m.filmsColl.FindOne(m.ctx, getFilmPathFilter(mediaPath)).Decode(&film)
+	err := m.filmsColl.FindOne(ctx, getFilmPathFilter(mediaPath)).Decode(&film)
 	if err != nil {
 		return err
 	}
@@ -320,7 +816,10 @@ func (m *MongoDB) RemoveSubtitleFile(mediaPath, subtitlePath string) error {
 	}
 	film.VolumeFiles[volumeIndex].ExtSubtitles = slices.Delete(film.VolumeFiles[volumeIndex].ExtSubtitles, subtitleIndex, subtitleIndex+1)
 
-	updateRes, err := m.filmsColl.UpdateOne(m.ctx, getFilmPathFilter(mediaPath), bson.M{"$set": bson.D{{Key: "volume_files", Value: film.VolumeFiles}}})
+	updateRes, err := m.filmsColl.UpdateOne(ctx, getFilmPathFilter(mediaPath), bson.M{"$set": bson.D{
+		{Key: "volume_files", Value: film.VolumeFiles},
+		{Key: "technical", Value: film.GetTechnicalInfo()},
+	}})
 	if err != nil {
 		return err
 	}
@@ -331,24 +830,23 @@ func (m *MongoDB) RemoveSubtitleFile(mediaPath, subtitlePath string) error {
 }
 
 // IsPersonPresent checks if a person is already registered in the DB
-func (m *MongoDB) IsPersonPresent(personID int64) bool {
-	res := m.peopleColl.FindOne(m.ctx, bson.M{"tmdb_id": personID})
+func (m *MongoDB) IsPersonPresent(ctx context.Context, personID int64) bool {
+	res := m.peopleColl.FindOne(ctx, bson.M{"tmdb_id": personID})
 	return res.Err() != mongo.ErrNoDocuments
 }
 
-// AddPerson adds a person to the DB
-// TODO: upsert
-func (m *MongoDB) AddPerson(person *model.Person) {
-	_, err := m.peopleColl.InsertOne(m.ctx, *person)
+// AddPerson adds a person to the DB, unless a person with the same TMDB ID is already there
+func (m *MongoDB) AddPerson(ctx context.Context, person *model.Person) {
+	_, err := m.peopleColl.UpdateOne(ctx, bson.M{"tmdb_id": person.TMDBID}, bson.M{"$setOnInsert": person}, options.Update().SetUpsert(true))
 	if err != nil {
 		log.Error().Int64("personID", person.TMDBID).Err(err).Send()
 	}
 }
 
 // AddActors upserts the actors of a film to the DB
-func (m *MongoDB) AddActors(actors []model.Person) {
+func (m *MongoDB) AddActors(ctx context.Context, actors []model.Person) {
 	for _, actor := range actors {
-		res, err := m.peopleColl.UpdateOne(m.ctx, bson.M{"tmdb_id": actor.TMDBID}, bson.M{"$set": actor}, options.Update().SetUpsert(true))
+		res, err := m.peopleColl.UpdateOne(ctx, bson.M{"tmdb_id": actor.TMDBID}, bson.M{"$set": actor}, options.Update().SetUpsert(true))
 		if err != nil {
 			log.Warn().Str("actorName", actor.Name).Err(err).Msg("Unable to add actor to database")
 		}
@@ -366,28 +864,202 @@ func (m *MongoDB) AddActors(actors []model.Person) {
 	}
 }
 
+// UpdatePerson updates a person in the DB, adding it if it is not present yet
+func (m *MongoDB) UpdatePerson(ctx context.Context, person *model.Person) error {
+	_, err := m.peopleColl.UpdateOne(ctx, bson.M{"tmdb_id": person.TMDBID}, bson.M{"$set": person}, options.Update().SetUpsert(true))
+	return err
+}
+
+// DeletePerson removes a person from the DB
+func (m *MongoDB) DeletePerson(ctx context.Context, personTMDBID int64) error {
+	if _, err := m.peopleColl.DeleteOne(ctx, bson.M{"tmdb_id": personTMDBID}); err != nil {
+		return fmt.Errorf("error while deleting person %d from DB: %w", personTMDBID, err)
+	}
+	return nil
+}
+
+// AddPersonRetry adds a person whose details will be fetched again, or updates its next attempt
+func (m *MongoDB) AddPersonRetry(ctx context.Context, retry *model.PersonRetry) error {
+	_, err := m.personRetriesColl.ReplaceOne(ctx, bson.M{"_id": retry.TMDBID}, retry, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("error while adding retry of person %d to DB: %w", retry.TMDBID, err)
+	}
+	return nil
+}
+
+// GetPersonRetries returns at most limit retries whose next attempt is before the given time, earliest first
+func (m *MongoDB) GetPersonRetries(ctx context.Context, before time.Time, limit int64) (retries []model.PersonRetry, err error) {
+	opt := options.Find().
+		SetSort(bson.M{"next_attempt": 1}).
+		SetLimit(limit)
+	retriesCur, err := m.personRetriesColl.Find(ctx, bson.M{"next_attempt": bson.M{"$lte": before}}, opt)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving person retries from DB: %w", err)
+	}
+	if err := retriesCur.All(ctx, &retries); err != nil {
+		return nil, fmt.Errorf("error while decoding person retries from DB: %w", err)
+	}
+	return retries, nil
+}
+
+// DeletePersonRetry removes the retry of a person, if there is one
+func (m *MongoDB) DeletePersonRetry(ctx context.Context, personTMDBID int64) error {
+	if _, err := m.personRetriesColl.DeleteOne(ctx, bson.M{"_id": personTMDBID}); err != nil {
+		return fmt.Errorf("error while deleting retry of person %d from DB: %w", personTMDBID, err)
+	}
+	return nil
+}
+
+// GetPeopleToRefresh returns at most limit people whose details were fetched before the given time or without a filmography, oldest first
+func (m *MongoDB) GetPeopleToRefresh(ctx context.Context, before time.Time, limit int64) (people []model.Person, err error) {
+	opt := options.Find().
+		SetSort(bson.M{"last_refreshed": 1}).
+		SetLimit(limit)
+	filter := bson.M{"$or": []bson.M{
+		{"last_refreshed": bson.M{"$lt": before}},
+		{"last_refreshed": bson.M{"$exists": false}},
+		{"filmography": bson.M{"$exists": false}},
+	}}
+	peopleCur, err := m.peopleColl.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving people to refresh from DB: %w", err)
+	}
+	for peopleCur.Next(ctx) {
+		var person model.Person
+		if err := peopleCur.Decode(&person); err != nil {
+			return nil, fmt.Errorf("error while decoding person from DB: %w", err)
+		}
+		people = append(people, person)
+	}
+	return people, nil
+}
+
 // GetPersonFromID returns the Person struct
-func (m *MongoDB) GetPersonFromID(ID primitive.ObjectID) (*model.Person, error) {
+func (m *MongoDB) GetPersonFromID(ctx context.Context, ID primitive.ObjectID) (*model.Person, error) {
 	var person model.Person
-	err := m.peopleColl.FindOne(m.ctx, bson.M{"_id": ID}).Decode(&person)
+	err := m.peopleColl.FindOne(ctx, bson.M{"_id": ID}).Decode(&person)
 	return &person, err
 }
 
 // GetPersonFromTMDBID returns the Person struct
-func (m *MongoDB) GetPersonFromTMDBID(TMDBID int64) (*model.Person, error) {
+func (m *MongoDB) GetPersonFromTMDBID(ctx context.Context, TMDBID int64) (*model.Person, error) {
 	var person model.Person
-	err := m.peopleColl.FindOne(m.ctx, bson.M{"tmdb_id": TMDBID}).Decode(&person)
+	err := m.peopleColl.FindOne(ctx, bson.M{"tmdb_id": TMDBID}).Decode(&person)
 	return &person, err
 }
 
-func (m *MongoDB) GetPeople() (people []model.Person, err error) {
+// GetPeopleFiltered returns the people of the library's films matching the filter with their number of films,
+// sorted and limited by the list options, and the total number of matching people
+func (m *MongoDB) GetPeopleFiltered(ctx context.Context, filter model.PersonFilter, listOptions model.ListOptions) (people []model.PersonFilmCount, total int64, err error) {
+	// Credits of every film, the crew being limited to the jobs of the crew roles
+	roleJobs := bson.A{}
+	roleBranches := bson.A{}
+	for _, role := range model.CrewRoles {
+		roleJobs = append(roleJobs, role.Job)
+		roleBranches = append(roleBranches, bson.M{"case": bson.M{"$eq": bson.A{"$$credit.job", role.Job}}, "then": role.Slug})
+	}
+	credits := bson.A{
+		bson.M{"$map": bson.M{"input": bson.M{"$ifNull": bson.A{"$characters", bson.A{}}}, "as": "credit", "in": bson.M{"id": "$$credit.actor_id", "role": model.PersonRoleActor}}},
+		bson.M{"$map": bson.M{"input": bson.M{"$ifNull": bson.A{"$directors", bson.A{}}}, "as": "credit", "in": bson.M{"id": "$$credit", "role": model.PersonRoleDirector}}},
+		bson.M{"$map": bson.M{"input": bson.M{"$ifNull": bson.A{"$writers", bson.A{}}}, "as": "credit", "in": bson.M{"id": "$$credit", "role": model.PersonRoleWriter}}},
+		bson.M{"$map": bson.M{
+			"input": bson.M{"$filter": bson.M{"input": bson.M{"$ifNull": bson.A{"$crew", bson.A{}}}, "as": "credit", "cond": bson.M{"$in": bson.A{"$$credit.job", roleJobs}}}},
+			"as":    "credit",
+			"in":    bson.M{"id": "$$credit.person_id", "role": bson.M{"$switch": bson.M{"branches": roleBranches, "default": ""}}},
+		}},
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{"credits": bson.M{"$concatArrays": credits}}}},
+		{{Key: "$unwind", Value: "$credits"}},
+	}
+	if filter.TMDBIDs != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"credits.id": bson.M{"$in": filter.TMDBIDs}}}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$group", Value: bson.M{
+		"_id":   "$credits.id",
+		"films": bson.M{"$addToSet": "$_id"},
+		"roles": bson.M{"$addToSet": "$credits.role"},
+	}}})
+	if filter.Role != "" {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"roles": filter.Role}}})
+	}
+
+	order := 1
+	if listOptions.Descending {
+		order = -1
+	}
+	var sort bson.D
+	switch listOptions.Sort {
+	case model.PersonSortFilms:
+		sort = bson.D{{Key: "film_count", Value: order}, {Key: "person.name", Value: 1}}
+	case model.PersonSortBirthday:
+		// People without a known birthday come last
+		sort = bson.D{{Key: "has_birthday", Value: -1}, {Key: "person.birthday", Value: order}}
+	default:
+		sort = bson.D{{Key: "person.name", Value: order}}
+	}
+	sort = append(sort, bson.E{Key: "_id", Value: order})
+	page := bson.A{bson.M{"$sort": sort}, bson.M{"$skip": listOptions.Skip}}
+	if listOptions.Limit > 0 {
+		page = append(page, bson.M{"$limit": listOptions.Limit})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$lookup", Value: bson.M{"from": m.peopleColl.Name(), "localField": "_id", "foreignField": "tmdb_id", "as": "person"}}},
+		bson.D{{Key: "$unwind", Value: "$person"}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"person":       1,
+			"film_count":   bson.M{"$size": "$films"},
+			"has_birthday": bson.M{"$gt": bson.A{"$person.birthday", ""}},
+		}}},
+		bson.D{{Key: "$facet", Value: bson.M{
+			"total":  bson.A{bson.M{"$count": "count"}},
+			"people": page,
+		}}},
+	)
+
+	peopleCur, err := m.filmsColl.Aggregate(ctx, pipeline, options.Aggregate().SetCollation(listCollation))
+	if err != nil {
+		return nil, 0, fmt.Errorf("error while retrieving people from DB: %w", err)
+	}
+	var results []struct {
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		People []model.PersonFilmCount `bson:"people"`
+	}
+	if err := peopleCur.All(ctx, &results); err != nil {
+		return nil, 0, fmt.Errorf("error while decoding people from DB: %w", err)
+	}
+	if len(results) == 0 || len(results[0].Total) == 0 {
+		return nil, 0, nil
+	}
+	return results[0].People, results[0].Total[0].Count, nil
+}
+
+// GetPeopleWithName returns the people with a name, ignoring its case and its accents
+func (m *MongoDB) GetPeopleWithName(ctx context.Context, name string) (people []model.Person, err error) {
+	peopleCur, err := m.peopleColl.Find(ctx, bson.M{"name": name}, options.Find().SetCollation(nameCollation))
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving people from DB: %w", err)
+	}
+	for peopleCur.Next(ctx) {
+		var person model.Person
+		if err := peopleCur.Decode(&person); err != nil {
+			return nil, fmt.Errorf("error while decoding person from DB: %w", err)
+		}
+		people = append(people, person)
+	}
+	return people, nil
+}
+
+func (m *MongoDB) GetPeople(ctx context.Context) (people []model.Person, err error) {
 	opt := options.Find()
 	opt.SetSort(bson.M{"title": 1})
-	peopleCur, err := m.peopleColl.Find(m.ctx, bson.M{}, opt)
+	peopleCur, err := m.peopleColl.Find(ctx, bson.M{}, opt)
 	if err != nil {
 		return nil, fmt.Errorf("error while retrieving people from DB: %w", err)
 	}
-	for peopleCur.Next(m.ctx) {
+	for peopleCur.Next(ctx) {
 		var person model.Person
 		err := peopleCur.Decode(&person)
 		if err != nil {
@@ -398,15 +1070,18 @@ func (m *MongoDB) GetPeople() (people []model.Person, err error) {
 	return
 }
 
-// GetFilmFromID returns a film from its TMDB ID
-func (m *MongoDB) GetFilmFromID(id primitive.ObjectID) (*model.Film, error) {
+// GetFilmFromID returns a film from its ID, or model.ErrNotFound if it is not in the DB
+func (m *MongoDB) GetFilmFromID(ctx context.Context, id primitive.ObjectID) (*model.Film, error) {
 	var film model.Film
-	err := m.filmsColl.FindOne(m.ctx, bson.M{"_id": id}).Decode(&film)
+	err := m.filmsColl.FindOne(ctx, bson.M{"_id": id}).Decode(&film)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = fmt.Errorf("film '%s': %w", id.Hex(), model.ErrNotFound)
+	}
 	return &film, err
 }
 
-func (m *MongoDB) GetFilmCount() int64 {
-	count, err := m.filmsColl.CountDocuments(m.ctx, bson.M{})
+func (m *MongoDB) GetFilmCount(ctx context.Context) int64 {
+	count, err := m.filmsColl.CountDocuments(ctx, bson.M{})
 	if err != nil {
 		return 0
 	}
@@ -414,14 +1089,14 @@ func (m *MongoDB) GetFilmCount() int64 {
 }
 
 // GetFilms returns a slice of Film
-func (m *MongoDB) GetFilms() (films []model.Film, err error) {
+func (m *MongoDB) GetFilms(ctx context.Context) (films []model.Film, err error) {
 	opt := options.Find()
 	opt.SetSort(bson.M{"title": 1})
-	filmsCur, err := m.filmsColl.Find(m.ctx, bson.M{}, opt)
+	filmsCur, err := m.filmsColl.Find(ctx, bson.M{}, opt)
 	if err != nil {
 		return nil, fmt.Errorf("error while retrieving films from DB: %w", err)
 	}
-	for filmsCur.Next(m.ctx) {
+	for filmsCur.Next(ctx) {
 		var film model.Film
 		err := filmsCur.Decode(&film)
 		if err != nil {
@@ -432,52 +1107,247 @@ func (m *MongoDB) GetFilms() (films []model.Film, err error) {
 	return
 }
 
-func (m *MongoDB) GetFilmsFiltered(years []int, genre, country string) (films []model.Film) {
-	opt := options.Find()
-	opt.SetSort(bson.M{"title": 1})
-	filter := bson.M{}
-	if len(years) > 0 {
-		var orYears []bson.M
-		for _, year := range years {
-			orYears = append(orYears, bson.M{"releaseyear": year})
-		}
-		filter["$or"] = orYears
+// GetFilmsToRefresh returns at most limit films whose details were fetched before the given time, oldest first
+func (m *MongoDB) GetFilmsToRefresh(ctx context.Context, before time.Time, limit int64) ([]model.Film, error) {
+	return m.getStaleFilms(ctx, "last_refreshed", before, limit)
+}
+
+// GetFilmsToRefreshRatings returns at most limit films whose ratings were scraped before the given time, oldest first
+func (m *MongoDB) GetFilmsToRefreshRatings(ctx context.Context, before time.Time, limit int64) ([]model.Film, error) {
+	return m.getStaleFilms(ctx, "ratings_refreshed", before, limit)
+}
+
+// getStaleFilms returns at most limit films matched on TMDB, whose date field is before the given time
+func (m *MongoDB) getStaleFilms(ctx context.Context, field string, before time.Time, limit int64) (films []model.Film, err error) {
+	opt := options.Find().
+		SetSort(bson.M{field: 1}).
+		SetLimit(limit)
+	filter := bson.M{
+		"tmdb_id": bson.M{"$ne": 0},
+		"$or": []bson.M{
+			{field: bson.M{"$lt": before}},
+			{field: bson.M{"$exists": false}},
+		},
 	}
-	if genre != "" {
-		filter["genres"] = primitive.Regex{Pattern: fmt.Sprintf("^%s$", genre), Options: "i"}
+	filmsCur, err := m.filmsColl.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving films to refresh from DB: %w", err)
 	}
-	if country != "" {
-		filter["prodcountries"] = primitive.Regex{Pattern: fmt.Sprintf("^%s$", country), Options: "i"}
+	for filmsCur.Next(ctx) {
+		var film model.Film
+		if err := filmsCur.Decode(&film); err != nil {
+			return nil, fmt.Errorf("error while decoding film from DB: %w", err)
+		}
+		films = append(films, film)
 	}
+	return films, nil
+}
+
+// GetFilmsFiltered returns the films matching the filter, sorted and limited by the list options, and the total number of matching films
+func (m *MongoDB) GetFilmsFiltered(ctx context.Context, filter model.FilmFilter, listOptions model.ListOptions) (films []model.Film, total int64, err error) {
+	mongoFilter := getFilmFilter(filter)
 
-	filmsCur, err := m.filmsColl.Find(m.ctx, filter, opt)
+	total, err = m.filmsColl.CountDocuments(ctx, mongoFilter, options.Count().SetCollation(listCollation))
 	if err != nil {
-		log.Error().Err(err).Msg("Unable to retrieve films from database")
-		return
+		return nil, 0, fmt.Errorf("error while counting films in DB: %w", err)
+	}
+
+	sortField, ok := filmSortFields[listOptions.Sort]
+	if !ok {
+		sortField = filmSortFields[model.FilmSortTitle]
+	}
+	opt := getListFindOptions(sortField, listOptions)
+	filmsCur, err := m.filmsColl.Find(ctx, mongoFilter, opt)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error while retrieving films from DB: %w", err)
 	}
-	for filmsCur.Next(m.ctx) {
+	for filmsCur.Next(ctx) {
 		var film model.Film
-		err := filmsCur.Decode(&film)
-		if err != nil {
-			log.Error().Err(err).Msg("Unable to fetch film from database")
+		if err := filmsCur.Decode(&film); err != nil {
+			return nil, 0, fmt.Errorf("error while decoding film from DB: %w", err)
 		}
 		films = append(films, film)
 	}
-	return
+	return films, total, nil
+}
+
+// GetRandomFilms returns at most number films picked at random among the ones matching the filter
+func (m *MongoDB) GetRandomFilms(ctx context.Context, filter model.FilmFilter, number int64) (films []model.Film, err error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: getFilmFilter(filter)}},
+		{{Key: "$sample", Value: bson.M{"size": number}}},
+	}
+	filmsCur, err := m.filmsColl.Aggregate(ctx, pipeline, options.Aggregate().SetCollation(listCollation))
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving random films from DB: %w", err)
+	}
+	if err := filmsCur.All(ctx, &films); err != nil {
+		return nil, fmt.Errorf("error while decoding films from DB: %w", err)
+	}
+	return films, nil
+}
+
+// GetDirectorsByFilmCount returns the TMDB IDs of the directors with the most films, from the one with the most
+func (m *MongoDB) GetDirectorsByFilmCount(ctx context.Context, number int64) ([]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$directors"}},
+		{{Key: "$group", Value: bson.M{"_id": "$directors", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: number}},
+	}
+	directorsCur, err := m.filmsColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("error while counting films by director in DB: %w", err)
+	}
+	var results []struct {
+		TMDBID int64 `bson:"_id"`
+	}
+	if err := directorsCur.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("error while decoding directors from DB: %w", err)
+	}
+	directorIDs := make([]int64, 0, len(results))
+	for _, result := range results {
+		directorIDs = append(directorIDs, result.TMDBID)
+	}
+	return directorIDs, nil
+}
+
+// getFilmFilter translates a film filter to a Mongo filter.
+// Genres and countries are compared case-insensitively thanks to the list collation
+func getFilmFilter(filter model.FilmFilter) bson.M {
+	mongoFilter := bson.M{}
+	if len(filter.Years) > 0 {
+		mongoFilter["release_year"] = bson.M{"$in": filter.Years}
+	}
+	if filter.Genre != "" {
+		mongoFilter["genres"] = filter.Genre
+	}
+	if filter.Country != "" {
+		mongoFilter["prod_countries"] = filter.Country
+	}
+	if filter.IDs != nil {
+		mongoFilter["_id"] = bson.M{"$in": filter.IDs}
+	}
+	if filter.Resolution != "" {
+		mongoFilter["technical.resolutions"] = filter.Resolution
+	}
+	if filter.VideoCodec != "" {
+		mongoFilter["technical.video_codecs"] = filter.VideoCodec
+	}
+	switch filter.HDR {
+	case "":
+	case model.HDRAny:
+		mongoFilter["technical.hdr_formats.0"] = bson.M{"$exists": true}
+	case model.HDRNone:
+		mongoFilter["technical.hdr_formats.0"] = bson.M{"$exists": false}
+	default:
+		mongoFilter["technical.hdr_formats"] = filter.HDR
+	}
+	if filter.AudioLanguage != "" {
+		mongoFilter["technical.audio_languages"] = filter.AudioLanguage
+	}
+	if filter.SubtitleLanguage != "" || filter.NoSubtitleLanguage != "" {
+		subtitleFilter := bson.M{}
+		if filter.SubtitleLanguage != "" {
+			subtitleFilter["$eq"] = filter.SubtitleLanguage
+		}
+		if filter.NoSubtitleLanguage != "" {
+			subtitleFilter["$ne"] = filter.NoSubtitleLanguage
+		}
+		mongoFilter["technical.subtitle_languages"] = subtitleFilter
+	}
+	if filter.Query != nil {
+		mongoFilter["$and"] = bson.A{getQueryFilter(filter.Query)}
+	}
+	return mongoFilter
+}
+
+// getQueryFilter translates a resolved film query to a Mongo filter
+func getQueryFilter(node model.QueryNode) bson.M {
+	switch n := node.(type) {
+	case model.QueryAnd:
+		return bson.M{"$and": getQueryFilters(n.Nodes)}
+	case model.QueryOr:
+		return bson.M{"$or": getQueryFilters(n.Nodes)}
+	case model.QueryNot:
+		return bson.M{"$nor": bson.A{getQueryFilter(n.Node)}}
+	case model.QueryMatch:
+		if n.Field == model.QueryFieldHDR {
+			switch n.Value {
+			case model.HDRAny:
+				return bson.M{"technical.hdr_formats.0": bson.M{"$exists": true}}
+			case model.HDRNone:
+				return bson.M{"technical.hdr_formats.0": bson.M{"$exists": false}}
+			}
+		}
+		return bson.M{queryFields[n.Field]: n.Value}
+	case model.QueryComparison:
+		operator := queryOperators[n.Operator]
+		// Unknown values are stored as 0, and must not match
+		comparison := bson.M{"$gt": 0}
+		if operator == "$gt" {
+			comparison["$gt"] = max(n.Value, 0)
+		} else {
+			comparison[operator] = n.Value
+		}
+		return bson.M{queryFields[n.Field]: comparison}
+	case model.QueryPerson:
+		ids := n.TMDBIDs
+		if ids == nil {
+			ids = []int64{}
+		}
+		return bson.M{queryFields[n.Field]: bson.M{"$in": ids}}
+	case model.QueryText:
+		ids := n.FilmIDs
+		if ids == nil {
+			ids = []primitive.ObjectID{}
+		}
+		return bson.M{"_id": bson.M{"$in": ids}}
+	}
+	log.Error().Type("node", node).Msg("Unknown film query node")
+	return bson.M{"_id": bson.M{"$exists": false}}
+}
+
+func getQueryFilters(nodes []model.QueryNode) bson.A {
+	filters := bson.A{}
+	for _, node := range nodes {
+		filters = append(filters, getQueryFilter(node))
+	}
+	return filters
+}
+
+// getListFindOptions returns the find options sorting on the field, then on the ID so that pages are stable
+func getListFindOptions(sortField string, listOptions model.ListOptions) *options.FindOptions {
+	order := 1
+	if listOptions.Descending {
+		order = -1
+	}
+	sort := bson.D{{Key: sortField, Value: order}}
+	if sortField != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: order})
+	}
+	opt := options.Find().
+		SetCollation(listCollation).
+		SetSort(sort).
+		SetSkip(listOptions.Skip)
+	if listOptions.Limit > 0 {
+		opt.SetLimit(listOptions.Limit)
+	}
+	return opt
 }
 
 // GetFilmsRange returns a slice of Film from start to number
-func (m *MongoDB) GetFilmsRange(start, number int) (films []model.Film) {
+func (m *MongoDB) GetFilmsRange(ctx context.Context, start, number int) (films []model.Film) {
 	opt := options.Find()
 	opt.SetSort(bson.M{"title": 1})
 	opt.SetSkip(int64(start))
 	opt.SetLimit(int64(number))
-	filmsCur, err := m.filmsColl.Find(m.ctx, bson.M{}, opt)
+	filmsCur, err := m.filmsColl.Find(ctx, bson.M{}, opt)
 	if err != nil {
 		log.Error().Err(err).Msg("Unable to retrieve films from database")
 		return
 	}
-	for filmsCur.Next(m.ctx) {
+	for filmsCur.Next(ctx) {
 		var film model.Film
 		err := filmsCur.Decode(&film)
 		if err != nil {
@@ -489,12 +1359,12 @@ func (m *MongoDB) GetFilmsRange(start, number int) (films []model.Film) {
 }
 
 // GetFilmsFromVolume retrieves all films from a specific volume ID
-func (m *MongoDB) GetFilmsFromVolume(id primitive.ObjectID) (films []model.Film) {
-	filmsCur, err := m.filmsColl.Find(m.ctx, bson.M{"volume_files": bson.D{{Key: "$elemMatch", Value: bson.M{"fromvolume": id}}}})
+func (m *MongoDB) GetFilmsFromVolume(ctx context.Context, id primitive.ObjectID) (films []model.Film) {
+	filmsCur, err := m.filmsColl.Find(ctx, bson.M{"volume_files": bson.D{{Key: "$elemMatch", Value: bson.M{"from_volume": id}}}})
 	if err != nil {
 		log.Error().Err(err).Msg("Unable to retrieve films from database")
 	}
-	for filmsCur.Next(m.ctx) {
+	for filmsCur.Next(ctx) {
 		var film model.Film
 		err := filmsCur.Decode(&film)
 		if err != nil {
@@ -506,13 +1376,13 @@ func (m *MongoDB) GetFilmsFromVolume(id primitive.ObjectID) (films []model.Film)
 }
 
 // GetFilmsWithActor returns a list of films starring desired actor ID
-func (m *MongoDB) GetFilmsWithActor(actorID int64) (films []model.Film) {
-	filmsCur, err := m.filmsColl.Find(m.ctx, bson.M{"characters": bson.D{{Key: "$elemMatch", Value: bson.M{"actor_id": actorID}}}})
+func (m *MongoDB) GetFilmsWithActor(ctx context.Context, actorID int64) (films []model.Film) {
+	filmsCur, err := m.filmsColl.Find(ctx, bson.M{"characters": bson.D{{Key: "$elemMatch", Value: bson.M{"actor_id": actorID}}}})
 	if err != nil {
 		log.Error().Err(err).Int64("actorID", actorID).Msg("Unable to retrieve films with actor from database")
 		return
 	}
-	for filmsCur.Next(m.ctx) {
+	for filmsCur.Next(ctx) {
 		var film model.Film
 		err := filmsCur.Decode(&film)
 		if err != nil {
@@ -524,13 +1394,13 @@ func (m *MongoDB) GetFilmsWithActor(actorID int64) (films []model.Film) {
 }
 
 // GetFilmsWithDirector returns a list of films directed by desired director ID
-func (m *MongoDB) GetFilmsWithDirector(directorID int64) (films []model.Film) {
-	filmsCur, err := m.filmsColl.Find(m.ctx, bson.M{"directors": bson.D{{Key: "$elemMatch", Value: bson.M{"$eq": directorID}}}})
+func (m *MongoDB) GetFilmsWithDirector(ctx context.Context, directorID int64) (films []model.Film) {
+	filmsCur, err := m.filmsColl.Find(ctx, bson.M{"directors": bson.D{{Key: "$elemMatch", Value: bson.M{"$eq": directorID}}}})
 	if err != nil {
 		log.Error().Err(err).Int64("directorID", directorID).Msg("Unable to retrieve films with actor from database")
 		return
 	}
-	for filmsCur.Next(m.ctx) {
+	for filmsCur.Next(ctx) {
 		var film model.Film
 		err := filmsCur.Decode(&film)
 		if err != nil {
@@ -542,13 +1412,31 @@ func (m *MongoDB) GetFilmsWithDirector(directorID int64) (films []model.Film) {
 }
 
 // GetFilmsWithWriter returns a list of films written by desired writer ID
-func (m *MongoDB) GetFilmsWithWriter(writerID int64) (films []model.Film) {
-	filmsCur, err := m.filmsColl.Find(m.ctx, bson.M{"writers": bson.D{{Key: "$elemMatch", Value: bson.M{"$eq": writerID}}}})
+func (m *MongoDB) GetFilmsWithWriter(ctx context.Context, writerID int64) (films []model.Film) {
+	filmsCur, err := m.filmsColl.Find(ctx, bson.M{"writers": bson.D{{Key: "$elemMatch", Value: bson.M{"$eq": writerID}}}})
 	if err != nil {
 		log.Error().Err(err).Int64("writerID", writerID).Msg("Unable to retrieve films with actor from database")
 		return
 	}
-	for filmsCur.Next(m.ctx) {
+	for filmsCur.Next(ctx) {
+		var film model.Film
+		err := filmsCur.Decode(&film)
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to fetch film from database")
+		}
+		films = append(films, film)
+	}
+	return
+}
+
+// GetFilmsWithCrewJob returns a list of films where a person had a job in the crew
+func (m *MongoDB) GetFilmsWithCrewJob(ctx context.Context, personID int64, job string) (films []model.Film) {
+	filmsCur, err := m.filmsColl.Find(ctx, bson.M{"crew": bson.D{{Key: "$elemMatch", Value: bson.M{"person_id": personID, "job": job}}}})
+	if err != nil {
+		log.Error().Err(err).Int64("personID", personID).Str("job", job).Msg("Unable to retrieve films with crew job from database")
+		return
+	}
+	for filmsCur.Next(ctx) {
 		var film model.Film
 		err := filmsCur.Decode(&film)
 		if err != nil {
@@ -560,9 +1448,9 @@ func (m *MongoDB) GetFilmsWithWriter(writerID int64) (films []model.Film) {
 }
 
 // AddSubtitleToFilmPath adds the subtitle to a film given the film path
-func (m *MongoDB) AddSubtitleToFilmPath(filmFilePath string, sub model.Subtitle) error {
+func (m *MongoDB) AddSubtitleToFilmPath(ctx context.Context, filmFilePath string, sub model.Subtitle) error {
 	var film model.Film
-	err := m.filmsColl.FindOne(m.ctx, getFilmPathFilter(filmFilePath)).Decode(&film)
+	err := m.filmsColl.FindOne(ctx, getFilmPathFilter(filmFilePath)).Decode(&film)
 	if err != nil {
 		return err
 	}
@@ -576,7 +1464,10 @@ func (m *MongoDB) AddSubtitleToFilmPath(filmFilePath string, sub model.Subtitle)
 		return errors.New("subtitle is already added to media")
 	}
 	film.VolumeFiles[i].ExtSubtitles = append(film.VolumeFiles[i].ExtSubtitles, sub)
-	updateRes, err := m.filmsColl.UpdateOne(m.ctx, getFilmPathFilter(filmFilePath), bson.M{"$set": bson.D{{Key: "volume_files", Value: film.VolumeFiles}}})
+	updateRes, err := m.filmsColl.UpdateOne(ctx, getFilmPathFilter(filmFilePath), bson.M{"$set": bson.D{
+		{Key: "volume_files", Value: film.VolumeFiles},
+		{Key: "technical", Value: film.GetTechnicalInfo()},
+	}})
 	if err != nil {
 		return err
 	}
@@ -587,14 +1478,14 @@ func (m *MongoDB) AddSubtitleToFilmPath(filmFilePath string, sub model.Subtitle)
 }
 
 // GetFilmFromExternalSubtitle returns a film from its external subtitle path
-func (m *MongoDB) GetFilmFromExternalSubtitle(subtitlePath string) (model.Film, error) {
+func (m *MongoDB) GetFilmFromExternalSubtitle(ctx context.Context, subtitlePath string) (model.Film, error) {
 	var film model.Film
 	err := m.filmsColl.FindOne(
-		m.ctx,
+		ctx,
 		bson.M{
 			"volume_files": bson.D{{
 				Key: "$elemMatch",
-				Value: bson.M{"extsubtitles": bson.D{{
+				Value: bson.M{"ext_subtitles": bson.D{{
 					Key:   "$elemMatch",
 					Value: bson.M{"path": subtitlePath},
 				}}},
@@ -0,0 +1,103 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// mongoMigration updates the indexes or the documents stored by a previous version of starfin.
+// As the documents of a database that was never migrated may already be up to date, a migration must be idempotent
+type mongoMigration struct {
+	description string
+	apply       func(m *MongoDB, ctx context.Context) error
+}
+
+// mongoMigrations are applied in order, their version being their index plus one, and recorded in the migrations collection.
+// A released migration must never be changed, a new one must be appended instead
+var mongoMigrations = []mongoMigration{
+	{"Create the indexes of the film and people listings", (*MongoDB).createListIndexes},
+	{"Compute the technical info of the films added before it existed", func(m *MongoDB, ctx context.Context) error {
+		return m.updateTechnicalInfos(ctx, bson.M{"technical": bson.M{"$exists": false}})
+	}},
+	{"Set the date added of the films added before it existed", func(m *MongoDB, ctx context.Context) error {
+		// The creation time of the film is the best estimate we have
+		_, err := m.filmsColl.UpdateMany(ctx,
+			bson.M{"date_added": bson.M{"$exists": false}},
+			bson.A{bson.M{"$set": bson.M{"date_added": bson.M{"$toDate": "$_id"}}}})
+		return err
+	}},
+	{"Convert the year and the runtime of films to numbers", func(m *MongoDB, ctx context.Context) error {
+		toInt := func(field string) bson.M {
+			return bson.M{"$convert": bson.M{"input": "$" + field, "to": "int", "onError": 0, "onNull": 0}}
+		}
+		_, err := m.filmsColl.UpdateMany(ctx,
+			bson.M{"$or": bson.A{bson.M{"year": bson.M{"$type": "string"}}, bson.M{"runtime": bson.M{"$type": "string"}}}},
+			bson.A{bson.M{"$set": bson.M{"year": toInt("year"), "runtime": toInt("runtime")}}})
+		return err
+	}},
+	{"Make the TMDB IDs and the film paths unique, once the duplicates are merged", func(m *MongoDB, ctx context.Context) error {
+		if err := checkNoDuplicates(m.MergeDuplicates(ctx, true)); err != nil {
+			return err
+		}
+		return m.createUniqueIndexes(ctx)
+	}},
+	{"Convert the legacy IMDb and Letterboxd ratings of the films, and remove them", func(m *MongoDB, ctx context.Context) error {
+		// The ratings were scraped as strings, empty when they could not be fetched.
+		// They are converted unless a rating was fetched from the same source since
+		legacyRating := func(source, field string, best float64) bson.M {
+			value := bson.M{"$convert": bson.M{"input": "$" + field, "to": "double", "onError": 0.0, "onNull": 0.0}}
+			return bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{value, 0.0}},
+				bson.M{source: bson.M{"value": value, "best": best, "votes": int64(0), "fetched_at": "$ratings_refreshed"}},
+				bson.M{},
+			}}
+		}
+		_, err := m.filmsColl.UpdateMany(ctx,
+			bson.M{"$or": bson.A{bson.M{"imdb_rating": bson.M{"$exists": true}}, bson.M{"letterboxd_rating": bson.M{"$exists": true}}}},
+			bson.A{
+				bson.M{"$set": bson.M{"ratings": bson.M{"$mergeObjects": bson.A{
+					legacyRating(model.RatingSourceIMDb, "imdb_rating", 10),
+					legacyRating(model.RatingSourceLetterboxd, "letterboxd_rating", 5),
+					bson.M{"$ifNull": bson.A{"$ratings", bson.M{}}},
+				}}}},
+				bson.M{"$unset": bson.A{"imdb_rating", "letterboxd_rating"}},
+			})
+		return err
+	}},
+}
+
+// Migrate applies the migrations that were not applied to the database yet, and returns them.
+// With dryRun, the pending migrations are only returned
+func (m *MongoDB) Migrate(ctx context.Context, dryRun bool) (pending []model.Migration, err error) {
+	var applied model.Migration
+	err = m.migrationsColl.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"_id": -1})).Decode(&applied)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("error while retrieving schema version: %w", err)
+	}
+	if applied.Version > len(mongoMigrations) {
+		return nil, fmt.Errorf("database schema version %d is more recent than the supported version %d", applied.Version, len(mongoMigrations))
+	}
+	for i := applied.Version; i < len(mongoMigrations); i++ {
+		migration := model.Migration{Version: i + 1, Description: mongoMigrations[i].description}
+		if !dryRun {
+			if err := mongoMigrations[i].apply(m, ctx); err != nil {
+				return pending, fmt.Errorf("error while applying migration %d: %w", migration.Version, err)
+			}
+			migration.AppliedAt = time.Now()
+			if _, err := m.migrationsColl.InsertOne(ctx, migration); err != nil {
+				return pending, fmt.Errorf("error while recording migration %d: %w", migration.Version, err)
+			}
+			log.Info().Int("version", migration.Version).Str("migration", migration.Description).Msg("MongoDB migration applied")
+		}
+		pending = append(pending, migration)
+	}
+	return pending, nil
+}
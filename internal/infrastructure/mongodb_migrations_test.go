@@ -0,0 +1,62 @@
+package infrastructure
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// TestMongoDBMigrateRatings converts the ratings stored as strings by the first versions of starfin
+func TestMongoDBMigrateRatings(t *testing.T) {
+	url := os.Getenv("TEST_DB_URL")
+	if url == "" {
+		t.Skip("TEST_DB_URL is not set")
+	}
+	ctx := context.Background()
+	dbName := "starfin_test_" + primitive.NewObjectID().Hex()
+	db := NewMongoDB(os.Getenv("TEST_DB_USER"), os.Getenv("TEST_DB_PASSWORD"), url, os.Getenv("TEST_DB_PORT"), dbName)
+	t.Cleanup(func() {
+		db.client.Database(dbName).Drop(ctx)
+		db.Close()
+	})
+
+	refreshed := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	heat, alien, ronin := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	_, err := db.filmsColl.InsertMany(ctx, []any{
+		bson.M{"_id": heat, "tmdb_id": 949, "title": "Heat", "imdb_rating": "8.3", "letterboxd_rating": "4.2", "ratings_refreshed": refreshed},
+		// A rating that could not be scraped was stored empty
+		bson.M{"_id": alien, "tmdb_id": 348, "title": "Alien", "imdb_rating": "", "letterboxd_rating": "4.3", "ratings_refreshed": refreshed},
+		// A rating fetched since is kept
+		bson.M{"_id": ronin, "tmdb_id": 8195, "title": "Ronin", "imdb_rating": "7.1",
+			"ratings": bson.M{model.RatingSourceIMDb: bson.M{"value": 7.2, "best": 10.0, "votes": int64(230000)}}},
+	})
+	require.NoError(t, err)
+	_, err = db.Migrate(ctx, false)
+	require.NoError(t, err)
+
+	film, err := db.GetFilmFromID(ctx, heat)
+	require.NoError(t, err)
+	assert.Equal(t, model.Rating{Value: 8.3, Best: 10, FetchedAt: refreshed}, film.Rating(model.RatingSourceIMDb))
+	assert.Equal(t, model.Rating{Value: 4.2, Best: 5, FetchedAt: refreshed}, film.Rating(model.RatingSourceLetterboxd))
+	film, err = db.GetFilmFromID(ctx, alien)
+	require.NoError(t, err)
+	assert.False(t, film.Rating(model.RatingSourceIMDb).IsSet())
+	assert.Equal(t, 4.3, film.Rating(model.RatingSourceLetterboxd).Value)
+	film, err = db.GetFilmFromID(ctx, ronin)
+	require.NoError(t, err)
+	assert.Equal(t, model.Rating{Value: 7.2, Best: 10, Votes: 230000}, film.Rating(model.RatingSourceIMDb))
+
+	legacy, err := db.filmsColl.CountDocuments(ctx, bson.M{"$or": bson.A{
+		bson.M{"imdb_rating": bson.M{"$exists": true}}, bson.M{"letterboxd_rating": bson.M{"$exists": true}},
+	}})
+	require.NoError(t, err)
+	assert.Zero(t, legacy)
+}
@@ -47,7 +47,7 @@ func (m *MongoDB) SearchTorrents(ctx context.Context, search, category string, p
 		log.Error().Err(err).Msg("Unable to retrieve torrents from database")
 		return
 	}
-	for torrentsCur.Next(m.ctx) {
+	for torrentsCur.Next(ctx) {
 		var rt model.RarbgTorrent
 		err := torrentsCur.Decode(&rt)
 		if err != nil {
@@ -76,7 +76,7 @@ func (m *MongoDB) GetTorrents(ctx context.Context, imdbID string, offset, limit
 		log.Error().Err(err).Msg("Unable to retrieve torrents from database")
 		return
 	}
-	for torrentsCur.Next(m.ctx) {
+	for torrentsCur.Next(ctx) {
 		var rt model.RarbgTorrent
 		err := torrentsCur.Decode(&rt)
 		if err != nil {
@@ -99,7 +99,7 @@ func (m *MongoDB) GetAllTVTorrents(ctx context.Context, offset, limit int64) (to
 		log.Error().Err(err).Msg("Unable to retrieve torrents from database")
 		return
 	}
-	for torrentsCur.Next(m.ctx) {
+	for torrentsCur.Next(ctx) {
 		var rt model.RarbgTorrent
 		err := torrentsCur.Decode(&rt)
 		if err != nil {
@@ -135,7 +135,7 @@ func (m *MongoDB) GetTVTorrents(ctx context.Context, imdbID, season, episode str
 		log.Error().Err(err).Msg("Unable to retrieve torrents from database")
 		return
 	}
-	for torrentsCur.Next(m.ctx) {
+	for torrentsCur.Next(ctx) {
 		var rt model.RarbgTorrent
 		err := torrentsCur.Decode(&rt)
 		if err != nil {
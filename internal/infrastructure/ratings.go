@@ -0,0 +1,207 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// RatingsProvider fetches film ratings from an online source
+type RatingsProvider interface {
+	// Source returns the key under which the ratings are stored
+	Source() string
+	// GetRating returns the rating of a film from its IMDb ID
+	GetRating(ctx context.Context, imdbID string) (model.Rating, error)
+}
+
+const (
+	imdbBaseURL       = "https://www.imdb.com"
+	letterboxdBaseURL = "https://letterboxd.com"
+
+	ratingsUserAgent  = "starfin (+https://github.com/Agurato/starfin)"
+	ratingsTimeout    = 10 * time.Second
+	ratingsRetries    = 3
+	ratingsRetryDelay = time.Second
+)
+
+var (
+	errRatingNotFound = errors.New("rating not found in page")
+	errRetryable      = errors.New("retryable HTTP error")
+)
+
+// ratingsHTTPClient is an HTTP client with a timeout, that retries on transient errors
+type ratingsHTTPClient struct {
+	client     *http.Client
+	retries    int
+	retryDelay time.Duration
+}
+
+func newRatingsHTTPClient() ratingsHTTPClient {
+	return ratingsHTTPClient{
+		client:     &http.Client{Timeout: ratingsTimeout},
+		retries:    ratingsRetries,
+		retryDelay: ratingsRetryDelay,
+	}
+}
+
+// getDocument fetches an HTML page, retrying with an exponential backoff on network errors, 429 and 5xx responses.
+// It gives up as soon as the context is done
+func (rc ratingsHTTPClient) getDocument(ctx context.Context, url string) (doc *goquery.Document, err error) {
+	delay := rc.retryDelay
+	for attempt := 0; attempt <= rc.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			delay *= 2
+		}
+		doc, err = rc.tryGetDocument(ctx, url)
+		if err == nil || !errors.Is(err, errRetryable) {
+			return doc, err
+		}
+	}
+	return nil, err
+}
+
+func (rc ratingsHTTPClient) tryGetDocument(ctx context.Context, url string) (*goquery.Document, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", ratingsUserAgent)
+	req.Header.Set("Accept-Language", "en")
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %w", errRetryable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%w: status %d", errRetryable, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return goquery.NewDocumentFromReader(resp.Body)
+}
+
+// jsonLDNumber is a JSON-LD number, which can be encoded as a JSON number or string
+type jsonLDNumber float64
+
+func (n *jsonLDNumber) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseFloat(strings.Trim(string(data), `"`), 64)
+	if err != nil {
+		return err
+	}
+	*n = jsonLDNumber(value)
+	return nil
+}
+
+// jsonLDMovie holds the structured data of a film page we are interested in
+type jsonLDMovie struct {
+	AggregateRating *struct {
+		RatingValue jsonLDNumber `json:"ratingValue"`
+		BestRating  jsonLDNumber `json:"bestRating"`
+		RatingCount jsonLDNumber `json:"ratingCount"`
+	} `json:"aggregateRating"`
+}
+
+// getJSONLDRating returns the aggregated rating from the JSON-LD structured data of a page
+func getJSONLDRating(doc *goquery.Document) (rating model.Rating, err error) {
+	err = errRatingNotFound
+	doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(_ int, script *goquery.Selection) bool {
+		// Some sites wrap their structured data in a commented CDATA section
+		content := strings.TrimSpace(script.Text())
+		content = strings.TrimPrefix(content, "/* <![CDATA[ */")
+		content = strings.TrimSuffix(content, "/* ]]> */")
+
+		var movie jsonLDMovie
+		if json.Unmarshal([]byte(content), &movie) != nil || movie.AggregateRating == nil {
+			return true
+		}
+		rating = model.Rating{
+			Value:     float64(movie.AggregateRating.RatingValue),
+			Best:      float64(movie.AggregateRating.BestRating),
+			Votes:     int64(movie.AggregateRating.RatingCount),
+			FetchedAt: time.Now(),
+		}
+		err = nil
+		return false
+	})
+	return rating, err
+}
+
+// IMDbRatingsProvider fetches ratings from IMDb
+type IMDbRatingsProvider struct {
+	baseURL string
+	http    ratingsHTTPClient
+}
+
+// NewIMDbRatingsProvider initializes an IMDbRatingsProvider. An empty base URL defaults to IMDb's
+func NewIMDbRatingsProvider(baseURL string) *IMDbRatingsProvider {
+	if baseURL == "" {
+		baseURL = imdbBaseURL
+	}
+	return &IMDbRatingsProvider{
+		baseURL: baseURL,
+		http:    newRatingsHTTPClient(),
+	}
+}
+
+func (p IMDbRatingsProvider) Source() string {
+	return model.RatingSourceIMDb
+}
+
+// GetRating fetches the rating from the film's IMDb page
+func (p IMDbRatingsProvider) GetRating(ctx context.Context, imdbID string) (model.Rating, error) {
+	doc, err := p.http.getDocument(ctx, fmt.Sprintf("%s/title/%s/", p.baseURL, imdbID))
+	if err != nil {
+		return model.Rating{}, fmt.Errorf("cannot fetch IMDb page: %w", err)
+	}
+	return getJSONLDRating(doc)
+}
+
+// LetterboxdRatingsProvider fetches ratings from Letterboxd
+type LetterboxdRatingsProvider struct {
+	baseURL string
+	http    ratingsHTTPClient
+}
+
+// NewLetterboxdRatingsProvider initializes a LetterboxdRatingsProvider. An empty base URL defaults to Letterboxd's
+func NewLetterboxdRatingsProvider(baseURL string) *LetterboxdRatingsProvider {
+	if baseURL == "" {
+		baseURL = letterboxdBaseURL
+	}
+	return &LetterboxdRatingsProvider{
+		baseURL: baseURL,
+		http:    newRatingsHTTPClient(),
+	}
+}
+
+func (p LetterboxdRatingsProvider) Source() string {
+	return model.RatingSourceLetterboxd
+}
+
+// GetRating fetches the rating from the film's Letterboxd page, which Letterboxd redirects to from the IMDb ID
+func (p LetterboxdRatingsProvider) GetRating(ctx context.Context, imdbID string) (model.Rating, error) {
+	doc, err := p.http.getDocument(ctx, fmt.Sprintf("%s/imdb/%s/", p.baseURL, imdbID))
+	if err != nil {
+		return model.Rating{}, fmt.Errorf("cannot fetch Letterboxd page: %w", err)
+	}
+	return getJSONLDRating(doc)
+}
@@ -0,0 +1,150 @@
+package infrastructure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// newFixturesServer serves the recorded IMDb and Letterboxd pages
+func newFixturesServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/title/tt0183649/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/imdb_tt0183649.html")
+	})
+	mux.HandleFunc("/title/tt9999999/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/imdb_no_rating.html")
+	})
+	mux.HandleFunc("/imdb/tt0183649/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/film/phone-booth/", http.StatusFound)
+	})
+	mux.HandleFunc("/film/phone-booth/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/letterboxd_phone-booth.html")
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIMDbRatingsProvider(t *testing.T) {
+	ctx := context.Background()
+	server := newFixturesServer(t)
+	provider := NewIMDbRatingsProvider(server.URL)
+
+	t.Run("Source", func(t *testing.T) {
+		assert.Equal(t, model.RatingSourceIMDb, provider.Source())
+	})
+
+	t.Run("GetRating", func(t *testing.T) {
+		rating, err := provider.GetRating(ctx, "tt0183649")
+		require.NoError(t, err)
+		assert.Equal(t, 7.0, rating.Value)
+		assert.Equal(t, 10.0, rating.Best)
+		assert.Equal(t, int64(297489), rating.Votes)
+		assert.WithinDuration(t, time.Now(), rating.FetchedAt, time.Minute)
+	})
+
+	t.Run("GetRating without rating", func(t *testing.T) {
+		_, err := provider.GetRating(ctx, "tt9999999")
+		assert.ErrorIs(t, err, errRatingNotFound)
+	})
+
+	t.Run("GetRating unknown film", func(t *testing.T) {
+		_, err := provider.GetRating(ctx, "tt0000000")
+		assert.Error(t, err)
+	})
+}
+
+func TestLetterboxdRatingsProvider(t *testing.T) {
+	ctx := context.Background()
+	server := newFixturesServer(t)
+	provider := NewLetterboxdRatingsProvider(server.URL)
+
+	t.Run("Source", func(t *testing.T) {
+		assert.Equal(t, model.RatingSourceLetterboxd, provider.Source())
+	})
+
+	t.Run("GetRating", func(t *testing.T) {
+		rating, err := provider.GetRating(ctx, "tt0183649")
+		require.NoError(t, err)
+		assert.Equal(t, 3.16, rating.Value)
+		assert.Equal(t, 5.0, rating.Best)
+		assert.Equal(t, int64(68315), rating.Votes)
+		assert.Equal(t, "3.2", rating.String())
+	})
+}
+
+func TestRatingsHTTPClient(t *testing.T) {
+	ctx := context.Background()
+	t.Run("Retries on server errors", func(t *testing.T) {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			http.ServeFile(w, r, "testdata/imdb_tt0183649.html")
+		}))
+		defer server.Close()
+
+		provider := NewIMDbRatingsProvider(server.URL)
+		provider.http.retryDelay = time.Millisecond
+		rating, err := provider.GetRating(ctx, "tt0183649")
+		require.NoError(t, err)
+		assert.Equal(t, 7.0, rating.Value)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("Does not retry on client errors", func(t *testing.T) {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		provider := NewIMDbRatingsProvider(server.URL)
+		provider.http.retryDelay = time.Millisecond
+		_, err := provider.GetRating(ctx, "tt0183649")
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("Times out", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		}))
+		defer server.Close()
+
+		provider := NewIMDbRatingsProvider(server.URL)
+		provider.http.client.Timeout = 10 * time.Millisecond
+		provider.http.retries = 0
+		_, err := provider.GetRating(ctx, "tt0183649")
+		assert.ErrorIs(t, err, errRetryable)
+	})
+
+	t.Run("Stops retrying when the context is done", func(t *testing.T) {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		provider := NewIMDbRatingsProvider(server.URL)
+		provider.http.retryDelay = time.Second
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err := provider.GetRating(ctx, "tt0183649")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, attempts)
+	})
+}
@@ -0,0 +1,1449 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glebarez/go-sqlite"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// SQLite stores the library in an embedded SQLite database, as an alternative to MongoDB.
+// Documents are stored as JSON, next to the columns on which they are looked up and sorted
+type SQLite struct {
+	db    *sql.DB
+	rarbg *sql.DB
+}
+
+// sqliteMigration updates the schema or the documents stored by a previous version of starfin
+type sqliteMigration struct {
+	description string
+	prepare     func(s *SQLite, ctx context.Context) error // Run before the statements, outside of their transaction
+	statements  string
+}
+
+// sqliteMigrations are applied in order, their version being their index plus one, and recorded in schema_migrations.
+// A released migration must never be changed, a new one must be appended instead
+var sqliteMigrations = []sqliteMigration{
+	{description: "Create the tables", statements: `CREATE TABLE users (
+		id       TEXT PRIMARY KEY,
+		name     TEXT NOT NULL,
+		is_owner INTEGER NOT NULL,
+		data     TEXT NOT NULL
+	);
+	CREATE INDEX users_name ON users (name);
+
+	CREATE TABLE settings (
+		id   TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	);
+
+	CREATE TABLE smart_collections (
+		id      TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		name    TEXT NOT NULL,
+		data    TEXT NOT NULL
+	);
+	CREATE INDEX smart_collections_user_id ON smart_collections (user_id);
+
+	CREATE TABLE collections (
+		id      TEXT PRIMARY KEY,
+		tmdb_id INTEGER NOT NULL,
+		name    TEXT NOT NULL,
+		data    TEXT NOT NULL
+	);
+	CREATE INDEX collections_tmdb_id ON collections (tmdb_id);
+
+	CREATE TABLE volumes (
+		id   TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	);
+
+	CREATE TABLE films (
+		id                TEXT PRIMARY KEY,
+		tmdb_id           INTEGER NOT NULL,
+		title             TEXT NOT NULL,
+		release_year      INTEGER NOT NULL,
+		runtime           INTEGER NOT NULL, -- In minutes, 0 if unknown
+		rating            REAL NOT NULL,    -- IMDb rating, 0 if unknown
+		collection_id     INTEGER NOT NULL,
+		date_added        INTEGER NOT NULL, -- Dates are stored in milliseconds since the Unix epoch
+		last_refreshed    INTEGER NOT NULL,
+		ratings_refreshed INTEGER NOT NULL,
+		data              TEXT NOT NULL
+	);
+	CREATE INDEX films_tmdb_id ON films (tmdb_id);
+	CREATE INDEX films_collection_id ON films (collection_id);
+	CREATE INDEX films_release_year ON films (release_year, id);
+	CREATE INDEX films_runtime ON films (runtime, id);
+	CREATE INDEX films_rating ON films (rating, id);
+	CREATE INDEX films_date_added ON films (date_added, id);
+	CREATE INDEX films_last_refreshed ON films (last_refreshed);
+	CREATE INDEX films_ratings_refreshed ON films (ratings_refreshed);
+
+	CREATE TABLE film_files (
+		path      TEXT NOT NULL,
+		film_id   TEXT NOT NULL REFERENCES films (id) ON DELETE CASCADE,
+		volume_id TEXT NOT NULL
+	);
+	CREATE INDEX film_files_path ON film_files (path);
+	CREATE INDEX film_files_film_id ON film_files (film_id);
+	CREATE INDEX film_files_volume_id ON film_files (volume_id);
+
+	CREATE TABLE film_subtitles (
+		path    TEXT NOT NULL,
+		film_id TEXT NOT NULL REFERENCES films (id) ON DELETE CASCADE
+	);
+	CREATE INDEX film_subtitles_path ON film_subtitles (path);
+	CREATE INDEX film_subtitles_film_id ON film_subtitles (film_id);
+
+	CREATE TABLE people (
+		id             TEXT PRIMARY KEY,
+		tmdb_id        INTEGER NOT NULL,
+		name           TEXT NOT NULL,
+		birthday       TEXT NOT NULL,
+		last_refreshed INTEGER NOT NULL,
+		data           TEXT NOT NULL
+	);
+	CREATE INDEX people_tmdb_id ON people (tmdb_id);
+	CREATE INDEX people_last_refreshed ON people (last_refreshed);
+
+	CREATE TABLE person_retries (
+		tmdb_id      INTEGER PRIMARY KEY,
+		next_attempt INTEGER NOT NULL,
+		data         TEXT NOT NULL
+	);
+	CREATE INDEX person_retries_next_attempt ON person_retries (next_attempt);`},
+	{description: "Convert the year and the runtime of films to numbers", statements: `UPDATE films
+		SET data = json_set(data, '$.Year', CAST(json_extract(data, '$.Year') AS INTEGER), '$.Runtime', CAST(json_extract(data, '$.Runtime') AS INTEGER))
+		WHERE json_type(data, '$.Year') = 'text' OR json_type(data, '$.Runtime') = 'text'`},
+	{
+		description: "Make the TMDB IDs and the film paths unique, once the duplicates are merged",
+		prepare: func(s *SQLite, ctx context.Context) error {
+			return checkNoDuplicates(s.MergeDuplicates(ctx, true))
+		},
+		// The films not matched on TMDB all have the ID 0
+		statements: `DROP INDEX IF EXISTS films_tmdb_id;
+		CREATE UNIQUE INDEX films_tmdb_id ON films (tmdb_id) WHERE tmdb_id > 0;
+		DROP INDEX IF EXISTS film_files_path;
+		CREATE UNIQUE INDEX film_files_path ON film_files (path);
+		DROP INDEX IF EXISTS people_tmdb_id;
+		CREATE UNIQUE INDEX people_tmdb_id ON people (tmdb_id);
+		DROP INDEX IF EXISTS collections_tmdb_id;
+		CREATE UNIQUE INDEX collections_tmdb_id ON collections (tmdb_id);`,
+	},
+}
+
+// sqliteQueryArrays are the JSON arrays of the films queried by the fields of the query language
+var sqliteQueryArrays = map[string]string{
+	model.QueryFieldGenre:      "$.Genres",
+	model.QueryFieldCountry:    "$.ProdCountries",
+	model.QueryFieldDirector:   "$.Directors",
+	model.QueryFieldWriter:     "$.Writers",
+	model.QueryFieldActor:      "$.Characters",
+	model.QueryFieldResolution: "$.Technical.Resolutions",
+	model.QueryFieldCodec:      "$.Technical.VideoCodecs",
+	model.QueryFieldHDR:        "$.Technical.HDRFormats",
+	model.QueryFieldAudio:      "$.Technical.AudioLanguages",
+	model.QueryFieldSubtitles:  "$.Technical.SubtitleLanguages",
+}
+
+// sqliteQueryColumns are the columns of the numeric fields of the query language
+var sqliteQueryColumns = map[string]string{
+	model.QueryFieldYear:    "release_year",
+	model.QueryFieldRuntime: "runtime",
+	model.QueryFieldRating:  "rating",
+}
+
+// sqliteQueryOperators are the comparison operators of the query language, which are also SQL operators
+var sqliteQueryOperators = []string{
+	model.QueryOperatorEqual,
+	model.QueryOperatorLess,
+	model.QueryOperatorLessOrEqual,
+	model.QueryOperatorGreater,
+	model.QueryOperatorGreaterOrEqual,
+}
+
+// sqliteFilmSortColumns are the expressions on which the films are sorted, by sort order
+var sqliteFilmSortColumns = map[string]string{
+	model.FilmSortTitle:     "list_key(title)",
+	model.FilmSortYear:      "release_year",
+	model.FilmSortDateAdded: "date_added",
+	model.FilmSortRuntime:   "runtime",
+	model.FilmSortRating:    "rating",
+}
+
+func init() {
+	// Keys sorting like the list collation of MongoDB: case-insensitive, and with numbers sorted by value
+	sqlite.MustRegisterDeterministicScalarFunction("list_key", 1, collationKey(collate.New(language.English, collate.IgnoreCase, collate.Numeric)))
+	// Keys comparing names ignoring their case and their accents, like the name collation of MongoDB
+	sqlite.MustRegisterDeterministicScalarFunction("name_key", 1, collationKey(collate.New(language.English, collate.Loose)))
+	// Implements the REGEXP operator, used to search torrents
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, matchRegexp)
+}
+
+// collationKey returns an SQL function computing the sort key of a text with the collator
+func collationKey(collator *collate.Collator) func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+	var (
+		mu  sync.Mutex
+		buf collate.Buffer
+	)
+	return func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		text, ok := args[0].(string)
+		if !ok {
+			return nil, nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		key := slices.Clone(collator.KeyFromString(&buf, text))
+		buf.Reset()
+		return key, nil
+	}
+}
+
+// NewSQLite opens the SQLite database at the given path, creating it if needed.
+// Its schema must be updated with Migrate before it is used
+func NewSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("error while opening SQLite database: %w", err)
+	}
+	// Writes are serialized, so that concurrent ones do not fail because the database is locked
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at INTEGER NOT NULL)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error while creating migrations table: %w", err)
+	}
+	return &SQLite{db: db}, nil
+}
+
+// Migrate applies the migrations that were not applied to the database yet, each in a transaction, and returns them.
+// With dryRun, the pending migrations are only returned
+func (s *SQLite) Migrate(ctx context.Context, dryRun bool) (pending []model.Migration, err error) {
+	var version int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return nil, fmt.Errorf("error while retrieving schema version: %w", err)
+	}
+	if version > len(sqliteMigrations) {
+		return nil, fmt.Errorf("database schema version %d is more recent than the supported version %d", version, len(sqliteMigrations))
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		migration := model.Migration{Version: i + 1, Description: sqliteMigrations[i].description}
+		if !dryRun {
+			if prepare := sqliteMigrations[i].prepare; prepare != nil {
+				if err := prepare(s, ctx); err != nil {
+					return pending, fmt.Errorf("error while preparing migration %d: %w", migration.Version, err)
+				}
+			}
+			migration.AppliedAt = time.Now()
+			err := s.inTx(ctx, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, sqliteMigrations[i].statements); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, migration.Version, migration.AppliedAt.UnixMilli())
+				return err
+			})
+			if err != nil {
+				return pending, fmt.Errorf("error while applying migration %d: %w", migration.Version, err)
+			}
+			log.Info().Int("version", migration.Version).Str("migration", migration.Description).Msg("SQLite migration applied")
+		}
+		pending = append(pending, migration)
+	}
+	return pending, nil
+}
+
+// Close closes the SQLite database, and the RARBG one if it was opened
+func (s *SQLite) Close() error {
+	if s.rarbg != nil {
+		if err := s.rarbg.Close(); err != nil {
+			return err
+		}
+	}
+	return s.db.Close()
+}
+
+// sqliteQuerier is implemented by the database and by its transactions
+type sqliteQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// inTx runs fn in a transaction, which is rolled back if fn returns an error
+func (s *SQLite) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error while starting transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryDocument returns the document decoded from the data column of the first row of the query.
+// The document is never nil, and sql.ErrNoRows is returned if there is no row
+func queryDocument[T any](ctx context.Context, q sqliteQuerier, query string, args ...any) (*T, error) {
+	doc := new(T)
+	var data []byte
+	if err := q.QueryRowContext(ctx, query, args...).Scan(&data); err != nil {
+		return doc, err
+	}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return doc, fmt.Errorf("error while decoding document from DB: %w", err)
+	}
+	return doc, nil
+}
+
+// queryDocuments returns the documents decoded from the data column of the rows of the query
+func queryDocuments[T any](ctx context.Context, q sqliteQuerier, query string, args ...any) (docs []T, err error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var doc T
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("error while decoding document from DB: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// notFound replaces sql.ErrNoRows by model.ErrNotFound, describing what was not found
+func notFound(err error, format string, args ...any) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), model.ErrNotFound)
+	}
+	return err
+}
+
+// sqlitePlaceholders returns the parenthesized placeholders of a list of n values
+func sqlitePlaceholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
+
+// sqliteArgs converts values to query arguments
+func sqliteArgs[T any](values []T) []any {
+	args := make([]any, 0, len(values))
+	for _, value := range values {
+		args = append(args, value)
+	}
+	return args
+}
+
+// sqliteIDArgs converts IDs to query arguments
+func sqliteIDArgs(ids []primitive.ObjectID) []any {
+	args := make([]any, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id.Hex())
+	}
+	return args
+}
+
+// sqliteOrder returns the SQL sort order of the list options
+func sqliteOrder(listOptions model.ListOptions) string {
+	if listOptions.Descending {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// sqliteLimit returns the LIMIT clause of the list options, with its arguments
+func sqliteLimit(listOptions model.ListOptions) (string, []any) {
+	limit := listOptions.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	return " LIMIT ? OFFSET ?", []any{limit, listOptions.Skip}
+}
+
+// IsOwnerPresent checks if there is an owner in the server
+func (s *SQLite) IsOwnerPresent(ctx context.Context) (bool, error) {
+	var count int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE is_owner`).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CreateUser adds a user to the database after checking parameter
+func (s *SQLite) CreateUser(ctx context.Context, user *model.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("error while encoding user: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO users (id, name, is_owner, data) VALUES (?, ?, ?, ?)`, user.ID.Hex(), user.Name, user.IsOwner, data)
+	return err
+}
+
+// DeleteUser deletes the user from the DB
+func (s *SQLite) DeleteUser(ctx context.Context, userId primitive.ObjectID) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userId.Hex())
+		if err != nil {
+			return err
+		}
+		if deleted, err := res.RowsAffected(); err != nil || deleted != 1 {
+			return errors.New("unable to delete user")
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM smart_collections WHERE user_id = ?`, userId.Hex()); err != nil {
+			return fmt.Errorf("error while deleting smart collections of user: %w", err)
+		}
+		return nil
+	})
+}
+
+// IsUsernameAvailable returns true if the username (case-insensitive) is not in use yet
+func (s *SQLite) IsUsernameAvailable(ctx context.Context, username string) (bool, error) {
+	var count int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE name = ? COLLATE NOCASE`, username).Scan(&count); err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// GetUserFromID gets user from its ID
+func (s *SQLite) GetUserFromID(ctx context.Context, id primitive.ObjectID) (*model.User, error) {
+	return queryDocument[model.User](ctx, s.db, `SELECT data FROM users WHERE id = ?`, id.Hex())
+}
+
+// GetUserFromName gets user from it name
+func (s *SQLite) GetUserFromName(ctx context.Context, username string, user *model.User) error {
+	found, err := queryDocument[model.User](ctx, s.db, `SELECT data FROM users WHERE name = ?`, username)
+	if err != nil {
+		return err
+	}
+	*user = *found
+	return nil
+}
+
+// GetUserNb returns the number of users from the DB
+func (s *SQLite) GetUserNb(ctx context.Context) (count int64, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, err
+}
+
+// GetUsers returns the list of users in the DB
+func (s *SQLite) GetUsers(ctx context.Context) ([]model.User, error) {
+	users, err := queryDocuments[model.User](ctx, s.db, `SELECT data FROM users ORDER BY rowid`)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving users from DB: %w", err)
+	}
+	return users, nil
+}
+
+// SetUserPassword set a new password for a specific user
+func (s *SQLite) SetUserPassword(ctx context.Context, userID primitive.ObjectID, newPassword string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET data = json_set(data, '$.Password', ?) WHERE id = ?`, newPassword, userID.Hex())
+	return err
+}
+
+// SetUserHiddenHomeRows sets the keys of the home rows a user does not want to see
+func (s *SQLite) SetUserHiddenHomeRows(ctx context.Context, userID primitive.ObjectID, rowKeys []string) error {
+	keys, err := json.Marshal(rowKeys)
+	if err != nil {
+		return fmt.Errorf("error while encoding home rows: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE users SET data = json_set(data, '$.HiddenHomeRows', json(?)) WHERE id = ?`, string(keys), userID.Hex())
+	return err
+}
+
+// getSettings decodes the settings saved with the given ID, or returns sql.ErrNoRows if they were never saved
+func (s *SQLite) getSettings(ctx context.Context, id string, settings any) error {
+	var data []byte
+	if err := s.db.QueryRowContext(ctx, `SELECT data FROM settings WHERE id = ?`, id).Scan(&data); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		return fmt.Errorf("error while decoding settings from DB: %w", err)
+	}
+	return nil
+}
+
+// setSettings saves settings with the given ID
+func (s *SQLite) setSettings(ctx context.Context, id string, settings any) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("error while encoding settings: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO settings (id, data) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data`, id, data)
+	return err
+}
+
+// GetMetadataSettings returns the metadata settings, or sql.ErrNoRows if they were never saved
+func (s *SQLite) GetMetadataSettings(ctx context.Context) (*model.MetadataSettings, error) {
+	var settings model.MetadataSettings
+	if err := s.getSettings(ctx, metadataSettingsID, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetMetadataSettings saves the metadata settings
+func (s *SQLite) SetMetadataSettings(ctx context.Context, settings *model.MetadataSettings) error {
+	return s.setSettings(ctx, metadataSettingsID, settings)
+}
+
+// GetHomeSettings returns the home dashboard settings, or sql.ErrNoRows if they were never saved
+func (s *SQLite) GetHomeSettings(ctx context.Context) (*model.HomeSettings, error) {
+	var settings model.HomeSettings
+	if err := s.getSettings(ctx, homeSettingsID, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetHomeSettings saves the home dashboard settings
+func (s *SQLite) SetHomeSettings(ctx context.Context, settings *model.HomeSettings) error {
+	return s.setSettings(ctx, homeSettingsID, settings)
+}
+
+// GetServerSettings returns the server settings, or sql.ErrNoRows if they were never saved
+func (s *SQLite) GetServerSettings(ctx context.Context) (*model.ServerSettings, error) {
+	var settings model.ServerSettings
+	if err := s.getSettings(ctx, serverSettingsID, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetServerSettings saves the server settings
+func (s *SQLite) SetServerSettings(ctx context.Context, settings *model.ServerSettings) error {
+	return s.setSettings(ctx, serverSettingsID, settings)
+}
+
+// AddSmartCollection adds a smart collection to the DB
+func (s *SQLite) AddSmartCollection(ctx context.Context, collection *model.SmartCollection) error {
+	collection.ID = primitive.NewObjectID()
+	data, err := json.Marshal(collection)
+	if err != nil {
+		return fmt.Errorf("error while encoding smart collection: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO smart_collections (id, user_id, name, data) VALUES (?, ?, ?, ?)`,
+		collection.ID.Hex(), collection.UserID.Hex(), collection.Name, data)
+	return err
+}
+
+// UpdateSmartCollection updates the name and the query of a smart collection
+func (s *SQLite) UpdateSmartCollection(ctx context.Context, collection *model.SmartCollection) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE smart_collections SET name = ?, data = json_set(data, '$.Name', ?, '$.Query', ?) WHERE id = ?`,
+		collection.Name, collection.Name, collection.Query, collection.ID.Hex())
+	return err
+}
+
+// DeleteSmartCollection deletes a smart collection from the DB
+func (s *SQLite) DeleteSmartCollection(ctx context.Context, collectionID primitive.ObjectID) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM smart_collections WHERE id = ?`, collectionID.Hex())
+	if err != nil {
+		return err
+	}
+	if deleted, err := res.RowsAffected(); err != nil || deleted != 1 {
+		return errors.New("unable to delete smart collection")
+	}
+	return nil
+}
+
+// GetSmartCollectionFromID returns a smart collection from its ID
+func (s *SQLite) GetSmartCollectionFromID(ctx context.Context, collectionID primitive.ObjectID) (*model.SmartCollection, error) {
+	return queryDocument[model.SmartCollection](ctx, s.db, `SELECT data FROM smart_collections WHERE id = ?`, collectionID.Hex())
+}
+
+// GetSmartCollections returns the smart collections of a user, sorted by name
+func (s *SQLite) GetSmartCollections(ctx context.Context, userID primitive.ObjectID) ([]model.SmartCollection, error) {
+	collections, err := queryDocuments[model.SmartCollection](ctx, s.db,
+		`SELECT data FROM smart_collections WHERE user_id = ? ORDER BY list_key(name), id`, userID.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving smart collections from DB: %w", err)
+	}
+	return collections, nil
+}
+
+// AddCollection adds a collection to the DB
+// If the collection is already in the database, updates it
+// A collection stored with another ID keeps it
+func (s *SQLite) AddCollection(ctx context.Context, collection *model.Collection) error {
+	data, err := json.Marshal(collection)
+	if err != nil {
+		return fmt.Errorf("error while encoding collection: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO collections (id, tmdb_id, name, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET tmdb_id = excluded.tmdb_id, name = excluded.name, data = excluded.data
+		ON CONFLICT (tmdb_id) DO UPDATE SET name = excluded.name, data = json_set(excluded.data, '$.ID', collections.id)`,
+		collection.ID.Hex(), collection.TMDBID, collection.Name, data)
+	return err
+}
+
+// GetCollectionFromID returns a collection from its ID
+func (s *SQLite) GetCollectionFromID(ctx context.Context, collectionID primitive.ObjectID) (*model.Collection, error) {
+	collection, err := queryDocument[model.Collection](ctx, s.db, `SELECT data FROM collections WHERE id = ?`, collectionID.Hex())
+	return collection, notFound(err, "collection '%s'", collectionID.Hex())
+}
+
+// GetCollectionFromTMDBID returns a collection from its TMDB ID
+func (s *SQLite) GetCollectionFromTMDBID(ctx context.Context, tmdbID int64) (*model.Collection, error) {
+	collection, err := queryDocument[model.Collection](ctx, s.db, `SELECT data FROM collections WHERE tmdb_id = ?`, tmdbID)
+	return collection, notFound(err, "collection with TMDB ID %d", tmdbID)
+}
+
+// GetCollectionSummaries returns the collections having films in the library, sorted by name, with their number of films
+func (s *SQLite) GetCollectionSummaries(ctx context.Context) (summaries []model.CollectionSummary, err error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT collections.data, counts.owned_count FROM collections
+		JOIN (SELECT collection_id, COUNT(*) AS owned_count FROM films WHERE collection_id > 0 GROUP BY collection_id) AS counts
+		ON collections.tmdb_id = counts.collection_id
+		ORDER BY list_key(collections.name), collections.id`)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving collections from DB: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			data    []byte
+			summary model.CollectionSummary
+		)
+		if err := rows.Scan(&data, &summary.OwnedCount); err != nil {
+			return nil, fmt.Errorf("error while retrieving collections from DB: %w", err)
+		}
+		if err := json.Unmarshal(data, &summary.Collection); err != nil {
+			return nil, fmt.Errorf("error while decoding collection from DB: %w", err)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
+
+// GetFilmsFromCollection returns the films of the library belonging to a collection
+func (s *SQLite) GetFilmsFromCollection(ctx context.Context, tmdbID int64) ([]model.Film, error) {
+	films, err := queryDocuments[model.Film](ctx, s.db, `SELECT data FROM films WHERE collection_id = ?`, tmdbID)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving films of collection from DB: %w", err)
+	}
+	return films, nil
+}
+
+// GetVolumeFromID fetches volume from DB using specified ID and returns it via pointer
+func (s *SQLite) GetVolumeFromID(ctx context.Context, id primitive.ObjectID) (*model.Volume, error) {
+	return queryDocument[model.Volume](ctx, s.db, `SELECT data FROM volumes WHERE id = ?`, id.Hex())
+}
+
+// GetVolumes returns the list of volumes in the DB
+func (s *SQLite) GetVolumes(ctx context.Context) ([]model.Volume, error) {
+	volumes, err := queryDocuments[model.Volume](ctx, s.db, `SELECT data FROM volumes ORDER BY rowid`)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving volumes from DB: %w", err)
+	}
+	return volumes, nil
+}
+
+// AddVolume adds a volume to the DB
+func (s *SQLite) AddVolume(ctx context.Context, volume *model.Volume) error {
+	data, err := json.Marshal(volume)
+	if err != nil {
+		return fmt.Errorf("error while encoding volume: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO volumes (id, data) VALUES (?, ?)`, volume.ID.Hex(), data)
+	return err
+}
+
+// DeleteVolume deletes the volume from the DB and all the film which originated only from this volume
+func (s *SQLite) DeleteVolume(ctx context.Context, volumeId primitive.ObjectID) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		films, err := queryDocuments[model.Film](ctx, tx,
+			`SELECT data FROM films WHERE id IN (SELECT film_id FROM film_files WHERE volume_id = ?)`, volumeId.Hex())
+		if err != nil {
+			return fmt.Errorf("error while retrieving films of volume from DB: %w", err)
+		}
+		log.Info().Any("volumeId", volumeId).Msgf("%d films are concerned with this volume deletion\n", len(films))
+		deleted := 0
+		for _, film := range films {
+			film := film
+			film.VolumeFiles = slices.DeleteFunc(film.VolumeFiles, func(vf model.VolumeFile) bool {
+				return vf.FromVolume == volumeId
+			})
+			if len(film.VolumeFiles) == 0 {
+				if _, err := tx.ExecContext(ctx, `DELETE FROM films WHERE id = ?`, film.ID.Hex()); err != nil {
+					return err
+				}
+				deleted++
+				continue
+			}
+			film.Technical = film.GetTechnicalInfo()
+			if err := saveFilm(ctx, tx, &film); err != nil {
+				return err
+			}
+		}
+		log.Info().Any("volumeId", volumeId).Msgf("%d films were removed from database\n", deleted)
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM volumes WHERE id = ?`, volumeId.Hex())
+		if err != nil {
+			return err
+		}
+		if deleted, err := res.RowsAffected(); err != nil || deleted != 1 {
+			return errors.New("unable to delete volume")
+		}
+		log.Info().Any("volumeId", volumeId).Msg("Volume removed from database")
+		return nil
+	})
+}
+
+// saveFilm inserts the film, or replaces it if it is already in the DB, along with the paths of its files
+func saveFilm(ctx context.Context, q sqliteQuerier, film *model.Film) error {
+	data, err := json.Marshal(film)
+	if err != nil {
+		return fmt.Errorf("error while encoding film: %w", err)
+	}
+	_, err = q.ExecContext(ctx, `INSERT INTO films (id, tmdb_id, title, release_year, runtime, rating, collection_id, date_added, last_refreshed, ratings_refreshed, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET tmdb_id = excluded.tmdb_id, title = excluded.title, release_year = excluded.release_year,
+			runtime = excluded.runtime, rating = excluded.rating, collection_id = excluded.collection_id, date_added = excluded.date_added,
+			last_refreshed = excluded.last_refreshed, ratings_refreshed = excluded.ratings_refreshed, data = excluded.data`,
+		film.ID.Hex(), film.TMDBID, film.Title, film.ReleaseYear, film.Runtime, film.Rating(model.RatingSourceIMDb).Value, film.CollectionID,
+		film.DateAdded.UnixMilli(), film.LastRefreshed.UnixMilli(), film.RatingsRefreshed.UnixMilli(), data)
+	if err != nil {
+		return fmt.Errorf("error while saving film: %w", err)
+	}
+
+	if _, err := q.ExecContext(ctx, `DELETE FROM film_files WHERE film_id = ?`, film.ID.Hex()); err != nil {
+		return fmt.Errorf("error while deleting film files: %w", err)
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM film_subtitles WHERE film_id = ?`, film.ID.Hex()); err != nil {
+		return fmt.Errorf("error while deleting film subtitles: %w", err)
+	}
+	for _, volumeFile := range film.VolumeFiles {
+		if _, err := q.ExecContext(ctx, `INSERT INTO film_files (path, film_id, volume_id) VALUES (?, ?, ?)`, volumeFile.Path, film.ID.Hex(), volumeFile.FromVolume.Hex()); err != nil {
+			return fmt.Errorf("error while saving film file: %w", err)
+		}
+		for _, sub := range volumeFile.ExtSubtitles {
+			if _, err := q.ExecContext(ctx, `INSERT INTO film_subtitles (path, film_id) VALUES (?, ?)`, sub.Path, film.ID.Hex()); err != nil {
+				return fmt.Errorf("error while saving film subtitle: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// getFilmFromPath returns the film having a file at the path
+func getFilmFromPath(ctx context.Context, q sqliteQuerier, path string) (*model.Film, error) {
+	return queryDocument[model.Film](ctx, q, `SELECT data FROM films WHERE id IN (SELECT film_id FROM film_files WHERE path = ?) LIMIT 1`, path)
+}
+
+// IsFilmPathPresent checks if a film path is present in the database
+func (s *SQLite) IsFilmPathPresent(ctx context.Context, filmPath string) bool {
+	var present bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM film_files WHERE path = ?)`, filmPath).Scan(&present)
+	return err == nil && present
+}
+
+// IsSubtitlePathPresent checks if a subtitle path is present in the database
+func (s *SQLite) IsSubtitlePathPresent(ctx context.Context, subPath string) bool {
+	var present bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM film_subtitles WHERE path = ?)`, subPath).Scan(&present)
+	return err == nil && present
+}
+
+// AddFilm adds a given film to the DB
+// If the film is already in the database, updates it
+func (s *SQLite) AddFilm(ctx context.Context, film *model.Film) error {
+	film.Technical = film.GetTechnicalInfo()
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return saveFilm(ctx, tx, film)
+	})
+}
+
+// MergeFilm adds the film to the DB, or adds its files to the film having the same TMDB ID, which then replaces it.
+// It returns whether the film was added
+func (s *SQLite) MergeFilm(ctx context.Context, film *model.Film) (added bool, err error) {
+	film.Technical = film.GetTechnicalInfo()
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDocument[model.Film](ctx, tx, `SELECT data FROM films WHERE tmdb_id = ?`, film.TMDBID)
+		if errors.Is(err, sql.ErrNoRows) {
+			added = true
+			return saveFilm(ctx, tx, film)
+		} else if err != nil {
+			return fmt.Errorf("error while retrieving film from DB: %w", err)
+		}
+		addVolumeFiles(stored, film.VolumeFiles)
+		*film = *stored
+		return saveFilm(ctx, tx, film)
+	})
+	if err != nil {
+		return false, err
+	}
+	if !added {
+		log.Debug().Str("path", film.VolumeFiles[len(film.VolumeFiles)-1].Path).Msg("Added volume as source of film to database")
+	}
+	return added, nil
+}
+
+// UpdateFilmMatch saves a film matched with another TMDB film: it replaces the film having the same ID, or, if another film
+// has its TMDB ID, its files are added to that film, which then replaces it, and it is deleted. It returns whether it was merged
+func (s *SQLite) UpdateFilmMatch(ctx context.Context, film *model.Film) (merged bool, err error) {
+	film.Technical = film.GetTechnicalInfo()
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := queryDocument[model.Film](ctx, tx, `SELECT data FROM films WHERE id = ?`, film.ID.Hex()); err != nil {
+			return notFound(err, "film '%s'", film.ID.Hex())
+		}
+		stored, err := queryDocument[model.Film](ctx, tx, `SELECT data FROM films WHERE tmdb_id = ? AND tmdb_id > 0 AND id != ?`, film.TMDBID, film.ID.Hex())
+		if errors.Is(err, sql.ErrNoRows) {
+			return saveFilm(ctx, tx, film)
+		} else if err != nil {
+			return fmt.Errorf("error while retrieving film from DB: %w", err)
+		}
+		// The files of the film are deleted along with it
+		if _, err := tx.ExecContext(ctx, `DELETE FROM films WHERE id = ?`, film.ID.Hex()); err != nil {
+			return fmt.Errorf("error while deleting film: %w", err)
+		}
+		addVolumeFiles(stored, film.VolumeFiles)
+		*film = *stored
+		merged = true
+		return saveFilm(ctx, tx, film)
+	})
+	if err != nil {
+		return false, err
+	}
+	return merged, nil
+}
+
+// MergeDuplicates merges the films, people and collections stored several times with the same TMDB ID, and returns them
+// along with the files that are in several films, which are left as they are. With dryRun, nothing is merged
+func (s *SQLite) MergeDuplicates(ctx context.Context, dryRun bool) ([]model.Duplicate, error) {
+	films, err := s.GetFilms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	people, err := s.GetPeople(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collections, err := queryDocuments[model.Collection](ctx, s.db, `SELECT data FROM collections`)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving collections from DB: %w", err)
+	}
+
+	filmMerge := mergeDuplicateFilms(films)
+	personDuplicates, deletedPeople := mergeDuplicateDocuments(model.DuplicatePerson, personKeys(people))
+	collectionDuplicates, deletedCollections := mergeDuplicateDocuments(model.DuplicateCollection, collectionKeys(collections))
+	duplicates := append(append(filmMerge.duplicates, personDuplicates...), collectionDuplicates...)
+	if dryRun {
+		return duplicates, nil
+	}
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		// The films kept and the duplicates are changed together
+		for table, ids := range map[string][]primitive.ObjectID{"films": filmMerge.deleted, "people": deletedPeople, "collections": deletedCollections} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE id IN `+sqlitePlaceholders(len(ids)), sqliteIDArgs(ids)...); err != nil {
+				return fmt.Errorf("error while deleting duplicate %s: %w", table, err)
+			}
+		}
+		for _, film := range filmMerge.saved {
+			film := film
+			if err := saveFilm(ctx, tx, &film); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return duplicates, nil
+}
+
+// GetFilmFromPath retrieves a film from a path
+func (s *SQLite) GetFilmFromPath(ctx context.Context, filmPath string) (*model.Film, error) {
+	film, err := getFilmFromPath(ctx, s.db, filmPath)
+	if err != nil {
+		return nil, errors.New("could not get film from path")
+	}
+	return film, nil
+}
+
+// UpdateFilmVolumeFile updates the path to a film.
+// film: Film struct that has its path changed
+// oldPath: file path of the volumeFile that will be changed
+// newVolumeFile: VolumeFile struct that replaces the previous one
+func (s *SQLite) UpdateFilmVolumeFile(ctx context.Context, film *model.Film, oldPath string, newVolumeFile model.VolumeFile) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := getFilmFromPath(ctx, tx, oldPath)
+		if err != nil {
+			return errors.New("could not update the volume file")
+		}
+		oldPathIndex := slices.IndexFunc(stored.VolumeFiles, func(vf model.VolumeFile) bool {
+			return vf.Path == oldPath
+		})
+		stored.VolumeFiles[oldPathIndex] = newVolumeFile
+		stored.Technical = stored.GetTechnicalInfo()
+		return saveFilm(ctx, tx, stored)
+	})
+}
+
+// UpdateFilmRatings saves the ratings of a film and the time they were scraped, leaving the rest of the stored film as it is.
+// It returns model.ErrNotFound if the film is not in the DB
+func (s *SQLite) UpdateFilmRatings(ctx context.Context, film *model.Film) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		stored, err := queryDocument[model.Film](ctx, tx, `SELECT data FROM films WHERE id = ?`, film.ID.Hex())
+		if err != nil {
+			return notFound(err, "film '%s'", film.ID.Hex())
+		}
+		stored.Ratings = film.Ratings
+		stored.RatingsRefreshed = film.RatingsRefreshed
+		return saveFilm(ctx, tx, stored)
+	})
+}
+
+// DeleteFilm deletes a film
+func (s *SQLite) DeleteFilm(ctx context.Context, ID primitive.ObjectID) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM films WHERE id = ?`, ID.Hex())
+	if err != nil {
+		return err
+	}
+	if deleted, err := res.RowsAffected(); err != nil || deleted == 0 {
+		return errors.New("could not delete film")
+	}
+	return nil
+}
+
+// DeleteFilmVolumeFile removes a film from the database
+// If the film has only 1 volume file, then the film is entirely deleted
+func (s *SQLite) DeleteFilmVolumeFile(ctx context.Context, path string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		film, err := getFilmFromPath(ctx, tx, path)
+		if err != nil {
+			return errors.New("could not get film from path")
+		}
+		// If it only had 1 volumeFile, remove the film entirely
+		if len(film.VolumeFiles) == 1 {
+			_, err := tx.ExecContext(ctx, `DELETE FROM films WHERE id = ?`, film.ID.Hex())
+			return err
+		}
+		film.VolumeFiles = slices.DeleteFunc(film.VolumeFiles, func(vf model.VolumeFile) bool {
+			return vf.Path == path
+		})
+		film.Technical = film.GetTechnicalInfo()
+		return saveFilm(ctx, tx, film)
+	})
+}
+
+// RemoveSubtitleFile removes a film subtitle from the database
+func (s *SQLite) RemoveSubtitleFile(ctx context.Context, mediaPath, subtitlePath string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		film, err := getFilmFromPath(ctx, tx, mediaPath)
+		if err != nil {
+			return err
+		}
+		volumeIndex := slices.IndexFunc(film.VolumeFiles, func(vFile model.VolumeFile) bool {
+			return vFile.Path == mediaPath
+		})
+		subtitleIndex := slices.IndexFunc(film.VolumeFiles[volumeIndex].ExtSubtitles, func(sub model.Subtitle) bool {
+			return sub.Path == subtitlePath
+		})
+		if subtitleIndex == -1 {
+			return errors.New("cannot remove subtitle from film (no matching subtitle file")
+		}
+		film.VolumeFiles[volumeIndex].ExtSubtitles = slices.Delete(film.VolumeFiles[volumeIndex].ExtSubtitles, subtitleIndex, subtitleIndex+1)
+		film.Technical = film.GetTechnicalInfo()
+		return saveFilm(ctx, tx, film)
+	})
+}
+
+// AddSubtitleToFilmPath adds the subtitle to a film given the film path
+func (s *SQLite) AddSubtitleToFilmPath(ctx context.Context, filmFilePath string, sub model.Subtitle) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		film, err := getFilmFromPath(ctx, tx, filmFilePath)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(film.VolumeFiles, func(vFile model.VolumeFile) bool {
+			return vFile.Path == filmFilePath
+		})
+		if slices.Contains(film.VolumeFiles[i].ExtSubtitles, sub) {
+			return errors.New("subtitle is already added to media")
+		}
+		film.VolumeFiles[i].ExtSubtitles = append(film.VolumeFiles[i].ExtSubtitles, sub)
+		film.Technical = film.GetTechnicalInfo()
+		return saveFilm(ctx, tx, film)
+	})
+}
+
+// GetFilmFromExternalSubtitle returns a film from its external subtitle path
+func (s *SQLite) GetFilmFromExternalSubtitle(ctx context.Context, subtitlePath string) (model.Film, error) {
+	film, err := queryDocument[model.Film](ctx, s.db,
+		`SELECT data FROM films WHERE id IN (SELECT film_id FROM film_subtitles WHERE path = ?) LIMIT 1`, subtitlePath)
+	return *film, err
+}
+
+// savePerson inserts the person with the given ID, or replaces it if it is already in the DB
+func savePerson(ctx context.Context, q sqliteQuerier, person *model.Person) error {
+	data, err := json.Marshal(person)
+	if err != nil {
+		return fmt.Errorf("error while encoding person: %w", err)
+	}
+	_, err = q.ExecContext(ctx, `INSERT INTO people (id, tmdb_id, name, birthday, last_refreshed, data) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET tmdb_id = excluded.tmdb_id, name = excluded.name, birthday = excluded.birthday,
+			last_refreshed = excluded.last_refreshed, data = excluded.data`,
+		person.ID.Hex(), person.TMDBID, person.Name, person.Birthday, person.LastRefreshed.UnixMilli(), data)
+	return err
+}
+
+// IsPersonPresent checks if a person is already registered in the DB
+func (s *SQLite) IsPersonPresent(ctx context.Context, personID int64) bool {
+	var present bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM people WHERE tmdb_id = ?)`, personID).Scan(&present)
+	return err == nil && present
+}
+
+// AddPerson adds a person to the DB, unless a person with the same TMDB ID is already there
+func (s *SQLite) AddPerson(ctx context.Context, person *model.Person) {
+	data, err := json.Marshal(person)
+	if err == nil {
+		_, err = s.db.ExecContext(ctx, `INSERT INTO people (id, tmdb_id, name, birthday, last_refreshed, data) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT DO NOTHING`,
+			person.ID.Hex(), person.TMDBID, person.Name, person.Birthday, person.LastRefreshed.UnixMilli(), data)
+	}
+	if err != nil {
+		log.Error().Int64("personID", person.TMDBID).Err(err).Send()
+	}
+}
+
+// UpdatePerson updates a person in the DB, adding it if it is not present yet
+func (s *SQLite) UpdatePerson(ctx context.Context, person *model.Person) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		// People are identified by their TMDB ID, the person replaces the one stored with another ID
+		if _, err := tx.ExecContext(ctx, `DELETE FROM people WHERE tmdb_id = ? AND id != ?`, person.TMDBID, person.ID.Hex()); err != nil {
+			return err
+		}
+		return savePerson(ctx, tx, person)
+	})
+}
+
+// DeletePerson removes a person from the DB
+func (s *SQLite) DeletePerson(ctx context.Context, personTMDBID int64) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM people WHERE tmdb_id = ?`, personTMDBID); err != nil {
+		return fmt.Errorf("error while deleting person %d from DB: %w", personTMDBID, err)
+	}
+	return nil
+}
+
+// AddPersonRetry adds a person whose details will be fetched again, or updates its next attempt
+func (s *SQLite) AddPersonRetry(ctx context.Context, retry *model.PersonRetry) error {
+	data, err := json.Marshal(retry)
+	if err != nil {
+		return fmt.Errorf("error while encoding retry of person %d: %w", retry.TMDBID, err)
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO person_retries (tmdb_id, next_attempt, data) VALUES (?, ?, ?)
+		ON CONFLICT (tmdb_id) DO UPDATE SET next_attempt = excluded.next_attempt, data = excluded.data`,
+		retry.TMDBID, retry.NextAttempt.UnixMilli(), data)
+	if err != nil {
+		return fmt.Errorf("error while adding retry of person %d to DB: %w", retry.TMDBID, err)
+	}
+	return nil
+}
+
+// GetPersonRetries returns at most limit retries whose next attempt is before the given time, earliest first
+func (s *SQLite) GetPersonRetries(ctx context.Context, before time.Time, limit int64) ([]model.PersonRetry, error) {
+	retries, err := queryDocuments[model.PersonRetry](ctx, s.db,
+		`SELECT data FROM person_retries WHERE next_attempt <= ? ORDER BY next_attempt LIMIT ?`, before.UnixMilli(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving person retries from DB: %w", err)
+	}
+	return retries, nil
+}
+
+// DeletePersonRetry removes the retry of a person, if there is one
+func (s *SQLite) DeletePersonRetry(ctx context.Context, personTMDBID int64) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM person_retries WHERE tmdb_id = ?`, personTMDBID); err != nil {
+		return fmt.Errorf("error while deleting retry of person %d from DB: %w", personTMDBID, err)
+	}
+	return nil
+}
+
+// GetPeopleToRefresh returns at most limit people whose details were fetched before the given time or without a filmography, oldest first
+func (s *SQLite) GetPeopleToRefresh(ctx context.Context, before time.Time, limit int64) ([]model.Person, error) {
+	people, err := queryDocuments[model.Person](ctx, s.db,
+		`SELECT data FROM people WHERE last_refreshed < ? OR json_type(data, '$.Filmography') IS NULL ORDER BY last_refreshed LIMIT ?`,
+		before.UnixMilli(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving people to refresh from DB: %w", err)
+	}
+	return people, nil
+}
+
+// GetPersonFromID returns the Person struct
+func (s *SQLite) GetPersonFromID(ctx context.Context, ID primitive.ObjectID) (*model.Person, error) {
+	return queryDocument[model.Person](ctx, s.db, `SELECT data FROM people WHERE id = ?`, ID.Hex())
+}
+
+// GetPersonFromTMDBID returns the Person struct
+func (s *SQLite) GetPersonFromTMDBID(ctx context.Context, TMDBID int64) (*model.Person, error) {
+	return queryDocument[model.Person](ctx, s.db, `SELECT data FROM people WHERE tmdb_id = ? LIMIT 1`, TMDBID)
+}
+
+// GetPeopleFiltered returns the people of the library's films matching the filter with their number of films,
+// sorted and limited by the list options, and the total number of matching people
+func (s *SQLite) GetPeopleFiltered(ctx context.Context, filter model.PersonFilter, listOptions model.ListOptions) (people []model.PersonFilmCount, total int64, err error) {
+	// Credits of every film, the crew being limited to the jobs of the crew roles
+	args := []any{model.PersonRoleActor, model.PersonRoleDirector, model.PersonRoleWriter}
+	var roleCases strings.Builder
+	for _, role := range model.CrewRoles {
+		roleCases.WriteString(" WHEN ? THEN ?")
+		args = append(args, role.Job, role.Slug)
+	}
+	for _, role := range model.CrewRoles {
+		args = append(args, role.Job)
+	}
+	query := `WITH credits (film_id, person_id, role) AS (
+			SELECT films.id, json_extract(value, '$.ActorID'), ? FROM films, json_each(films.data, '$.Characters')
+			UNION ALL SELECT films.id, value, ? FROM films, json_each(films.data, '$.Directors')
+			UNION ALL SELECT films.id, value, ? FROM films, json_each(films.data, '$.Writers')
+			UNION ALL SELECT films.id, json_extract(value, '$.PersonID'), CASE json_extract(value, '$.Job')` + roleCases.String() + ` ELSE '' END
+				FROM films, json_each(films.data, '$.Crew') WHERE json_extract(value, '$.Job') IN ` + sqlitePlaceholders(len(model.CrewRoles)) + `
+		), person_films (person_id, film_count) AS (
+			SELECT person_id, COUNT(DISTINCT film_id) FROM credits`
+	if filter.TMDBIDs != nil {
+		query += ` WHERE person_id IN ` + sqlitePlaceholders(len(filter.TMDBIDs))
+		args = append(args, sqliteArgs(filter.TMDBIDs)...)
+	}
+	query += ` GROUP BY person_id`
+	if filter.Role != "" {
+		query += ` HAVING SUM(role = ?) > 0`
+		args = append(args, filter.Role)
+	}
+	query += `) SELECT %s FROM person_films JOIN people ON people.tmdb_id = person_films.person_id`
+
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf(query, "COUNT(*)"), args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error while counting people in DB: %w", err)
+	}
+
+	order := sqliteOrder(listOptions)
+	var sort string
+	switch listOptions.Sort {
+	case model.PersonSortFilms:
+		sort = fmt.Sprintf("person_films.film_count %s, list_key(people.name) ASC", order)
+	case model.PersonSortBirthday:
+		// People without a known birthday come last
+		sort = fmt.Sprintf("people.birthday = '' ASC, people.birthday %s", order)
+	default:
+		sort = fmt.Sprintf("list_key(people.name) %s", order)
+	}
+	limit, limitArgs := sqliteLimit(listOptions)
+	query = fmt.Sprintf(query, "people.data, person_films.film_count") + fmt.Sprintf(" ORDER BY %s, person_films.person_id %s", sort, order) + limit
+	rows, err := s.db.QueryContext(ctx, query, append(args, limitArgs...)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error while retrieving people from DB: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			data   []byte
+			person model.PersonFilmCount
+		)
+		if err := rows.Scan(&data, &person.FilmCount); err != nil {
+			return nil, 0, fmt.Errorf("error while retrieving people from DB: %w", err)
+		}
+		if err := json.Unmarshal(data, &person.Person); err != nil {
+			return nil, 0, fmt.Errorf("error while decoding person from DB: %w", err)
+		}
+		people = append(people, person)
+	}
+	return people, total, rows.Err()
+}
+
+// GetPeopleWithName returns the people with a name, ignoring its case and its accents
+func (s *SQLite) GetPeopleWithName(ctx context.Context, name string) ([]model.Person, error) {
+	people, err := queryDocuments[model.Person](ctx, s.db, `SELECT data FROM people WHERE name_key(name) = name_key(?)`, name)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving people from DB: %w", err)
+	}
+	return people, nil
+}
+
+func (s *SQLite) GetPeople(ctx context.Context) ([]model.Person, error) {
+	people, err := queryDocuments[model.Person](ctx, s.db, `SELECT data FROM people`)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving people from DB: %w", err)
+	}
+	return people, nil
+}
+
+// GetFilmFromID returns a film from its ID, or model.ErrNotFound if it is not in the DB
+func (s *SQLite) GetFilmFromID(ctx context.Context, id primitive.ObjectID) (*model.Film, error) {
+	film, err := queryDocument[model.Film](ctx, s.db, `SELECT data FROM films WHERE id = ?`, id.Hex())
+	return film, notFound(err, "film '%s'", id.Hex())
+}
+
+func (s *SQLite) GetFilmCount(ctx context.Context) int64 {
+	var count int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM films`).Scan(&count); err != nil {
+		return 0
+	}
+	return count
+}
+
+// GetFilms returns a slice of Film
+func (s *SQLite) GetFilms(ctx context.Context) ([]model.Film, error) {
+	films, err := queryDocuments[model.Film](ctx, s.db, `SELECT data FROM films ORDER BY title`)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving films from DB: %w", err)
+	}
+	return films, nil
+}
+
+// GetFilmsToRefresh returns at most limit films whose details were fetched before the given time, oldest first
+func (s *SQLite) GetFilmsToRefresh(ctx context.Context, before time.Time, limit int64) ([]model.Film, error) {
+	return s.getStaleFilms(ctx, "last_refreshed", before, limit)
+}
+
+// GetFilmsToRefreshRatings returns at most limit films whose ratings were scraped before the given time, oldest first
+func (s *SQLite) GetFilmsToRefreshRatings(ctx context.Context, before time.Time, limit int64) ([]model.Film, error) {
+	return s.getStaleFilms(ctx, "ratings_refreshed", before, limit)
+}
+
+// getStaleFilms returns at most limit films matched on TMDB, whose date column is before the given time
+func (s *SQLite) getStaleFilms(ctx context.Context, column string, before time.Time, limit int64) ([]model.Film, error) {
+	films, err := queryDocuments[model.Film](ctx, s.db,
+		fmt.Sprintf(`SELECT data FROM films WHERE tmdb_id != 0 AND %s < ? ORDER BY %s LIMIT ?`, column, column), before.UnixMilli(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving films to refresh from DB: %w", err)
+	}
+	return films, nil
+}
+
+// GetFilmsFiltered returns the films matching the filter, sorted and limited by the list options, and the total number of matching films
+func (s *SQLite) GetFilmsFiltered(ctx context.Context, filter model.FilmFilter, listOptions model.ListOptions) (films []model.Film, total int64, err error) {
+	where, args := getSQLiteFilmFilter(filter)
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM films`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error while counting films in DB: %w", err)
+	}
+
+	sortColumn, ok := sqliteFilmSortColumns[listOptions.Sort]
+	if !ok {
+		sortColumn = sqliteFilmSortColumns[model.FilmSortTitle]
+	}
+	order := sqliteOrder(listOptions)
+	limit, limitArgs := sqliteLimit(listOptions)
+	query := fmt.Sprintf(`SELECT data FROM films%s ORDER BY %s %s, id %s`, where, sortColumn, order, order) + limit
+	films, err = queryDocuments[model.Film](ctx, s.db, query, append(args, limitArgs...)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error while retrieving films from DB: %w", err)
+	}
+	return films, total, nil
+}
+
+// GetRandomFilms returns at most number films picked at random among the ones matching the filter
+func (s *SQLite) GetRandomFilms(ctx context.Context, filter model.FilmFilter, number int64) ([]model.Film, error) {
+	where, args := getSQLiteFilmFilter(filter)
+	films, err := queryDocuments[model.Film](ctx, s.db, `SELECT data FROM films`+where+` ORDER BY random() LIMIT ?`, append(args, number)...)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving random films from DB: %w", err)
+	}
+	return films, nil
+}
+
+// GetDirectorsByFilmCount returns the TMDB IDs of the directors with the most films, from the one with the most
+func (s *SQLite) GetDirectorsByFilmCount(ctx context.Context, number int64) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT value FROM films, json_each(films.data, '$.Directors')
+		WHERE value IS NOT NULL GROUP BY value ORDER BY COUNT(*) DESC, value LIMIT ?`, number)
+	if err != nil {
+		return nil, fmt.Errorf("error while counting films by director in DB: %w", err)
+	}
+	defer rows.Close()
+	var directorIDs []int64
+	for rows.Next() {
+		var directorID int64
+		if err := rows.Scan(&directorID); err != nil {
+			return nil, fmt.Errorf("error while decoding directors from DB: %w", err)
+		}
+		directorIDs = append(directorIDs, directorID)
+	}
+	return directorIDs, rows.Err()
+}
+
+// sqliteArrayContains returns a condition on the films whose JSON array contains a value, compared case-insensitively
+func sqliteArrayContains(path string) string {
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM json_each(films.data, '%s') WHERE value = ? COLLATE NOCASE)`, path)
+}
+
+// getSQLiteFilmFilter translates a film filter to a WHERE clause, with its arguments
+func getSQLiteFilmFilter(filter model.FilmFilter) (string, []any) {
+	var (
+		conditions []string
+		args       []any
+	)
+	add := func(condition string, conditionArgs ...any) {
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+	if len(filter.Years) > 0 {
+		add("release_year IN "+sqlitePlaceholders(len(filter.Years)), sqliteArgs(filter.Years)...)
+	}
+	if filter.Genre != "" {
+		add(sqliteArrayContains("$.Genres"), filter.Genre)
+	}
+	if filter.Country != "" {
+		add(sqliteArrayContains("$.ProdCountries"), filter.Country)
+	}
+	if filter.IDs != nil {
+		add("id IN "+sqlitePlaceholders(len(filter.IDs)), sqliteIDArgs(filter.IDs)...)
+	}
+	if filter.Resolution != "" {
+		add(sqliteArrayContains("$.Technical.Resolutions"), filter.Resolution)
+	}
+	if filter.VideoCodec != "" {
+		add(sqliteArrayContains("$.Technical.VideoCodecs"), filter.VideoCodec)
+	}
+	switch filter.HDR {
+	case "":
+	case model.HDRAny:
+		add("json_array_length(data, '$.Technical.HDRFormats') > 0")
+	case model.HDRNone:
+		add("json_array_length(data, '$.Technical.HDRFormats') = 0")
+	default:
+		add(sqliteArrayContains("$.Technical.HDRFormats"), filter.HDR)
+	}
+	if filter.AudioLanguage != "" {
+		add(sqliteArrayContains("$.Technical.AudioLanguages"), filter.AudioLanguage)
+	}
+	if filter.SubtitleLanguage != "" {
+		add(sqliteArrayContains("$.Technical.SubtitleLanguages"), filter.SubtitleLanguage)
+	}
+	if filter.NoSubtitleLanguage != "" {
+		add("NOT "+sqliteArrayContains("$.Technical.SubtitleLanguages"), filter.NoSubtitleLanguage)
+	}
+	if filter.Query != nil {
+		condition, queryArgs := getSQLiteQueryCondition(filter.Query)
+		add(condition, queryArgs...)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// getSQLiteQueryCondition translates a resolved film query to an SQL condition, with its arguments
+func getSQLiteQueryCondition(node model.QueryNode) (string, []any) {
+	switch n := node.(type) {
+	case model.QueryAnd:
+		return getSQLiteQueryConditions(n.Nodes, " AND ")
+	case model.QueryOr:
+		return getSQLiteQueryConditions(n.Nodes, " OR ")
+	case model.QueryNot:
+		condition, args := getSQLiteQueryCondition(n.Node)
+		return "NOT " + condition, args
+	case model.QueryMatch:
+		if n.Field == model.QueryFieldHDR {
+			switch n.Value {
+			case model.HDRAny:
+				return "json_array_length(data, '$.Technical.HDRFormats') > 0", nil
+			case model.HDRNone:
+				return "json_array_length(data, '$.Technical.HDRFormats') = 0", nil
+			}
+		}
+		if path, ok := sqliteQueryArrays[n.Field]; ok {
+			return sqliteArrayContains(path), []any{n.Value}
+		}
+	case model.QueryComparison:
+		column, ok := sqliteQueryColumns[n.Field]
+		if ok && slices.Contains(sqliteQueryOperators, n.Operator) {
+			// Unknown values are stored as 0, and must not match
+			return fmt.Sprintf("(%s > 0 AND %s %s ?)", column, column, n.Operator), []any{n.Value}
+		}
+	case model.QueryPerson:
+		if path, ok := sqliteQueryArrays[n.Field]; ok {
+			value := "value"
+			if n.Field == model.QueryFieldActor {
+				value = "json_extract(value, '$.ActorID')"
+			}
+			return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(films.data, '%s') WHERE %s IN %s)", path, value, sqlitePlaceholders(len(n.TMDBIDs))),
+				sqliteArgs(n.TMDBIDs)
+		}
+	case model.QueryText:
+		return "id IN " + sqlitePlaceholders(len(n.FilmIDs)), sqliteIDArgs(n.FilmIDs)
+	}
+	log.Error().Type("node", node).Msg("Unknown film query node")
+	return "0", nil
+}
+
+func getSQLiteQueryConditions(nodes []model.QueryNode, operator string) (string, []any) {
+	var (
+		conditions []string
+		args       []any
+	)
+	for _, node := range nodes {
+		condition, conditionArgs := getSQLiteQueryCondition(node)
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+	return "(" + strings.Join(conditions, operator) + ")", args
+}
+
+// GetFilmsFromVolume retrieves all films from a specific volume ID
+func (s *SQLite) GetFilmsFromVolume(ctx context.Context, id primitive.ObjectID) []model.Film {
+	films, err := queryDocuments[model.Film](ctx, s.db, `SELECT data FROM films WHERE id IN (SELECT film_id FROM film_files WHERE volume_id = ?)`, id.Hex())
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to retrieve films from database")
+	}
+	return films
+}
+
+// GetFilmsWithActor returns a list of films starring desired actor ID
+func (s *SQLite) GetFilmsWithActor(ctx context.Context, actorID int64) []model.Film {
+	films, err := queryDocuments[model.Film](ctx, s.db,
+		`SELECT data FROM films WHERE EXISTS (SELECT 1 FROM json_each(films.data, '$.Characters') WHERE json_extract(value, '$.ActorID') = ?)`, actorID)
+	if err != nil {
+		log.Error().Err(err).Int64("actorID", actorID).Msg("Unable to retrieve films with actor from database")
+	}
+	return films
+}
+
+// GetFilmsWithDirector returns a list of films directed by desired director ID
+func (s *SQLite) GetFilmsWithDirector(ctx context.Context, directorID int64) []model.Film {
+	films, err := queryDocuments[model.Film](ctx, s.db,
+		`SELECT data FROM films WHERE EXISTS (SELECT 1 FROM json_each(films.data, '$.Directors') WHERE value = ?)`, directorID)
+	if err != nil {
+		log.Error().Err(err).Int64("directorID", directorID).Msg("Unable to retrieve films with director from database")
+	}
+	return films
+}
+
+// GetFilmsWithWriter returns a list of films written by desired writer ID
+func (s *SQLite) GetFilmsWithWriter(ctx context.Context, writerID int64) []model.Film {
+	films, err := queryDocuments[model.Film](ctx, s.db,
+		`SELECT data FROM films WHERE EXISTS (SELECT 1 FROM json_each(films.data, '$.Writers') WHERE value = ?)`, writerID)
+	if err != nil {
+		log.Error().Err(err).Int64("writerID", writerID).Msg("Unable to retrieve films with writer from database")
+	}
+	return films
+}
+
+// GetFilmsWithCrewJob returns a list of films where a person had a job in the crew
+func (s *SQLite) GetFilmsWithCrewJob(ctx context.Context, personID int64, job string) []model.Film {
+	films, err := queryDocuments[model.Film](ctx, s.db,
+		`SELECT data FROM films WHERE EXISTS (SELECT 1 FROM json_each(films.data, '$.Crew')
+			WHERE json_extract(value, '$.PersonID') = ? AND json_extract(value, '$.Job') = ?)`, personID, job)
+	if err != nil {
+		log.Error().Err(err).Int64("personID", personID).Str("job", job).Msg("Unable to retrieve films with crew job from database")
+	}
+	return films
+}
@@ -0,0 +1,140 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glebarez/go-sqlite"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// rarbgTimeLayout is the layout of the dates of the RARBG dump
+const rarbgTimeLayout = "2006-01-02 15:04:05"
+
+// sqliteRegexps caches the compiled patterns of the REGEXP operator
+var sqliteRegexps sync.Map
+
+// matchRegexp implements the REGEXP operator: X REGEXP Y calls regexp(Y, X)
+func matchRegexp(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, ok := args[0].(string)
+	if !ok {
+		return nil, nil
+	}
+	value, ok := args[1].(string)
+	if !ok {
+		return false, nil
+	}
+	re, ok := sqliteRegexps.Load(pattern)
+	if !ok {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		re, _ = sqliteRegexps.LoadOrStore(pattern, compiled)
+	}
+	return re.(*regexp.Regexp).MatchString(value), nil
+}
+
+// InitRarbg opens the SQLite dump of the RARBG torrents, read-only
+func (s *SQLite) InitRarbg(path string) error {
+	rarbg, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return fmt.Errorf("error while opening RARBG database: %w", err)
+	}
+	if err := rarbg.Ping(); err != nil {
+		rarbg.Close()
+		return fmt.Errorf("error while opening RARBG database: %w", err)
+	}
+	s.rarbg = rarbg
+	return nil
+}
+
+// queryTorrents returns the torrents of the RARBG dump matching the conditions
+func (s *SQLite) queryTorrents(ctx context.Context, conditions []string, args []any, order string, offset, limit int64) (torrents []model.RarbgTorrent, err error) {
+	if s.rarbg == nil {
+		return nil, errors.New("the RARBG database is not opened")
+	}
+	query := `SELECT hash, title, dt, cat, size, imdb FROM items`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += order + ` LIMIT ? OFFSET ?`
+	rows, err := s.rarbg.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving torrents from DB: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			rt model.RarbgTorrent
+			dt string
+		)
+		if err := rows.Scan(&rt.Hash, &rt.Title, &dt, &rt.Category, &rt.Size, &rt.IMDbID); err != nil {
+			return nil, fmt.Errorf("error while decoding torrent from DB: %w", err)
+		}
+		rt.DT, _ = time.Parse(rarbgTimeLayout, dt)
+		torrents = append(torrents, rt)
+	}
+	return torrents, rows.Err()
+}
+
+// getIMDbID returns the IMDb ID with its "tt" prefix
+func getIMDbID(imdbID string) string {
+	if strings.HasPrefix(imdbID, "tt") {
+		return imdbID
+	}
+	return fmt.Sprintf("tt%s", imdbID)
+}
+
+func (s *SQLite) SearchTorrents(ctx context.Context, search, category string, page uint) ([]model.RarbgTorrent, error) {
+	const limit = 100
+
+	var (
+		conditions []string
+		args       []any
+	)
+	if search != "" {
+		search = strings.Trim(strings.ToLower(search), " ")
+		searchWords := strings.FieldsFunc(search, func(r rune) bool {
+			return r == '.' || r == ' '
+		})
+		for _, w := range searchWords {
+			conditions = append(conditions, "title REGEXP ?")
+			args = append(args, fmt.Sprintf(`(?i)^(.*[^a-z0-9])?%s[^a-z0-9].*$`, w))
+		}
+	}
+	if category != "" {
+		conditions = append(conditions, "cat REGEXP ?")
+		args = append(args, fmt.Sprintf("(?i)^%s.*$", category))
+	}
+	return s.queryTorrents(ctx, conditions, args, " ORDER BY dt DESC", int64(page-1)*limit, limit)
+}
+
+func (s *SQLite) GetTorrents(ctx context.Context, imdbID string, offset, limit int64) ([]model.RarbgTorrent, error) {
+	if imdbID == "" {
+		return s.queryTorrents(ctx, []string{"imdb IS NOT NULL"}, nil, "", offset, limit)
+	}
+	return s.queryTorrents(ctx, []string{"imdb = ?"}, []any{getIMDbID(imdbID)}, "", offset, limit)
+}
+
+func (s *SQLite) GetAllTVTorrents(ctx context.Context, offset, limit int64) ([]model.RarbgTorrent, error) {
+	return s.queryTorrents(ctx, []string{"cat REGEXP ?"}, []any{"(?i)tv"}, "", offset, limit)
+}
+
+func (s *SQLite) GetTVTorrents(ctx context.Context, imdbID, season, episode string, offset, limit int64) ([]model.RarbgTorrent, error) {
+	conditions := []string{"imdb = ?", "title REGEXP ?"}
+	args := []any{getIMDbID(imdbID), fmt.Sprintf(`(?i)s%s`, season)}
+	if episode != "" {
+		conditions = append(conditions, "title REGEXP ?")
+		args = append(args, fmt.Sprintf(`(?i)e%s`, episode))
+	}
+	return s.queryTorrents(ctx, conditions, args, "", offset, limit)
+}
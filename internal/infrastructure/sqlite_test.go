@@ -0,0 +1,124 @@
+package infrastructure
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+func TestSQLiteMigrate(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQLite(filepath.Join(t.TempDir(), "starfin.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	pending, err := db.Migrate(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, pending, len(sqliteMigrations))
+	assert.Equal(t, 1, pending[0].Version)
+	assert.True(t, pending[0].AppliedAt.IsZero())
+	applied, err := db.Migrate(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, applied, len(sqliteMigrations))
+	assert.False(t, applied[0].AppliedAt.IsZero())
+	pending, err = db.Migrate(ctx, true)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// Films stored by the versions where the year and the runtime were strings
+	film := newTestFilm("Heat", "/films/heat.mkv", func(f *model.Film) {})
+	require.NoError(t, db.AddFilm(ctx, &film))
+	_, err = db.db.Exec(`UPDATE films SET data = json_set(data, '$.Year', '1995', '$.Runtime', '') WHERE id = ?`, film.ID.Hex())
+	require.NoError(t, err)
+	_, err = db.db.Exec(`DELETE FROM schema_migrations WHERE version >= 2`)
+	require.NoError(t, err)
+	applied, err = db.Migrate(ctx, false)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(sqliteMigrations)-1)
+	saved, err := db.GetFilmFromID(ctx, film.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1995, saved.Year)
+	assert.Zero(t, saved.Runtime)
+
+	_, err = db.db.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, 0)`, len(sqliteMigrations)+1)
+	require.NoError(t, err)
+	_, err = db.Migrate(ctx, false)
+	assert.Error(t, err)
+}
+
+func TestSQLiteMergeDuplicates(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQLite(filepath.Join(t.TempDir(), "starfin.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Migrate(ctx, false)
+	require.NoError(t, err)
+
+	// Duplicates stored before the indexes were unique
+	_, err = db.db.Exec(`DROP INDEX films_tmdb_id; DROP INDEX film_files_path; DROP INDEX people_tmdb_id;
+		DELETE FROM schema_migrations WHERE version = 3`)
+	require.NoError(t, err)
+	date := time.Date(2024, 3, 10, 20, 30, 0, 0, time.UTC)
+	first := newTestFilm("Heat", "/films/heat.mkv", func(f *model.Film) { f.DateAdded = date })
+	second := newTestFilm("Heat", "/other/heat.mkv", func(f *model.Film) {
+		f.TMDBID = first.TMDBID
+		f.DateAdded = date.Add(-time.Hour)
+		f.VolumeFiles = append(f.VolumeFiles, first.VolumeFiles[0])
+	})
+	other := newTestFilm("Alien", "/other/heat.mkv", func(f *model.Film) {})
+	for _, film := range []*model.Film{&first, &second, &other} {
+		require.NoError(t, db.AddFilm(ctx, film))
+	}
+	oldPerson := model.Person{ID: primitive.NewObjectID(), TMDBID: 1, Name: "Al Pacino", LastRefreshed: date}
+	person := model.Person{ID: primitive.NewObjectID(), TMDBID: 1, Name: "Al Pacino", LastRefreshed: date.Add(time.Hour)}
+	require.NoError(t, savePerson(ctx, db.db, &oldPerson))
+	require.NoError(t, savePerson(ctx, db.db, &person))
+
+	// The file of Alien is also in Heat, which is another film: it is reported, but left as it is
+	duplicates, err := db.MergeDuplicates(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, []model.Duplicate{
+		{Kind: model.DuplicateFilm, TMDBID: int64(first.TMDBID), Name: "Heat", IDs: []primitive.ObjectID{first.ID, second.ID}},
+		{Kind: model.DuplicateFile, Name: "/other/heat.mkv", IDs: []primitive.ObjectID{first.ID, other.ID}},
+		{Kind: model.DuplicatePerson, TMDBID: 1, Name: "Al Pacino", IDs: []primitive.ObjectID{person.ID, oldPerson.ID}},
+	}, duplicates)
+	assert.EqualValues(t, 3, db.GetFilmCount(ctx))
+
+	// The migration does not merge the duplicates by itself
+	_, err = db.Migrate(ctx, false)
+	assert.ErrorContains(t, err, "merge-duplicates")
+	assert.EqualValues(t, 3, db.GetFilmCount(ctx))
+
+	duplicates, err = db.MergeDuplicates(ctx, false)
+	require.NoError(t, err)
+	assert.Len(t, duplicates, 3)
+	assert.EqualValues(t, 2, db.GetFilmCount(ctx))
+	_, err = db.GetFilmFromID(ctx, other.ID)
+	require.NoError(t, err)
+	duplicates, err = db.MergeDuplicates(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, []model.Duplicate{{Kind: model.DuplicateFile, Name: "/other/heat.mkv", IDs: []primitive.ObjectID{first.ID, other.ID}}}, duplicates)
+
+	// Once the file is in a single film, the migration makes the paths unique
+	require.NoError(t, db.DeleteFilm(ctx, other.ID))
+	_, err = db.Migrate(ctx, false)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, db.GetFilmCount(ctx))
+	saved, err := db.GetFilmFromID(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, date.Add(-time.Hour), saved.DateAdded.UTC())
+	assert.Equal(t, []string{"/films/heat.mkv", "/other/heat.mkv"}, []string{saved.VolumeFiles[0].Path, saved.VolumeFiles[1].Path})
+	savedPerson, err := db.GetPersonFromTMDBID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, person.ID, savedPerson.ID)
+
+	// The indexes are unique again
+	assert.Error(t, db.AddFilm(ctx, &second))
+}
@@ -0,0 +1,656 @@
+package infrastructure
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// storage is the part of the storage backends checked by the conformance suite
+type storage interface {
+	Migrate(ctx context.Context, dryRun bool) ([]model.Migration, error)
+
+	IsOwnerPresent(ctx context.Context) (bool, error)
+	CreateUser(ctx context.Context, user *model.User) error
+	DeleteUser(ctx context.Context, userId primitive.ObjectID) error
+	IsUsernameAvailable(ctx context.Context, username string) (bool, error)
+	GetUserFromID(ctx context.Context, id primitive.ObjectID) (*model.User, error)
+	GetUserFromName(ctx context.Context, username string, user *model.User) error
+	GetUserNb(ctx context.Context) (int64, error)
+	SetUserPassword(ctx context.Context, userID primitive.ObjectID, newPassword string) error
+	SetUserHiddenHomeRows(ctx context.Context, userID primitive.ObjectID, rowKeys []string) error
+
+	GetMetadataSettings(ctx context.Context) (*model.MetadataSettings, error)
+	SetMetadataSettings(ctx context.Context, settings *model.MetadataSettings) error
+	GetHomeSettings(ctx context.Context) (*model.HomeSettings, error)
+	SetHomeSettings(ctx context.Context, settings *model.HomeSettings) error
+	GetServerSettings(ctx context.Context) (*model.ServerSettings, error)
+	SetServerSettings(ctx context.Context, settings *model.ServerSettings) error
+
+	AddSmartCollection(ctx context.Context, collection *model.SmartCollection) error
+	UpdateSmartCollection(ctx context.Context, collection *model.SmartCollection) error
+	GetSmartCollections(ctx context.Context, userID primitive.ObjectID) ([]model.SmartCollection, error)
+
+	AddCollection(ctx context.Context, collection *model.Collection) error
+	GetCollectionFromID(ctx context.Context, collectionID primitive.ObjectID) (*model.Collection, error)
+	GetCollectionFromTMDBID(ctx context.Context, tmdbID int64) (*model.Collection, error)
+	GetCollectionSummaries(ctx context.Context) ([]model.CollectionSummary, error)
+
+	AddVolume(ctx context.Context, volume *model.Volume) error
+	GetVolumeFromID(ctx context.Context, id primitive.ObjectID) (*model.Volume, error)
+	GetVolumes(ctx context.Context) ([]model.Volume, error)
+	DeleteVolume(ctx context.Context, volumeId primitive.ObjectID) error
+	GetFilmsFromVolume(ctx context.Context, id primitive.ObjectID) []model.Film
+
+	AddFilm(ctx context.Context, film *model.Film) error
+	IsFilmPathPresent(ctx context.Context, filmPath string) bool
+	IsSubtitlePathPresent(ctx context.Context, subPath string) bool
+	GetFilmFromID(ctx context.Context, id primitive.ObjectID) (*model.Film, error)
+	GetFilmFromPath(ctx context.Context, filmPath string) (*model.Film, error)
+	MergeFilm(ctx context.Context, film *model.Film) (added bool, err error)
+	UpdateFilmMatch(ctx context.Context, film *model.Film) (merged bool, err error)
+	MergeDuplicates(ctx context.Context, dryRun bool) ([]model.Duplicate, error)
+	UpdateFilmVolumeFile(ctx context.Context, film *model.Film, oldPath string, newVolumeFile model.VolumeFile) error
+	UpdateFilmRatings(ctx context.Context, film *model.Film) error
+	DeleteFilmVolumeFile(ctx context.Context, path string) error
+	AddSubtitleToFilmPath(ctx context.Context, filmFilePath string, sub model.Subtitle) error
+	RemoveSubtitleFile(ctx context.Context, mediaPath, subtitlePath string) error
+	GetFilmCount(ctx context.Context) int64
+	GetFilmsToRefresh(ctx context.Context, before time.Time, limit int64) ([]model.Film, error)
+	GetFilmsFiltered(ctx context.Context, filter model.FilmFilter, listOptions model.ListOptions) ([]model.Film, int64, error)
+	GetRandomFilms(ctx context.Context, filter model.FilmFilter, number int64) ([]model.Film, error)
+	GetDirectorsByFilmCount(ctx context.Context, number int64) ([]int64, error)
+	GetFilmsWithActor(ctx context.Context, actorID int64) []model.Film
+	GetFilmsWithCrewJob(ctx context.Context, personID int64, job string) []model.Film
+
+	AddPerson(ctx context.Context, person *model.Person)
+	UpdatePerson(ctx context.Context, person *model.Person) error
+	DeletePerson(ctx context.Context, personTMDBID int64) error
+	IsPersonPresent(ctx context.Context, personID int64) bool
+	GetPersonFromTMDBID(ctx context.Context, TMDBID int64) (*model.Person, error)
+	GetPeopleWithName(ctx context.Context, name string) ([]model.Person, error)
+	GetPeopleToRefresh(ctx context.Context, before time.Time, limit int64) ([]model.Person, error)
+	GetPeopleFiltered(ctx context.Context, filter model.PersonFilter, listOptions model.ListOptions) ([]model.PersonFilmCount, int64, error)
+	AddPersonRetry(ctx context.Context, retry *model.PersonRetry) error
+	GetPersonRetries(ctx context.Context, before time.Time, limit int64) ([]model.PersonRetry, error)
+	DeletePersonRetry(ctx context.Context, personTMDBID int64) error
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	testStorage(t, func(t *testing.T) storage {
+		db, err := NewSQLite(filepath.Join(t.TempDir(), "starfin.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		_, err = db.Migrate(context.Background(), false)
+		require.NoError(t, err)
+		return db
+	})
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorage(t, func(t *testing.T) storage {
+		return NewMemory()
+	})
+}
+
+// TestMongoDBStorage runs the conformance suite against the MongoDB server configured by the TEST_DB_ variables,
+// in a database dropped afterwards
+func TestMongoDBStorage(t *testing.T) {
+	url := os.Getenv("TEST_DB_URL")
+	if url == "" {
+		t.Skip("TEST_DB_URL is not set")
+	}
+	testStorage(t, func(t *testing.T) storage {
+		dbName := "starfin_test_" + primitive.NewObjectID().Hex()
+		db := NewMongoDB(os.Getenv("TEST_DB_USER"), os.Getenv("TEST_DB_PASSWORD"), url, os.Getenv("TEST_DB_PORT"), dbName)
+		t.Cleanup(func() {
+			db.client.Database(dbName).Drop(context.Background())
+			db.Close()
+		})
+		_, err := db.Migrate(context.Background(), false)
+		require.NoError(t, err)
+		return db
+	})
+}
+
+// testStorage checks that a storage backend behaves like the others, each test getting an empty storage
+func testStorage(t *testing.T, newStorage func(t *testing.T) storage) {
+	ctx := context.Background()
+	date := time.Date(2024, 3, 10, 20, 30, 0, 0, time.UTC)
+
+	t.Run("Users", func(t *testing.T) {
+		s := newStorage(t)
+		present, err := s.IsOwnerPresent(ctx)
+		require.NoError(t, err)
+		assert.False(t, present)
+
+		owner := model.User{ID: primitive.NewObjectID(), Name: "Alice", Password: "hash", IsOwner: true, IsAdmin: true}
+		require.NoError(t, s.CreateUser(ctx, &owner))
+		present, err = s.IsOwnerPresent(ctx)
+		require.NoError(t, err)
+		assert.True(t, present)
+
+		available, err := s.IsUsernameAvailable(ctx, "alice")
+		require.NoError(t, err)
+		assert.False(t, available)
+		available, err = s.IsUsernameAvailable(ctx, "bob")
+		require.NoError(t, err)
+		assert.True(t, available)
+
+		require.NoError(t, s.SetUserPassword(ctx, owner.ID, "new hash"))
+		require.NoError(t, s.SetUserHiddenHomeRows(ctx, owner.ID, []string{model.HomeRowRandom}))
+		var user model.User
+		require.NoError(t, s.GetUserFromName(ctx, "Alice", &user))
+		assert.Equal(t, owner.ID, user.ID)
+		assert.Equal(t, "new hash", user.Password)
+		assert.Equal(t, []string{model.HomeRowRandom}, user.HiddenHomeRows)
+		assert.Error(t, s.GetUserFromName(ctx, "Bob", &user))
+
+		collection := model.SmartCollection{UserID: owner.ID, Name: "Short", Query: "runtime<90"}
+		require.NoError(t, s.AddSmartCollection(ctx, &collection))
+		require.NoError(t, s.DeleteUser(ctx, owner.ID))
+		_, err = s.GetUserFromID(ctx, owner.ID)
+		assert.Error(t, err)
+		count, err := s.GetUserNb(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+		collections, err := s.GetSmartCollections(ctx, owner.ID)
+		require.NoError(t, err)
+		assert.Empty(t, collections)
+		assert.Error(t, s.DeleteUser(ctx, owner.ID))
+	})
+
+	t.Run("Settings", func(t *testing.T) {
+		s := newStorage(t)
+		_, err := s.GetMetadataSettings(ctx)
+		assert.Error(t, err)
+		_, err = s.GetHomeSettings(ctx)
+		assert.Error(t, err)
+		_, err = s.GetServerSettings(ctx)
+		assert.Error(t, err)
+
+		metadataSettings := model.MetadataSettings{Language: "fr-FR", FallbackLanguage: "en-US", CertificationCountries: []string{"FR", "US"}}
+		require.NoError(t, s.SetMetadataSettings(ctx, &metadataSettings))
+		metadataSettings.Language = "de-DE"
+		require.NoError(t, s.SetMetadataSettings(ctx, &metadataSettings))
+		savedMetadata, err := s.GetMetadataSettings(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, metadataSettings, *savedMetadata)
+
+		homeSettings := model.HomeSettings{Rows: []model.HomeRow{{Type: model.HomeRowGenre, Genre: "Drama"}, {Type: model.HomeRowRandom}}}
+		require.NoError(t, s.SetHomeSettings(ctx, &homeSettings))
+		savedHome, err := s.GetHomeSettings(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, homeSettings, *savedHome)
+
+		serverSettings := model.ServerSettings{LogLevel: "warn", TMDBAPIKey: "key", ItemsPerPage: 30, RefreshRateLimit: 5 * time.Second}
+		require.NoError(t, s.SetServerSettings(ctx, &serverSettings))
+		savedServer, err := s.GetServerSettings(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, serverSettings, *savedServer)
+	})
+
+	t.Run("SmartCollections", func(t *testing.T) {
+		s := newStorage(t)
+		userID := primitive.NewObjectID()
+		for _, name := range []string{"b", "A", "c"} {
+			require.NoError(t, s.AddSmartCollection(ctx, &model.SmartCollection{UserID: userID, Name: name, Query: "genre:drama"}))
+		}
+		require.NoError(t, s.AddSmartCollection(ctx, &model.SmartCollection{UserID: primitive.NewObjectID(), Name: "Other"}))
+		collections, err := s.GetSmartCollections(ctx, userID)
+		require.NoError(t, err)
+		require.Len(t, collections, 3)
+		assert.Equal(t, []string{"A", "b", "c"}, []string{collections[0].Name, collections[1].Name, collections[2].Name})
+
+		collections[0].Name = "d"
+		collections[0].Query = "year>2000"
+		require.NoError(t, s.UpdateSmartCollection(ctx, &collections[0]))
+		collections, err = s.GetSmartCollections(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, "d", collections[2].Name)
+		assert.Equal(t, "year>2000", collections[2].Query)
+	})
+
+	t.Run("Collections", func(t *testing.T) {
+		s := newStorage(t)
+		_, err := s.GetCollectionFromID(ctx, primitive.NewObjectID())
+		assert.ErrorIs(t, err, model.ErrNotFound)
+		_, err = s.GetCollectionFromTMDBID(ctx, 10)
+		assert.ErrorIs(t, err, model.ErrNotFound)
+
+		alien := model.Collection{ID: primitive.NewObjectID(), TMDBID: 8091, Name: "Alien Collection", LastRefreshed: date}
+		batman := model.Collection{ID: primitive.NewObjectID(), TMDBID: 263, Name: "batman Collection"}
+		empty := model.Collection{ID: primitive.NewObjectID(), TMDBID: 1, Name: "Empty Collection"}
+		for _, collection := range []*model.Collection{&batman, &alien, &empty} {
+			require.NoError(t, s.AddCollection(ctx, collection))
+		}
+		alien.Overview = "In space"
+		require.NoError(t, s.AddCollection(ctx, &alien))
+		saved, err := s.GetCollectionFromTMDBID(ctx, alien.TMDBID)
+		require.NoError(t, err)
+		assert.Equal(t, alien, *saved)
+		// Fetched again with another ID
+		refetched := alien
+		refetched.ID = primitive.NewObjectID()
+		refetched.Name = "Alien Anthology"
+		require.NoError(t, s.AddCollection(ctx, &refetched))
+		saved, err = s.GetCollectionFromTMDBID(ctx, alien.TMDBID)
+		require.NoError(t, err)
+		assert.Equal(t, alien.ID, saved.ID)
+		assert.Equal(t, "Alien Anthology", saved.Name)
+		alien.Name = saved.Name
+
+		for _, film := range []model.Film{
+			newTestFilm("Alien", "alien.mkv", func(f *model.Film) { f.CollectionID = alien.TMDBID }),
+			newTestFilm("Aliens", "aliens.mkv", func(f *model.Film) { f.CollectionID = alien.TMDBID }),
+			newTestFilm("Batman", "batman.mkv", func(f *model.Film) { f.CollectionID = batman.TMDBID }),
+		} {
+			film := film
+			require.NoError(t, s.AddFilm(ctx, &film))
+		}
+		summaries, err := s.GetCollectionSummaries(ctx)
+		require.NoError(t, err)
+		require.Len(t, summaries, 2)
+		assert.Equal(t, alien.ID, summaries[0].ID)
+		assert.Equal(t, 2, summaries[0].OwnedCount)
+		assert.Equal(t, batman.ID, summaries[1].ID)
+		assert.Equal(t, 1, summaries[1].OwnedCount)
+	})
+
+	t.Run("Volumes", func(t *testing.T) {
+		s := newStorage(t)
+		volume := model.Volume{ID: primitive.NewObjectID(), Name: "Films", Path: "/films", IsRecursive: true, MediaType: "Movie"}
+		require.NoError(t, s.AddVolume(ctx, &volume))
+		saved, err := s.GetVolumeFromID(ctx, volume.ID)
+		require.NoError(t, err)
+		assert.Equal(t, volume, *saved)
+		volumes, err := s.GetVolumes(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []model.Volume{volume}, volumes)
+
+		other := model.Volume{ID: primitive.NewObjectID(), Name: "Other films", Path: "/other", MediaType: "Movie"}
+		require.NoError(t, s.AddVolume(ctx, &other))
+		heat := newTestFilm("Heat", "/films/heat.mkv", func(f *model.Film) {
+			f.VolumeFiles[0].FromVolume = volume.ID
+			f.VolumeFiles[0].ExtSubtitles = []model.Subtitle{{Language: "fr", Path: "/films/heat.fr.srt"}}
+			f.VolumeFiles = append(f.VolumeFiles, model.VolumeFile{Path: "/other/heat.mkv", FromVolume: other.ID})
+		})
+		alien := newTestFilm("Alien", "/films/alien.mkv", func(f *model.Film) { f.VolumeFiles[0].FromVolume = volume.ID })
+		for _, film := range []model.Film{heat, alien} {
+			film := film
+			require.NoError(t, s.AddFilm(ctx, &film))
+		}
+		assert.Len(t, s.GetFilmsFromVolume(ctx, volume.ID), 2)
+		assert.True(t, s.IsSubtitlePathPresent(ctx, "/films/heat.fr.srt"))
+
+		// The films only in the deleted volume are deleted, the others lose its files
+		require.NoError(t, s.DeleteVolume(ctx, volume.ID))
+		_, err = s.GetVolumeFromID(ctx, volume.ID)
+		assert.Error(t, err)
+		assert.EqualValues(t, 1, s.GetFilmCount(ctx))
+		assert.Empty(t, s.GetFilmsFromVolume(ctx, volume.ID))
+		assert.False(t, s.IsSubtitlePathPresent(ctx, "/films/heat.fr.srt"))
+		savedHeat, err := s.GetFilmFromID(ctx, heat.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []model.VolumeFile{{Path: "/other/heat.mkv", FromVolume: other.ID}}, savedHeat.VolumeFiles)
+		assert.Empty(t, savedHeat.Technical.SubtitleLanguages)
+	})
+
+	t.Run("FilmFiles", func(t *testing.T) {
+		s := newStorage(t)
+		film := newTestFilm("Heat", "/films/heat.mkv", func(f *model.Film) {
+			f.VolumeFiles[0].Info.Resolution = "1080p"
+		})
+		added, err := s.MergeFilm(ctx, &film)
+		require.NoError(t, err)
+		assert.True(t, added)
+		assert.True(t, s.IsFilmPathPresent(ctx, "/films/heat.mkv"))
+		assert.False(t, s.IsFilmPathPresent(ctx, "/films/other.mkv"))
+		saved, err := s.GetFilmFromID(ctx, film.ID)
+		require.NoError(t, err)
+		assert.Equal(t, film, *saved)
+		_, err = s.GetFilmFromID(ctx, primitive.NewObjectID())
+		assert.ErrorIs(t, err, model.ErrNotFound)
+		_, err = s.GetFilmFromPath(ctx, "/films/other.mkv")
+		assert.Error(t, err)
+
+		// Second file of the film, in 4K
+		source := newTestFilm("Heat", "/other/heat.mkv", func(f *model.Film) {
+			f.TMDBID = film.TMDBID
+			f.VolumeFiles[0].Info.Resolution = "2160p"
+		})
+		added, err = s.MergeFilm(ctx, &source)
+		require.NoError(t, err)
+		assert.False(t, added)
+		assert.Equal(t, film.ID, source.ID)
+		assert.Equal(t, []string{"1080p", "2160p"}, source.Technical.Resolutions)
+		saved, err = s.GetFilmFromPath(ctx, "/other/heat.mkv")
+		require.NoError(t, err)
+		assert.Equal(t, source, *saved)
+		added, err = s.MergeFilm(ctx, &source)
+		require.NoError(t, err)
+		assert.False(t, added)
+		assert.Len(t, source.VolumeFiles, 2)
+		duplicates, err := s.MergeDuplicates(ctx, true)
+		require.NoError(t, err)
+		assert.Empty(t, duplicates)
+
+		sub := model.Subtitle{Language: "fr", Path: "/films/heat.fr.srt"}
+		require.NoError(t, s.AddSubtitleToFilmPath(ctx, "/films/heat.mkv", sub))
+		assert.Error(t, s.AddSubtitleToFilmPath(ctx, "/films/heat.mkv", sub))
+		saved, err = s.GetFilmFromID(ctx, film.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"fr"}, saved.Technical.SubtitleLanguages)
+		require.NoError(t, s.RemoveSubtitleFile(ctx, "/films/heat.mkv", sub.Path))
+
+		moved := saved.VolumeFiles[1]
+		moved.Path = "/other/heat (1995).mkv"
+		require.NoError(t, s.UpdateFilmVolumeFile(ctx, saved, "/other/heat.mkv", moved))
+		assert.False(t, s.IsFilmPathPresent(ctx, "/other/heat.mkv"))
+		assert.True(t, s.IsFilmPathPresent(ctx, moved.Path))
+
+		require.NoError(t, s.DeleteFilmVolumeFile(ctx, "/films/heat.mkv"))
+		saved, err = s.GetFilmFromID(ctx, film.ID)
+		require.NoError(t, err)
+		assert.Len(t, saved.VolumeFiles, 1)
+		assert.Equal(t, []string{"2160p"}, saved.Technical.Resolutions)
+		require.NoError(t, s.DeleteFilmVolumeFile(ctx, moved.Path))
+		assert.Zero(t, s.GetFilmCount(ctx))
+	})
+
+	t.Run("FilmMatch", func(t *testing.T) {
+		s := newStorage(t)
+		heat := newTestFilm("Heat", "/films/heat.mkv", func(f *model.Film) {})
+		alien := newTestFilm("Alien", "/films/alien.mkv", func(f *model.Film) {})
+		require.NoError(t, s.AddFilm(ctx, &heat))
+		require.NoError(t, s.AddFilm(ctx, &alien))
+
+		// A TMDB ID that no other film has is set in place
+		rematched := heat
+		rematched.TMDBID, rematched.Title = 949, "Heat (1995)"
+		merged, err := s.UpdateFilmMatch(ctx, &rematched)
+		require.NoError(t, err)
+		assert.False(t, merged)
+		saved, err := s.GetFilmFromID(ctx, heat.ID)
+		require.NoError(t, err)
+		assert.Equal(t, rematched, *saved)
+
+		// The film matched with the TMDB film of another one is merged into it
+		rematched.TMDBID = alien.TMDBID
+		merged, err = s.UpdateFilmMatch(ctx, &rematched)
+		require.NoError(t, err)
+		assert.True(t, merged)
+		assert.Equal(t, alien.ID, rematched.ID)
+		assert.Equal(t, "Alien", rematched.Title)
+		assert.EqualValues(t, 1, s.GetFilmCount(ctx))
+		_, err = s.GetFilmFromID(ctx, heat.ID)
+		assert.ErrorIs(t, err, model.ErrNotFound)
+		saved, err = s.GetFilmFromPath(ctx, "/films/heat.mkv")
+		require.NoError(t, err)
+		assert.Equal(t, rematched, *saved)
+		assert.Len(t, saved.VolumeFiles, 2)
+
+		// A film that is no longer in the database is not added again
+		_, err = s.UpdateFilmMatch(ctx, &heat)
+		assert.ErrorIs(t, err, model.ErrNotFound)
+		assert.EqualValues(t, 1, s.GetFilmCount(ctx))
+	})
+
+	t.Run("FilmsFiltered", func(t *testing.T) {
+		s := newStorage(t)
+		films := []model.Film{
+			newTestFilm("Film 10", "10.mkv", func(f *model.Film) {
+				f.ReleaseYear, f.Runtime, f.Genres = 1999, 140, []string{"Drama"}
+				f.Directors = []int64{1}
+				f.VolumeFiles[0].Info.Video = []model.VideoInfo{{Format: "HEVC", HDRFormat: "Dolby Vision"}}
+			}),
+			newTestFilm("film 2", "2.mkv", func(f *model.Film) {
+				f.ReleaseYear, f.Runtime, f.Genres = 2005, 95, []string{"Comedy", "Drama"}
+				f.Directors = []int64{1}
+				f.Characters = []model.Character{{CharacterName: "Hero", ActorID: 3}}
+			}),
+			newTestFilm("Film 1", "1.mkv", func(f *model.Film) {
+				f.ReleaseYear, f.Runtime, f.Genres = 2005, 0, []string{"Horror"}
+				f.Directors = []int64{2}
+				f.Crew = []model.CrewCredit{{PersonID: 4, Job: "Editor"}}
+				f.Ratings = map[string]model.Rating{model.RatingSourceIMDb: {Value: 7.5, Best: 10}}
+			}),
+		}
+		for i := range films {
+			require.NoError(t, s.AddFilm(ctx, &films[i]))
+		}
+		titles := func(films []model.Film) (titles []string) {
+			for _, film := range films {
+				titles = append(titles, film.Title)
+			}
+			return titles
+		}
+
+		list, total, err := s.GetFilmsFiltered(ctx, model.FilmFilter{}, model.ListOptions{Sort: model.FilmSortTitle})
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, total)
+		assert.Equal(t, []string{"Film 1", "film 2", "Film 10"}, titles(list))
+
+		list, total, err = s.GetFilmsFiltered(ctx, model.FilmFilter{Genre: "drama"}, model.ListOptions{Sort: model.FilmSortRuntime, Descending: true, Limit: 1})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, total)
+		assert.Equal(t, []string{"Film 10"}, titles(list))
+
+		list, _, err = s.GetFilmsFiltered(ctx, model.FilmFilter{Years: []int{2005}, HDR: model.HDRNone}, model.ListOptions{Sort: model.FilmSortTitle, Skip: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"film 2"}, titles(list))
+
+		list, _, err = s.GetFilmsFiltered(ctx, model.FilmFilter{HDR: "dolby vision"}, model.ListOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Film 10"}, titles(list))
+
+		list, _, err = s.GetFilmsFiltered(ctx, model.FilmFilter{IDs: []primitive.ObjectID{}}, model.ListOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, list)
+
+		// Films of director 1 under 2 hours, or rated above 7
+		query := model.QueryOr{Nodes: []model.QueryNode{
+			model.QueryAnd{Nodes: []model.QueryNode{
+				model.QueryPerson{Field: model.QueryFieldDirector, TMDBIDs: []int64{1}},
+				model.QueryComparison{Field: model.QueryFieldRuntime, Operator: model.QueryOperatorLess, Value: 120},
+			}},
+			model.QueryComparison{Field: model.QueryFieldRating, Operator: model.QueryOperatorGreater, Value: 7},
+		}}
+		list, _, err = s.GetFilmsFiltered(ctx, model.FilmFilter{Query: query}, model.ListOptions{Sort: model.FilmSortYear})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"film 2", "Film 1"}, titles(list))
+
+		// Films not known to be under 2 hours, including the ones whose runtime is unknown
+		query2 := model.QueryNot{Node: model.QueryComparison{Field: model.QueryFieldRuntime, Operator: model.QueryOperatorLess, Value: 120}}
+		list, _, err = s.GetFilmsFiltered(ctx, model.FilmFilter{Query: query2}, model.ListOptions{Sort: model.FilmSortTitle})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Film 1", "Film 10"}, titles(list))
+
+		list, _, err = s.GetFilmsFiltered(ctx, model.FilmFilter{Query: model.QueryPerson{Field: model.QueryFieldActor, TMDBIDs: []int64{3}}}, model.ListOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"film 2"}, titles(list))
+
+		random, err := s.GetRandomFilms(ctx, model.FilmFilter{Genre: "Drama"}, 5)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"Film 10", "film 2"}, titles(random))
+
+		directors, err := s.GetDirectorsByFilmCount(ctx, 5)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1, 2}, directors)
+
+		assert.Equal(t, []string{"film 2"}, titles(s.GetFilmsWithActor(ctx, 3)))
+		assert.Equal(t, []string{"Film 1"}, titles(s.GetFilmsWithCrewJob(ctx, 4, "Editor")))
+		assert.Empty(t, s.GetFilmsWithCrewJob(ctx, 4, "Producer"))
+	})
+
+	t.Run("FilmsToRefresh", func(t *testing.T) {
+		s := newStorage(t)
+		for i, refreshed := range []time.Time{date, date.Add(-time.Hour), date.Add(time.Hour)} {
+			film := newTestFilm("Film", filepath.Join("/films", string(rune('a'+i))), func(f *model.Film) { f.LastRefreshed = refreshed })
+			require.NoError(t, s.AddFilm(ctx, &film))
+		}
+		unmatched := newTestFilm("Unmatched", "/films/unmatched.mkv", func(f *model.Film) { f.TMDBID = 0 })
+		require.NoError(t, s.AddFilm(ctx, &unmatched))
+
+		films, err := s.GetFilmsToRefresh(ctx, date.Add(time.Minute), 10)
+		require.NoError(t, err)
+		require.Len(t, films, 2)
+		assert.Equal(t, date.Add(-time.Hour), films[0].LastRefreshed)
+		assert.Equal(t, date, films[1].LastRefreshed)
+	})
+
+	t.Run("FilmRatings", func(t *testing.T) {
+		s := newStorage(t)
+		film := newTestFilm("Heat", "/films/heat.mkv", func(f *model.Film) {})
+		require.NoError(t, s.AddFilm(ctx, &film))
+		// The file added since the film was read is kept
+		stale := film
+		film.VolumeFiles = append(film.VolumeFiles, model.VolumeFile{Path: "/other/heat.mkv", FromVolume: primitive.NewObjectID()})
+		require.NoError(t, s.AddFilm(ctx, &film))
+
+		stale.Ratings = map[string]model.Rating{model.RatingSourceIMDb: {Value: 8.3, Best: 10, Votes: 700000, FetchedAt: date}}
+		stale.RatingsRefreshed = date
+		require.NoError(t, s.UpdateFilmRatings(ctx, &stale))
+		saved, err := s.GetFilmFromID(ctx, film.ID)
+		require.NoError(t, err)
+		assert.Equal(t, stale.Ratings, saved.Ratings)
+		assert.Equal(t, date, saved.RatingsRefreshed)
+		assert.Len(t, saved.VolumeFiles, 2)
+
+		// Films sorted by rating see the new rating
+		films, _, err := s.GetFilmsFiltered(ctx, model.FilmFilter{Query: model.QueryComparison{Field: model.QueryFieldRating, Operator: model.QueryOperatorGreater, Value: 8}}, model.ListOptions{})
+		require.NoError(t, err)
+		assert.Len(t, films, 1)
+
+		missing := newTestFilm("Missing", "/films/missing.mkv", func(f *model.Film) {})
+		assert.ErrorIs(t, s.UpdateFilmRatings(ctx, &missing), model.ErrNotFound)
+	})
+
+	t.Run("People", func(t *testing.T) {
+		s := newStorage(t)
+		actor := model.Person{ID: primitive.NewObjectID(), TMDBID: 3, Name: "Zoë Saldaña", Birthday: "1978-06-19", LastRefreshed: date,
+			Filmography: []model.PersonCredit{{TMDBID: 19995, Title: "Avatar", ReleaseDate: "2009-12-15", Department: "Acting", Job: "Neytiri"}}}
+		director := model.Person{ID: primitive.NewObjectID(), TMDBID: 1, Name: "Ann", LastRefreshed: date.Add(-time.Hour), Filmography: []model.PersonCredit{}}
+		editor := model.Person{ID: primitive.NewObjectID(), TMDBID: 4, Name: "bob", Birthday: "1950-01-01", LastRefreshed: date, Filmography: []model.PersonCredit{}}
+		s.AddPerson(ctx, &actor)
+		s.AddPerson(ctx, &director)
+		// Added again while its film is scanned
+		s.AddPerson(ctx, &model.Person{ID: primitive.NewObjectID(), TMDBID: 3})
+		require.NoError(t, s.UpdatePerson(ctx, &editor))
+		assert.True(t, s.IsPersonPresent(ctx, 3))
+		assert.False(t, s.IsPersonPresent(ctx, 5))
+
+		saved, err := s.GetPersonFromTMDBID(ctx, 3)
+		require.NoError(t, err)
+		assert.Equal(t, actor, *saved)
+		for _, name := range []string{"zoe saldana", "ZOË SALDAÑA", "Zoe Saldana", "zoë saldaña"} {
+			people, err := s.GetPeopleWithName(ctx, name)
+			require.NoError(t, err)
+			require.Len(t, people, 1, name)
+			assert.Equal(t, actor.ID, people[0].ID)
+		}
+		people, err := s.GetPeopleWithName(ctx, "zoe")
+		require.NoError(t, err)
+		assert.Empty(t, people)
+
+		toRefresh, err := s.GetPeopleToRefresh(ctx, date, 10)
+		require.NoError(t, err)
+		require.Len(t, toRefresh, 1)
+		assert.Equal(t, director.ID, toRefresh[0].ID)
+		director.LastRefreshed = date
+		require.NoError(t, s.UpdatePerson(ctx, &director))
+		toRefresh, err = s.GetPeopleToRefresh(ctx, date, 10)
+		require.NoError(t, err)
+		assert.Empty(t, toRefresh)
+
+		for _, film := range []model.Film{
+			newTestFilm("A", "a.mkv", func(f *model.Film) {
+				f.Directors = []int64{1}
+				f.Characters = []model.Character{{ActorID: 3}, {ActorID: 1}}
+				f.Crew = []model.CrewCredit{{PersonID: 4, Job: "Editor"}, {PersonID: 3, Job: "Gaffer"}}
+			}),
+			newTestFilm("B", "b.mkv", func(f *model.Film) {
+				f.Directors = []int64{1}
+				f.Writers = []int64{1}
+			}),
+		} {
+			film := film
+			require.NoError(t, s.AddFilm(ctx, &film))
+		}
+		counts := func(people []model.PersonFilmCount) map[string]int {
+			result := make(map[string]int)
+			for _, person := range people {
+				result[person.Name] = person.FilmCount
+			}
+			return result
+		}
+
+		list, total, err := s.GetPeopleFiltered(ctx, model.PersonFilter{}, model.ListOptions{Sort: model.PersonSortFilms, Descending: true})
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, total)
+		require.Len(t, list, 3)
+		assert.Equal(t, "Ann", list[0].Name)
+		assert.Equal(t, map[string]int{"Ann": 2, "bob": 1, "Zoë Saldaña": 1}, counts(list))
+
+		list, total, err = s.GetPeopleFiltered(ctx, model.PersonFilter{Role: model.PersonRoleActor}, model.ListOptions{Sort: model.PersonSortName})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, total)
+		assert.Equal(t, []string{"Ann", "Zoë Saldaña"}, []string{list[0].Name, list[1].Name})
+
+		list, _, err = s.GetPeopleFiltered(ctx, model.PersonFilter{Role: "editor"}, model.ListOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"bob": 1}, counts(list))
+
+		list, total, err = s.GetPeopleFiltered(ctx, model.PersonFilter{TMDBIDs: []int64{1, 4}}, model.ListOptions{Sort: model.PersonSortBirthday, Limit: 1})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, total)
+		assert.Equal(t, []string{"bob"}, []string{list[0].Name})
+
+		require.NoError(t, s.DeletePerson(ctx, 4))
+		assert.False(t, s.IsPersonPresent(ctx, 4))
+	})
+
+	t.Run("PersonRetries", func(t *testing.T) {
+		s := newStorage(t)
+		require.NoError(t, s.AddPersonRetry(ctx, &model.PersonRetry{TMDBID: 1, Attempts: 1, NextAttempt: date.Add(time.Hour)}))
+		require.NoError(t, s.AddPersonRetry(ctx, &model.PersonRetry{TMDBID: 2, Attempts: 1, NextAttempt: date}))
+		require.NoError(t, s.AddPersonRetry(ctx, &model.PersonRetry{TMDBID: 1, Attempts: 2, NextAttempt: date.Add(-time.Hour)}))
+
+		retries, err := s.GetPersonRetries(ctx, date, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []model.PersonRetry{
+			{TMDBID: 1, Attempts: 2, NextAttempt: date.Add(-time.Hour)},
+			{TMDBID: 2, Attempts: 1, NextAttempt: date},
+		}, retries)
+
+		require.NoError(t, s.DeletePersonRetry(ctx, 1))
+		retries, err = s.GetPersonRetries(ctx, date, 10)
+		require.NoError(t, err)
+		assert.Len(t, retries, 1)
+	})
+}
+
+// testFilmTMDBID is the TMDB ID of the last test film, each film getting a different one
+var testFilmTMDBID int
+
+// newTestFilm returns a film with a single file at the path, changed by edit
+func newTestFilm(title, path string, edit func(f *model.Film)) model.Film {
+	testFilmTMDBID++
+	film := model.Film{
+		ID:          primitive.NewObjectID(),
+		VolumeFiles: []model.VolumeFile{{Path: path, FromVolume: primitive.NewObjectID()}},
+		TMDBID:      testFilmTMDBID,
+		Title:       title,
+	}
+	edit(&film)
+	film.Technical = film.GetTechnicalInfo()
+	return film
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Collection is a TMDB collection, grouping the films of a franchise
+type Collection struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	TMDBID       int64              `bson:"tmdb_id"`
+	Name         string             `bson:"name"`
+	Overview     string             `bson:"overview"`
+	PosterPath   string             `bson:"poster_path"`
+	BackdropPath string             `bson:"backdrop_path"`
+	Parts        []CollectionPart   `bson:"parts"` // Films of the collection, in release order
+
+	LastRefreshed time.Time `bson:"last_refreshed"` // Last time the details were fetched from TMDB
+}
+
+// CollectionPart is a film of a collection, which may not be in the library
+type CollectionPart struct {
+	TMDBID      int    `bson:"tmdb_id"`
+	Title       string `bson:"title"`
+	ReleaseDate string `bson:"release_date"` // Formatted as 2006-01-02, empty if unknown
+	PosterPath  string `bson:"poster_path"`
+}
+
+// Year returns the release year of the part, or an empty string if it is unknown
+func (cp CollectionPart) Year() string {
+	if len(cp.ReleaseDate) < 4 {
+		return ""
+	}
+	return cp.ReleaseDate[:4]
+}
+
+// CollectionEntry is a film of a collection, with the film of the library if it is owned
+type CollectionEntry struct {
+	CollectionPart
+	Film *Film // nil if the film is missing from the library
+}
+
+// CollectionSummary is a collection with the number of its films that are in the library
+type CollectionSummary struct {
+	Collection
+	OwnedCount int
+}
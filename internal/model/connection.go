@@ -0,0 +1,14 @@
+package model
+
+// Connection links two people through a film they both worked on
+type Connection struct {
+	From Person
+	Film Film
+	To   Person
+}
+
+// Collaborator is a person who worked with another one, with the films they made together
+type Collaborator struct {
+	Person Person
+	Films  []Film
+}
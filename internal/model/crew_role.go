@@ -0,0 +1,35 @@
+package model
+
+// CrewRole is a crew job with a page listing the films of a person having it
+type CrewRole struct {
+	Slug  string // Used in the route of the page, e.g. "composer"
+	Job   string // Job on TMDB, e.g. "Original Music Composer"
+	Label string // Describes the films of a person, e.g. "Scored by"
+}
+
+// CrewRoles are the crew jobs with a page, in display order.
+// Directors and writers have their own pages, as they are stored apart from the rest of the crew
+var CrewRoles = []CrewRole{
+	{Slug: "composer", Job: "Original Music Composer", Label: "Scored by"},
+	{Slug: "cinematographer", Job: "Director of Photography", Label: "Shot by"},
+	{Slug: "editor", Job: "Editor", Label: "Edited by"},
+	{Slug: "producer", Job: "Producer", Label: "Produced by"},
+}
+
+// GetCrewRoleFromJob returns the role of a TMDB job, and false if it has no page
+func GetCrewRoleFromJob(job string) (CrewRole, bool) {
+	for _, role := range CrewRoles {
+		if role.Job == job {
+			return role, true
+		}
+	}
+	return CrewRole{}, false
+}
+
+// CrewGroup is a job in the crew of a film, with the people who had it
+type CrewGroup struct {
+	Department string
+	Job        string
+	Role       string   // Slug of the role of the job, empty if it has no page
+	People     []Person // People that are not in the database only have a TMDB ID and a name
+}
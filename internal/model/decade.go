@@ -2,5 +2,12 @@ package model
 
 type Decade struct {
 	DecadeYear int
-	Years      []int
+	Count      int // Number of films released during the decade
+	Years      []Year
+}
+
+// Year is a release year, with the number of films released that year
+type Year struct {
+	Year  int
+	Count int
 }
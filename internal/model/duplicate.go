@@ -0,0 +1,20 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Kinds of documents identified by their TMDB ID
+const (
+	DuplicateFilm       = "film"
+	DuplicatePerson     = "person"
+	DuplicateCollection = "collection"
+	DuplicateFile       = "file" // A file in films having different TMDB IDs, which are not merged
+)
+
+// Duplicate is a document stored several times with the same TMDB ID,
+// or a file that is in several films, whose path is then the name of the duplicate
+type Duplicate struct {
+	Kind   string
+	TMDBID int64
+	Name   string
+	IDs    []primitive.ObjectID // The first one is kept, and the others are merged into it, unless they are films having the same file
+}
@@ -4,4 +4,6 @@ import "errors"
 
 var (
 	ErrOwnerAlreadyExists = errors.New("owner already exists")
+	ErrInvalidQuery       = errors.New("invalid query")
+	ErrNotFound           = errors.New("not found")
 )
@@ -0,0 +1,18 @@
+package model
+
+// FacetValue is a value the films can be filtered on, with the number of films having it
+type FacetValue struct {
+	Value string
+	Count int
+}
+
+// TechnicalFacets holds the technical characteristics of the films, with the number of films having each of them
+type TechnicalFacets struct {
+	Resolutions       []FacetValue
+	VideoCodecs       []FacetValue
+	HDRFormats        []FacetValue
+	AudioLanguages    []FacetValue
+	SubtitleLanguages []FacetValue
+
+	HDRFilms int // Number of films with at least one HDR format
+}
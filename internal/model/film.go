@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -14,22 +16,31 @@ type Film struct {
 	IMDbID      string             `bson:"imdb_id"`
 
 	// Fetched from online sources. Only these variables will be used by the template
-	Title            string      `bson:"title"`
-	OriginalTitle    string      `bson:"original_title"`
-	Year             string      `bson:"year"`
-	Runtime          string      `bson:"runtime"`
-	Tagline          string      `bson:"tagline"`
-	Overview         string      `bson:"overview"`
-	PosterPath       string      `bson:"poster_path"`
-	BackdropPath     string      `bson:"backdrop_path"`
-	Classification   string      `bson:"classification"`
-	IMDbRating       string      `bson:"imdb_rating"`
-	LetterboxdRating string      `bson:"letterboxd_rating"`
-	Genres           []string    `bson:"genres"`
-	Directors        []int64     `bson:"directors"`
-	Writers          []int64     `bson:"writers"`
-	Characters       []Character `bson:"characters"`
-	ProdCountries    []string    `bson:"prod_countries"`
+	Title             string            `bson:"title"`
+	OriginalTitle     string            `bson:"original_title"`
+	AlternativeTitles []string          `bson:"alternative_titles"` // Titles under which the film is also known
+	TranslatedTitles  []string          `bson:"translated_titles"`  // Titles in other languages
+	Year              int               `bson:"year"`               // 0 if unknown
+	Runtime           int               `bson:"runtime"`            // In minutes, 0 if unknown
+	Tagline           string            `bson:"tagline"`
+	Overview          string            `bson:"overview"`
+	PosterPath        string            `bson:"poster_path"`
+	BackdropPath      string            `bson:"backdrop_path"`
+	Classification    string            `bson:"classification"`
+	Ratings           map[string]Rating `bson:"ratings"` // Ratings by source
+	Genres            []string          `bson:"genres"`
+	Directors         []int64           `bson:"directors"`
+	Writers           []int64           `bson:"writers"`
+	Characters        []Character       `bson:"characters"`
+	Crew              []CrewCredit      `bson:"crew"` // Whole crew, including the directors and the writers
+	ProdCountries     []string          `bson:"prod_countries"`
+	CollectionID      int64             `bson:"collection_id"` // TMDB ID of the collection the film belongs to, 0 if none
+
+	Technical TechnicalInfo `bson:"technical"` // Computed from the volume files
+
+	DateAdded        time.Time `bson:"date_added"`        // When the first file of the film was added
+	LastRefreshed    time.Time `bson:"last_refreshed"`    // Last time the details were fetched from TMDB
+	RatingsRefreshed time.Time `bson:"ratings_refreshed"` // Last time the ratings were scraped
 }
 
 type Character struct {
@@ -37,12 +48,31 @@ type Character struct {
 	ActorID       int64  `bson:"actor_id"`
 }
 
+// CrewCredit is the job of a person in the crew of a film
+type CrewCredit struct {
+	PersonID   int64  `bson:"person_id"`
+	Name       string `bson:"name"`       // Name of the person, as they may not be in the database
+	Department string `bson:"department"` // e.g. "Sound"
+	Job        string `bson:"job"`        // e.g. "Original Music Composer"
+}
+
+// Rating returns the rating of the film from a specific source
+func (f Film) Rating(source string) Rating {
+	return f.Ratings[source]
+}
+
 func (f Film) GetCastAndCrewIDs() (ids []int64) {
 	for _, cast := range f.Characters {
 		ids = append(ids, cast.ActorID)
 	}
 	ids = append(ids, f.Directors...)
 	ids = append(ids, f.Writers...)
+	// Only the crew members that have a role page are fetched, as a film can have hundreds
+	for _, credit := range f.Crew {
+		if _, ok := GetCrewRoleFromJob(credit.Job); ok {
+			ids = append(ids, credit.PersonID)
+		}
+	}
 
 	return
 }
@@ -0,0 +1,63 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Sort orders of the film listing
+const (
+	FilmSortTitle     = "title"
+	FilmSortYear      = "year"
+	FilmSortDateAdded = "added"
+	FilmSortRuntime   = "runtime"
+	FilmSortRating    = "rating"
+	FilmSortRelevance = "relevance" // Only available when searching
+)
+
+// FilmSorts are the available sort orders of the film listing, in display order
+var FilmSorts = []string{FilmSortTitle, FilmSortYear, FilmSortDateAdded, FilmSortRuntime, FilmSortRating}
+
+// FilmFilter holds the criteria a film must match to be listed. Empty criteria are ignored
+type FilmFilter struct {
+	Years   []int
+	Genre   string
+	Country string
+	IDs     []primitive.ObjectID // Only list these films, e.g. the results of a search
+	Query   QueryNode            // Parsed film query, whose people and texts are resolved
+
+	Resolution         string
+	VideoCodec         string
+	HDR                string // An HDR format, HDRAny or HDRNone
+	AudioLanguage      string
+	SubtitleLanguage   string
+	NoSubtitleLanguage string // Films without subtitles in this language
+}
+
+// Sort orders of the people listing
+const (
+	PersonSortFilms    = "films" // Number of films of the library
+	PersonSortName     = "name"
+	PersonSortBirthday = "birthday"
+)
+
+// PersonSorts are the available sort orders of the people listing, in display order
+var PersonSorts = []string{PersonSortName, PersonSortFilms, PersonSortBirthday}
+
+// Roles of the people listing, besides the slugs of the crew roles
+const (
+	PersonRoleActor    = "actor"
+	PersonRoleDirector = "director"
+	PersonRoleWriter   = "writer"
+)
+
+// PersonFilter holds the criteria a person must match to be listed. Empty criteria are ignored
+type PersonFilter struct {
+	Role    string  // A person role, or the slug of a crew role
+	TMDBIDs []int64 // Only list these people, e.g. the results of a search. Ignored if nil
+}
+
+// ListOptions holds the sort order and the range of items to list
+type ListOptions struct {
+	Sort       string
+	Descending bool
+	Skip       int64
+	Limit      int64 // No limit if 0
+}
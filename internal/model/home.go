@@ -0,0 +1,78 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Types of the rows of the home dashboard
+const (
+	HomeRowRecentlyAdded      = "recently_added"
+	HomeRowRandom             = "random"
+	HomeRowTopRated           = "top_rated"
+	HomeRowFavouriteDirectors = "favourite_directors"
+	HomeRowGenre              = "genre" // Needs a genre
+)
+
+// HomeRowTypes are the types of rows that don't need any parameter, in their default display order
+var HomeRowTypes = []string{HomeRowRecentlyAdded, HomeRowRandom, HomeRowTopRated, HomeRowFavouriteDirectors}
+
+// HomeRow is a row of films on the home dashboard
+type HomeRow struct {
+	Type  string `bson:"type"`
+	Genre string `bson:"genre,omitempty"` // Only for genre rows
+}
+
+// Key identifies the row in the settings, e.g. "top_rated" or "genre:Drama"
+func (hr HomeRow) Key() string {
+	if hr.Type == HomeRowGenre {
+		return HomeRowGenre + ":" + hr.Genre
+	}
+	return hr.Type
+}
+
+// Title is the heading of the row on the home dashboard
+func (hr HomeRow) Title() string {
+	switch hr.Type {
+	case HomeRowRecentlyAdded:
+		return "Recently added"
+	case HomeRowRandom:
+		return "Random picks"
+	case HomeRowTopRated:
+		return "Top rated by IMDb"
+	case HomeRowFavouriteDirectors:
+		return "From your favourite directors"
+	case HomeRowGenre:
+		return hr.Genre
+	}
+	return hr.Type
+}
+
+// ParseHomeRow returns the row identified by a key, see HomeRow.Key
+func ParseHomeRow(key string) (HomeRow, error) {
+	key = strings.TrimSpace(key)
+	if genre, ok := strings.CutPrefix(key, HomeRowGenre+":"); ok {
+		genre = strings.TrimSpace(genre)
+		if genre == "" {
+			return HomeRow{}, fmt.Errorf("home row '%s' needs a genre", key)
+		}
+		return HomeRow{Type: HomeRowGenre, Genre: genre}, nil
+	}
+	for _, rowType := range HomeRowTypes {
+		if key == rowType {
+			return HomeRow{Type: rowType}, nil
+		}
+	}
+	return HomeRow{}, fmt.Errorf("unknown home row '%s'", key)
+}
+
+// HomeSettings holds the server-wide rows of the home dashboard, in display order
+type HomeSettings struct {
+	Rows []HomeRow `bson:"rows"`
+}
+
+// HomeRowFilms is a row of the home dashboard with its films
+type HomeRowFilms struct {
+	HomeRow
+	Films []Film
+}
@@ -17,11 +17,14 @@ type MediaInfoJSONOutput struct {
 }
 
 type VideoInfo struct {
-	CodecID    string
-	Profile    string
-	Resolution string
-	FrameRate  string
-	BitDepth   string
+	CodecID                 string
+	Format                  string // e.g. "HEVC"
+	Profile                 string
+	Resolution              string
+	FrameRate               string
+	BitDepth                string
+	HDRFormat               string // e.g. "Dolby Vision / SMPTE ST 2086"
+	TransferCharacteristics string // e.g. "PQ" or "HLG"
 }
 
 type AudioInfo struct {
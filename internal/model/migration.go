@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+// Migration is a versioned change of the stored documents, applied once and in order
+type Migration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"` // Zero if the migration is not applied yet
+}
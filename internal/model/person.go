@@ -2,6 +2,7 @@ package model
 
 import (
 	"html/template"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -20,4 +21,45 @@ type Person struct {
 	Birthday string             `bson:"birthday"`
 	Deathday string             `bson:"deathday"`
 	IMDbID   string             `bson:"imdb_id"`
+
+	Filmography []PersonCredit `bson:"filmography"` // Films the person worked on, whether they are in the library or not
+
+	LastRefreshed time.Time `bson:"last_refreshed"` // Last time the details were fetched from TMDB
+}
+
+// PersonCredit is a film a person worked on, from their TMDB credits
+type PersonCredit struct {
+	TMDBID      int    `bson:"tmdb_id"`
+	Title       string `bson:"title"`
+	ReleaseDate string `bson:"release_date"` // Formatted as 2006-01-02, empty if unknown
+	PosterPath  string `bson:"poster_path"`
+	Department  string `bson:"department"` // "Acting" for the cast
+	Job         string `bson:"job"`        // Name of the character for the cast
+}
+
+// Year returns the release year of the film, or an empty string if it is unknown
+func (pc PersonCredit) Year() string {
+	if len(pc.ReleaseDate) < 4 {
+		return ""
+	}
+	return pc.ReleaseDate[:4]
+}
+
+// FilmographyEntry is a film a person worked on, with the film of the library if it is owned
+type FilmographyEntry struct {
+	PersonCredit
+	Film *Film // nil if the film is missing from the library
+}
+
+// PersonFilmCount is a person with the number of films of the library they worked on
+type PersonFilmCount struct {
+	Person    `bson:"person"`
+	FilmCount int `bson:"film_count"`
+}
+
+// PersonRetry is a person whose details could not be fetched from TMDB, and will be fetched again
+type PersonRetry struct {
+	TMDBID      int64     `bson:"_id"`
+	Attempts    int       `bson:"attempts"` // Number of failed fetches
+	NextAttempt time.Time `bson:"next_attempt"`
 }
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fields of the film query language
+const (
+	QueryFieldGenre      = "genre"
+	QueryFieldCountry    = "country"
+	QueryFieldYear       = "year"
+	QueryFieldRuntime    = "runtime" // In minutes
+	QueryFieldRating     = "rating"  // IMDb rating
+	QueryFieldDirector   = "director"
+	QueryFieldWriter     = "writer"
+	QueryFieldActor      = "actor"
+	QueryFieldResolution = "resolution"
+	QueryFieldCodec      = "codec"
+	QueryFieldHDR        = "hdr" // An HDR format, HDRAny or HDRNone
+	QueryFieldAudio      = "audio"
+	QueryFieldSubtitles  = "subtitles"
+)
+
+// Comparison operators of the numeric fields
+const (
+	QueryOperatorEqual          = "="
+	QueryOperatorLess           = "<"
+	QueryOperatorLessOrEqual    = "<="
+	QueryOperatorGreater        = ">"
+	QueryOperatorGreaterOrEqual = ">="
+)
+
+// QueryNode is a node of a parsed film query.
+// Its String method returns the query it was parsed from, normalized
+type QueryNode interface {
+	String() string
+}
+
+// QueryAnd matches the films matching all of its nodes
+type QueryAnd struct {
+	Nodes []QueryNode
+}
+
+// QueryOr matches the films matching any of its nodes
+type QueryOr struct {
+	Nodes []QueryNode
+}
+
+// QueryNot matches the films not matching its node
+type QueryNot struct {
+	Node QueryNode
+}
+
+// QueryMatch matches the films having a value in a list field, such as a genre or an audio language
+type QueryMatch struct {
+	Field string
+	Value string
+}
+
+// QueryComparison matches the films whose numeric field compares to a value, such as a runtime under 100 minutes
+type QueryComparison struct {
+	Field    string
+	Operator string
+	Value    float64
+}
+
+// QueryPerson matches the films with a person in a role, such as a director.
+// The TMDB IDs of the people with this name are resolved before the query is evaluated
+type QueryPerson struct {
+	Field   string
+	Name    string
+	TMDBIDs []int64
+}
+
+// QueryText matches the films found by a full-text search.
+// The IDs of the films found are resolved before the query is evaluated
+type QueryText struct {
+	Text    string
+	FilmIDs []primitive.ObjectID
+}
+
+func (q QueryAnd) String() string {
+	return joinQueryNodes(q.Nodes, " ")
+}
+
+func (q QueryOr) String() string {
+	return joinQueryNodes(q.Nodes, " OR ")
+}
+
+func (q QueryNot) String() string {
+	return "-" + joinQueryNodes([]QueryNode{q.Node}, "")
+}
+
+func (q QueryMatch) String() string {
+	return q.Field + ":" + quoteQueryValue(q.Value)
+}
+
+func (q QueryComparison) String() string {
+	operator := q.Operator
+	if operator == QueryOperatorEqual {
+		operator = ""
+	}
+	return q.Field + ":" + operator + strconv.FormatFloat(q.Value, 'f', -1, 64)
+}
+
+func (q QueryPerson) String() string {
+	return q.Field + ":" + quoteQueryValue(q.Name)
+}
+
+func (q QueryText) String() string {
+	return quoteQueryValue(q.Text)
+}
+
+// joinQueryNodes joins the nodes with a separator, grouping the nodes made of several terms
+func joinQueryNodes(nodes []QueryNode, sep string) string {
+	terms := make([]string, len(nodes))
+	for i, node := range nodes {
+		switch node.(type) {
+		case QueryAnd, QueryOr:
+			terms[i] = "(" + node.String() + ")"
+		default:
+			terms[i] = node.String()
+		}
+	}
+	return strings.Join(terms, sep)
+}
+
+// queryValueEscaper escapes the only characters the query parser reads after a backslash in a quoted value
+var queryValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// quoteQueryValue quotes a value if it contains spaces or characters of the query syntax,
+// or if it would be read as a negation or an OR
+func quoteQueryValue(value string) string {
+	if value == "" || strings.ContainsFunc(value, unicode.IsSpace) || strings.ContainsAny(value, "\"(),:") ||
+		strings.HasPrefix(value, "-") || value == "OR" {
+		return `"` + queryValueEscaper.Replace(value) + `"`
+	}
+	return value
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// Rating sources
+const (
+	RatingSourceIMDb       = "imdb"
+	RatingSourceLetterboxd = "letterboxd"
+)
+
+// Rating is a film rating fetched from an online source
+type Rating struct {
+	Value     float64   `bson:"value"`
+	Best      float64   `bson:"best"` // Best value possible, e.g. 10 for IMDb and 5 for Letterboxd
+	Votes     int64     `bson:"votes"`
+	FetchedAt time.Time `bson:"fetched_at"`
+}
+
+// IsSet returns true if the rating has been fetched
+func (r Rating) IsSet() bool {
+	return r.Value > 0
+}
+
+// String formats the rating value with 1 decimal
+func (r Rating) String() string {
+	return strconv.FormatFloat(r.Value, 'f', 1, 64)
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// SearchResult is a film or a person matching a search, with its relevance
+type SearchResult struct {
+	Film   *Film
+	Person *Person
+	Score  float64
+}
+
+// NormalizeText returns the text without accents, case, punctuation nor spaces, so that texts can be compared loosely
+func NormalizeText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if isWordRune(r) {
+			return r
+		}
+		return -1
+	}, foldText(text))
+}
+
+// SearchTokens splits a text into words without accents nor case
+func SearchTokens(text string) []string {
+	return strings.FieldsFunc(foldText(text), func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+// foldText removes the accents and the case of a text
+func foldText(text string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	unaccented, _, err := transform.String(t, text)
+	if err != nil {
+		unaccented = text
+	}
+	return cases.Fold().String(unaccented)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchTokens(t *testing.T) {
+	tests := []struct {
+		text   string
+		tokens []string
+	}{
+		{"Amélie", []string{"amelie"}},
+		{"AMÉLIE", []string{"amelie"}},
+		{"Le Fabuleux Destin d'Amélie Poulain", []string{"le", "fabuleux", "destin", "d", "amelie", "poulain"}},
+		{"La Haïne", []string{"la", "haine"}},
+		{"Zoë Saldaña", []string{"zoe", "saldana"}},
+		{"Straße", []string{"strasse"}},
+		{"Kimi no Na wa.", []string{"kimi", "no", "na", "wa"}},
+		{"君の名は。", []string{"君の名は"}},
+		{"Mission: Impossible – Fallout", []string{"mission", "impossible", "fallout"}},
+		{"2001: A Space Odyssey", []string{"2001", "a", "space", "odyssey"}},
+		{" -- ", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			assert.Equal(t, test.tokens, SearchTokens(test.text))
+		})
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		text       string
+		normalized string
+	}{
+		{"Amélie", "amelie"},
+		{"amelie", "amelie"},
+		{"La Haine", "lahaine"},
+		{"Léon: The Professional", "leontheprofessional"},
+		{"Ørsted", "ørsted"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			assert.Equal(t, test.normalized, NormalizeText(test.text))
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// MetadataSettings holds the server-wide settings used when fetching metadata from TMDB
+type MetadataSettings struct {
+	Language               string   `bson:"language"`                // Language of titles, overviews and genres, e.g. "fr-FR"
+	FallbackLanguage       string   `bson:"fallback_language"`       // Language used when a text is not translated
+	CertificationCountries []string `bson:"certification_countries"` // Countries to get the certification from, by order of preference
+}
+
+// Equal returns true if both settings are the same
+func (ms MetadataSettings) Equal(other MetadataSettings) bool {
+	if ms.Language != other.Language || ms.FallbackLanguage != other.FallbackLanguage ||
+		len(ms.CertificationCountries) != len(other.CertificationCountries) {
+		return false
+	}
+	for i, country := range ms.CertificationCountries {
+		if other.CertificationCountries[i] != country {
+			return false
+		}
+	}
+	return true
+}
+
+// ServerSettings holds the server-wide settings that can be changed from the admin panel while the server runs
+type ServerSettings struct {
+	LogLevel         string        `bson:"log_level"`          // Minimum level of the logs, e.g. "info"
+	TMDBAPIKey       string        `bson:"tmdb_api_key"`       // Key used to query TMDB
+	ItemsPerPage     int64         `bson:"items_per_page"`     // Number of films or people on a page
+	RefreshRateLimit time.Duration `bson:"refresh_rate_limit"` // Minimum delay between two background refreshes
+}
@@ -0,0 +1,12 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// SmartCollection is a named film query saved by a user.
+// Its films are the ones matching the query when it is displayed, so it is always up to date
+type SmartCollection struct {
+	ID     primitive.ObjectID `bson:"_id"`
+	UserID primitive.ObjectID `bson:"user_id"`
+	Name   string             `bson:"name"`
+	Query  string             `bson:"query"`
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Values of the HDR filter that are not HDR formats
+const (
+	HDRAny  = "any"  // Films with any HDR format
+	HDRNone = "none" // Films in SDR only
+)
+
+// TechnicalInfo holds the technical characteristics of all the files of a film, normalized to be filtered on
+type TechnicalInfo struct {
+	Resolutions       []string `bson:"resolutions"`
+	VideoCodecs       []string `bson:"video_codecs"`
+	HDRFormats        []string `bson:"hdr_formats"`
+	AudioLanguages    []string `bson:"audio_languages"`    // ISO 639-1 codes when known
+	SubtitleLanguages []string `bson:"subtitle_languages"` // ISO 639-1 codes when known, from embedded and external subtitles
+}
+
+// GetTechnicalInfo computes the technical characteristics of all the files of the film
+func (f Film) GetTechnicalInfo() (info TechnicalInfo) {
+	for _, volumeFile := range f.VolumeFiles {
+		info.Resolutions = appendUnique(info.Resolutions, volumeFile.Info.Resolution)
+		for _, video := range volumeFile.Info.Video {
+			info.VideoCodecs = appendUnique(info.VideoCodecs, video.Codec())
+			info.HDRFormats = appendUnique(info.HDRFormats, video.HDR())
+		}
+		for _, audio := range volumeFile.Info.Audio {
+			info.AudioLanguages = appendUnique(info.AudioLanguages, NormalizeLanguage(audio.Language))
+		}
+		for _, sub := range volumeFile.Info.Subs {
+			info.SubtitleLanguages = appendUnique(info.SubtitleLanguages, NormalizeLanguage(sub.Language))
+		}
+		for _, sub := range volumeFile.ExtSubtitles {
+			info.SubtitleLanguages = appendUnique(info.SubtitleLanguages, NormalizeLanguage(sub.Language))
+		}
+	}
+	return info
+}
+
+// Codec returns the common name of the video codec, e.g. "HEVC"
+func (vi VideoInfo) Codec() string {
+	if vi.Format != "" {
+		return vi.Format
+	}
+	// Files scanned before the format was stored only have the codec ID, e.g. "V_MPEGH/ISO/HEVC" or "avc1"
+	codecID := strings.ToUpper(vi.CodecID)
+	switch {
+	case strings.Contains(codecID, "HEVC") || strings.HasPrefix(codecID, "HVC1") || strings.HasPrefix(codecID, "HEV1"):
+		return "HEVC"
+	case strings.Contains(codecID, "AVC") || strings.HasPrefix(codecID, "H264"):
+		return "AVC"
+	case strings.Contains(codecID, "AV1") || strings.HasPrefix(codecID, "AV01"):
+		return "AV1"
+	case strings.Contains(codecID, "VP9") || strings.HasPrefix(codecID, "VP09"):
+		return "VP9"
+	case strings.Contains(codecID, "XVID") || strings.Contains(codecID, "DIVX") || strings.Contains(codecID, "MPEG4"):
+		return "MPEG-4 Visual"
+	}
+	return vi.CodecID
+}
+
+// HDR returns the HDR format of the video stream, or an empty string if it is SDR
+func (vi VideoInfo) HDR() string {
+	switch {
+	case strings.Contains(vi.HDRFormat, "Dolby Vision"):
+		return "Dolby Vision"
+	case strings.Contains(vi.HDRFormat, "HDR10+") || strings.Contains(vi.HDRFormat, "SMPTE ST 2094"):
+		return "HDR10+"
+	case strings.Contains(vi.HDRFormat, "HDR10") || strings.Contains(vi.HDRFormat, "SMPTE ST 2086") || vi.TransferCharacteristics == "PQ":
+		return "HDR10"
+	case vi.TransferCharacteristics == "HLG":
+		return "HLG"
+	}
+	return ""
+}
+
+// NormalizeLanguage returns the ISO 639-1 code of a language given as a code or a tag, e.g. "fre", "fr-FR" or "fr" all return "fr".
+// Unknown languages are returned lowercased
+func NormalizeLanguage(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if lang == "" {
+		return ""
+	}
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return lang
+	}
+	base, confidence := tag.Base()
+	if confidence == language.No || base.String() == "und" {
+		return lang
+	}
+	return base.String()
+}
+
+// appendUnique appends a non-empty value to the slice if it is not already in it
+func appendUnique(values []string, value string) []string {
+	if value == "" || slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
@@ -9,4 +9,6 @@ type User struct {
 	Password string             `bson:"password"`
 	IsOwner  bool               `bson:"is_owner"`
 	IsAdmin  bool               `bson:"is_admin"`
+
+	HiddenHomeRows []string `bson:"hidden_home_rows"` // Keys of the home rows the user does not want to see
 }
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -13,72 +14,124 @@ import (
 )
 
 type AdminFilmManager interface {
-	CacheFilms()
-
-	EditFilmWithLink(filmID, inputUrl string) error
+	EditFilmWithLink(ctx context.Context, filmID, inputUrl string) (string, error)
 }
 
 type AdminUserManager interface {
-	GetUsers() ([]model.User, error)
-	GetUser(userHexID string) (*model.User, error)
+	GetUsers(ctx context.Context) ([]model.User, error)
+	GetUser(ctx context.Context, userHexID string) (*model.User, error)
 
-	CreateUser(username, password1, password2 string, isAdmin, isOwner bool) (*model.User, error)
+	CreateUser(ctx context.Context, username, password1, password2 string, isAdmin, isOwner bool) (*model.User, error)
 
-	DeleteUser(userHexID string) error
+	DeleteUser(ctx context.Context, userHexID string) error
 }
 
 type AdminVolumeManager interface {
-	GetVolumes() ([]model.Volume, error)
-	GetVolume(volumeHexID string) (*model.Volume, error)
+	GetVolumes(ctx context.Context) ([]model.Volume, error)
+	GetVolume(ctx context.Context, volumeHexID string) (*model.Volume, error)
+
+	CreateVolume(ctx context.Context, name, path string, isRecursive bool, mediaType string) error
+
+	DeleteVolume(ctx context.Context, volumeHexID string) error
+}
 
-	CreateVolume(name, path string, isRecursive bool, mediaType string) error
+type AdminRefresher interface {
+	RefreshFilm(ctx context.Context, filmHexID string) error
+	RefreshLibrary(ctx context.Context) error
+	ReloadCache(ctx context.Context) error
+	CleanOrphanPeople(ctx context.Context) (int, error)
+}
+
+type AdminSettingsManager interface {
+	GetMetadataSettings(ctx context.Context) model.MetadataSettings
+	SetMetadataSettings(ctx context.Context, metadataLanguage, fallbackLanguage, countries string) error
+	GetServerSettings(ctx context.Context) model.ServerSettings
+	SetServerSettings(ctx context.Context, logLevel, tmdbAPIKey, itemsPerPage, refreshRateLimit string) error
+}
 
-	DeleteVolume(volumeHexID string) error
+type AdminHomeManager interface {
+	GetHomeSettings(ctx context.Context) model.HomeSettings
+	SetHomeRows(ctx context.Context, rowKeys string) error
 }
 
 type AdminHandler struct {
 	AdminFilmManager
 	AdminUserManager
 	AdminVolumeManager
+	AdminRefresher
+	AdminSettingsManager
+	AdminHomeManager
 }
 
-func NewAdminHandler(fm AdminFilmManager, um AdminUserManager, vm AdminVolumeManager) *AdminHandler {
+// logLevels are the log levels that can be chosen from the admin panel, from the most verbose
+var logLevels = []string{"trace", "debug", "info", "warn", "error"}
+
+func NewAdminHandler(fm AdminFilmManager, um AdminUserManager, vm AdminVolumeManager, r AdminRefresher, sm AdminSettingsManager, hm AdminHomeManager) *AdminHandler {
 	return &AdminHandler{
-		AdminFilmManager:   fm,
-		AdminUserManager:   um,
-		AdminVolumeManager: vm,
+		AdminFilmManager:     fm,
+		AdminUserManager:     um,
+		AdminVolumeManager:   vm,
+		AdminRefresher:       r,
+		AdminSettingsManager: sm,
+		AdminHomeManager:     hm,
 	}
 }
 
 // GETAdmin displays the admin page
 func (ah AdminHandler) GETAdmin(c *gin.Context) {
-	var allErr error
-	volumes, err := ah.AdminVolumeManager.GetVolumes()
+	ah.renderAdmin(c, http.StatusOK, nil)
+}
+
+// renderAdmin renders the admin page, with an optional error
+func (ah AdminHandler) renderAdmin(c *gin.Context, code int, allErr error) {
+	volumes, err := ah.AdminVolumeManager.GetVolumes(c)
 	if err != nil {
 		log.Error().Err(err).Msg("error while fetching volumes")
 		allErr = errors.Join(allErr, err)
 	}
 
-	users, err := ah.AdminUserManager.GetUsers()
+	users, err := ah.AdminUserManager.GetUsers(c)
 	if err != nil {
 		log.Error().Err(err).Msg("error while fetching users")
 		allErr = errors.Join(allErr, err)
 	}
 
+	metadataSettings := ah.AdminSettingsManager.GetMetadataSettings(c)
+	serverSettings := ah.AdminSettingsManager.GetServerSettings(c)
+	// The TMDB API key is never displayed
+	serverSettings.TMDBAPIKey = ""
+
+	var homeRowKeys []string
+	for _, row := range ah.AdminHomeManager.GetHomeSettings(c).Rows {
+		homeRowKeys = append(homeRowKeys, row.Key())
+	}
+
 	if allErr != nil {
-		RenderHTML(c, http.StatusOK, "pages/admin.go.html", gin.H{
-			"title":   "Admin",
-			"volumes": volumes,
-			"users":   users,
-			"error":   allErr.Error(),
+		RenderHTML(c, code, "pages/admin.go.html", gin.H{
+			"title":                  "Admin",
+			"volumes":                volumes,
+			"users":                  users,
+			"metadataSettings":       metadataSettings,
+			"certificationCountries": strings.Join(metadataSettings.CertificationCountries, ","),
+			"serverSettings":         serverSettings,
+			"logLevels":              logLevels,
+			"homeRows":               strings.Join(homeRowKeys, ","),
+			"homeRowTypes":           model.HomeRowTypes,
+			"error":                  allErr.Error(),
 		})
 		return
 	}
 
-	RenderHTML(c, http.StatusOK, "pages/admin.go.html", gin.H{
-		"title":   "Admin",
-		"volumes": volumes,
-		"users":   users,
+	RenderHTML(c, code, "pages/admin.go.html", gin.H{
+		"title":                  "Admin",
+		"volumes":                volumes,
+		"users":                  users,
+		"metadataSettings":       metadataSettings,
+		"certificationCountries": strings.Join(metadataSettings.CertificationCountries, ","),
+		"serverSettings":         serverSettings,
+		"logLevels":              logLevels,
+		"homeRows":               strings.Join(homeRowKeys, ","),
+		"homeRowTypes":           model.HomeRowTypes,
 	})
 }
 
@@ -96,7 +149,7 @@ func (ah AdminHandler) GETAdminVolume(c *gin.Context) {
 		return
 	}
 
-	volume, err := ah.AdminVolumeManager.GetVolume(volumeIdStr)
+	volume, err := ah.AdminVolumeManager.GetVolume(c, volumeIdStr)
 	if err != nil {
 		RenderHTML(c, http.StatusOK, "pages/admin_volume.go.html", gin.H{
 			"title": "Edit volume",
@@ -122,7 +175,7 @@ func (ah AdminHandler) POSTEditVolume(c *gin.Context) {
 	volumeMediaType := c.PostForm("mediatype") // "Film" or "TV"
 
 	if volumeIdStr == "" {
-		err := ah.AdminVolumeManager.CreateVolume(volumeName, volumePath, volumeIsRecursive, volumeMediaType)
+		err := ah.AdminVolumeManager.CreateVolume(c, volumeName, volumePath, volumeIsRecursive, volumeMediaType)
 		if err != nil {
 			RenderHTML(c, http.StatusUnauthorized, "pages/admin_volume.go.html", gin.H{
 				"title":  "Add new volume",
@@ -134,7 +187,7 @@ func (ah AdminHandler) POSTEditVolume(c *gin.Context) {
 		}
 	} else {
 		// TODO: editing a volume
-		volume, _ := ah.AdminVolumeManager.GetVolume(volumeIdStr)
+		volume, _ := ah.AdminVolumeManager.GetVolume(c, volumeIdStr)
 		RenderHTML(c, http.StatusUnauthorized, "pages/admin_volume.go.html", gin.H{
 			"title":  "Edit volume",
 			"volume": volume,
@@ -152,7 +205,7 @@ func (ah AdminHandler) POSTEditVolume(c *gin.Context) {
 func (ah AdminHandler) POSTDeleteVolume(c *gin.Context) {
 	volumeID := c.PostForm("volumeId")
 
-	err := ah.AdminVolumeManager.DeleteVolume(volumeID)
+	err := ah.AdminVolumeManager.DeleteVolume(c, volumeID)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{"error": err})
 	}
@@ -174,7 +227,7 @@ func (ah AdminHandler) GETAdminUser(c *gin.Context) {
 		return
 	}
 
-	user, err := ah.AdminUserManager.GetUser(userIdStr)
+	user, err := ah.AdminUserManager.GetUser(c, userIdStr)
 	if err != nil {
 		RenderHTML(c, http.StatusOK, "pages/admin_user.go.html", gin.H{
 			"title": "Edit user",
@@ -200,7 +253,7 @@ func (ah AdminHandler) POSTEditUser(c *gin.Context) {
 	isAdmin := c.PostForm("isadmin") == "isadmin"
 
 	if userIdStr == "" {
-		_, err := ah.AdminUserManager.CreateUser(username, password1, password2, isAdmin, false)
+		_, err := ah.AdminUserManager.CreateUser(c, username, password1, password2, isAdmin, false)
 		if err != nil {
 			RenderHTML(c, http.StatusUnauthorized, "pages/admin_user.go.html", gin.H{
 				"title":    "Add new user",
@@ -212,7 +265,7 @@ func (ah AdminHandler) POSTEditUser(c *gin.Context) {
 		}
 	} else {
 		// TODO: editing a user
-		user, _ := ah.AdminUserManager.GetUser(userIdStr)
+		user, _ := ah.AdminUserManager.GetUser(c, userIdStr)
 		RenderHTML(c, http.StatusUnauthorized, "pages/admin_user.go.html", gin.H{
 			"title":    "Edit user",
 			"userEdit": user,
@@ -230,7 +283,7 @@ func (ah AdminHandler) POSTEditUser(c *gin.Context) {
 func (ah AdminHandler) POSTDeleteUser(c *gin.Context) {
 	userID := c.PostForm("userId")
 
-	err := ah.AdminUserManager.DeleteUser(userID)
+	err := ah.AdminUserManager.DeleteUser(c, userID)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{"error": err})
 	}
@@ -238,11 +291,14 @@ func (ah AdminHandler) POSTDeleteUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("User #%s deleted", userID)})
 }
 
-// POSTReloadCache reloads the cache
+// POSTReloadCache queues the caching of the images of every film and of their people
 func (ah AdminHandler) POSTReloadCache(c *gin.Context) {
-	ah.AdminFilmManager.CacheFilms()
+	if err := ah.AdminRefresher.ReloadCache(c); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{})
+	c.JSON(http.StatusOK, gin.H{"message": "Cache will be reloaded"})
 }
 
 // POSTEditFilmOnline handle editing a film from online link
@@ -250,11 +306,76 @@ func (ah AdminHandler) POSTEditFilmOnline(c *gin.Context) {
 	inputUrl := c.PostForm("url")
 	filmID := c.PostForm("filmID")
 
-	err := ah.AdminFilmManager.EditFilmWithLink(filmID, inputUrl)
+	filmID, err := ah.AdminFilmManager.EditFilmWithLink(c, filmID, inputUrl)
 	if err != nil {
 		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{})
+	c.JSON(http.StatusOK, gin.H{"filmID": filmID})
+}
+
+// POSTRefreshFilm queues the refresh of a film's metadata and ratings
+func (ah AdminHandler) POSTRefreshFilm(c *gin.Context) {
+	filmID := c.PostForm("filmID")
+
+	if err := ah.AdminRefresher.RefreshFilm(c, filmID); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Film #%s will be refreshed", filmID)})
+}
+
+// POSTRefreshLibrary queues the refresh of every film's metadata and ratings
+func (ah AdminHandler) POSTRefreshLibrary(c *gin.Context) {
+	if err := ah.AdminRefresher.RefreshLibrary(c); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Library will be refreshed"})
+}
+
+// POSTCleanOrphanPeople removes the people who are no longer in any film
+func (ah AdminHandler) POSTCleanOrphanPeople(c *gin.Context) {
+	removed, err := ah.AdminRefresher.CleanOrphanPeople(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("%d people removed", removed)})
+}
+
+// POSTMetadataSettings saves the language and certification countries used when fetching metadata
+func (ah AdminHandler) POSTMetadataSettings(c *gin.Context) {
+	err := ah.AdminSettingsManager.SetMetadataSettings(c, c.PostForm("language"), c.PostForm("fallbackLanguage"), c.PostForm("certificationCountries"))
+	if err != nil {
+		ah.renderAdmin(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin")
+}
+
+// POSTServerSettings saves the log level, TMDB API key, items per page and refresh rate limit, and applies them
+func (ah AdminHandler) POSTServerSettings(c *gin.Context) {
+	err := ah.AdminSettingsManager.SetServerSettings(c, c.PostForm("logLevel"), c.PostForm("tmdbAPIKey"), c.PostForm("itemsPerPage"), c.PostForm("refreshRateLimit"))
+	if err != nil {
+		ah.renderAdmin(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin")
+}
+
+// POSTHomeSettings saves the rows of the home dashboard
+func (ah AdminHandler) POSTHomeSettings(c *gin.Context) {
+	if err := ah.AdminHomeManager.SetHomeRows(c, c.PostForm("homeRows")); err != nil {
+		ah.renderAdmin(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin")
 }
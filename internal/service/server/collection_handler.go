@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+type CollectionManager interface {
+	GetCollections(ctx context.Context) ([]model.CollectionSummary, error)
+	GetCollection(ctx context.Context, collectionHexID string) (*model.Collection, []model.CollectionEntry, error)
+}
+
+type CollectionHandler struct {
+	CollectionManager
+}
+
+func NewCollectionHandler(cm CollectionManager) *CollectionHandler {
+	return &CollectionHandler{
+		CollectionManager: cm,
+	}
+}
+
+// GETCollections displays the collections having films in the library
+func (ch CollectionHandler) GETCollections(c *gin.Context) {
+	collections, err := ch.CollectionManager.GetCollections(c)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get collections")
+		RenderHTML(c, http.StatusInternalServerError, "pages/collections.go.html", gin.H{
+			"title": "Collections",
+			"error": "Collections could not be retrieved",
+		})
+		return
+	}
+	RenderHTML(c, http.StatusOK, "pages/collections.go.html", gin.H{
+		"title":       "Collections",
+		"collections": collections,
+	})
+}
+
+// GETCollection displays the films of a collection, whether they are in the library or not
+func (ch CollectionHandler) GETCollection(c *gin.Context) {
+	collection, entries, err := ch.CollectionManager.GetCollection(c, c.Param("id"))
+	if err != nil {
+		log.Debug().Err(err).Send()
+		RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
+			"title": "404 - Not Found",
+		})
+		return
+	}
+
+	ownedCount := 0
+	for _, entry := range entries {
+		if entry.Film != nil {
+			ownedCount++
+		}
+	}
+	RenderHTML(c, http.StatusOK, "pages/collection.go.html", gin.H{
+		"title":      collection.Name,
+		"collection": collection,
+		"entries":    entries,
+		"ownedCount": ownedCount,
+	})
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+// frequentCollaboratorsNumber is the number of frequent collaborators of a person that are displayed
+const frequentCollaboratorsNumber = 12
+
+type ConnectionManager interface {
+	FindPerson(ctx context.Context, query string) (*model.Person, error)
+	GetShortestPath(ctx context.Context, from, to *model.Person) ([]model.Connection, error)
+	GetFrequentCollaborators(ctx context.Context, person *model.Person, number int) ([]model.Collaborator, error)
+}
+
+type ConnectionHandler struct {
+	ConnectionManager
+}
+
+func NewConnectionHandler(cm ConnectionManager) *ConnectionHandler {
+	return &ConnectionHandler{
+		ConnectionManager: cm,
+	}
+}
+
+// personSummary is the JSON representation of a person in the connections
+type personSummary struct {
+	ID       string `json:"id"`
+	TMDBID   int64  `json:"tmdb_id"`
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	PhotoURL string `json:"photo_url,omitempty"`
+}
+
+// connectionSummary is the JSON representation of a connection between two people
+type connectionSummary struct {
+	From personSummary `json:"from"`
+	Film filmSummary   `json:"film"`
+	To   personSummary `json:"to"`
+}
+
+// collaboratorSummary is the JSON representation of a frequent collaborator
+type collaboratorSummary struct {
+	Person personSummary `json:"person"`
+	Films  []filmSummary `json:"films"`
+}
+
+// GETConnections displays the frequent collaborators of a person,
+// and the shortest path between two people if both are given.
+// People are given by their ID or by their name
+func (ch ConnectionHandler) GETConnections(c *gin.Context) {
+	fromQuery := strings.TrimSpace(c.Query("from"))
+	toQuery := strings.TrimSpace(c.Query("to"))
+	data := gin.H{
+		"title":     "Connections",
+		"fromQuery": fromQuery,
+		"toQuery":   toQuery,
+	}
+	if fromQuery == "" {
+		RenderHTML(c, http.StatusOK, "pages/connections.go.html", data)
+		return
+	}
+
+	from, err := ch.ConnectionManager.FindPerson(c, fromQuery)
+	if err != nil {
+		log.Debug().Err(err).Send()
+		data["error"] = "Nobody in the library matches '" + fromQuery + "'"
+		RenderHTML(c, http.StatusOK, "pages/connections.go.html", data)
+		return
+	}
+	data["from"] = from
+	data["fromQuery"] = from.Name
+	collaborators, err := ch.ConnectionManager.GetFrequentCollaborators(c, from, frequentCollaboratorsNumber)
+	if err != nil {
+		log.Error().Err(err).Str("personID", from.ID.Hex()).Msg("Unable to get frequent collaborators")
+	}
+	data["collaborators"] = collaborators
+
+	if toQuery != "" {
+		to, err := ch.ConnectionManager.FindPerson(c, toQuery)
+		if err != nil {
+			log.Debug().Err(err).Send()
+			data["error"] = "Nobody in the library matches '" + toQuery + "'"
+			RenderHTML(c, http.StatusOK, "pages/connections.go.html", data)
+			return
+		}
+		data["to"] = to
+		data["toQuery"] = to.Name
+		path, err := ch.ConnectionManager.GetShortestPath(c, from, to)
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to get the connection path")
+			data["error"] = "The connection could not be computed"
+		}
+		data["path"] = path
+	}
+
+	RenderHTML(c, http.StatusOK, "pages/connections.go.html", data)
+}
+
+// GETConnectionPath returns the shortest path between two people as JSON
+func (ch ConnectionHandler) GETConnectionPath(c *gin.Context) {
+	fromQuery := strings.TrimSpace(c.Query("from"))
+	toQuery := strings.TrimSpace(c.Query("to"))
+	if fromQuery == "" || toQuery == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "both 'from' and 'to' people are required"})
+		return
+	}
+	from, err := ch.ConnectionManager.FindPerson(c, fromQuery)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "nobody in the library matches '" + fromQuery + "'"})
+		return
+	}
+	to, err := ch.ConnectionManager.FindPerson(c, toQuery)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "nobody in the library matches '" + toQuery + "'"})
+		return
+	}
+	path, err := ch.ConnectionManager.GetShortestPath(c, from, to)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get the connection path")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "the connection could not be computed"})
+		return
+	}
+
+	connections := []connectionSummary{}
+	for _, connection := range path {
+		connections = append(connections, connectionSummary{
+			From: getPersonSummary(connection.From),
+			Film: getFilmSummary(connection.Film),
+			To:   getPersonSummary(connection.To),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":      getPersonSummary(*from),
+		"to":        getPersonSummary(*to),
+		"connected": len(path) > 0 || from.TMDBID == to.TMDBID,
+		"degrees":   len(path),
+		"path":      connections,
+	})
+}
+
+// GETPersonCollaborators returns the frequent collaborators of a person as JSON
+func (ch ConnectionHandler) GETPersonCollaborators(c *gin.Context) {
+	person, err := ch.ConnectionManager.FindPerson(c, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "person not found"})
+		return
+	}
+	collaborators, err := ch.ConnectionManager.GetFrequentCollaborators(c, person, frequentCollaboratorsNumber)
+	if err != nil {
+		log.Error().Err(err).Str("personID", person.ID.Hex()).Msg("Unable to get frequent collaborators")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "the collaborators could not be computed"})
+		return
+	}
+
+	summaries := []collaboratorSummary{}
+	for _, collaborator := range collaborators {
+		summary := collaboratorSummary{Person: getPersonSummary(collaborator.Person), Films: []filmSummary{}}
+		for _, film := range collaborator.Films {
+			summary.Films = append(summary.Films, getFilmSummary(film))
+		}
+		summaries = append(summaries, summary)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"person":        getPersonSummary(*person),
+		"collaborators": summaries,
+	})
+}
+
+// getPersonSummary returns the JSON representation of a person
+func getPersonSummary(person model.Person) personSummary {
+	summary := personSummary{
+		ID:     person.ID.Hex(),
+		TMDBID: person.TMDBID,
+		Name:   person.Name,
+		URL:    "/person/" + person.ID.Hex(),
+	}
+	if person.Photo != "" {
+		summary.PhotoURL = "/cache/photo" + person.Photo
+	}
+	return summary
+}
@@ -1,39 +1,63 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pariz/gountries"
+	"github.com/rs/zerolog/log"
 
 	"github.com/Agurato/starfin/internal/model"
 )
 
 type FilmManager interface {
-	GetFilm(filmHexID string) (*model.Film, error)
-	GetFilmPath(filmHexID, filmIndex string) (string, error)
-	GetFilmSubtitlePath(filmHexID, filmIndex, subtitleIndex string) (string, error)
+	GetFilm(ctx context.Context, filmHexID string) (*model.Film, error)
+	GetFilmPath(ctx context.Context, filmHexID, filmIndex string) (string, error)
+	GetFilmSubtitlePath(ctx context.Context, filmHexID, filmIndex, subtitleIndex string) (string, error)
 
-	GetFilms() []model.Film
-	GetFilmsFiltered(years []int, genre, country, search string) (films []model.Film)
+	GetFilms(ctx context.Context) []model.Film
+	GetFilmsFiltered(ctx context.Context, filter model.FilmFilter, query string, listOptions model.ListOptions) ([]model.Film, int64, error)
+	GetSimilarFilms(ctx context.Context, film *model.Film, number int) ([]model.Film, error)
+	GetFilmCollection(ctx context.Context, film *model.Film) (*model.Collection, error)
 }
 
 type FilmPersonManager interface {
-	GetFilmStaff(*model.Film) ([]model.Cast, []model.Person, []model.Person, error)
+	GetFilmStaff(ctx context.Context, film *model.Film) ([]model.Cast, []model.Person, []model.Person, []model.CrewGroup, error)
 }
 
 type Filterer interface {
 	ParseParamsFilters(params string) (yearFilter string, years []int, genre, country string, page int, err error)
 	GetCountryName(code string) string
+	GetLanguageName(code string) string
 
-	GetCountries() []string
+	GetFilmCount() int
+	GetCountryFacets() []model.FacetValue
 	GetDecades() []model.Decade
 	GetGenres() []string
+	GetGenreFacets() []model.FacetValue
+	GetTechnicalFacets() model.TechnicalFacets
 }
 
-type FilmPaginater[T model.Film] interface {
-	GetPagination(currentPage int64, items []T) ([]T, []model.Pagination)
+type Paginater interface {
+	GetListOptions(currentPage int64, sort string, descending bool) model.ListOptions
+	GetPagination(currentPage, totalItems int64) []model.Pagination
+}
+
+// similarFilmsNumber is the number of similar films shown on a film page and returned as recommendations
+const similarFilmsNumber = 12
+
+// filmSummary is the JSON representation of a film in the recommendations
+type filmSummary struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Year      int    `json:"year"`
+	URL       string `json:"url"`
+	PosterURL string `json:"poster_url,omitempty"`
 }
 
 type countryMapping struct {
@@ -46,10 +70,49 @@ type FilmHandler struct {
 	FilmPersonManager
 	countries []countryMapping
 	Filterer
-	FilmPaginater[model.Film]
+	Paginater
+}
+
+// filterLink is a link to the film listing with another filter or sort order
+type filterLink struct {
+	Label  string
+	URL    string
+	Active bool
+	Count  int // Number of films in the library with this filter value, 0 for sort orders
 }
 
-func NewFilmHandler(fm FilmManager, fpm FilmPersonManager, f Filterer, fp FilmPaginater[model.Film]) *FilmHandler {
+// technicalFilter is a group of links to filter the films on a technical characteristic
+type technicalFilter struct {
+	Label string
+	Links []filterLink
+}
+
+// Query parameters of the technical filters
+const (
+	technicalParamResolution  = "resolution"
+	technicalParamCodec       = "codec"
+	technicalParamHDR         = "hdr"
+	technicalParamAudio       = "audio"
+	technicalParamSubtitles   = "subtitles"
+	technicalParamNoSubtitles = "nosubtitles"
+)
+
+var technicalParams = []string{
+	technicalParamResolution, technicalParamCodec, technicalParamHDR,
+	technicalParamAudio, technicalParamSubtitles, technicalParamNoSubtitles,
+}
+
+// filmSortLabels are the displayed names of the sort orders
+var filmSortLabels = map[string]string{
+	model.FilmSortTitle:     "Title",
+	model.FilmSortYear:      "Release year",
+	model.FilmSortDateAdded: "Date added",
+	model.FilmSortRuntime:   "Runtime",
+	model.FilmSortRating:    "Rating",
+	model.FilmSortRelevance: "Relevance",
+}
+
+func NewFilmHandler(fm FilmManager, fpm FilmPersonManager, f Filterer, p Paginater) *FilmHandler {
 	var countries []countryMapping
 	for code, country := range gountries.New().Countries {
 		countries = append(countries, countryMapping{
@@ -62,13 +125,13 @@ func NewFilmHandler(fm FilmManager, fpm FilmPersonManager, f Filterer, fp FilmPa
 		FilmPersonManager: fpm,
 		countries:         countries,
 		Filterer:          f,
-		FilmPaginater:     fp,
+		Paginater:         p,
 	}
 }
 
 // GETFilm displays information about a film
 func (fh FilmHandler) GETFilm(c *gin.Context) {
-	film, err := fh.FilmManager.GetFilm(c.Param("id"))
+	film, err := fh.FilmManager.GetFilm(c, c.Param("id"))
 	if err != nil {
 		RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
 			"title": "404 - Not Found",
@@ -76,14 +139,27 @@ func (fh FilmHandler) GETFilm(c *gin.Context) {
 		return
 	}
 
-	cast, directors, writers, err := fh.FilmPersonManager.GetFilmStaff(film)
+	cast, directors, writers, crew, err := fh.FilmPersonManager.GetFilmStaff(c, film)
+
+	similarFilms, err := fh.FilmManager.GetSimilarFilms(c, film, similarFilmsNumber)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get similar films")
+	}
+
+	collection, err := fh.FilmManager.GetFilmCollection(c, film)
+	if err != nil {
+		log.Debug().Err(err).Msg("Unable to get film collection")
+	}
 
 	RenderHTML(c, http.StatusOK, "pages/film.go.html", gin.H{
-		"title":     fmt.Sprintf("%s (%d)", film.Title, film.ReleaseYear),
-		"film":      film,
-		"directors": directors,
-		"writers":   writers,
-		"cast":      cast,
+		"title":        fmt.Sprintf("%s (%d)", film.Title, film.ReleaseYear),
+		"film":         film,
+		"directors":    directors,
+		"writers":      writers,
+		"cast":         cast,
+		"crew":         crew,
+		"similarFilms": similarFilms,
+		"collection":   collection,
 		"admin": gin.H{
 			"genres":    fh.Filterer.GetGenres(),
 			"countries": fh.countries,
@@ -91,9 +167,51 @@ func (fh FilmHandler) GETFilm(c *gin.Context) {
 	})
 }
 
+// GETFilmRecommendations returns the films of the library recommended because the user watched a film, as JSON
+func (fh FilmHandler) GETFilmRecommendations(c *gin.Context) {
+	film, err := fh.FilmManager.GetFilm(c, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The film could not be found"})
+		return
+	}
+	similarFilms, err := fh.FilmManager.GetSimilarFilms(c, film, similarFilmsNumber)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get similar films")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Recommendations could not be computed"})
+		return
+	}
+
+	recommendations := []filmSummary{}
+	for _, similarFilm := range similarFilms {
+		recommendations = append(recommendations, getFilmSummary(similarFilm))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"title":           "Because you watched " + film.Title,
+		"film":            getFilmSummary(*film),
+		"recommendations": recommendations,
+	})
+}
+
+// getFilmSummary returns the JSON representation of a film
+func getFilmSummary(film model.Film) filmSummary {
+	summary := filmSummary{
+		ID:    film.ID.Hex(),
+		Title: film.Title,
+		Year:  film.ReleaseYear,
+		URL:   "/film/" + film.ID.Hex(),
+	}
+	if summary.Title == "" {
+		summary.Title = film.Name
+	}
+	if film.PosterPath != "" {
+		summary.PosterURL = "/cache/poster" + film.PosterPath
+	}
+	return summary
+}
+
 // GETFilmDownload downloads a film file
 func (fh FilmHandler) GETFilmDownload(c *gin.Context) {
-	filmPath, err := fh.FilmManager.GetFilmPath(c.Param("id"), c.Param("idx"))
+	filmPath, err := fh.FilmManager.GetFilmPath(c, c.Param("id"), c.Param("idx"))
 	if err != nil {
 		RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
 			"title": "404 - Not Found",
@@ -105,7 +223,7 @@ func (fh FilmHandler) GETFilmDownload(c *gin.Context) {
 
 // GETSubtitleDownload downloads a subtitle file
 func (fh FilmHandler) GETSubtitleDownload(c *gin.Context) {
-	subPath, err := fh.FilmManager.GetFilmSubtitlePath(c.Param("id"), c.Param("idx"), c.Param("subIdx"))
+	subPath, err := fh.FilmManager.GetFilmSubtitlePath(c, c.Param("id"), c.Param("idx"), c.Param("subIdx"))
 	if err != nil {
 		RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
 			"title": "404 - Not Found",
@@ -122,23 +240,228 @@ func (fh FilmHandler) GETFilms(c *gin.Context) {
 		RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
 			"title": "404 - Not Found",
 		})
+		return
+	}
+
+	query := parseFilmListQuery(c)
+	filter := model.FilmFilter{
+		Years:              years,
+		Genre:              genre,
+		Country:            country,
+		Resolution:         query.technical.Get(technicalParamResolution),
+		VideoCodec:         query.technical.Get(technicalParamCodec),
+		HDR:                query.technical.Get(technicalParamHDR),
+		AudioLanguage:      query.technical.Get(technicalParamAudio),
+		SubtitleLanguage:   query.technical.Get(technicalParamSubtitles),
+		NoSubtitleLanguage: query.technical.Get(technicalParamNoSubtitles),
 	}
 
-	search, _ := c.GetQuery("search")
-	films := fh.FilmManager.GetFilmsFiltered(years, genre, country, search)
+	listOptions := fh.Paginater.GetListOptions(int64(page), query.sort, query.descending)
+	films, total, err := fh.FilmManager.GetFilmsFiltered(c, filter, query.search, listOptions)
+	errorMessage := ""
+	if errors.Is(err, model.ErrInvalidQuery) {
+		errorMessage = err.Error()
+	} else if err != nil {
+		log.Error().Err(err).Msg("Unable to get films")
+	}
+	pages := fh.Paginater.GetPagination(int64(page), total)
+
+	// Links lead to the first page of the current filters
+	filterPath := "/films"
+	if yearFilter != "" {
+		filterPath += "/year/" + yearFilter
+	}
+	if genre != "" {
+		filterPath += "/genre/" + strings.ToLower(genre)
+	}
+	if country != "" {
+		filterPath += "/country/" + strings.ToLower(country)
+	}
+	filterPath += "/"
 
-	films, pages := fh.FilmPaginater.GetPagination(int64(page), films)
+	sorts := model.FilmSorts
+	if query.search != "" {
+		sorts = append([]string{model.FilmSortRelevance}, sorts...)
+	}
+	var sortLinks []filterLink
+	for _, filmSort := range sorts {
+		sortQuery := query
+		sortQuery.sort = filmSort
+		sortQuery.descending = isFilmSortDescending(filmSort)
+		sortLinks = append(sortLinks, filterLink{
+			Label:  filmSortLabels[filmSort],
+			URL:    filterPath + sortQuery.encode(),
+			Active: filmSort == query.sort,
+		})
+	}
+	reversedQuery := query
+	reversedQuery.descending = !query.descending
 
 	RenderHTML(c, http.StatusOK, "pages/films.go.html", gin.H{
 		"title":             "Films",
+		"error":             errorMessage,
 		"films":             films,
-		"filtererCountries": fh.Filterer.GetCountries(),
+		"total":             total,
+		"filtererCountries": fh.Filterer.GetCountryFacets(),
 		"filtererDecades":   fh.Filterer.GetDecades(),
-		"filtererGenres":    fh.Filterer.GetGenres(),
+		"filtererGenres":    fh.Filterer.GetGenreFacets(),
 		"filterYear":        yearFilter,
 		"filterGenre":       genre,
 		"filterCountry":     country,
-		"search":            search,
+		"search":            query.search,
+		"query":             query.encode(),
+		"technicalParams":   query.technical,
+		"technicalFilters":  fh.getTechnicalFilters(filterPath, query),
+		"sortLabel":         filmSortLabels[query.sort],
+		"sortLinks":         sortLinks,
+		"descending":        query.descending,
+		"canReverseOrder":   query.sort != model.FilmSortRelevance,
+		"reverseOrderURL":   filterPath + reversedQuery.encode(),
 		"pages":             pages,
 	})
 }
+
+// getTechnicalFilters returns the links to filter the films by their technical characteristics.
+// Following the link of an active filter removes it
+func (fh FilmHandler) getTechnicalFilters(filterPath string, query filmListQuery) (filters []technicalFilter) {
+	facets := fh.Filterer.GetTechnicalFacets()
+	filmCount := fh.Filterer.GetFilmCount()
+	hdrFormats := append([]model.FacetValue{
+		{Value: model.HDRAny, Count: facets.HDRFilms},
+		{Value: model.HDRNone, Count: filmCount - facets.HDRFilms},
+	}, facets.HDRFormats...)
+	// Films without subtitles in a language are all the others
+	var noSubtitleLanguages []model.FacetValue
+	for _, language := range facets.SubtitleLanguages {
+		noSubtitleLanguages = append(noSubtitleLanguages, model.FacetValue{Value: language.Value, Count: filmCount - language.Count})
+	}
+	groups := []struct {
+		label  string
+		param  string
+		values []model.FacetValue
+		name   func(string) string
+	}{
+		{"Resolution", technicalParamResolution, facets.Resolutions, nil},
+		{"Video codec", technicalParamCodec, facets.VideoCodecs, nil},
+		{"HDR", technicalParamHDR, hdrFormats, func(value string) string {
+			switch value {
+			case model.HDRAny:
+				return "Any HDR"
+			case model.HDRNone:
+				return "SDR"
+			}
+			return value
+		}},
+		{"Audio", technicalParamAudio, facets.AudioLanguages, fh.Filterer.GetLanguageName},
+		{"Subtitles", technicalParamSubtitles, facets.SubtitleLanguages, fh.Filterer.GetLanguageName},
+		{"Without subtitles", technicalParamNoSubtitles, noSubtitleLanguages, fh.Filterer.GetLanguageName},
+	}
+	for _, group := range groups {
+		if len(group.values) == 0 || (group.param == technicalParamHDR && len(facets.HDRFormats) == 0) {
+			continue
+		}
+		filter := technicalFilter{Label: group.label}
+		for _, value := range group.values {
+			linkQuery := query.withTechnical(group.param, value.Value)
+			label := value.Value
+			if group.name != nil {
+				label = group.name(value.Value)
+			}
+			filter.Links = append(filter.Links, filterLink{
+				Label:  label,
+				URL:    filterPath + linkQuery.encode(),
+				Active: query.technical.Get(group.param) == value.Value,
+				Count:  value.Count,
+			})
+		}
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+// filmListQuery holds the query parameters of the film listing
+type filmListQuery struct {
+	search     string
+	sort       string
+	descending bool
+	technical  url.Values
+}
+
+// parseFilmListQuery reads the query parameters of the film listing. Search results are sorted by relevance by default
+func parseFilmListQuery(c *gin.Context) (query filmListQuery) {
+	query.search = strings.TrimSpace(c.Query("search"))
+
+	query.sort = c.Query("sort")
+	if _, ok := filmSortLabels[query.sort]; !ok || (query.sort == model.FilmSortRelevance && query.search == "") {
+		query.sort = query.defaultSort()
+	}
+	switch c.Query("order") {
+	case "asc":
+		query.descending = false
+	case "desc":
+		query.descending = true
+	default:
+		query.descending = isFilmSortDescending(query.sort)
+	}
+
+	query.technical = url.Values{}
+	for _, param := range technicalParams {
+		if value := c.Query(param); value != "" {
+			query.technical.Set(param, value)
+		}
+	}
+	return query
+}
+
+// defaultSort returns the sort order used when none is given
+func (q filmListQuery) defaultSort() string {
+	if q.search != "" {
+		return model.FilmSortRelevance
+	}
+	return model.FilmSortTitle
+}
+
+// withTechnical returns a copy of the query with a technical filter set, or removed if it was already set to this value
+func (q filmListQuery) withTechnical(param, value string) filmListQuery {
+	technical := url.Values{}
+	for key, values := range q.technical {
+		technical[key] = values
+	}
+	if technical.Get(param) == value {
+		technical.Del(param)
+	} else {
+		technical.Set(param, value)
+	}
+	q.technical = technical
+	return q
+}
+
+// encode returns the query string of the film listing, with only the non-default parameters
+func (q filmListQuery) encode() string {
+	query := url.Values{}
+	if q.search != "" {
+		query.Set("search", q.search)
+	}
+	if q.sort != q.defaultSort() {
+		query.Set("sort", q.sort)
+	}
+	if q.descending != isFilmSortDescending(q.sort) {
+		if q.descending {
+			query.Set("order", "desc")
+		} else {
+			query.Set("order", "asc")
+		}
+	}
+	for key, values := range q.technical {
+		query[key] = values
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
+
+// isFilmSortDescending returns the default order of a sort: alphabetical for titles, highest first otherwise
+func isFilmSortDescending(sort string) bool {
+	return sort != model.FilmSortTitle && sort != model.FilmSortRelevance
+}
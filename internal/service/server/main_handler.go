@@ -1,12 +1,15 @@
 package server
 
 import (
+	"context"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/Agurato/starfin/internal/model"
 )
@@ -16,24 +19,47 @@ type MainCacher interface {
 }
 
 type MainUserManager interface {
-	IsOwnerPresent() (bool, error)
-	CreateOwner(username, password1, password2 string) (*model.User, error)
-	CheckLogin(username, password string) (user *model.User, err error)
-	SetUserPassword(username, oldPassword, password1, password2 string) error
+	IsOwnerPresent(ctx context.Context) (bool, error)
+	CreateOwner(ctx context.Context, username, password1, password2 string) (*model.User, error)
+	CheckLogin(ctx context.Context, username, password string) (user *model.User, err error)
+	SetUserPassword(ctx context.Context, username, oldPassword, password1, password2 string) error
+}
+
+type MainHomeManager interface {
+	GetHomeSettings(ctx context.Context) model.HomeSettings
+	GetHomeRowsFilms(ctx context.Context, userID primitive.ObjectID) []model.HomeRowFilms
+
+	GetUserHiddenHomeRows(ctx context.Context, userID primitive.ObjectID) []string
+	SetUserShownHomeRows(ctx context.Context, userID primitive.ObjectID, rowKeys []string) error
 }
 
 type MainHandler struct {
 	MainCacher
 	MainUserManager
+	MainHomeManager
 }
 
-func NewMainHandler(mc MainCacher, mum MainUserManager) *MainHandler {
+func NewMainHandler(mc MainCacher, mum MainUserManager, mhm MainHomeManager) *MainHandler {
 	return &MainHandler{
 		MainCacher:      mc,
 		MainUserManager: mum,
+		MainHomeManager: mhm,
 	}
 }
 
+// homeRow is a row of the home dashboard, with the link to all of its films
+type homeRow struct {
+	model.HomeRowFilms
+	URL string // Empty if the films of the row cannot be listed
+}
+
+// homeRowSetting is a row of the home dashboard that a user can hide
+type homeRowSetting struct {
+	Key   string
+	Title string
+	Shown bool
+}
+
 // Error404 displays the 404 page
 func (mh MainHandler) Error404(c *gin.Context) {
 	RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
@@ -41,16 +67,34 @@ func (mh MainHandler) Error404(c *gin.Context) {
 	})
 }
 
-// GETIndex displays the index page
+// GETIndex displays the home dashboard
 func (mh MainHandler) GETIndex(c *gin.Context) {
+	var rows []homeRow
+	for _, row := range mh.MainHomeManager.GetHomeRowsFilms(c, getSessionUser(c).ID) {
+		rows = append(rows, homeRow{HomeRowFilms: row, URL: getHomeRowURL(row.HomeRow)})
+	}
 	RenderHTML(c, http.StatusOK, "pages/index.go.html", gin.H{
 		"title": "starfin",
+		"rows":  rows,
 	})
 }
 
+// getHomeRowURL returns the link to the film listing with the films of a home row, or an empty string if there is none
+func getHomeRowURL(row model.HomeRow) string {
+	switch row.Type {
+	case model.HomeRowRecentlyAdded:
+		return "/films/?sort=" + model.FilmSortDateAdded + "&order=desc"
+	case model.HomeRowTopRated:
+		return "/films/?sort=" + model.FilmSortRating + "&order=desc"
+	case model.HomeRowGenre:
+		return "/films/genre/" + strings.ToLower(row.Genre) + "/"
+	}
+	return ""
+}
+
 // GetStart allows regsitration of first user (admin & owner)
 func (mh MainHandler) GETStart(c *gin.Context) {
-	if ownerPresent, err := mh.MainUserManager.IsOwnerPresent(); err != nil {
+	if ownerPresent, err := mh.MainUserManager.IsOwnerPresent(c); err != nil {
 		log.Error().Err(err).Send()
 		c.Redirect(http.StatusTemporaryRedirect, "/")
 		return
@@ -71,7 +115,7 @@ func (mh MainHandler) POSTStart(c *gin.Context) {
 	password1 := strings.Trim(c.PostForm("password1"), " ")
 	password2 := strings.Trim(c.PostForm("password2"), " ")
 
-	user, err := mh.MainUserManager.CreateOwner(username, password1, password2)
+	user, err := mh.MainUserManager.CreateOwner(c, username, password1, password2)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -105,7 +149,7 @@ func (mh MainHandler) POSTLogin(c *gin.Context) {
 	username := strings.Trim(c.PostForm("username"), " ")
 	password := strings.Trim(c.PostForm("password"), " ")
 
-	user, err := mh.MainUserManager.CheckLogin(username, password)
+	user, err := mh.MainUserManager.CheckLogin(c, username, password)
 	// TODO: always return a JSON response. Redirection will be handled client-side
 	if err != nil {
 		RenderHTML(c, http.StatusUnauthorized, "pages/login.go.html", gin.H{
@@ -152,16 +196,48 @@ func (mh MainHandler) GETLogout(c *gin.Context) {
 // GETSettings displays the user settings page
 func (mh MainHandler) GETSettings(c *gin.Context) {
 	success := ""
-	setPassword := c.Query("setpassword")
-	if setPassword == "success" {
+	switch {
+	case c.Query("setpassword") == "success":
 		success = "Password changed successfully"
+	case c.Query("homerows") == "success":
+		success = "Home rows saved successfully"
 	}
 	RenderHTML(c, http.StatusOK, "pages/settings.go.html", gin.H{
-		"title":   "Settings",
-		"success": success,
+		"title":    "Settings",
+		"success":  success,
+		"homeRows": mh.getHomeRowSettings(c, getSessionUser(c).ID),
 	})
 }
 
+// POSTHomeRows saves the rows of the home dashboard the user wants to see
+func (mh MainHandler) POSTHomeRows(c *gin.Context) {
+	user := getSessionUser(c)
+	if err := mh.MainHomeManager.SetUserShownHomeRows(c, user.ID, c.PostFormArray("rows")); err != nil {
+		log.Error().Err(err).Str("userID", user.ID.Hex()).Send()
+		RenderHTML(c, http.StatusInternalServerError, "pages/settings.go.html", gin.H{
+			"title":    "Settings",
+			"error":    "Home rows could not be saved",
+			"homeRows": mh.getHomeRowSettings(c, user.ID),
+		})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings?homerows=success")
+}
+
+// getHomeRowSettings returns the rows of the home dashboard, and whether the user sees them
+func (mh MainHandler) getHomeRowSettings(ctx context.Context, userID primitive.ObjectID) (rows []homeRowSetting) {
+	hidden := mh.MainHomeManager.GetUserHiddenHomeRows(ctx, userID)
+	for _, row := range mh.MainHomeManager.GetHomeSettings(ctx).Rows {
+		rows = append(rows, homeRowSetting{
+			Key:   row.Key(),
+			Title: row.Title(),
+			Shown: !slices.Contains(hidden, row.Key()),
+		})
+	}
+	return rows
+}
+
 // POSTSetPassword handles changing password from POST request
 func (mh MainHandler) POSTSetPassword(c *gin.Context) {
 	session := sessions.Default(c)
@@ -171,10 +247,11 @@ func (mh MainHandler) POSTSetPassword(c *gin.Context) {
 	password1 := strings.Trim(c.PostForm("password1"), " ")
 	password2 := strings.Trim(c.PostForm("password2"), " ")
 
-	if err := mh.MainUserManager.SetUserPassword(user.Name, oldPassword, password1, password2); err != nil {
+	if err := mh.MainUserManager.SetUserPassword(c, user.Name, oldPassword, password1, password2); err != nil {
 		RenderHTML(c, http.StatusUnauthorized, "pages/settings.go.html", gin.H{
-			"title": "Settings",
-			"error": err.Error(),
+			"title":    "Settings",
+			"error":    err.Error(),
+			"homeRows": mh.getHomeRowSettings(c, user.ID),
 		})
 		return
 	}
@@ -1,52 +1,67 @@
 package server
 
 import (
+	"context"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 
 	"github.com/Agurato/starfin/internal/model"
 )
 
 type PersonManager interface {
-	GetPeople() []model.Person
+	GetPeopleFiltered(ctx context.Context, filter model.PersonFilter, search string, listOptions model.ListOptions) ([]model.PersonFilmCount, int64, error)
 
-	GetPerson(personHexID string) (*model.Person, error)
+	GetPerson(ctx context.Context, personHexID string) (*model.Person, error)
+	GetFilmography(person *model.Person, job string, films []model.Film) []model.FilmographyEntry
 }
 
 type PersonFilmManager interface {
-	GetFilmsWithActor(actorID int64) (films []model.Film)
-	GetFilmsWithDirector(directorID int64) (films []model.Film)
-	GetFilmsWithWriter(writerID int64) (films []model.Film)
-}
-
-type PersonPaginater[T model.Person] interface {
-	GetPagination(currentPage int64, items []T) ([]T, []model.Pagination)
+	GetFilmsWithActor(ctx context.Context, actorID int64) (films []model.Film)
+	GetFilmsWithDirector(ctx context.Context, directorID int64) (films []model.Film)
+	GetFilmsWithWriter(ctx context.Context, writerID int64) (films []model.Film)
+	GetFilmsWithCrewJob(ctx context.Context, personID int64, job string) (films []model.Film)
 }
 
 type PersonHandler struct {
 	PersonManager
 	PersonFilmManager
-	PersonPaginater[model.Person]
+	Paginater
 }
 
-func NewPersonHandler(pm PersonManager, pfm PersonFilmManager, pp PersonPaginater[model.Person]) *PersonHandler {
+func NewPersonHandler(pm PersonManager, pfm PersonFilmManager, p Paginater) *PersonHandler {
 	return &PersonHandler{
 		PersonManager:     pm,
 		PersonFilmManager: pfm,
-		PersonPaginater:   pp,
+		Paginater:         p,
 	}
 }
 
-// HandleGETFilms displays the list of people
+// personSortLabels are the displayed names of the sort orders of the people listing
+var personSortLabels = map[string]string{
+	model.PersonSortName:     "Name",
+	model.PersonSortFilms:    "Number of films",
+	model.PersonSortBirthday: "Birth date",
+}
+
+// peopleListQuery holds the query parameters of the people listing
+type peopleListQuery struct {
+	search     string
+	role       string
+	sort       string
+	descending bool
+}
+
+// GETPeople displays the people of the library, filtered by role and name, with their number of films
 func (ph PersonHandler) GETPeople(c *gin.Context) {
 	var (
-		inputSearch string
-		searchTerm  string
-		page        int
-		err         error
-		// ok          bool
+		page int
+		err  error
 	)
 
 	// Get page number
@@ -59,27 +74,141 @@ func (ph PersonHandler) GETPeople(c *gin.Context) {
 			RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
 				"title": "404 - Not Found",
 			})
+			return
 		}
 	}
 
-	people := ph.PersonManager.GetPeople()
+	query := parsePeopleListQuery(c)
+	roles := getPersonRoles()
+	if !slices.ContainsFunc(roles, func(role personRole) bool { return role.Value == query.role }) {
+		query.role = ""
+	}
 
-	// Filter films from search
-	// if inputSearch, ok = c.GetQuery("search"); ok {
-	// 	films, searchTerm, searchYear = SearchFilms(inputSearch, people)
-	// }
+	listOptions := ph.Paginater.GetListOptions(int64(page), query.sort, query.descending)
+	people, total, err := ph.PersonManager.GetPeopleFiltered(c, model.PersonFilter{Role: query.role}, query.search, listOptions)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get people")
+	}
+	pages := ph.Paginater.GetPagination(int64(page), total)
 
-	people, pages := ph.PersonPaginater.GetPagination(int64(page), people)
+	// Links lead to the first page of the current filters
+	roleLinks := []filterLink{}
+	for _, role := range roles {
+		roleQuery := query
+		roleQuery.role = role.Value
+		roleLinks = append(roleLinks, filterLink{
+			Label:  role.Label,
+			URL:    "/people" + roleQuery.encode(),
+			Active: role.Value == query.role,
+		})
+	}
+	var sortLinks []filterLink
+	for _, personSort := range model.PersonSorts {
+		sortQuery := query
+		sortQuery.sort = personSort
+		sortQuery.descending = isPersonSortDescending(personSort)
+		sortLinks = append(sortLinks, filterLink{
+			Label:  personSortLabels[personSort],
+			URL:    "/people" + sortQuery.encode(),
+			Active: personSort == query.sort,
+		})
+	}
+	reversedQuery := query
+	reversedQuery.descending = !query.descending
 
 	RenderHTML(c, http.StatusOK, "pages/people.go.html", gin.H{
-		"title":      "People",
-		"people":     people,
-		"search":     inputSearch,
-		"searchTerm": searchTerm,
-		"pages":      pages,
+		"title":           "People",
+		"people":          people,
+		"total":           total,
+		"search":          query.search,
+		"role":            query.role,
+		"roleLinks":       roleLinks,
+		"sortLabel":       personSortLabels[query.sort],
+		"sortLinks":       sortLinks,
+		"descending":      query.descending,
+		"reverseOrderURL": "/people" + reversedQuery.encode(),
+		"query":           query.encode(),
+		"pages":           pages,
 	})
 }
 
+// personRole is a role by which the people listing can be filtered
+type personRole struct {
+	Value string // Empty to list everybody
+	Label string
+}
+
+// getPersonRoles returns the roles of the people listing in display order
+func getPersonRoles() []personRole {
+	roles := []personRole{
+		{Value: "", Label: "Everybody"},
+		{Value: model.PersonRoleActor, Label: "Actors"},
+		{Value: model.PersonRoleDirector, Label: "Directors"},
+		{Value: model.PersonRoleWriter, Label: "Writers"},
+	}
+	for _, role := range model.CrewRoles {
+		roles = append(roles, personRole{Value: role.Slug, Label: strings.ToUpper(role.Slug[:1]) + role.Slug[1:] + "s"})
+	}
+	return roles
+}
+
+// parsePeopleListQuery reads the query parameters of the people listing
+func parsePeopleListQuery(c *gin.Context) (query peopleListQuery) {
+	query.search = strings.TrimSpace(c.Query("search"))
+	query.role = c.Query("role")
+
+	query.sort = c.Query("sort")
+	if _, ok := personSortLabels[query.sort]; !ok {
+		query.sort = model.PersonSortName
+	}
+	switch c.Query("order") {
+	case "asc":
+		query.descending = false
+	case "desc":
+		query.descending = true
+	default:
+		query.descending = isPersonSortDescending(query.sort)
+	}
+	return query
+}
+
+// encode returns the query string of the people listing, with only the non-default parameters
+func (q peopleListQuery) encode() string {
+	query := url.Values{}
+	if q.search != "" {
+		query.Set("search", q.search)
+	}
+	if q.role != "" {
+		query.Set("role", q.role)
+	}
+	if q.sort != model.PersonSortName {
+		query.Set("sort", q.sort)
+	}
+	if q.descending != isPersonSortDescending(q.sort) {
+		if q.descending {
+			query.Set("order", "desc")
+		} else {
+			query.Set("order", "asc")
+		}
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
+
+// isPersonSortDescending returns the default order of a sort: most films first, ascending otherwise
+func isPersonSortDescending(sort string) bool {
+	return sort == model.PersonSortFilms
+}
+
+// personJob is a link to the films of a person with a job
+type personJob struct {
+	Label    string // e.g. "Directed by"
+	URL      string
+	Selected bool
+}
+
 // GETPerson displays the actor's bio and the films they star in
 func (ph PersonHandler) GETPerson(c *gin.Context) {
 	ph.GETActor(c)
@@ -87,47 +216,32 @@ func (ph PersonHandler) GETPerson(c *gin.Context) {
 
 // GETActor displays the actor's bio and the films they star in
 func (ph PersonHandler) GETActor(c *gin.Context) {
-	person, err := ph.PersonManager.GetPerson(c.Param("id"))
-	if err != nil {
-		RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
-			"title": "404 - Not Found",
-		})
-		return
-	}
+	ph.renderPerson(c, "actor", ph.PersonFilmManager.GetFilmsWithActor)
+}
 
-	films := ph.PersonFilmManager.GetFilmsWithActor(person.TMDBID)
+// GETDirector displays the directors's bio and the films they directed
+func (ph PersonHandler) GETDirector(c *gin.Context) {
+	ph.renderPerson(c, "director", ph.PersonFilmManager.GetFilmsWithDirector)
+}
 
-	RenderHTML(c, http.StatusOK, "pages/person.go.html", gin.H{
-		"title":  person.Name,
-		"job":    "actor",
-		"person": person,
-		"films":  films,
-	})
+// GETWriter displays the writer's bio and the films they wrote
+func (ph PersonHandler) GETWriter(c *gin.Context) {
+	ph.renderPerson(c, "writer", ph.PersonFilmManager.GetFilmsWithWriter)
 }
 
-// HandleGetDirector displays the directors's bio and the films they directed
-func (ph PersonHandler) GETDirector(c *gin.Context) {
-	person, err := ph.PersonManager.GetPerson(c.Param("id"))
-	if err != nil {
-		RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
-			"title": "404 - Not Found",
+// GETCrewRole returns a handler displaying the person's bio and the films where they had the job of a crew role
+func (ph PersonHandler) GETCrewRole(role model.CrewRole) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ph.renderPerson(c, role.Slug, func(ctx context.Context, personID int64) []model.Film {
+			return ph.PersonFilmManager.GetFilmsWithCrewJob(ctx, personID, role.Job)
 		})
-		return
 	}
-
-	films := ph.PersonFilmManager.GetFilmsWithDirector(person.TMDBID)
-
-	RenderHTML(c, http.StatusOK, "pages/person.go.html", gin.H{
-		"title":  person.Name,
-		"job":    "director",
-		"person": person,
-		"films":  films,
-	})
 }
 
-// HandleGetWriter displays the writer's bio and the films they wrote
-func (ph PersonHandler) GETWriter(c *gin.Context) {
-	person, err := ph.PersonManager.GetPerson(c.Param("id"))
+// renderPerson displays the person's bio and their filmography with a job, the films of the library being highlighted,
+// along with links to their films with the other jobs
+func (ph PersonHandler) renderPerson(c *gin.Context, job string, getFilms func(ctx context.Context, personID int64) []model.Film) {
+	person, err := ph.PersonManager.GetPerson(c, c.Param("id"))
 	if err != nil {
 		RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
 			"title": "404 - Not Found",
@@ -135,12 +249,40 @@ func (ph PersonHandler) GETWriter(c *gin.Context) {
 		return
 	}
 
-	films := ph.PersonFilmManager.GetFilmsWithWriter(person.TMDBID)
+	jobs := []personJob{
+		{Label: "Starring", URL: "/actor/"},
+		{Label: "Directed by", URL: "/director/"},
+		{Label: "Written by", URL: "/writer/"},
+	}
+	slugs := []string{"actor", "director", "writer"}
+	for _, role := range model.CrewRoles {
+		jobs = append(jobs, personJob{Label: role.Label, URL: "/" + role.Slug + "/"})
+		slugs = append(slugs, role.Slug)
+	}
+	heading := ""
+	for i := range jobs {
+		jobs[i].URL += person.ID.Hex() + "#films"
+		jobs[i].Selected = slugs[i] == job
+		if jobs[i].Selected {
+			heading = "Films " + strings.ToLower(jobs[i].Label) + " " + person.Name
+		}
+	}
+
+	filmography := ph.PersonManager.GetFilmography(person, job, getFilms(c, person.TMDBID))
+	ownedCount := 0
+	for _, entry := range filmography {
+		if entry.Film != nil {
+			ownedCount++
+		}
+	}
 
 	RenderHTML(c, http.StatusOK, "pages/person.go.html", gin.H{
-		"title":  person.Name,
-		"job":    "writer",
-		"person": person,
-		"films":  films,
+		"title":       person.Name,
+		"job":         job,
+		"jobs":        jobs,
+		"heading":     heading,
+		"person":      person,
+		"filmography": filmography,
+		"ownedCount":  ownedCount,
 	})
 }
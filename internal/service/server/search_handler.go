@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+type SearchManager interface {
+	Search(ctx context.Context, query string) []model.SearchResult
+}
+
+type SearchHandler struct {
+	SearchManager
+}
+
+func NewSearchHandler(sm SearchManager) *SearchHandler {
+	return &SearchHandler{
+		SearchManager: sm,
+	}
+}
+
+// GETSearch displays the films and people matching the search query
+func (sh SearchHandler) GETSearch(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+
+	var results []model.SearchResult
+	if query != "" {
+		results = sh.SearchManager.Search(c, query)
+	}
+
+	RenderHTML(c, http.StatusOK, "pages/search.go.html", gin.H{
+		"title":         "Search",
+		"searchQuery":   query,
+		"searchResults": results,
+	})
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
@@ -33,16 +34,23 @@ var (
 )
 
 type OwnerStorer interface {
-	IsOwnerPresent() (bool, error)
+	IsOwnerPresent(ctx context.Context) (bool, error)
 }
 
-// NewServer initializes the server
-func NewServer(cookieSecret string, mainHandler *MainHandler, adminHandler *AdminHandler, filmHandler *FilmHandler, personHandler *PersonHandler, rarbgHandler *RarbgHandler, db OwnerStorer) *gin.Engine {
+// NewServer initializes the server.
+// The storage and metadata calls of a request are cancelled after requestTimeout, if it is positive
+func NewServer(cookieSecret string, requestTimeout time.Duration, mainHandler *MainHandler, adminHandler *AdminHandler, filmHandler *FilmHandler, personHandler *PersonHandler, searchHandler *SearchHandler, smartCollectionHandler *SmartCollectionHandler, collectionHandler *CollectionHandler,
+	connectionHandler *ConnectionHandler, rarbgHandler *RarbgHandler, db OwnerStorer) *gin.Engine {
 	// Set Gin to production mode
 	// TODO: change to release for deployment
 	// gin.SetMode(gin.DebugMode)
 
 	router := gin.Default()
+	// Handlers pass the gin context to the managers, which must be cancelled with the request
+	router.ContextWithFallback = true
+	if requestTimeout > 0 {
+		router.Use(timeoutRequest(requestTimeout))
+	}
 
 	_ = router.SetTrustedProxies(nil)
 
@@ -132,16 +140,35 @@ func NewServer(cookieSecret string, mainHandler *MainHandler, adminHandler *Admi
 		GET("/", mainHandler.GETIndex).
 		GET("/films/*params", filmHandler.GETFilms).
 		GET("/film/:id", filmHandler.GETFilm).
+		GET("/film/:id/recommendations", filmHandler.GETFilmRecommendations).
 		GET("/film/:id/download/:idx", filmHandler.GETFilmDownload).
 		GET("/film/:id/download/:idx/sub/:subIdx", filmHandler.GETSubtitleDownload).
 		GET("/people", personHandler.GETPeople).
+		GET("/people/page/:page", personHandler.GETPeople).
 		GET("/person/:id", personHandler.GETPerson).
 		GET("/actor/:id", personHandler.GETActor).
 		GET("/director/:id", personHandler.GETDirector).
 		GET("/writer/:id", personHandler.GETWriter).
+		GET("/person/:id/collaborators", connectionHandler.GETPersonCollaborators).
+		GET("/connections", connectionHandler.GETConnections).
+		GET("/connections/path", connectionHandler.GETConnectionPath).
+		GET("/search", searchHandler.GETSearch).
+		GET("/collections", collectionHandler.GETCollections).
+		GET("/collection/:id", collectionHandler.GETCollection).
+		GET("/smartcollections", smartCollectionHandler.GETSmartCollections).
+		POST("/smartcollections", smartCollectionHandler.POSTSmartCollections).
+		GET("/smartcollection/:id", smartCollectionHandler.GETSmartCollection).
+		GET("/smartcollection/:id/page/:page", smartCollectionHandler.GETSmartCollection).
+		POST("/smartcollection/:id/edit", smartCollectionHandler.POSTEditSmartCollection).
+		POST("/smartcollection/:id/delete", smartCollectionHandler.POSTDeleteSmartCollection).
 		GET("/settings", mainHandler.GETSettings).
 		POST("/setpassword", mainHandler.POSTSetPassword).
+		POST("/settings/homerows", mainHandler.POSTHomeRows).
 		GET("/cache/*path", mainHandler.GETCache)
+	// Films of a person by crew role, such as /composer/:id
+	for _, role := range model.CrewRoles {
+		mainRouter.GET("/"+role.Slug+"/:id", personHandler.GETCrewRole(role))
+	}
 
 	if rarbgHandler != nil {
 		mainRouter.Use(authRequired).
@@ -161,10 +188,16 @@ func NewServer(cookieSecret string, mainHandler *MainHandler, adminHandler *Admi
 		POST("/admin/edituser", adminHandler.POSTEditUser).
 		POST("/admin/deleteuser", adminHandler.POSTDeleteUser).
 		POST("/admin/reloadcache", adminHandler.POSTReloadCache).
+		POST("/admin/refreshlibrary", adminHandler.POSTRefreshLibrary).
+		POST("/admin/cleanpeople", adminHandler.POSTCleanOrphanPeople).
+		POST("/admin/refreshfilm", adminHandler.POSTRefreshFilm).
+		POST("/admin/metadatasettings", adminHandler.POSTMetadataSettings).
+		POST("/admin/serversettings", adminHandler.POSTServerSettings).
+		POST("/admin/homesettings", adminHandler.POSTHomeSettings).
 		POST("/admin/editfilmonline", adminHandler.POSTEditFilmOnline)
 
 	var err error
-	setupDone, err = db.IsOwnerPresent()
+	setupDone, err = db.IsOwnerPresent(context.Background())
 	if err != nil {
 		log.Fatal().Err(err).Msg("error while checking for owner")
 	}
@@ -193,6 +226,16 @@ func RenderHTML(c *gin.Context, code int, name string, obj gin.H) {
 	c.HTML(code, name, obj)
 }
 
+// timeoutRequest cancels the context of a request after a timeout
+func timeoutRequest(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
 // CheckSetupDone ensures that the setup has been done once (a user is registered in the database)
 func checkSetupDone(c *gin.Context) {
 	if !setupDone {
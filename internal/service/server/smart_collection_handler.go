@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Agurato/starfin/internal/model"
+)
+
+type SmartCollectionManager interface {
+	GetSmartCollections(ctx context.Context, userID primitive.ObjectID) ([]model.SmartCollection, error)
+	GetSmartCollection(ctx context.Context, userID primitive.ObjectID, collectionHexID string) (*model.SmartCollection, error)
+	CreateSmartCollection(ctx context.Context, userID primitive.ObjectID, name, query string) (*model.SmartCollection, error)
+	EditSmartCollection(ctx context.Context, userID primitive.ObjectID, collectionHexID, name, query string) error
+	DeleteSmartCollection(ctx context.Context, userID primitive.ObjectID, collectionHexID string) error
+
+	GetSmartCollectionFilms(ctx context.Context, collection *model.SmartCollection, listOptions model.ListOptions) ([]model.Film, int64, error)
+}
+
+type SmartCollectionHandler struct {
+	SmartCollectionManager
+	Paginater
+}
+
+func NewSmartCollectionHandler(scm SmartCollectionManager, p Paginater) *SmartCollectionHandler {
+	return &SmartCollectionHandler{
+		SmartCollectionManager: scm,
+		Paginater:              p,
+	}
+}
+
+// GETSmartCollections displays the smart collections of the user
+func (sch SmartCollectionHandler) GETSmartCollections(c *gin.Context) {
+	sch.renderSmartCollections(c, http.StatusOK, "", "", "")
+}
+
+// POSTSmartCollections creates a smart collection from a name and a query, and displays it
+func (sch SmartCollectionHandler) POSTSmartCollections(c *gin.Context) {
+	name := c.PostForm("name")
+	query := c.PostForm("query")
+	collection, err := sch.SmartCollectionManager.CreateSmartCollection(c, getSessionUser(c).ID, name, query)
+	if err != nil {
+		sch.renderSmartCollections(c, http.StatusBadRequest, err.Error(), name, query)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/smartcollection/"+collection.ID.Hex())
+}
+
+// GETSmartCollection displays the films of a smart collection
+func (sch SmartCollectionHandler) GETSmartCollection(c *gin.Context) {
+	collection, err := sch.SmartCollectionManager.GetSmartCollection(c, getSessionUser(c).ID, c.Param("id"))
+	if err != nil {
+		log.Debug().Err(err).Send()
+		RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
+			"title": "404 - Not Found",
+		})
+		return
+	}
+	sch.renderSmartCollection(c, http.StatusOK, collection, "")
+}
+
+// POSTEditSmartCollection changes the name and the query of a smart collection
+func (sch SmartCollectionHandler) POSTEditSmartCollection(c *gin.Context) {
+	user := getSessionUser(c)
+	collectionID := c.Param("id")
+	if err := sch.SmartCollectionManager.EditSmartCollection(c, user.ID, collectionID, c.PostForm("name"), c.PostForm("query")); err != nil {
+		collection, getErr := sch.SmartCollectionManager.GetSmartCollection(c, user.ID, collectionID)
+		if getErr != nil {
+			RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
+				"title": "404 - Not Found",
+			})
+			return
+		}
+		sch.renderSmartCollection(c, http.StatusBadRequest, collection, err.Error())
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/smartcollection/"+collectionID)
+}
+
+// POSTDeleteSmartCollection deletes a smart collection
+func (sch SmartCollectionHandler) POSTDeleteSmartCollection(c *gin.Context) {
+	if err := sch.SmartCollectionManager.DeleteSmartCollection(c, getSessionUser(c).ID, c.Param("id")); err != nil {
+		sch.renderSmartCollections(c, http.StatusBadRequest, err.Error(), "", "")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/smartcollections")
+}
+
+// renderSmartCollections displays the smart collections of the user, with the creation form filled with a name and a query
+func (sch SmartCollectionHandler) renderSmartCollections(c *gin.Context, code int, errorMessage, name, query string) {
+	collections, err := sch.SmartCollectionManager.GetSmartCollections(c, getSessionUser(c).ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get smart collections")
+	}
+	RenderHTML(c, code, "pages/smart_collections.go.html", gin.H{
+		"title":       "Smart collections",
+		"collections": collections,
+		"error":       errorMessage,
+		"name":        name,
+		"query":       query,
+	})
+}
+
+// renderSmartCollection displays a page of the films of a smart collection
+func (sch SmartCollectionHandler) renderSmartCollection(c *gin.Context, code int, collection *model.SmartCollection, errorMessage string) {
+	page := 1
+	if pageParam := c.Param("page"); pageParam != "" {
+		var err error
+		page, err = strconv.Atoi(pageParam)
+		if err != nil {
+			RenderHTML(c, http.StatusNotFound, "pages/404.go.html", gin.H{
+				"title": "404 - Not Found",
+			})
+			return
+		}
+	}
+
+	listOptions := sch.Paginater.GetListOptions(int64(page), model.FilmSortTitle, false)
+	films, total, err := sch.SmartCollectionManager.GetSmartCollectionFilms(c, collection, listOptions)
+	if err != nil {
+		// The query may have been saved by a previous version with a different syntax
+		log.Error().Err(err).Str("collectionID", collection.ID.Hex()).Msg("Unable to get smart collection films")
+		if errorMessage == "" {
+			errorMessage = err.Error()
+		}
+	}
+
+	RenderHTML(c, code, "pages/smart_collection.go.html", gin.H{
+		"title":      collection.Name,
+		"collection": collection,
+		"films":      films,
+		"total":      total,
+		"pages":      sch.Paginater.GetPagination(int64(page), total),
+		"error":      errorMessage,
+	})
+}
+
+// getSessionUser returns the logged in user
+func getSessionUser(c *gin.Context) model.User {
+	user, _ := sessions.Default(c).Get(UserKey).(model.User)
+	return user
+}
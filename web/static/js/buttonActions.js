@@ -115,6 +115,72 @@ function reloadCache(el) {
   });
 }
 
+function refreshLibrary(el) {
+  let url = "/admin/refreshlibrary";
+
+  el.setAttribute("disabled", "");
+  let spinner = el.children.item(0);
+  spinner.style.display = "inline-block";
+
+  fetch(url, {
+    method: "POST",
+  }).then((res) => {
+    res.json().then((data) => {
+      if (data.error) {
+        console.error(res.status, data.error);
+      } else {
+        console.log(data.message);
+      }
+      el.removeAttribute("disabled");
+      spinner.style.display = "none";
+    });
+  });
+}
+
+function cleanOrphanPeople(el) {
+  let url = "/admin/cleanpeople";
+
+  el.setAttribute("disabled", "");
+  let spinner = el.children.item(0);
+  spinner.style.display = "inline-block";
+
+  fetch(url, {
+    method: "POST",
+  }).then((res) => {
+    res.json().then((data) => {
+      if (data.error) {
+        console.error(res.status, data.error);
+      } else {
+        console.log(data.message);
+      }
+      el.removeAttribute("disabled");
+      spinner.style.display = "none";
+    });
+  });
+}
+
+function refreshFilm(el) {
+  let url = "/admin/refreshfilm";
+
+  el.setAttribute("disabled", "");
+
+  fetch(url, {
+    method: "POST",
+    body: new URLSearchParams({
+      "filmID": el.getAttribute("film-id"),
+    }),
+  }).then((res) => {
+    res.json().then((data) => {
+      if (data.error) {
+        console.error(res.status, data.error);
+        el.removeAttribute("disabled");
+      } else {
+        console.log(data.message);
+      }
+    });
+  });
+}
+
 function editFilmOnlineButton(el) {
   let url = "/admin/editfilmonline";
 
@@ -131,7 +197,8 @@ function editFilmOnlineButton(el) {
           console.error(data.error);
         } else {
           console.log(data);
-          location.reload();
+          // The film may have been merged into another one
+          location.assign("/film/" + data.filmID);
           // el.removeAttribute("disabled");
           // spinner.style.display = "none";
         }